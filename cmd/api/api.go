@@ -10,10 +10,17 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"usual_store/internal/driver"
+	"usual_store/internal/keyring"
+	"usual_store/internal/messaging"
 	"usual_store/internal/models"
+	"usual_store/internal/oidc"
+	"usual_store/internal/password"
+	"usual_store/internal/pii"
+	"usual_store/internal/workerpool"
 
 	// "usual_store/internal/telemetry"  // Temporarily disabled for certificate issues
 	"usual_store/pkg/repository"
@@ -39,8 +46,33 @@ type config struct {
 		username string
 		password string
 	}
-	secretkey string
-	frontend  string
+	kafka struct {
+		brokers []string
+		topic   string
+	}
+	secretkey  string
+	frontend   string
+	invoiceURL string
+	apiURL     string
+	exportDir  string
+	// corsOrigins are the origins whose pages may call the API with credentials
+	corsOrigins []string
+	// abandonedCheckoutAfter is how long an unfinished checkout waits for a recovery email
+	abandonedCheckoutAfter time.Duration
+	// oidc configures single sign-on for staff; it is off without an issuer
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURIs []string
+		groupRoles   map[string]string
+	}
+	// passwords configures how passwords are hashed and which ones users may set
+	passwords struct {
+		params       password.Params
+		minLength    int
+		breachedFile string
+	}
 }
 
 // application holds all the dependencies for the application
@@ -51,7 +83,12 @@ type application struct {
 	version           string
 	DB                models.DBModel
 	tokenService      service.TokenService
+	producer          *messaging.Producer
+	exportPool        *workerpool.Pool
 	telemetryShutdown func(context.Context) error
+	oidc              *oidc.Provider
+	passwordPolicy    *password.Policy
+	keys              *keyring.Keyring
 }
 
 // serve starts the HTTP server and handles graceful shutdown
@@ -138,12 +175,75 @@ func main() {
 
 	// Application secrets and frontend URL
 	flag.StringVar(&cfg.secretkey, "secret", secretKeyForFront, "Secret key")
+	secretKeys := flag.String("secret-keys", os.Getenv("SECRET_KEYS"), "Keys for signed links and encrypted data as id:secret pairs, comma-separated, the active key first (default: the secret key as key 0)")
+	piiIndexKey := flag.String("pii-index-key", os.Getenv("PII_INDEX_KEY"), "Key of the blind index of encrypted emails, at least 32 bytes; empty to store names and emails in plain text")
 	flag.StringVar(&cfg.frontend, "frontend", frontUrl, "Frontend URL")
+	corsOrigins := flag.String("cors-origins", getEnvOrDefault("CORS_ALLOWED_ORIGINS", strings.TrimSuffix(frontUrl, "/")), "Origins allowed to call the API with credentials (comma-separated)")
+
+	// Invoice microservice
+	flag.StringVar(&cfg.invoiceURL, "invoice-url", getEnvOrDefault("INVOICE_SERVICE_URL", "http://localhost:5000"), "Invoice microservice base URL")
+	flag.StringVar(&cfg.apiURL, "api-url", getEnvOrDefault("API_URL", fmt.Sprintf("http://localhost:%d", apiPort)), "Public base URL of this API, used in signed download links")
+	flag.StringVar(&cfg.exportDir, "export-dir", getEnvOrDefault("EXPORT_DIR", "./exports"), "Directory for generated export files")
+
+	// Abandoned checkout recovery
+	abandonedCheckoutHours := flag.Int("abandoned-checkout-hours", getEnvIntOrDefault("ABANDONED_CHECKOUT_HOURS", 24), "Hours after which an unfinished checkout gets a recovery email")
+
+	// Kafka settings for queued emails
+	kafkaBrokers := flag.String("kafka-brokers", getEnvOrDefault("KAFKA_BROKERS", "kafka:9092"), "Kafka brokers (comma-separated)")
+	flag.StringVar(&cfg.kafka.topic, "kafka-topic", getEnvOrDefault("KAFKA_TOPIC", "email-notifications"), "Kafka topic for emails")
+
+	// OpenID Connect single sign-on for staff
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "OpenID Connect issuer URL, empty to disable single sign-on")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	oidcRedirectURIs := flag.String("oidc-redirect-uris", getEnvOrDefault("OIDC_REDIRECT_URIS", frontUrl+"/auth/oidc/callback"), "Allowed single sign-on redirect URIs (comma-separated)")
+	oidcGroupRoles := flag.String("oidc-group-roles", os.Getenv("OIDC_GROUP_ROLES"), "Identity provider groups and the roles they get, as group=role (comma-separated)")
+
+	// Password hashing and policy
+	argon2Memory := flag.Int("argon2-memory", getEnvIntOrDefault("ARGON2_MEMORY_KIB", int(password.DefaultParams.Memory)), "Argon2id memory for each password hash, in KiB")
+	argon2Iterations := flag.Int("argon2-iterations", getEnvIntOrDefault("ARGON2_ITERATIONS", int(password.DefaultParams.Iterations)), "Argon2id passes over the memory for each password hash")
+	argon2Parallelism := flag.Int("argon2-parallelism", getEnvIntOrDefault("ARGON2_PARALLELISM", int(password.DefaultParams.Parallelism)), "Argon2id threads for each password hash")
+	flag.IntVar(&cfg.passwords.minLength, "password-min-length", getEnvIntOrDefault("PASSWORD_MIN_LENGTH", password.MinLength), "Shortest password users may set")
+	flag.StringVar(&cfg.passwords.breachedFile, "breached-passwords", os.Getenv("BREACHED_PASSWORDS_FILE"), "File of breached passwords users may not set, one a line, in addition to the built-in list")
 
 	// Parse the command-line flags and apply their values.
 	// This step processes all the flags defined above, overriding default values
 	// with those provided in the command line.
 	flag.Parse()
+	cfg.kafka.brokers = strings.Split(*kafkaBrokers, ",")
+	cfg.abandonedCheckoutAfter = time.Duration(*abandonedCheckoutHours) * time.Hour
+	cfg.oidc.redirectURIs = strings.Split(*oidcRedirectURIs, ",")
+	cfg.corsOrigins = strings.Split(*corsOrigins, ",")
+	groupRoles, err := parseGroupRoles(*oidcGroupRoles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.oidc.groupRoles = groupRoles
+	if *argon2Memory < 8**argon2Parallelism || *argon2Iterations < 1 || *argon2Parallelism < 1 || *argon2Parallelism > 255 {
+		log.Fatal("argon2 needs at least one iteration, 1-255 threads and 8 KiB of memory a thread")
+	}
+	cfg.passwords.params = password.Params{
+		Memory:      uint32(*argon2Memory),
+		Iterations:  uint32(*argon2Iterations),
+		Parallelism: uint8(*argon2Parallelism),
+		SaltLength:  password.DefaultParams.SaltLength,
+		KeyLength:   password.DefaultParams.KeyLength,
+	}
+	keys, err := keyring.Parse(*secretKeys, cfg.secretkey)
+	if err != nil {
+		log.Fatalf("Error loading secret keys: %v", err)
+	}
+	piiCipher, err := pii.Load(keys, *piiIndexKey)
+	if err != nil {
+		log.Fatalf("Error loading PII key: %v", err)
+	}
+	passwordPolicy := password.NewPolicy(cfg.passwords.minLength)
+	if cfg.passwords.breachedFile != "" {
+		err = passwordPolicy.AddBreachedFile(cfg.passwords.breachedFile)
+		if err != nil {
+			log.Fatalf("Error loading breached passwords: %v", err)
+		}
+	}
 
 	// Load Stripe keys from environment variables
 	cfg.stripe.key = mustGetEnv("STRIPE_KEY")
@@ -173,6 +273,8 @@ func main() {
 	case dbModel := <-dbConnectedCh:
 		// Initialize the repository with the database connection
 		repo := repository.NewDBModel(dbModel.DB)
+		repo.PII = piiCipher
+		repo.ErrorLog = errorLog
 
 		// Initialize OpenTelemetry if enabled (temporarily disabled for certificate issues)
 		// var telemetryShutdown func(context.Context) error
@@ -205,11 +307,42 @@ func main() {
 			errorLog: errorLog,
 			version:  version,
 			DB: models.DBModel{
-				DB: dbModel.DB,
+				DB:  dbModel.DB,
+				PII: piiCipher,
 			},
 			tokenService:      *service.NewTokenService(repo),
+			producer:          messaging.NewProducer(cfg.kafka.brokers, cfg.kafka.topic, infoLog),
 			telemetryShutdown: nil, // Temporarily disabled
+			passwordPolicy:    passwordPolicy,
+			keys:              keys,
 		}
+		if cfg.oidc.issuer != "" {
+			app.oidc = oidc.NewProvider(oidc.Config{
+				Issuer:       cfg.oidc.issuer,
+				ClientID:     cfg.oidc.clientID,
+				ClientSecret: cfg.oidc.clientSecret,
+			})
+			infoLog.Printf("Single sign-on enabled with %s", cfg.oidc.issuer)
+		}
+		defer func() {
+			if err := app.producer.Close(); err != nil {
+				errorLog.Printf("Error closing Kafka producer: %v", err)
+			}
+		}()
+
+		// Exports run on a small worker pool so they never block a request
+		app.exportPool = workerpool.New(exportWorkers, exportQueueSize, infoLog)
+		app.exportPool.Start()
+		go app.logExportResults()
+		defer app.exportPool.Stop()
+
+		// Run background jobs: scheduled price changes, low-stock alerts, checkout recovery and pruning of sign-in attempts
+		schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+		defer stopScheduler()
+		go app.runEvery(schedulerCtx, "price scheduler", priceSchedulerInterval, app.applyPriceSchedules)
+		go app.runEvery(schedulerCtx, "low-stock alerts", lowStockCheckInterval, app.sendLowStockAlerts)
+		go app.runEvery(schedulerCtx, "checkout recovery", checkoutRecoveryInterval, app.sendCheckoutRecoveryEmails)
+		go app.runEvery(schedulerCtx, "login attempt pruning", loginAttemptPruneInterval, app.pruneLoginAttempts)
 
 		// Start the server
 		err := app.serve()
@@ -233,6 +366,14 @@ func mustGetEnv(key string) string {
 	return value
 }
 
+// getEnvOrDefault returns the environment variable or a default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 // mustParseEnvInt retrieves an environment variable and parses it as an integer.
 func mustParseEnvInt(key string) int {
 	value := os.Getenv(key)
@@ -249,3 +390,12 @@ func mustParseEnvInt(key string) int {
 
 	return intValue
 }
+
+// getEnvIntOrDefault returns the environment variable as an integer, or a
+// default value when it is not set or not a number
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"usual_store/internal/cards"
+	"usual_store/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// errInvoiceNotFound is returned when the invoice service has no PDF for an order
+var errInvoiceNotFound = errors.New("invoice not found")
+
+// accountPage reads the page and page_size query parameters of the account listings
+func accountPage(r *http.Request) (int, int, error) {
+	page, pageSize := 1, 10
+
+	var err error
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+	}
+	if value := r.URL.Query().Get("page_size"); value != "" {
+		pageSize, err = strconv.Atoi(value)
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return 0, 0, errors.New("page_size must be between 1 and 100")
+		}
+	}
+	return page, pageSize, nil
+}
+
+// MyAccount returns the authenticated user. Sessions of staff impersonating
+// the user say so in impersonated_by.
+func (app *application) MyAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		ID             int             `json:"id"`
+		FirstName      string          `json:"first_name"`
+		LastName       string          `json:"last_name"`
+		Email          string          `json:"email"`
+		Role           string          `json:"role"`
+		ImpersonatedBy *impersonatedBy `json:"impersonated_by,omitempty"`
+	}
+	resp.ID = user.ID
+	resp.FirstName = user.FirstName
+	resp.LastName = user.LastName
+	resp.Email = user.Email
+	resp.Role = user.Role
+	resp.ImpersonatedBy = app.impersonatedBy(r)
+
+	err := app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// MyOrders lists the one-off orders of the authenticated customer
+func (app *application) MyOrders(w http.ResponseWriter, r *http.Request) {
+	app.listAccountOrders(w, r, false)
+}
+
+// MySubscriptions lists the subscriptions of the authenticated customer
+func (app *application) MySubscriptions(w http.ResponseWriter, r *http.Request) {
+	app.listAccountOrders(w, r, true)
+}
+
+func (app *application) listAccountOrders(w http.ResponseWriter, r *http.Request, isRecurring bool) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	page, pageSize, err := accountPage(r)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	orders, lastPage, totalRecords, err := app.DB.GetOrdersForCustomer(user.Email, isRecurring, pageSize, page)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var response struct {
+		CurrentPage  int             `json:"current_page"`
+		PageSize     int             `json:"page_size"`
+		LastPage     int             `json:"last_page"`
+		TotalRecords int             `json:"total_records"`
+		Orders       []*models.Order `json:"orders"`
+	}
+	response.CurrentPage = page
+	response.PageSize = pageSize
+	response.LastPage = lastPage
+	response.TotalRecords = totalRecords
+	response.Orders = orders
+
+	err = app.writeJSON(w, http.StatusOK, response)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// accountOrder loads the order in the URL if it belongs to the authenticated
+// customer. It writes the error response and returns false otherwise.
+func (app *application) accountOrder(w http.ResponseWriter, r *http.Request) (models.Order, bool) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return models.Order{}, false
+	}
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return models.Order{}, false
+	}
+
+	order, err := app.DB.GetOrderForCustomer(orderID, user.Email)
+	if errors.Is(err, models.ErrOrderNotFound) {
+		app.errorJSON(w, http.StatusNotFound, err.Error())
+		return models.Order{}, false
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return models.Order{}, false
+	}
+	return order, true
+}
+
+// MyOrder returns one order of the authenticated customer
+func (app *application) MyOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.accountOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.LoadFulfilment(&order)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, order)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// MyOrderInvoice downloads the PDF invoice of one of the authenticated customer's orders.
+// Invoices missing from the invoice service are generated on demand, which
+// emails them to the customer, so not while impersonating.
+func (app *application) MyOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.accountOrder(w, r)
+	if !ok {
+		return
+	}
+
+	pdf, err := app.fetchInvoice(order.ID)
+	if errors.Is(err, errInvoiceNotFound) {
+		if app.refuseImpersonated(w, r) {
+			return
+		}
+		err = app.callInvoiceMicroservice(Invoice{
+			ID:        order.ID,
+			WidgetID:  order.WidgetID,
+			Amount:    order.Amount,
+			Quantity:  order.Quantity,
+			Product:   order.Widget.Name,
+			FirstName: order.Customer.FirstName,
+			LastName:  order.Customer.LastName,
+			Email:     order.Customer.Email,
+			CreatedAt: order.CreatedAt,
+		})
+		if err == nil {
+			pdf, err = app.fetchInvoice(order.ID)
+		}
+	}
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusBadGateway, "invoice is not available right now")
+		return
+	}
+
+	w.Header().Set(contentType, "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%d.pdf"`, order.ID))
+	_, err = w.Write(pdf)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// fetchInvoice downloads an invoice PDF from the invoice microservice
+func (app *application) fetchInvoice(orderID int) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/invoice/%d", app.config.invoiceURL, orderID))
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err = Body.Close()
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errInvoiceNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("invoice service returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// CancelMySubscription cancels one of the authenticated customer's subscriptions at the end of the period
+func (app *application) CancelMySubscription(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.accountOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if !order.Widget.IsRecurring {
+		app.errorJSON(w, http.StatusNotFound, models.ErrOrderNotFound.Error())
+		return
+	}
+	if order.StatusID != models.OrderStatusCleared {
+		err := app.badRequest(w, r, errors.New("subscription is not active"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	card := cards.Card{
+		Secret:   app.config.stripe.secret,
+		Key:      app.config.stripe.key,
+		Currency: order.Transaction.Currency,
+	}
+
+	err := card.CancelSubscription(order.Transaction.PaymentIntent)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.audit(r, models.AuditSubscriptionCancel, models.AuditTargetOrder, order.ID, nil, map[string]interface{}{
+		"payment_intent": order.Transaction.PaymentIntent,
+	})
+
+	err = app.DB.UpdateOrderStatus(order.ID, models.OrderStatusCancelled)
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("subscription was canceled, but error happens while updating order in DB"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "successfully cancelled subscription"
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
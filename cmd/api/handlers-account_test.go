@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelMySubscription_Audited(t *testing.T) {
+	calls := fakeStripe(t, http.StatusOK)
+	app, mock, _ := mfaTestApp(t)
+	customer := &models.User{ID: 7, Email: "jane@example.com", Role: "user"}
+
+	mock.ExpectQuery("WHERE o.id = \\$1").
+		WithArgs(3, "jane@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(make([]string, 24)).
+			AddRow(3, 1, 1, 1, models.OrderStatusCleared, 1, 1000, time.Now(), time.Now(), 1, "Plan", true, 1, 1000, "usd", "4242",
+				12, 2030, "sub_1", "", 1, "Jane", "Doe", "jane@example.com"))
+	_, after := expectAudit(mock, "jane@example.com", models.AuditSubscriptionCancel, "3")
+	mock.ExpectExec("UPDATE orders SET status_id = \\$1 WHERE id = \\$2").
+		WithArgs(models.OrderStatusCancelled, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "3")
+	r := httptest.NewRequest(http.MethodPost, "/api/account/subscriptions/3/cancel", nil)
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), UserKey, customer))
+	w := httptest.NewRecorder()
+	app.CancelMySubscription(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"POST /v1/subscriptions/sub_1"}, *calls)
+	assert.JSONEq(t, `{"payment_intent":"sub_1"}`, string(after.value.([]byte)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -14,12 +14,12 @@ import (
 	"usual_store/internal/cards"
 	"usual_store/internal/messaging"
 	"usual_store/internal/models"
+	"usual_store/internal/rbac"
 	"usual_store/internal/urlsigner"
 	"usual_store/internal/validator"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stripe/stripe-go/v72"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const contentType = "Content-Type"
@@ -38,6 +38,9 @@ type stripePayload struct {
 	FirstName     string `json:"first_name"`
 	LastName      string `json:"last_name"`
 	Plan          string `json:"plan"`
+
+	ShippingMethodID int    `json:"shipping_method_id"`
+	Country          string `json:"country"`
 }
 
 type jsonResponse struct {
@@ -73,6 +76,16 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 		app.errorLog.Println(err)
 	}
 
+	shipping, err := app.shippingCost(payload, amount)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	amount += shipping
+
 	card := mappingPayloadToCard(app, payload)
 
 	ok := true
@@ -84,6 +97,8 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 	}
 
 	if ok {
+		app.recordCheckout(r, payload, pi.ID, amount)
+
 		out, err := json.MarshalIndent(pi, "", "	")
 		if err != nil {
 			app.errorLog.Println(err)
@@ -116,9 +131,15 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// GetAllWidgets get all widgets/products
+// GetAllWidgets get all widgets/products, optionally sorted with sort_by (id, name, price, rating, reviews) and sort_order
 func (app *application) GetAllWidgets(w http.ResponseWriter, r *http.Request) {
-	widgets, err := app.DB.GetAllWidgets()
+	sortBy := r.URL.Query().Get("sort_by")
+	sortOrder := r.URL.Query().Get("sort_order")
+	if sortBy == "" {
+		sortBy = "id"
+	}
+
+	widgets, err := app.DB.GetAllWidgetsSorted(sortBy, sortOrder)
 	if err != nil {
 		app.errorLog.Println(err)
 		http.Error(w, "Failed to retrieve products", http.StatusInternalServerError)
@@ -247,14 +268,14 @@ func (app *application) CreateCustomerAndSubscribeToPlan(w http.ResponseWriter,
 			UpdatedAt:     time.Now(),
 		}
 
-		_, err = app.SaveOrder(order)
+		orderID, err := app.SaveOrder(order)
 		if err != nil {
 			app.errorLog.Println(err)
 			return
 		}
 
 		invoice := Invoice{
-			ID:        order.ID,
+			ID:        orderID,
 			Amount:    3000,
 			Product:   "Subscription",
 			Quantity:  order.Quantity,
@@ -288,7 +309,7 @@ func (app *application) CreateCustomerAndSubscribeToPlan(w http.ResponseWriter,
 
 // callInvoiceMicroservice calls invoice microservice that create invoice
 func (app *application) callInvoiceMicroservice(invoice Invoice) error {
-	url := "http://localhost:5000/invoice/create-and-send"
+	url := app.config.invoiceURL + "/invoice/create-and-send"
 	out, err := json.MarshalIndent(invoice, "", "\t")
 	if err != nil {
 		return err
@@ -312,6 +333,10 @@ func (app *application) callInvoiceMicroservice(invoice Invoice) error {
 		}
 	}(resp.Body)
 
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("invoice service returned %s", resp.Status)
+	}
+
 	return nil
 }
 
@@ -366,10 +391,10 @@ func (app *application) SaveOrder(order models.Order) (int, error) {
 
 // CreateAuthToken handle creating authenticate token
 func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
 	var userInput struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Device   string `json:"device"` // optional name of the device signing in
 	}
 
 	err := app.readJSON(w, r, &userInput)
@@ -387,6 +412,10 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		fmt.Println("error getting user by email")
 
+		if !app.loginAllowed(w, r, 0) {
+			return
+		}
+		app.loginFailed(r, userInput.Email, models.User{})
 		err = app.invalidCredentials(w)
 		if err != nil {
 			app.errorLog.Println(err)
@@ -395,10 +424,16 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Locked accounts and throttled addresses do not get to try a password
+	if !app.loginAllowed(w, r, user.ID) {
+		return
+	}
+
 	validPassword, err := app.passwordMatchers(user.Password, userInput.Password)
 	if err != nil {
 		fmt.Println("error password")
 
+		app.loginFailed(r, userInput.Email, user)
 		err = app.invalidCredentials(w)
 		if err != nil {
 			app.errorLog.Println(err)
@@ -410,6 +445,7 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 	if !validPassword {
 		fmt.Println("error - not valid password")
 
+		app.loginFailed(r, userInput.Email, user)
 		err = app.invalidCredentials(w)
 		if err != nil {
 			app.errorLog.Println(err)
@@ -417,37 +453,29 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	app.upgradePassword(user, userInput.Password)
 
-	// Call the TokenService to create and store the token
-	token, err := app.tokenService.CreateToken(ctx, user, 24*time.Hour, models.ScopeAuthentication)
+	// The password is right, but the email address is not verified yet
+	if user.Status != models.UserStatusActive {
+		app.errorJSON(w, http.StatusForbidden, "please verify your email address first, we sent you a link")
+		return
+	}
+
+	// Admins and supporters, and everyone who opted in, need a second factor
+	mfa, err := app.DB.GetUserMFA(user.ID)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
 			app.errorLog.Println(err)
-			return
 		}
 		return
 	}
-
-	var payload struct {
-		Error     bool          `json:"error"`
-		Message   string        `json:"message"`
-		Token     *models.Token `json:"authentication_token"`
-		ID        int           `json:"id"`
-		FirstName string        `json:"first_name"`
-		LastName  string        `json:"last_name"`
-		Email     string        `json:"email"`
-		Role      string        `json:"role"`
+	if mfa.Enabled || rbac.RequiresMFA(user.Role) {
+		app.challengeMFA(w, r, user, mfa, userInput.Device)
+		return
 	}
-	payload.Error = false
-	payload.Message = fmt.Sprintf("Token for user %s created.", userInput.Email)
-	payload.Token = token
-	payload.ID = user.ID
-	payload.FirstName = user.FirstName
-	payload.LastName = user.LastName
-	payload.Email = user.Email
-	payload.Role = user.Role
-	_ = app.writeJSON(w, http.StatusOK, payload)
+
+	app.issueAuthTokens(w, r, user, userInput.Device, nil)
 }
 
 func (app *application) CheckAuthentication(w http.ResponseWriter, r *http.Request) {
@@ -630,7 +658,7 @@ func (app *application) SendPasswordResetEmail(w http.ResponseWriter, r *http.Re
 	link := fmt.Sprintf("%s/reset-password?email=%s", app.config.frontend, payload.Email)
 
 	sign := urlsigner.Signer{
-		Secret: []byte(app.config.secretkey),
+		Keys: app.keys,
 	}
 	signedLink := sign.GenerateTokenFromString(link)
 
@@ -681,7 +709,7 @@ func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
 
 	// Verify the signed URL token
 	sign := urlsigner.Signer{
-		Secret: []byte(app.config.secretkey),
+		Keys: app.keys,
 	}
 
 	// Verify that the token is valid
@@ -710,7 +738,14 @@ func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newHash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), 12)
+	v := validator.New()
+	app.checkPassword(v, payload.Password)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	newHash, err := app.hashPassword(payload.Password)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
@@ -720,7 +755,7 @@ func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.DB.UpdatePasswordForUser(user, string(newHash))
+	err = app.DB.UpdatePasswordForUser(user, newHash)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
@@ -865,6 +900,14 @@ func (app *application) GetSale(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	err = app.DB.LoadFulfilment(&order)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
 	err = app.writeJSON(w, http.StatusOK, order)
 	if err != nil {
 		app.errorLog.Println(err)
@@ -905,6 +948,11 @@ func (app *application) RefundCharge(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, models.AuditOrderRefund, models.AuditTargetOrder, chargeToRefund.ID, nil, map[string]interface{}{
+		"payment_intent": chargeToRefund.PaymentIntent,
+		"amount":         chargeToRefund.Amount,
+		"currency":       chargeToRefund.Currency,
+	})
 
 	//update status in DB
 	err = app.DB.UpdateOrderStatus(chargeToRefund.ID, 2)
@@ -966,6 +1014,9 @@ func (app *application) CancelSubscription(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+	app.audit(r, models.AuditSubscriptionCancel, models.AuditTargetOrder, subscriptionToCancel.ID, nil, map[string]interface{}{
+		"payment_intent": subscriptionToCancel.PaymentIntent,
+	})
 
 	//update status in DB
 	err = app.DB.UpdateOrderStatus(subscriptionToCancel.ID, 3)
@@ -1030,6 +1081,8 @@ func (app *application) ShowUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// EditUser updates the name and email of a user. A new user (ID 0) is
+// invited, and sets their own password from the link emailed to them.
 func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	userID, _ := strconv.Atoi(id)
@@ -1047,7 +1100,8 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if userID > 0 {
-		err = app.DB.EditUser(user)
+		var before models.User
+		before, err = app.DB.GetUserByID(user.ID)
 		if err != nil {
 			err = app.badRequest(w, r, err)
 			if err != nil {
@@ -1057,29 +1111,7 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if user.Password != "" {
-			newHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
-			if err != nil {
-				err = app.badRequest(w, r, err)
-				if err != nil {
-					app.errorLog.Println(err)
-					return
-				}
-				return
-			}
-
-			err = app.DB.UpdatePasswordForUser(user, string(newHash))
-			if err != nil {
-				err = app.badRequest(w, r, err)
-				if err != nil {
-					app.errorLog.Println(err)
-					return
-				}
-				return
-			}
-		}
-	} else {
-		newHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+		err = app.DB.EditUser(user)
 		if err != nil {
 			err = app.badRequest(w, r, err)
 			if err != nil {
@@ -1088,7 +1120,20 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		err = app.DB.AddUser(user, string(newHash))
+
+		after := before
+		after.FirstName, after.LastName, after.Email = user.FirstName, user.LastName, user.Email
+		app.audit(r, models.AuditUserEdit, models.AuditTargetUser, user.ID, auditedUser(before), auditedUser(after))
+	} else {
+		admin, ok := app.authenticatedUser(r)
+		if !ok {
+			_ = app.invalidCredentials(w)
+			return
+		}
+		if user.Role == "" {
+			user.Role = rbac.RoleUser
+		}
+		_, err = app.inviteUser(r, user, *admin)
 		if err != nil {
 			err = app.badRequest(w, r, err)
 			if err != nil {
@@ -1115,7 +1160,7 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	userID, _ := strconv.Atoi(id)
-	err := app.DB.DeleteUser(userID)
+	user, err := app.DB.GetUserByID(userID)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
@@ -1124,6 +1169,17 @@ func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+
+	err = app.DB.DeleteUser(userID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+		return
+	}
+	app.audit(r, models.AuditUserDelete, models.AuditTargetUser, userID, auditedUser(user), nil)
 	var resp struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -1269,6 +1325,7 @@ func (app *application) DeleteUserByID(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, models.AuditUserDelete, models.AuditTargetUser, userID, auditedUser(userToDelete), nil)
 
 	var resp struct {
 		Error   bool   `json:"error"`
@@ -1284,7 +1341,8 @@ func (app *application) DeleteUserByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// CreateUser creates a new user account
+// CreateUser creates a new user account, which is pending until the user
+// verifies their email address
 func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		FirstName string `json:"first_name"`
@@ -1336,8 +1394,15 @@ func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		payload.Role = "supporter"
 	}
 
+	v := validator.New()
+	app.checkPassword(v, payload.Password)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), 12)
+	hashedPassword, err := app.hashPassword(payload.Password)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
@@ -1347,26 +1412,17 @@ func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create user
+	// The account stays pending until the user follows the link emailed to them
 	user := models.User{
 		FirstName: payload.FirstName,
 		LastName:  payload.LastName,
 		Email:     payload.Email,
 		Role:      payload.Role,
+		Status:    models.UserStatusPending,
 	}
 
-	err = app.DB.AddUser(user, string(hashedPassword))
-	if err != nil {
-		err = app.badRequest(w, r, err)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-		return
-	}
-
-	// Get the created user to return the ID
-	createdUser, err := app.DB.GetUserByEmail(payload.Email)
+	sentAt := linkSentAt()
+	user.ID, err = app.DB.InsertSignupUser(user, hashedPassword, 0, sentAt)
 	if err != nil {
 		err = app.badRequest(w, r, err)
 		if err != nil {
@@ -1375,6 +1431,8 @@ func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, models.AuditUserCreate, models.AuditTargetUser, user.ID, nil, auditedUser(user))
+	app.sendVerificationEmail(user, sentAt)
 
 	var resp struct {
 		Error     bool   `json:"error"`
@@ -1384,14 +1442,16 @@ func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		LastName  string `json:"last_name"`
 		Email     string `json:"email"`
 		Role      string `json:"role"`
+		Status    string `json:"status"`
 	}
 	resp.Error = false
-	resp.Message = "User created successfully"
-	resp.ID = createdUser.ID
-	resp.FirstName = createdUser.FirstName
-	resp.LastName = createdUser.LastName
-	resp.Email = createdUser.Email
-	resp.Role = createdUser.Role
+	resp.Message = "User created, a verification email was sent"
+	resp.ID = user.ID
+	resp.FirstName = user.FirstName
+	resp.LastName = user.LastName
+	resp.Email = user.Email
+	resp.Role = user.Role
+	resp.Status = user.Status
 
 	err = app.writeJSON(w, http.StatusCreated, resp)
 	if err != nil {
@@ -1428,32 +1488,13 @@ func (app *application) SendMessageViaKafka(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Create Kafka producer
-	brokers := []string{"kafka:9092"} // Use Kafka broker from Docker network
-	topic := "email-notifications"
-	producer := messaging.NewProducer(brokers, topic, app.infoLog)
-	defer func() {
-		if closeErr := producer.Close(); closeErr != nil {
-			app.errorLog.Printf("Error closing Kafka producer: %v", closeErr)
-		}
-	}()
-
 	// Create email message with plain text (no template)
 	data := map[string]interface{}{
 		"body": payload.Message,
 	}
 
-	// Publish message to Kafka using SendEmail method
-	ctx := context.Background()
-	err = producer.SendEmail(
-		ctx,
-		"noreply@usualstore.com", // from
-		payload.To,               // to
-		payload.Subject,          // subject
-		"plain",                  // template (we'll need to create this)
-		data,                     // data
-		messaging.PriorityNormal, // priority
-	)
+	// Publish message to Kafka
+	err = app.queueEmail(r.Context(), payload.To, payload.Subject, "plain", data, messaging.PriorityNormal)
 	if err != nil {
 		app.errorLog.Printf("Failed to publish message to Kafka: %v", err)
 		err = app.badRequest(w, r, errors.New("failed to queue message"))
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyHeader carries the API key of machine-to-machine clients
+const apiKeyHeader = "X-API-Key"
+
+const (
+	// defaultAPIKeyRateLimit is how many requests a minute a key may make unless set
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 6000
+	// defaultAPIKeyDays is how long a key lasts unless set
+	defaultAPIKeyDays = 90
+	maxAPIKeyDays     = 365
+)
+
+// authenticateAPIKey returns the key sent in the X-API-Key header and the user
+// it acts as, and records its use. It writes an error response and returns
+// false when the key is unknown, revoked or expired, used from an address it
+// does not allow, or over its rate limit.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request) (*models.User, *models.APIKey, bool) {
+	now := time.Now()
+	key, user, err := app.DB.GetUserForAPIKey(models.HashAPIKey(r.Header.Get(apiKeyHeader)), now)
+	if err != nil {
+		if !errors.Is(err, models.ErrAPIKeyNotFound) {
+			app.errorLog.Println(err)
+		}
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return nil, nil, false
+	}
+
+	ip := clientIP(r)
+	if !key.AllowsIP(ip) {
+		app.errorLog.Printf("api key %d used from %s, which it does not allow", key.ID, ip)
+		app.errorJSON(w, http.StatusForbidden, "this API key may not be used from your address")
+		return nil, nil, false
+	}
+
+	if !GetKeyLimiter(key.ID, key.RateLimit).Allow() {
+		w.Header().Set("Retry-After", "60")
+		app.errorJSON(w, http.StatusTooManyRequests, fmt.Sprintf("this API key is limited to %d requests a minute", key.RateLimit))
+		return nil, nil, false
+	}
+
+	err = app.DB.TouchAPIKey(key.ID, ip, now)
+	if err != nil {
+		app.errorLog.Printf("failed to record use of api key %d: %v", key.ID, err)
+	}
+	return &user, &key, true
+}
+
+// apiKeyScopeAllowed reports whether keys may be given scope p. Keys cannot
+// act on the account of their creator, create further keys or impersonate
+// customers.
+func apiKeyScopeAllowed(p rbac.Permission) bool {
+	return rbac.Valid(p) && p != rbac.PermAccount && p != rbac.PermAPIKeysManage && p != rbac.PermUsersImpersonate
+}
+
+// AllAPIKeys lists every API key, without the keys themselves
+func (app *application) AllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.DB.GetAPIKeys()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, keys)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// CreateAPIKey creates an API key acting as the signed-in admin, limited to
+// the requested scopes. The key is only ever shown in this response.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		_ = app.invalidCredentials(w)
+		return
+	}
+
+	var payload struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		AllowedIPs    []string `json:"allowed_ips"`
+		RateLimit     int      `json:"rate_limit"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if payload.RateLimit == 0 {
+		payload.RateLimit = defaultAPIKeyRateLimit
+	}
+	if payload.ExpiresInDays == 0 {
+		payload.ExpiresInDays = defaultAPIKeyDays
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(payload.Name) != "", "name", "must be provided")
+	v.Check(len(payload.Scopes) > 0, "scopes", "at least one scope is required")
+	scopes := make([]string, 0, len(payload.Scopes))
+	seen := make(map[string]bool, len(payload.Scopes))
+	for _, scope := range payload.Scopes {
+		p := rbac.Permission(scope)
+		v.Check(apiKeyScopeAllowed(p), "scopes", fmt.Sprintf("%q cannot be given to API keys", scope))
+		// Nobody can hand out more than they may do themselves
+		v.Check(rbac.Can(user.Role, p), "scopes", fmt.Sprintf("you do not have %q", scope))
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, allowed := range payload.AllowedIPs {
+		_, _, cidrErr := net.ParseCIDR(allowed)
+		v.Check(cidrErr == nil || net.ParseIP(allowed) != nil, "allowed_ips", fmt.Sprintf("%q is not an IP address or CIDR range", allowed))
+	}
+	v.Check(payload.RateLimit > 0 && payload.RateLimit <= maxAPIKeyRateLimit, "rate_limit",
+		fmt.Sprintf("must be between 1 and %d requests a minute", maxAPIKeyRateLimit))
+	v.Check(payload.ExpiresInDays > 0 && payload.ExpiresInDays <= maxAPIKeyDays, "expires_in_days",
+		fmt.Sprintf("must be between 1 and %d", maxAPIKeyDays))
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	plain, hash, err := models.GenerateAPIKey()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	key := models.APIKey{
+		UserID:     user.ID,
+		Name:       strings.TrimSpace(payload.Name),
+		Prefix:     plain[:len(models.APIKeyPrefix)+8],
+		Scopes:     scopes,
+		AllowedIPs: append([]string{}, payload.AllowedIPs...),
+		RateLimit:  payload.RateLimit,
+		ExpiresAt:  time.Now().Add(time.Duration(payload.ExpiresInDays) * 24 * time.Hour),
+		CreatedAt:  time.Now(),
+	}
+	key.ID, err = app.DB.InsertAPIKey(key, hash)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.infoLog.Printf("user %d created api key %d (%s) with scopes %v", user.ID, key.ID, key.Prefix, key.Scopes)
+	app.audit(r, models.AuditAPIKeyCreate, models.AuditTargetAPIKey, key.ID, nil, key)
+
+	var resp struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Key     string         `json:"key"`
+		APIKey  *models.APIKey `json:"api_key"`
+	}
+	resp.Message = "API key created. Copy it now, it will not be shown again."
+	resp.Key = plain
+	resp.APIKey = &key
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// RevokeAPIKey stops an API key from being used
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid API key ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(id)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			app.errorJSON(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.audit(r, models.AuditAPIKeyRevoke, models.AuditTargetAPIKey, id, nil, nil)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "API key revoked",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
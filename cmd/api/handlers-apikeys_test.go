@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIKey = "usk_TESTKEY"
+
+func expectAPIKey(mock sqlmock.Sqlmock, id int, role, scopes, allowedIPs string, rateLimit int) {
+	now := time.Now()
+	mock.ExpectQuery("FROM api_keys k JOIN users u").
+		WithArgs(models.HashAPIKey(testAPIKey), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "scopes", "allowed_ips", "rate_limit",
+			"expires_at", "last_used_at", "last_used_ip", "revoked_at", "created_at",
+			"id", "first_name", "last_name", "email", "role", "created_at", "updated_at"}).
+			AddRow(id, 3, "ERP", "usk_TESTKEY", scopes, allowedIPs, rateLimit, now.Add(time.Hour), nil, "", nil, now,
+				3, "Jane", "Doe", "jane@example.com", role, now, now))
+}
+
+func TestAuth_APIKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          int
+		role        string
+		scopes      string
+		allowedIPs  string
+		found       bool
+		wantStatus  int
+		wantMessage string
+	}{
+		{"scope granted", 101, "admin", "{shipping:manage}", "{}", true, http.StatusOK, ""},
+		{"scope missing", 102, "admin", "{orders:read}", "{}", true, http.StatusForbidden, "scope shipping:manage required"},
+		{"creator lost the permission", 103, "supporter", "{shipping:manage}", "{}", true, http.StatusForbidden,
+			"permission shipping:manage required"},
+		{"address allowed", 104, "admin", "{shipping:manage}", "{192.0.2.0/24}", true, http.StatusOK, ""},
+		{"address not allowed", 105, "admin", "{shipping:manage}", "{198.51.100.0/24}", true, http.StatusForbidden,
+			"this API key may not be used from your address"},
+		{"unknown, revoked or expired key", 0, "", "", "", false, http.StatusUnauthorized, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			if tt.found {
+				expectAPIKey(mock, tt.id, tt.role, tt.scopes, tt.allowedIPs, 60)
+			} else {
+				mock.ExpectQuery("FROM api_keys k JOIN users u").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			}
+			if tt.found && !strings.Contains(tt.allowedIPs, "198.51.100") {
+				mock.ExpectExec("UPDATE api_keys SET last_used_at = \\$1, last_used_ip = \\$2 WHERE id = \\$3").
+					WithArgs(sqlmock.AnyArg(), fmt.Sprintf("192.0.2.%d", 100+i), tt.id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tt.wantStatus == http.StatusOK {
+				mock.ExpectQuery("SELECT id, name, countries, created_at, updated_at FROM shipping_zones").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "countries", "created_at", "updated_at"}))
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/shipping/zones", nil)
+			r.RemoteAddr = fmt.Sprintf("192.0.2.%d:40000", 100+i)
+			r.Header.Set("User-Agent", "erp-sync/1.0")
+			r.Header.Set(apiKeyHeader, testAPIKey)
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantMessage != "" {
+				assert.Contains(t, w.Body.String(), tt.wantMessage)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuth_APIKeyRateLimit(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		expectAPIKey(mock, 110, "admin", "{shipping:manage}", "{}", 2)
+		if i < 2 {
+			mock.ExpectExec("UPDATE api_keys SET last_used_at").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("FROM shipping_zones").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "countries", "created_at", "updated_at"}))
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/shipping/zones", nil)
+		r.RemoteAddr = "192.0.2.120:40000"
+		r.Header.Set("User-Agent", "erp-sync/1.0")
+		r.Header.Set(apiKeyHeader, testAPIKey)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	admin := &models.User{ID: 3, Role: "admin"}
+	supporter := &models.User{ID: 4, Role: "supporter"}
+
+	tests := []struct {
+		name      string
+		user      *models.User
+		body      string
+		wantField string
+	}{
+		{"missing name", admin, `{"scopes":["orders:read"]}`, "name"},
+		{"no scopes", admin, `{"name":"ERP"}`, "scopes"},
+		{"unknown scope", admin, `{"name":"ERP","scopes":["widgets:launch"]}`, "scopes"},
+		{"account scope", admin, `{"name":"ERP","scopes":["account:self"]}`, "scopes"},
+		{"key management scope", admin, `{"name":"ERP","scopes":["apikeys:manage"]}`, "scopes"},
+		{"scope the creator lacks", supporter, `{"name":"ERP","scopes":["orders:refund"]}`, "scopes"},
+		{"bad allowlist", admin, `{"name":"ERP","scopes":["orders:read"],"allowed_ips":["office"]}`, "allowed_ips"},
+		{"rate limit too high", admin, `{"name":"ERP","scopes":["orders:read"],"rate_limit":100000}`, "rate_limit"},
+		{"expiry too far", admin, `{"name":"ERP","scopes":["orders:read"],"expires_in_days":1000}`, "expires_in_days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/api-keys", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), UserKey, tt.user))
+			w := httptest.NewRecorder()
+			app.CreateAPIKey(w, r)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), `"`+tt.wantField+`"`)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("created and shown once", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("INSERT INTO api_keys").
+			WithArgs(3, "ERP", sqlmock.AnyArg(), sqlmock.AnyArg(), "{\"orders:read\",\"catalog:write\"}",
+				"{\"198.51.100.0/24\"}", 60, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		_, audited := expectAudit(mock, admin.Email, models.AuditAPIKeyCreate, "7")
+
+		body := `{"name":" ERP ","scopes":["orders:read","catalog:write","orders:read"],"allowed_ips":["198.51.100.0/24"]}`
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/api-keys", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), UserKey, admin))
+		w := httptest.NewRecorder()
+		app.CreateAPIKey(w, r)
+
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			Key    string        `json:"key"`
+			APIKey models.APIKey `json:"api_key"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, strings.HasPrefix(resp.Key, resp.APIKey.Prefix))
+		assert.Equal(t, 7, resp.APIKey.ID)
+		assert.WithinDuration(t, time.Now().Add(defaultAPIKeyDays*24*time.Hour), resp.APIKey.ExpiresAt, time.Minute)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Contains(t, string(audited.value.([]byte)), resp.APIKey.Prefix)
+		assert.NotContains(t, string(audited.value.([]byte)), resp.Key)
+	})
+}
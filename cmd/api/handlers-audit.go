@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"usual_store/internal/models"
+)
+
+// auditEvent starts an audit event for action on a target, done by whoever
+// made r: the signed-in user, and the API key they used if any, or the staff
+// member impersonating them
+func (app *application) auditEvent(r *http.Request, action, targetType string, targetID interface{}) models.AuditEvent {
+	e := models.AuditEvent{
+		Actor:      "anonymous",
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		IPAddress:  clientIP(r),
+	}
+	e.TraceID, _ = r.Context().Value(TraceIDKey).(string)
+
+	if user, ok := app.authenticatedUser(r); ok {
+		id := user.ID
+		e.ActorID = &id
+		e.Actor = user.Email
+		if key, ok := app.authenticatedAPIKey(r); ok {
+			e.Actor += " (API key " + key.Prefix + ")"
+		}
+		if imp, ok := app.impersonation(r); ok {
+			id = imp.ActorID
+			e.Actor = imp.ActorEmail + " impersonating " + user.Email
+		}
+	}
+	return e
+}
+
+// recordAudit stores e in the audit log with the fields that differ between
+// before and after, the target before and after the action or nil where it
+// did not exist. The action has already happened, so a failure is logged
+// rather than returned.
+func (app *application) recordAudit(e models.AuditEvent, before, after interface{}) {
+	var err error
+	e.Before, e.After, err = models.AuditDiff(before, after)
+	if err == nil {
+		_, err = app.DB.InsertAuditEvent(e)
+	}
+	if err != nil {
+		app.errorLog.Printf("failed to audit %s of %s %s by %s: %v", e.Action, e.TargetType, e.TargetID, e.Actor, err)
+	}
+}
+
+// audit records in the audit log that whoever made r did action on a target
+func (app *application) audit(r *http.Request, action, targetType string, targetID interface{}, before, after interface{}) {
+	app.recordAudit(app.auditEvent(r, action, targetType, targetID), before, after)
+}
+
+// auditedUser returns the fields of user kept in the audit log
+func auditedUser(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"email":      user.Email,
+		"role":       user.Role,
+		"status":     user.Status,
+	}
+}
+
+// auditFilter reads the audit log query parameters: actor_id, action,
+// target_type, target_id, from and to (RFC 3339 or YYYY-MM-DD, to is
+// inclusive for dates), before_id (the id of the oldest event of the previous
+// page) and limit (1-500).
+func auditFilter(query url.Values) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	if value := query.Get("from"); value != "" {
+		from, err := parseReportTime(value, false)
+		if err != nil {
+			return filter, errors.New("invalid from date: " + err.Error())
+		}
+		filter.Since = from
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := parseReportTime(value, true)
+		if err != nil {
+			return filter, errors.New("invalid to date: " + err.Error())
+		}
+		filter.Until = to
+	}
+
+	if value := query.Get("actor_id"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return filter, errors.New("actor_id must be a positive number")
+		}
+		filter.ActorID = n
+	}
+	if value := query.Get("before_id"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return filter, errors.New("before_id must be a positive number")
+		}
+		filter.BeforeID = n
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			return filter, errors.New("limit must be between 1 and 500")
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+// GetAuditEvents lists the audit log newest first, see auditFilter for the
+// query parameters
+func (app *application) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	events, err := app.DB.GetAuditEvents(filter)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, events)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// VerifyAuditEvents checks the hash chain of the audit log and reports the
+// first event that was tampered with
+func (app *application) VerifyAuditEvents(w http.ResponseWriter, r *http.Request) {
+	report, err := app.DB.VerifyAuditChain()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if !report.Valid {
+		app.errorLog.Printf("audit log tampered with at event %d: %s", report.BrokenAt, report.Problem)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, report)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectAudit expects the first event of the audit log, and returns what is
+// stored as its before and after
+func expectAudit(mock sqlmock.Sqlmock, actor, action, targetID string) (before, after *captured) {
+	before, after = &captured{}, &captured{}
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE audit_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events").WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	mock.ExpectQuery("INSERT INTO audit_events").
+		WithArgs(sqlmock.AnyArg(), actor, action, sqlmock.AnyArg(), targetID, before, after,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	return before, after
+}
+
+func TestAuditEvent_Actor(t *testing.T) {
+	app, _, _ := mfaTestApp(t)
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: "admin"}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/refund", nil)
+	r.RemoteAddr = "192.0.2.1:51234"
+	ctx := context.WithValue(r.Context(), TraceIDKey, "trace-1")
+	e := app.auditEvent(r.WithContext(ctx), models.AuditOrderRefund, models.AuditTargetOrder, 12)
+	assert.Nil(t, e.ActorID)
+	assert.Equal(t, "anonymous", e.Actor)
+	assert.Equal(t, "12", e.TargetID)
+	assert.Equal(t, "192.0.2.1", e.IPAddress)
+	assert.Equal(t, "trace-1", e.TraceID)
+
+	ctx = context.WithValue(ctx, UserKey, admin)
+	e = app.auditEvent(r.WithContext(ctx), models.AuditOrderRefund, models.AuditTargetOrder, 12)
+	require.NotNil(t, e.ActorID)
+	assert.Equal(t, 1, *e.ActorID)
+	assert.Equal(t, "admin@example.com", e.Actor)
+
+	ctx = context.WithValue(ctx, APIKeyKey, &models.APIKey{ID: 3, UserID: 1, Prefix: "usk_ab12cd34"})
+	e = app.auditEvent(r.WithContext(ctx), models.AuditOrderRefund, models.AuditTargetOrder, 12)
+	assert.Equal(t, "admin@example.com (API key usk_ab12cd34)", e.Actor)
+}
+
+func TestDeleteUser_IsAudited(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: "admin"}
+
+	mock.ExpectQuery("FROM users WHERE id").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(signupUserColumns).
+			AddRow(7, "Jane", "Doe", "jane@example.com", "hash", "supporter", time.Now(), time.Now(), models.UserStatusActive))
+	mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	before, after := expectAudit(mock, "admin@example.com", models.AuditUserDelete, "7")
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "7")
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/all-users/delete/7", nil)
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), UserKey, admin))
+	w := httptest.NewRecorder()
+	app.DeleteUser(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var stored map[string]interface{}
+	require.NoError(t, json.Unmarshal(before.value.([]byte), &stored))
+	assert.Equal(t, "jane@example.com", stored["email"])
+	assert.Equal(t, "supporter", stored["role"])
+	assert.NotContains(t, stored, "password")
+	assert.Equal(t, []byte("{}"), after.value)
+}
+
+func TestAuditFilter(t *testing.T) {
+	filter, err := auditFilter(url.Values{
+		"actor_id": {"1"}, "action": {models.AuditUserDelete}, "target_type": {"user"}, "target_id": {"7"},
+		"from": {"2026-10-01"}, "to": {"2026-10-18"}, "before_id": {"120"}, "limit": {"20"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.AuditFilter{
+		ActorID: 1, Action: models.AuditUserDelete, TargetType: "user", TargetID: "7",
+		Since: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), Until: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		BeforeID: 120, Limit: 20,
+	}, filter)
+
+	for _, query := range []string{"actor_id=admin", "before_id=0", "limit=501", "from=yesterday"} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = auditFilter(values)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("FROM audit_events WHERE action = \\$2 ORDER BY id DESC LIMIT \\$1").
+		WithArgs(50, models.AuditOrderRefund).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor_id", "actor", "action", "target_type", "target_id", "before",
+			"after", "ip_address", "trace_id", "created_at", "prev_hash", "hash"}).
+			AddRow(4, 1, "admin@example.com", models.AuditOrderRefund, "order", "12", []byte("{}"),
+				[]byte(`{"amount": 1000}`), "192.0.2.1", "trace-1", time.Now(), []byte{1}, []byte{2}))
+
+	w := httptest.NewRecorder()
+	app.GetAuditEvents(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit-events?action=order.refund", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var events []models.AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, "12", events[0].TargetID)
+	assert.Equal(t, float64(1000), events[0].After["amount"])
+	assert.Equal(t, "02", events[0].Hash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	app.GetAuditEvents(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit-events?limit=all", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "limit"))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"usual_store/internal/models"
+)
+
+// recordCheckout tracks a checkout whose payment intent was just created, so
+// it can be recovered if the customer never completes it. Recovery emails go
+// to the verified email of the signed-in customer, never to an address from
+// the payload, so checkouts of visitors who are not signed in, or without a
+// product, are not tracked.
+func (app *application) recordCheckout(r *http.Request, payload stripePayload, paymentIntent string, amount int) {
+	widgetID, err := strconv.Atoi(payload.ProductID)
+	if err != nil || widgetID == 0 || r.Header.Get("Authorization") == "" {
+		return
+	}
+	user, err := app.authenticateToken(r)
+	if err != nil {
+		return
+	}
+
+	_, err = app.DB.InsertCheckout(models.Checkout{
+		PaymentIntent: paymentIntent,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		WidgetID:      widgetID,
+		Quantity:      1,
+		Amount:        amount,
+		Currency:      payload.Currency,
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// CheckoutRecoveryReport reports how many checkouts of a period were abandoned,
+// reminded and recovered. It takes the same from and to parameters as the
+// sales price report.
+func (app *application) CheckoutRecoveryReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	report, err := app.DB.GetCheckoutRecoveryReport(from, to)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, report)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCheckout(t *testing.T) {
+	payload := stripePayload{Currency: "usd", ProductID: "3", Email: "stranger@example.com", FirstName: "Stranger"}
+	token := strings.Repeat("c", 26)
+
+	t.Run("signed-in customer, at the email of their account", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		repo.EXPECT().GetUserForToken(gomock.Any(), token).
+			Return(&models.User{ID: 3, FirstName: "Jane", Email: "jane@example.com", Role: "user"}, nil)
+		mock.ExpectQuery("INSERT INTO checkout_sessions").
+			WithArgs("pi_1", "jane@example.com", "Jane", 3, 1, 1000, "usd", models.CheckoutStatusOpen, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		r := httptest.NewRequest(http.MethodPost, "/api/payment-intent", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		app.recordCheckout(r, payload, "pi_1", 1000)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("visitor who is not signed in", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+
+		app.recordCheckout(httptest.NewRequest(http.MethodPost, "/api/payment-intent", nil), payload, "pi_1", 1000)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
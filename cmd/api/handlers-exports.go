@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"usual_store/internal/export"
+	"usual_store/internal/models"
+	"usual_store/internal/urlsigner"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	exportWorkers   = 2
+	exportQueueSize = 32
+	// exportLinkMinutes is how long a signed export download link stays valid
+	exportLinkMinutes = 24 * 60
+)
+
+// logExportResults logs the outcome of every export run by the worker pool
+func (app *application) logExportResults() {
+	for result := range app.exportPool.Results() {
+		if result.Success {
+			app.infoLog.Printf("%s completed", result.JobID)
+		} else {
+			app.errorLog.Printf("%s failed: %v", result.JobID, result.Error)
+		}
+	}
+}
+
+// CreateExport starts an asynchronous export of orders, transactions or customers.
+// The filters take the same parameters as the sales search.
+func (app *application) CreateExport(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Kind          string            `json:"kind"`
+		Format        string            `json:"format"`
+		Subscriptions bool              `json:"subscriptions"`
+		Filters       map[string]string `json:"filters"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	_, kindErr := models.ExportColumns(payload.Kind)
+	_, formatOK := export.ContentTypes[payload.Format]
+
+	v := validator.New()
+	v.Check(kindErr == nil, "kind", "must be orders, transactions or customers")
+	v.Check(formatOK, "format", "must be csv, json or xlsx")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	query := url.Values{}
+	for key, value := range payload.Filters {
+		query.Set(key, value)
+	}
+	filter, err := orderFilter(query)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	filter.IsRecurring = payload.Subscriptions
+
+	adminID := admin.ID
+	e := models.Export{
+		Kind:        payload.Kind,
+		Format:      payload.Format,
+		Filter:      filter,
+		Status:      models.ExportStatusPending,
+		RequestedBy: &adminID,
+	}
+	e.ID, err = app.DB.InsertExport(e)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.exportPool.Submit(&export.Job{Export: e, DB: &app.DB, Dir: app.config.exportDir})
+	if err != nil {
+		app.errorLog.Println(err)
+		if failErr := app.DB.FailExport(e.ID, err.Error()); failErr != nil {
+			app.errorLog.Println(failErr)
+		}
+		app.errorJSON(w, http.StatusServiceUnavailable, "too many exports are running, please try again later")
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "export started",
+		ID:      e.ID,
+	}
+	err = app.writeJSON(w, http.StatusAccepted, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetExport returns the status of an export and, once completed, a signed download link
+func (app *application) GetExport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid export ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	e, err := app.DB.GetExport(id)
+	if errors.Is(err, models.ErrExportNotFound) {
+		app.errorJSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		models.Export
+		DownloadURL string `json:"download_url,omitempty"`
+	}
+	resp.Export = e
+	if e.Status == models.ExportStatusCompleted {
+		signer := urlsigner.Signer{
+			Keys: app.keys,
+		}
+		resp.DownloadURL = signer.GenerateTokenFromString(fmt.Sprintf("%s/api/exports/%d/download", app.config.apiURL, e.ID))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// DownloadExport serves an export file to the holder of a signed link from GetExport
+func (app *application) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	signedURL := fmt.Sprintf("%s%s", app.config.apiURL, r.RequestURI)
+	if !signer.VerifyToken(signedURL) || signer.Expired(signedURL, exportLinkMinutes) {
+		app.errorJSON(w, http.StatusForbidden, "invalid or expired download link")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid export ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	e, err := app.DB.GetExport(id)
+	if err != nil || e.Status != models.ExportStatusCompleted {
+		app.errorJSON(w, http.StatusNotFound, models.ErrExportNotFound.Error())
+		return
+	}
+
+	f, err := os.Open(filepath.Join(app.config.exportDir, filepath.Base(e.FileName)))
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusNotFound, "export file is no longer available")
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, "export file is not readable")
+		return
+	}
+
+	w.Header().Set(contentType, export.ContentTypes[e.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, e.FileName))
+	http.ServeContent(w, r, e.FileName, info.ModTime(), f)
+}
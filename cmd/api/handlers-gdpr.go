@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/export"
+	"usual_store/internal/validator"
+)
+
+// gdprRequestsLimit caps the audit trail returned by GetGDPRRequests
+const gdprRequestsLimit = 500
+
+type gdprPayload struct {
+	Email   string `json:"email"`
+	Confirm bool   `json:"confirm"`
+}
+
+// readGDPRPayload reads and validates the data subject of a GDPR request,
+// writing the error response itself when it returns false
+func (app *application) readGDPRPayload(w http.ResponseWriter, r *http.Request, erasure bool) (gdprPayload, bool) {
+	var payload gdprPayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return payload, false
+	}
+	payload.Email = strings.TrimSpace(payload.Email)
+
+	v := validator.New()
+	v.Check(strings.Contains(payload.Email, "@"), "email", "must be a valid email address")
+	if erasure {
+		v.Check(payload.Confirm, "confirm", "must be true to erase personal data")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return payload, false
+	}
+	return payload, true
+}
+
+// SubjectAccess returns everything held about an email address as a zip of JSON files
+func (app *application) SubjectAccess(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	payload, ok := app.readGDPRPayload(w, r, false)
+	if !ok {
+		return
+	}
+
+	data, err := app.DB.CollectSubjectData(payload.Email)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, "failed to collect personal data")
+		return
+	}
+
+	// build the archive in memory so a failure can still be reported as JSON
+	var buf bytes.Buffer
+	summary, err := export.WriteSubjectArchive(&buf, payload.Email, data, time.Now())
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, "failed to build archive")
+		return
+	}
+
+	adminID := admin.ID
+	err = app.DB.RecordSubjectAccess(payload.Email, admin.Email, &adminID, summary)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, "failed to record request")
+		return
+	}
+
+	w.Header().Set(contentType, export.SubjectArchiveContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="subject-access-%s.zip"`, time.Now().Format("20060102-150405")))
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// SubjectErasure anonymises the personal data held about an email address,
+// keeping the financial records required for bookkeeping
+func (app *application) SubjectErasure(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	payload, ok := app.readGDPRPayload(w, r, true)
+	if !ok {
+		return
+	}
+
+	adminID := admin.ID
+	summary, err := app.DB.EraseSubject(payload.Email, admin.Email, &adminID)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, "failed to erase personal data")
+		return
+	}
+
+	resp := struct {
+		OK      bool             `json:"ok"`
+		Message string           `json:"message"`
+		Summary map[string]int64 `json:"summary"`
+	}{
+		OK:      true,
+		Message: "personal data erased",
+		Summary: summary,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetGDPRRequests returns the audit trail of access and erasure requests,
+// optionally only those for the email in the query string
+func (app *application) GetGDPRRequests(w http.ResponseWriter, r *http.Request) {
+	limit := gdprRequestsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > gdprRequestsLimit {
+			err = app.badRequest(w, r, fmt.Errorf("limit must be between 1 and %d", gdprRequestsLimit))
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+		limit = n
+	}
+
+	requests, err := app.DB.GetGDPRRequests(r.URL.Query().Get("email"), limit)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, requests)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
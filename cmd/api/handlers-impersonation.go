@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// impersonationTTL is how long an impersonation token can be used
+const impersonationTTL = 30 * time.Minute
+
+// impersonatedByHeader flags every response to an impersonated request with
+// the staff member acting as the user and the ticket they are working on
+const impersonatedByHeader = "X-Impersonated-By"
+
+// impersonatedBy flags the JSON of an impersonated session with the staff
+// member acting as the user, for clients that do not read headers
+type impersonatedBy struct {
+	Email     string    `json:"email"`
+	TicketID  int       `json:"ticket_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// impersonatedBy returns who is acting as the user of r, or nil when the user
+// made the request themselves
+func (app *application) impersonatedBy(r *http.Request) *impersonatedBy {
+	imp, ok := app.impersonation(r)
+	if !ok {
+		return nil
+	}
+	return &impersonatedBy{Email: imp.ActorEmail, TicketID: imp.TicketID, ExpiresAt: imp.ExpiresAt}
+}
+
+// refuseImpersonated writes an error response and returns true when r is
+// impersonated. GET handlers with side effects call it before making them,
+// as Auth only refuses impersonated requests by their method.
+func (app *application) refuseImpersonated(w http.ResponseWriter, r *http.Request) bool {
+	imp, ok := app.impersonation(r)
+	if !ok {
+		return false
+	}
+	app.errorLog.Printf("user %d tried %s %s with side effects while impersonating user %d", imp.ActorID, r.Method, r.URL.Path, imp.UserID)
+	app.errorJSON(w, http.StatusForbidden, "impersonation is read-only")
+	return true
+}
+
+// impersonationToken returns the bearer token of r when it is an
+// impersonation token
+func impersonationToken(r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || !strings.HasPrefix(token, models.ImpersonationTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
+// authenticateImpersonation returns the user an impersonation token acts as
+// and the impersonation. It writes an error response and returns false when
+// the token is unknown, ended or expired, the ticket it was given for was
+// closed, or r would change anything: impersonation is read-only.
+func (app *application) authenticateImpersonation(w http.ResponseWriter, r *http.Request, token string) (*models.User, *models.Impersonation, bool) {
+	imp, user, err := app.DB.GetUserForImpersonation(models.HashAPIKey(token), time.Now())
+	if err != nil {
+		if !errors.Is(err, models.ErrImpersonationNotFound) {
+			app.errorLog.Println(err)
+		}
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return nil, nil, false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		app.errorLog.Printf("user %d tried %s %s while impersonating user %d", imp.ActorID, r.Method, r.URL.Path, user.ID)
+		app.errorJSON(w, http.StatusForbidden, "impersonation is read-only")
+		return nil, nil, false
+	}
+	return &user, &imp, true
+}
+
+// auditImpersonatedRequest records a request made while impersonating a user
+func (app *application) auditImpersonatedRequest(r *http.Request, user *models.User, imp *models.Impersonation) {
+	app.audit(r, models.AuditImpersonatedRequest, models.AuditTargetUser, user.ID, nil, map[string]interface{}{
+		"impersonation_id": imp.ID,
+		"ticket_id":        imp.TicketID,
+		"method":           r.Method,
+		"path":             r.URL.Path,
+	})
+}
+
+// StartImpersonation gives the signed-in staff member a short-lived,
+// read-only token acting as a customer who has a support ticket open. The
+// token is only ever shown in this response.
+func (app *application) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	actor, ok := app.authenticatedUser(r)
+	if !ok {
+		_ = app.invalidCredentials(w)
+		return
+	}
+
+	var payload struct {
+		UserID   int    `json:"user_id"`
+		TicketID int    `json:"ticket_id"`
+		Reason   string `json:"reason"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.UserID > 0, "user_id", "must be provided")
+	v.Check(payload.TicketID > 0, "ticket_id", "must be provided")
+	v.Check(strings.TrimSpace(payload.Reason) != "", "reason", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.DB.GetUserByID(payload.UserID)
+	if err != nil {
+		app.errorJSON(w, http.StatusNotFound, "user not found")
+		return
+	}
+	// Staff accounts see more than customers do, and are never impersonated
+	if user.Role != rbac.RoleUser {
+		app.errorJSON(w, http.StatusForbidden, "only customers can be impersonated")
+		return
+	}
+
+	open, err := app.DB.HasOpenTicket(payload.TicketID, user.ID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if !open {
+		app.errorJSON(w, http.StatusForbidden, fmt.Sprintf("ticket %d is not an open ticket of this user", payload.TicketID))
+		return
+	}
+
+	token, hash, err := models.GenerateImpersonationToken()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	imp := models.Impersonation{
+		UserID:     user.ID,
+		ActorID:    actor.ID,
+		ActorEmail: actor.Email,
+		TicketID:   payload.TicketID,
+		Reason:     strings.TrimSpace(payload.Reason),
+		ExpiresAt:  time.Now().Add(impersonationTTL),
+		CreatedAt:  time.Now(),
+	}
+	imp.ID, err = app.DB.InsertImpersonation(imp, hash)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.infoLog.Printf("user %d started impersonation %d of user %d for ticket %d", actor.ID, imp.ID, user.ID, imp.TicketID)
+	app.audit(r, models.AuditUserImpersonate, models.AuditTargetUser, user.ID, nil, map[string]interface{}{
+		"impersonation_id": imp.ID,
+		"ticket_id":        imp.TicketID,
+		"reason":           imp.Reason,
+		"expires_at":       imp.ExpiresAt,
+	})
+
+	var resp struct {
+		Error         bool                  `json:"error"`
+		Message       string                `json:"message"`
+		Token         string                `json:"token"`
+		Impersonation *models.Impersonation `json:"impersonation"`
+	}
+	resp.Message = fmt.Sprintf("Read-only access as %s for %s", user.Email, impersonationTTL)
+	resp.Token = token
+	resp.Impersonation = &imp
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// EndImpersonation stops an impersonation token of the signed-in staff
+// member from being used before it expires
+func (app *application) EndImpersonation(w http.ResponseWriter, r *http.Request) {
+	actor, ok := app.authenticatedUser(r)
+	if !ok {
+		_ = app.invalidCredentials(w)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid impersonation ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	imp, err := app.DB.EndImpersonation(id, actor.ID)
+	if errors.Is(err, models.ErrImpersonationNotFound) {
+		app.errorJSON(w, http.StatusNotFound, "impersonation not found or already ended")
+		return
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.audit(r, models.AuditUserImpersonateEnd, models.AuditTargetUser, imp.UserID, nil, map[string]interface{}{
+		"impersonation_id": imp.ID,
+		"ticket_id":        imp.TicketID,
+	})
+
+	resp := jsonResponse{OK: true, Message: "Impersonation ended"}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testImpersonationToken = models.ImpersonationTokenPrefix + "TESTTOKEN"
+
+func impersonationRequest(body string, user *models.User) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/impersonations", strings.NewReader(body))
+	return r.WithContext(context.WithValue(r.Context(), UserKey, user))
+}
+
+func TestStartImpersonation(t *testing.T) {
+	supporter := &models.User{ID: 2, Email: "support@example.com", Role: "supporter"}
+	body := `{"user_id":7,"ticket_id":12,"reason":"checkout shows the wrong total"}`
+
+	expectUser := func(mock sqlmock.Sqlmock, role string) {
+		mock.ExpectQuery("FROM users WHERE id").
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows(signupUserColumns).
+				AddRow(7, "Jane", "Doe", "jane@example.com", "hash", role, time.Now(), time.Now(), models.UserStatusActive))
+	}
+	expectTicket := func(mock sqlmock.Sqlmock, open bool) {
+		mock.ExpectQuery("FROM support_tickets").
+			WithArgs(12, 7).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(open))
+	}
+
+	t.Run("gives a token for an open ticket", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		expectUser(mock, "user")
+		expectTicket(mock, true)
+		mock.ExpectQuery("INSERT INTO impersonations").
+			WithArgs(sqlmock.AnyArg(), 7, 2, 12, "checkout shows the wrong total", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		_, after := expectAudit(mock, "support@example.com", models.AuditUserImpersonate, "7")
+
+		w := httptest.NewRecorder()
+		app.StartImpersonation(w, impersonationRequest(body, supporter))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var resp struct {
+			Token         string               `json:"token"`
+			Impersonation models.Impersonation `json:"impersonation"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, strings.HasPrefix(resp.Token, models.ImpersonationTokenPrefix))
+		assert.Equal(t, 4, resp.Impersonation.ID)
+		assert.WithinDuration(t, time.Now().Add(impersonationTTL), resp.Impersonation.ExpiresAt, time.Minute)
+		assert.Contains(t, string(after.value.([]byte)), `"ticket_id":12`)
+	})
+
+	t.Run("refuses staff", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		expectUser(mock, "admin")
+
+		w := httptest.NewRecorder()
+		app.StartImpersonation(w, impersonationRequest(body, supporter))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses without an open ticket", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		expectUser(mock, "user")
+		expectTicket(mock, false)
+
+		w := httptest.NewRecorder()
+		app.StartImpersonation(w, impersonationRequest(body, supporter))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "not an open ticket")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("requires a reason", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+
+		w := httptest.NewRecorder()
+		app.StartImpersonation(w, impersonationRequest(`{"user_id":7,"ticket_id":12}`, supporter))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "reason")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func expectImpersonation(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM impersonations i JOIN users u").
+		WithArgs(models.HashAPIKey(testImpersonationToken), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "actor_id", "email", "ticket_id", "reason", "expires_at",
+			"created_at", "id", "first_name", "last_name", "email", "role", "status", "created_at", "updated_at"}).
+			AddRow(4, 7, 2, "support@example.com", 12, "checkout shows the wrong total", time.Now().Add(time.Minute),
+				time.Now(), 7, "Jane", "Doe", "jane@example.com", "user", models.UserStatusActive, time.Now(), time.Now()))
+}
+
+func TestAuth_Impersonation(t *testing.T) {
+	t.Run("reads as the user and is audited", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		expectImpersonation(mock)
+		_, after := expectAudit(mock, "support@example.com impersonating jane@example.com",
+			models.AuditImpersonatedRequest, "7")
+		mock.ExpectQuery("FROM store_credits").
+			WithArgs("jane@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1500))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/account/store-credit", nil)
+		r.RemoteAddr = "198.51.100.46:40000"
+		r.Header.Set("User-Agent", "support-console/1.0")
+		r.Header.Set("Authorization", "Bearer "+testImpersonationToken)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.JSONEq(t, `{"balance":1500}`, w.Body.String())
+		assert.Equal(t, "support@example.com (ticket 12)", w.Header().Get(impersonatedByHeader))
+		assert.Contains(t, string(after.value.([]byte)), `"path":"/api/account/store-credit"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("is read-only", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		expectImpersonation(mock)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/account/sessions/logout-all", nil)
+		r.RemoteAddr = "198.51.100.46:40000"
+		r.Header.Set("User-Agent", "support-console/1.0")
+		r.Header.Set("Authorization", "Bearer "+testImpersonationToken)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "read-only")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("ended, expired or ticket closed", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("FROM impersonations i JOIN users u").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		r := httptest.NewRequest(http.MethodGet, "/api/account/store-credit", nil)
+		r.RemoteAddr = "198.51.100.46:40000"
+		r.Header.Set("User-Agent", "support-console/1.0")
+		r.Header.Set("Authorization", "Bearer "+testImpersonationToken)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func impersonatedGet(path string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = "198.51.100.46:40000"
+	r.Header.Set("User-Agent", "support-console/1.0")
+	r.Header.Set("Authorization", "Bearer "+testImpersonationToken)
+	return r
+}
+
+func TestMyAccount_Impersonation(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	expectImpersonation(mock)
+	expectAudit(mock, "support@example.com impersonating jane@example.com", models.AuditImpersonatedRequest, "7")
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, impersonatedGet("/api/account/me"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var resp struct {
+		Email          string          `json:"email"`
+		ImpersonatedBy *impersonatedBy `json:"impersonated_by"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "jane@example.com", resp.Email)
+	require.NotNil(t, resp.ImpersonatedBy)
+	assert.Equal(t, "support@example.com", resp.ImpersonatedBy.Email)
+	assert.Equal(t, 12, resp.ImpersonatedBy.TicketID)
+
+	user := &models.User{ID: 7, Email: "jane@example.com", Role: "user"}
+	r := httptest.NewRequest(http.MethodGet, "/api/account/me", nil)
+	w = httptest.NewRecorder()
+	app.MyAccount(w, r.WithContext(context.WithValue(r.Context(), UserKey, user)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "impersonated_by")
+}
+
+func TestMyOrderInvoice_Impersonation(t *testing.T) {
+	var created atomic.Int32
+	invoices := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			created.Add(1)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer invoices.Close()
+
+	app, mock, _ := mfaTestApp(t)
+	app.config.invoiceURL = invoices.URL
+	expectImpersonation(mock)
+	expectAudit(mock, "support@example.com impersonating jane@example.com", models.AuditImpersonatedRequest, "7")
+	mock.ExpectQuery("WHERE o.id = \\$1").
+		WithArgs(3, "jane@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(make([]string, 24)).
+			AddRow(3, 1, 1, 1, 1, 1, 1000, time.Now(), time.Now(), 1, "Widget", false, 1, 1000, "usd", "4242",
+				12, 2030, "pi_1", "", 1, "Jane", "Doe", "jane@example.com"))
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, impersonatedGet("/api/account/orders/3/invoice"))
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "read-only")
+	assert.Zero(t, created.Load(), "the invoice was generated and emailed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// impersonationReadOnlyRoutes are the GET routes an impersonated session can
+// reach, each reviewed for side effects. Handlers with side effects must call
+// refuseImpersonated before making them.
+var impersonationReadOnlyRoutes = map[string]string{
+	"GET /api/account/me":                  "reads the user",
+	"GET /api/account/orders":              "reads orders",
+	"GET /api/account/orders/{id}":         "reads an order",
+	"GET /api/account/orders/{id}/invoice": "generating a missing invoice emails it, refused",
+	"GET /api/account/subscriptions":       "reads subscriptions",
+	"GET /api/account/subscriptions/{id}":  "reads a subscription",
+	"GET /api/account/returns":             "reads returns",
+	"GET /api/account/store-credit":        "reads the balance",
+	"GET /api/account/sessions":            "reads sessions",
+	"GET /api/account/mfa":                 "reads the MFA status",
+}
+
+func TestImpersonationReadOnlyRoutes(t *testing.T) {
+	for key, permission := range routePermissions {
+		if !strings.HasPrefix(key, http.MethodGet+" ") && !strings.HasPrefix(key, http.MethodHead+" ") {
+			continue
+		}
+		if !rbac.Can(rbac.RoleUser, permission) {
+			continue
+		}
+		_, ok := impersonationReadOnlyRoutes[key]
+		assert.True(t, ok, "%s is reachable while impersonating: review it for side effects and list it", key)
+	}
+	for key := range impersonationReadOnlyRoutes {
+		_, ok := routePermissions[key]
+		assert.True(t, ok, "%s is not a route", key)
+	}
+}
+
+func TestEndImpersonation(t *testing.T) {
+	supporter := &models.User{ID: 2, Email: "support@example.com", Role: "supporter"}
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("UPDATE impersonations SET ended_at").
+		WithArgs(sqlmock.AnyArg(), 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "ticket_id", "reason", "expires_at", "created_at"}).
+			AddRow(7, 12, "checkout shows the wrong total", time.Now().Add(time.Minute), time.Now()))
+	expectAudit(mock, "support@example.com", models.AuditUserImpersonateEnd, "7")
+	mock.ExpectQuery("UPDATE impersonations SET ended_at").
+		WithArgs(sqlmock.AnyArg(), 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "4")
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/impersonations/4/end", nil)
+		r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), UserKey, supporter))
+		w := httptest.NewRecorder()
+		app.EndImpersonation(w, r)
+		assert.Equal(t, want, w.Code, w.Body.String())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"usual_store/internal/models"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AdjustStock adds or removes stock of a widget by hand, e.g. after a stock take
+func (app *application) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Change int    `json:"change"`
+		Note   string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Change != 0, "change", "must not be zero")
+	v.Check(strings.TrimSpace(payload.Note) != "", "note", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	before, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Error(w, "Widget not found", http.StatusNotFound)
+		return
+	}
+
+	adminID := admin.ID
+	change, err := app.DB.AdjustInventory(models.StockAdjustment{
+		WidgetID:  widgetID,
+		Change:    payload.Change,
+		Reason:    models.StockReasonManualAdjustment,
+		Note:      payload.Note,
+		CreatedBy: &adminID,
+	})
+	if errors.Is(err, models.ErrInsufficientStock) {
+		app.errorJSON(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	after := before
+	after.InventoryLevel = change.After
+	app.widgetChanged(before, after)
+
+	var resp struct {
+		WidgetID       int `json:"widget_id"`
+		InventoryLevel int `json:"inventory_level"`
+	}
+	resp.WidgetID = widgetID
+	resp.InventoryLevel = change.After
+
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetStockMovements returns the inventory ledger of a widget (latest 100 entries by default)
+func (app *application) GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 1000 {
+			err = app.badRequest(w, r, errors.New("limit must be between 1 and 1000"))
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+	}
+
+	movements, err := app.DB.GetStockMovements(widgetID, limit)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if movements == nil {
+		movements = []*models.StockMovement{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, movements)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetStockSettings returns the low-stock threshold and restock settings of a widget
+func (app *application) GetStockSettings(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	settings, err := app.DB.GetStockSettings(widgetID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, settings)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// SaveStockSettings sets the low-stock threshold, reorder quantity and supplier of a widget
+func (app *application) SaveStockSettings(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var settings models.StockSettings
+	err = app.readJSON(w, r, &settings)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	settings.WidgetID = widgetID
+
+	v := validator.New()
+	v.Check(settings.LowStockThreshold >= 0, "low_stock_threshold", "must not be negative")
+	v.Check(settings.ReorderQuantity >= 0, "reorder_quantity", "must not be negative")
+	v.Check(settings.ReorderQuantity == 0 || settings.SupplierID != nil, "supplier_id", "must be provided with a reorder quantity")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.SaveStockSettings(settings)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, settings)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetSuppliers lists all suppliers
+func (app *application) GetSuppliers(w http.ResponseWriter, r *http.Request) {
+	suppliers, err := app.DB.GetAllSuppliers()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if suppliers == nil {
+		suppliers = []*models.Supplier{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, suppliers)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateSupplier adds a supplier
+func (app *application) CreateSupplier(w http.ResponseWriter, r *http.Request) {
+	var supplier models.Supplier
+	err := app.readJSON(w, r, &supplier)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(supplier.Name) != "", "name", "must be provided")
+	v.Check(supplier.Email == "" || strings.Contains(supplier.Email, "@"), "email", "must be a valid email address")
+	v.Check(supplier.LeadTimeDays >= 0, "lead_time_days", "must not be negative")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	id, err := app.DB.InsertSupplier(supplier)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "supplier created",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetPurchaseOrders lists purchase orders, optionally filtered with ?status=
+func (app *application) GetPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := app.DB.GetPurchaseOrders(r.URL.Query().Get("status"))
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if orders == nil {
+		orders = []*models.PurchaseOrder{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, orders)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetPurchaseOrder returns a purchase order with its items
+func (app *application) GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid purchase order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	po, err := app.DB.GetPurchaseOrder(id)
+	if err != nil {
+		app.purchaseOrderError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, po)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreatePurchaseOrder drafts a purchase order
+func (app *application) CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		SupplierID int    `json:"supplier_id"`
+		Notes      string `json:"notes"`
+		Items      []struct {
+			WidgetID int `json:"widget_id"`
+			Quantity int `json:"quantity"`
+			UnitCost int `json:"unit_cost"`
+		} `json:"items"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.SupplierID > 0, "supplier_id", "must be provided")
+	v.Check(len(payload.Items) > 0, "items", "must contain at least one item")
+	seen := make(map[int]bool)
+	for _, item := range payload.Items {
+		v.Check(item.Quantity > 0, "items", "quantities must be greater than zero")
+		v.Check(item.UnitCost >= 0, "items", "unit costs must not be negative")
+		v.Check(!seen[item.WidgetID], "items", fmt.Sprintf("widget %d is listed more than once", item.WidgetID))
+		seen[item.WidgetID] = true
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	adminID := admin.ID
+	po := models.PurchaseOrder{
+		SupplierID: payload.SupplierID,
+		Notes:      payload.Notes,
+		CreatedBy:  &adminID,
+	}
+	for _, item := range payload.Items {
+		po.Items = append(po.Items, &models.PurchaseOrderItem{
+			WidgetID:        item.WidgetID,
+			QuantityOrdered: item.Quantity,
+			UnitCost:        item.UnitCost,
+		})
+	}
+
+	id, err := app.DB.CreatePurchaseOrder(po)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "purchase order drafted",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// MarkPurchaseOrderOrdered records that a draft purchase order was sent to the supplier
+func (app *application) MarkPurchaseOrderOrdered(w http.ResponseWriter, r *http.Request) {
+	app.transitionPurchaseOrder(w, r, app.DB.MarkPurchaseOrderOrdered, "ordered")
+}
+
+// CancelPurchaseOrder cancels a purchase order nothing was received for
+func (app *application) CancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionPurchaseOrder(w, r, app.DB.CancelPurchaseOrder, "cancelled")
+}
+
+func (app *application) transitionPurchaseOrder(w http.ResponseWriter, r *http.Request, transition func(int) error, status string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid purchase order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = transition(id)
+	if err != nil {
+		app.purchaseOrderError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("purchase order %d %s", id, status),
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ReceivePurchaseOrder books a full or partial delivery of a purchase order.
+// An empty body receives everything still outstanding.
+func (app *application) ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid purchase order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Items []models.ReceiptLine `json:"items"`
+	}
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			err = app.badRequest(w, r, err)
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+	}
+
+	changes, err := app.DB.ReceivePurchaseOrder(id, payload.Items, admin.ID)
+	if err != nil {
+		app.purchaseOrderError(w, r, err)
+		return
+	}
+
+	for _, change := range changes {
+		after, err := app.DB.GetWidget(change.WidgetID)
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+		before := after
+		before.InventoryLevel = change.Before
+		after.InventoryLevel = change.After
+		app.widgetChanged(before, after)
+	}
+
+	po, err := app.DB.GetPurchaseOrder(id)
+	if err != nil {
+		app.purchaseOrderError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, po)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// purchaseOrderError writes the response for a failed purchase order operation
+func (app *application) purchaseOrderError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrPurchaseOrderNotFound) {
+		app.errorJSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	err = app.badRequest(w, r, err)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"usual_store/internal/lockout"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/urlsigner"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// suspiciousIPAccounts is how many different accounts failing to sign in from
+// one IP address, within lockout.IP.LockFor, look like credential stuffing
+const suspiciousIPAccounts = 10
+
+// loginAllowed checks that sign-in attempts from the request's IP address, and
+// on userID unless it is zero, are not held back after earlier failures. If
+// they are, it writes a 429 response with Retry-After and returns false.
+func (app *application) loginAllowed(w http.ResponseWriter, r *http.Request, userID int) bool {
+	now := time.Now()
+
+	failures, err := app.DB.GetIPLoginFailures(clientIP(r), now.Add(-lockout.IP.LockFor))
+	if err != nil {
+		// Failing closed would let a database hiccup lock everyone out
+		app.errorLog.Println(err)
+	} else if wait := lockout.IP.RetryAfter(failures.Failures, failures.LastFailure, now); wait > 0 {
+		app.tooManyAttempts(w, wait, "too many failed sign-in attempts from your network")
+		return false
+	}
+
+	if userID == 0 {
+		return true
+	}
+
+	state, err := app.DB.GetLoginState(userID)
+	if err != nil {
+		app.errorLog.Println(err)
+		return true
+	}
+	if state.Locked(now) {
+		app.tooManyAttempts(w, state.LockedUntil.Sub(now),
+			"this account is locked after too many failed sign-in attempts; check your email to unlock it")
+		return false
+	}
+	if next := state.LastFailedAt.Add(lockout.Account.Delay(state.FailedLogins)); next.After(now) {
+		app.tooManyAttempts(w, next.Sub(now), "too many failed sign-in attempts")
+		return false
+	}
+	return true
+}
+
+// tooManyAttempts writes a 429 response telling the client when to try again
+func (app *application) tooManyAttempts(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.errorJSON(w, http.StatusTooManyRequests, fmt.Sprintf("%s, try again in %d seconds", message, seconds))
+}
+
+// loginFailed records a failed password or second factor for email; user is
+// the zero User when no account has the email. The owner is emailed when the
+// failure locks their account, and admins when the IP address is trying many
+// accounts.
+func (app *application) loginFailed(r *http.Request, email string, user models.User) {
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     email,
+		UserID:    user.ID,
+		IPAddress: clientIP(r),
+		UserAgent: r.Header.Get("User-Agent"),
+	}
+
+	state, locked, err := app.DB.RecordLoginFailure(attempt, now, lockout.Account.LockAfter, now.Add(lockout.Account.LockFor))
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	if locked {
+		app.infoLog.Printf("account %d locked after %d failed sign-ins, the last from %s", user.ID, state.FailedLogins, attempt.IPAddress)
+		go app.sendAccountLockedEmail(user, state, attempt.IPAddress)
+	}
+
+	failures, err := app.DB.GetIPLoginFailures(attempt.IPAddress, now.Add(-lockout.IP.LockFor))
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	if failures.Accounts == suspiciousIPAccounts {
+		app.errorLog.Printf("%s failed to sign in to %d accounts", attempt.IPAddress, failures.Accounts)
+		go app.sendLoginAttackAlert(attempt.IPAddress, failures)
+	}
+}
+
+// loginSucceeded records that user signed in, clearing their failures. The
+// user is alerted when enough failures preceded it to have been held back.
+func (app *application) loginSucceeded(r *http.Request, user models.User) {
+	attempt := models.LoginAttempt{
+		Email:     user.Email,
+		UserID:    user.ID,
+		IPAddress: clientIP(r),
+		UserAgent: r.Header.Get("User-Agent"),
+	}
+
+	failures, err := app.DB.RecordLoginSuccess(attempt, time.Now())
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	if failures > lockout.Account.FreeAttempts {
+		go app.sendSuspiciousLoginEmail(user, failures, attempt)
+	}
+}
+
+func (app *application) sendAccountLockedEmail(user models.User, state models.LoginState, ip string) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+	link := fmt.Sprintf("%s/unlock-account?email=%s", app.config.frontend, url.QueryEscape(user.Email))
+
+	data := map[string]interface{}{
+		"FirstName":   user.FirstName,
+		"Attempts":    state.FailedLogins,
+		"IPAddress":   ip,
+		"LockedUntil": state.LockedUntil.Format("Jan 2, 2006 15:04 MST"),
+		"Link":        signer.GenerateTokenFromString(link),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := app.queueEmail(ctx, user.Email, "Your account has been locked", "account-locked", data, messaging.PriorityHigh)
+	if err != nil {
+		app.errorLog.Printf("failed to queue account locked email for user %d: %v", user.ID, err)
+	}
+}
+
+func (app *application) sendSuspiciousLoginEmail(user models.User, failures int, attempt models.LoginAttempt) {
+	data := map[string]interface{}{
+		"FirstName": user.FirstName,
+		"Failures":  failures,
+		"IPAddress": attempt.IPAddress,
+		"Device":    deviceLabel(attempt.UserAgent),
+		"Time":      time.Now().Format("Jan 2, 2006 15:04 MST"),
+		"Link":      fmt.Sprintf("%s/forgot-password", app.config.frontend),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := app.queueEmail(ctx, user.Email, "New sign-in after failed attempts", "suspicious-login", data, messaging.PriorityHigh)
+	if err != nil {
+		app.errorLog.Printf("failed to queue suspicious sign-in email for user %d: %v", user.ID, err)
+	}
+}
+
+func (app *application) sendLoginAttackAlert(ip string, failures models.IPLoginFailures) {
+	recipients, err := app.DB.GetAdminEmails()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"IPAddress": ip,
+		"Failures":  failures.Failures,
+		"Accounts":  failures.Accounts,
+		"Window":    lockout.IP.LockFor.String(),
+	}
+	subject := fmt.Sprintf("Possible credential stuffing from %s", ip)
+	for _, to := range recipients {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = app.queueEmail(ctx, to, subject, "login-attack-alert", data, messaging.PriorityHigh)
+		cancel()
+		if err != nil {
+			app.errorLog.Printf("failed to queue login attack alert to %s: %v", to, err)
+		}
+	}
+}
+
+// UnlockUserAccount clears the failed sign-ins and lockout of a user
+func (app *application) UnlockUserAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid user ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.DB.UnlockUser(id)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			app.errorJSON(w, http.StatusNotFound, "user not found")
+			return
+		}
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.audit(r, models.AuditUserUnlock, models.AuditTargetUser, id, nil, nil)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "account unlocked",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectIPLoginFailures(mock sqlmock.Sqlmock, failures, accounts int, last interface{}) {
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), MAX\\(created_at\\), COUNT\\(DISTINCT email\\) FROM login_attempts").
+		WithArgs("192.0.2.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max", "count"}).AddRow(failures, last, accounts))
+}
+
+func expectLoginState(mock sqlmock.Sqlmock, userID, failures int, lastFailed, lockedUntil interface{}) {
+	mock.ExpectQuery("SELECT failed_logins, last_failed_login_at, locked_until FROM users").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_logins", "last_failed_login_at", "locked_until"}).
+			AddRow(failures, lastFailed, lockedUntil))
+}
+
+// expectLoginAllowed expects the checks of a sign-in to an account without failures
+func expectLoginAllowed(mock sqlmock.Sqlmock, userID int) {
+	expectIPLoginFailures(mock, 0, 0, nil)
+	expectLoginState(mock, userID, 0, nil, nil)
+}
+
+func expectLoginSucceeded(mock sqlmock.Sqlmock, userID int) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE users u SET failed_logins = 0").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_logins"}).AddRow(0))
+	mock.ExpectCommit()
+}
+
+func expectLoginFailed(mock sqlmock.Sqlmock, userID, failures int) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+	if userID != 0 {
+		mock.ExpectQuery("UPDATE users u SET failed_logins = u.failed_logins \\+ 1").
+			WithArgs(sqlmock.AnyArg(), 10, sqlmock.AnyArg(), userID).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_logins", "locked_until", "locked_until"}).
+				AddRow(failures, nil, nil))
+	}
+	mock.ExpectCommit()
+	expectIPLoginFailures(mock, failures, 1, time.Now())
+}
+
+func authenticateRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/authenticate", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:51234"
+	return r
+}
+
+func TestCreateAuthToken_Lockout(t *testing.T) {
+	hash := testPasswordHash(t, "secret")
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "role", "created_at", "updated_at", "status"}).
+			AddRow(3, "Jane", "Doe", "jane@example.com", hash, "user", time.Now(), time.Now(), "active")
+	}
+
+	t.Run("locked account is refused before the password is checked", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+			WithArgs("jane@example.com", sqlmock.AnyArg()).
+			WillReturnRows(userRows())
+		expectIPLoginFailures(mock, 0, 0, nil)
+		expectLoginState(mock, 3, 10, time.Now(), time.Now().Add(20*time.Minute))
+
+		w := httptest.NewRecorder()
+		app.CreateAuthToken(w, authenticateRequest(`{"email":"jane@example.com","password":"secret"}`))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.InDelta(t, 20*60, retryAfter, 2)
+		assert.Contains(t, w.Body.String(), "locked")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("repeated failures are delayed", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+			WithArgs("jane@example.com", sqlmock.AnyArg()).
+			WillReturnRows(userRows())
+		expectIPLoginFailures(mock, 5, 1, time.Now())
+		expectLoginState(mock, 3, 5, time.Now(), nil)
+
+		w := httptest.NewRecorder()
+		app.CreateAuthToken(w, authenticateRequest(`{"email":"jane@example.com","password":"secret"}`))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("throttled address is refused for unknown emails too", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+			WithArgs("nobody@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		expectIPLoginFailures(mock, 100, 60, time.Now())
+
+		w := httptest.NewRecorder()
+		app.CreateAuthToken(w, authenticateRequest(`{"email":"nobody@example.com","password":"secret"}`))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong password is counted", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+			WithArgs("jane@example.com", sqlmock.AnyArg()).
+			WillReturnRows(userRows())
+		expectLoginAllowed(mock, 3)
+		expectLoginFailed(mock, 3, 1)
+
+		w := httptest.NewRecorder()
+		app.CreateAuthToken(w, authenticateRequest(`{"email":"jane@example.com","password":"wrong"}`))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown email is counted for the address", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+			WithArgs("nobody@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		expectIPLoginFailures(mock, 0, 0, nil)
+		expectLoginFailed(mock, 0, 1)
+
+		w := httptest.NewRecorder()
+		app.CreateAuthToken(w, authenticateRequest(`{"email":"nobody@example.com","password":"secret"}`))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUnlockUserAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		affected   int64
+		wantStatus int
+	}{
+		{"unlocked", 1, http.StatusOK},
+		{"no such user", 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			mock.ExpectExec("UPDATE users SET failed_logins = 0").
+				WithArgs(3).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.affected > 0 {
+				expectAudit(mock, "anonymous", models.AuditUserUnlock, "3")
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "3")
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/all-users/3/unlock", nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+			app.UnlockUserAccount(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/oidc"
+	"usual_store/internal/rbac"
+	"usual_store/internal/urlsigner"
+)
+
+// magicLinkMinutes is how long a magic sign-in link stays valid
+const magicLinkMinutes = 15
+
+// magicLink returns a signed link to the magic-link page of the frontend that
+// signs in the user with email once. The nonce tells links apart, so each can
+// be consumed on its own.
+func (app *application) magicLink(email string) (string, error) {
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+	link := fmt.Sprintf("%s/magic-link?email=%s&nonce=%s", app.config.frontend, url.QueryEscape(email), nonce)
+	return signer.GenerateTokenFromString(link), nil
+}
+
+// parseMagicLink checks a link made by magicLink and returns its email and
+// nonce
+func (app *application) parseMagicLink(link string) (string, string, error) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+	if !signer.VerifyToken(link) {
+		return "", "", models.ErrSignupLinkInvalid
+	}
+	if signer.Expired(link, magicLinkMinutes) {
+		return "", "", errSignupLinkExpired
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", models.ErrSignupLinkInvalid
+	}
+	email, nonce := u.Query().Get("email"), u.Query().Get("nonce")
+	if email == "" || nonce == "" {
+		return "", "", models.ErrSignupLinkInvalid
+	}
+	return email, nonce, nil
+}
+
+func (app *application) sendMagicLinkEmail(user models.User, link string) {
+	data := map[string]interface{}{
+		"FirstName": user.FirstName,
+		"Link":      link,
+		"Minutes":   magicLinkMinutes,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := app.queueEmail(ctx, user.Email, "Your Usual Store sign-in link", "magic-link", data, messaging.PriorityHigh)
+	if err != nil {
+		app.errorLog.Printf("failed to queue sign-in link for user %d: %v", user.ID, err)
+	}
+}
+
+// magicLinkUser returns the active user with email when they may sign in
+// with a magic link
+func (app *application) magicLinkUser(email string) (models.User, bool) {
+	user, err := app.DB.GetUserByEmail(email)
+	if err != nil || user.Status != models.UserStatusActive || rbac.RequiresMFA(user.Role) {
+		return user, false
+	}
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err != nil {
+		app.errorLog.Println(err)
+		return user, false
+	}
+	return user, !mfa.Enabled
+}
+
+// RequestMagicLink emails a single-use sign-in link to a customer, who then
+// does not need their password. Staff and users with two-factor
+// authentication sign in with their password or single sign-on. It answers
+// the same whether or not there is such a user.
+func (app *application) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	if user, ok := app.magicLinkUser(payload.Email); ok {
+		link, err := app.magicLink(user.Email)
+		if err != nil {
+			app.errorLog.Println(err)
+		} else {
+			app.sendMagicLinkEmail(user, link)
+		}
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "if the address belongs to an account, a sign-in link was sent to it",
+	}
+	err = app.writeJSON(w, http.StatusAccepted, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// MagicLinkLogin exchanges a magic sign-in link for auth tokens, as
+// CreateAuthToken does for a password. Each link works once: its nonce is
+// consumed here, not when the page it points to is opened, so mail scanners
+// following links do not use it up.
+func (app *application) MagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token  string `json:"token"`
+		Device string `json:"device"` // optional name of the device signing in
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	email, nonce, err := app.parseMagicLink(payload.Token)
+	if errors.Is(err, errSignupLinkExpired) {
+		app.errorJSON(w, http.StatusBadRequest, "this sign-in link has expired, please ask for a new one")
+		return
+	} else if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, "this sign-in link is invalid")
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(email)
+	if err != nil || user.Status != models.UserStatusActive {
+		app.errorJSON(w, http.StatusBadRequest, "this sign-in link is invalid")
+		return
+	}
+
+	// Locked accounts and throttled addresses cannot use links either
+	if !app.loginAllowed(w, r, user.ID) {
+		return
+	}
+
+	now := time.Now()
+	err = app.DB.ConsumeMagicLinkNonce(nonce, user.ID, now.Add(magicLinkMinutes*time.Minute), now)
+	if errors.Is(err, models.ErrMagicLinkUsed) {
+		app.loginFailed(r, email, user)
+		app.errorJSON(w, http.StatusBadRequest, "this sign-in link was already used, please ask for a new one")
+		return
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	// A link stands in for the password only, never for the second factor
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if mfa.Enabled || rbac.RequiresMFA(user.Role) {
+		app.challengeMFA(w, r, user, mfa, payload.Device)
+		return
+	}
+
+	app.issueAuthTokens(w, r, user, payload.Device, nil)
+}
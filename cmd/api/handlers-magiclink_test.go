@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expectUserByEmail(mock sqlmock.Sqlmock, email, role, status string) {
+	mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+		WithArgs(email, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "role", "created_at", "updated_at", "status"}).
+			AddRow(3, "Jane", "Doe", email, "hash", role, time.Now(), time.Now(), status))
+}
+
+func magicLinkRequest(t *testing.T, token string) *http.Request {
+	body, err := json.Marshal(map[string]string{"token": token})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/api/magic-link/login", strings.NewReader(string(body)))
+	r.RemoteAddr = "192.0.2.1:51234"
+	return r
+}
+
+func TestMagicLinkUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		expect  func(mock sqlmock.Sqlmock)
+		allowed bool
+	}{
+		{"customer", func(mock sqlmock.Sqlmock) {
+			expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusActive)
+			expectUserMFA(mock, 3, "", false)
+		}, true},
+		{"customer with two-factor authentication", func(mock sqlmock.Sqlmock) {
+			expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusActive)
+			expectUserMFA(mock, 3, "secret", true)
+		}, false},
+		{"staff", func(mock sqlmock.Sqlmock) {
+			expectUserByEmail(mock, "jane@example.com", "supporter", models.UserStatusActive)
+		}, false},
+		{"email not verified", func(mock sqlmock.Sqlmock) {
+			expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusPending)
+		}, false},
+		{"unknown email", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("FROM users").WithArgs("jane@example.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			tt.expect(mock)
+
+			_, ok := app.magicLinkUser("jane@example.com")
+			assert.Equal(t, tt.allowed, ok)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRequestMagicLink_SameAnswerForUnknownEmail(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("FROM users").WithArgs("nobody@example.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := httptest.NewRecorder()
+	body := `{"email":"nobody@example.com"}`
+	app.RequestMagicLink(w, httptest.NewRequest(http.MethodPost, "/api/magic-link", strings.NewReader(body)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseMagicLink(t *testing.T) {
+	app := &application{config: config{frontend: "https://store.example.com"}, keys: testKeyring(t, mfaTestKey)}
+	link, err := app.magicLink("jane+shop@example.com")
+	require.NoError(t, err)
+	other, err := app.magicLink("jane+shop@example.com")
+	require.NoError(t, err)
+
+	email, nonce, err := app.parseMagicLink(link)
+	require.NoError(t, err)
+	assert.Equal(t, "jane+shop@example.com", email)
+	_, otherNonce, err := app.parseMagicLink(other)
+	require.NoError(t, err)
+	assert.NotEqual(t, nonce, otherNonce)
+
+	tampered := strings.Replace(link, url.QueryEscape("jane+shop@example.com"), "admin%40example.com", 1)
+	_, _, err = app.parseMagicLink(tampered)
+	assert.ErrorIs(t, err, models.ErrSignupLinkInvalid)
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	t.Run("signs in once", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		link, err := app.magicLink("jane@example.com")
+		require.NoError(t, err)
+		_, nonce, err := app.parseMagicLink(link)
+		require.NoError(t, err)
+
+		expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusActive)
+		expectLoginAllowed(mock, 3)
+		mock.ExpectExec("DELETE FROM magic_link_nonces").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO magic_link_nonces").
+			WithArgs(nonce, 3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectUserMFA(mock, 3, "", false)
+		expectLoginSucceeded(mock, 3)
+		repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+		// The same link again
+		expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusActive)
+		expectLoginAllowed(mock, 3)
+		mock.ExpectExec("DELETE FROM magic_link_nonces").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO magic_link_nonces").
+			WithArgs(nonce, 3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectLoginFailed(mock, 3, 1)
+
+		w := httptest.NewRecorder()
+		app.MagicLinkLogin(w, magicLinkRequest(t, link))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.NotNil(t, resp["authentication_token"])
+
+		w = httptest.NewRecorder()
+		app.MagicLinkLogin(w, magicLinkRequest(t, link))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "already used")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("still asks for the second factor", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		link, err := app.magicLink("jane@example.com")
+		require.NoError(t, err)
+
+		expectUserByEmail(mock, "jane@example.com", "user", models.UserStatusActive)
+		expectLoginAllowed(mock, 3)
+		mock.ExpectExec("DELETE FROM magic_link_nonces").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO magic_link_nonces").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectUserMFA(mock, 3, "secret", true)
+		repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+		w := httptest.NewRecorder()
+		app.MagicLinkLogin(w, magicLinkRequest(t, link))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, true, resp["mfa_required"])
+		assert.Nil(t, resp["authentication_token"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("link of another secret", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		other := &application{keys: testKeyring(t, "another-secret-key-of-32-bytes!!")}
+		link, err := other.magicLink("jane@example.com")
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		app.MagicLinkLogin(w, magicLinkRequest(t, link))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
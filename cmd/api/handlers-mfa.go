@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"usual_store/internal/encryption"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/internal/totp"
+	"usual_store/pkg/repository"
+	"usual_store/pkg/service"
+)
+
+const (
+	// totpIssuer names the store in authenticator apps
+	totpIssuer = "Usual Store"
+	// recoveryCodeCount is how many recovery codes a user is given at a time
+	recoveryCodeCount = 10
+)
+
+var errInvalidMFACode = errors.New("invalid two-factor authentication code")
+
+// issueAuthTokens signs user in on the device the request came from and
+// writes the tokens. Wishlists of the anonymous session of the request's
+// wishlist cookie move to the user. recoveryCodes are included when enrolment
+// just completed.
+func (app *application) issueAuthTokens(w http.ResponseWriter, r *http.Request, user models.User, device string, recoveryCodes []string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	// Call the TokenService to create and store a short-lived access token and a refresh token
+	token, refreshToken, err := app.tokenService.CreateTokenPair(ctx, user, tokenDevice(r, device))
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.loginSucceeded(r, user)
+
+	// Move wishlists saved while browsing anonymously to the user
+	if sessionID := anonymousSession(r); sessionID != "" {
+		err = app.DB.MergeSessionWishlists(sessionID, user.ID)
+		if err != nil {
+			app.errorLog.Printf("failed to merge wishlists of user %d: %v", user.ID, err)
+		} else {
+			clearAnonymousSession(w)
+		}
+	}
+
+	var payload struct {
+		Error         bool          `json:"error"`
+		Message       string        `json:"message"`
+		Token         *models.Token `json:"authentication_token"`
+		RefreshToken  *models.Token `json:"refresh_token"`
+		ID            int           `json:"id"`
+		FirstName     string        `json:"first_name"`
+		LastName      string        `json:"last_name"`
+		Email         string        `json:"email"`
+		Role          string        `json:"role"`
+		RecoveryCodes []string      `json:"recovery_codes,omitempty"`
+	}
+	payload.Error = false
+	payload.Message = fmt.Sprintf("Token for user %s created.", user.Email)
+	payload.Token = token
+	payload.RefreshToken = refreshToken
+	payload.ID = user.ID
+	payload.FirstName = user.FirstName
+	payload.LastName = user.LastName
+	payload.Email = user.Email
+	payload.Role = user.Role
+	payload.RecoveryCodes = recoveryCodes
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// challengeMFA answers a correct password of a user who must also present a
+// second factor with a short-lived mfa_pending token instead of signing them in.
+func (app *application) challengeMFA(w http.ResponseWriter, r *http.Request, user models.User, mfa models.UserMFA, device string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	token, err := app.tokenService.CreateToken(ctx, user, service.MFAPendingTokenTTL, models.ScopeMFAPending, tokenDevice(r, device))
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Error       bool          `json:"error"`
+		Message     string        `json:"message"`
+		MFARequired bool          `json:"mfa_required"`
+		MFAEnrolled bool          `json:"mfa_enrolled"`
+		MFAToken    *models.Token `json:"mfa_token"`
+	}
+	payload.MFARequired = true
+	payload.MFAEnrolled = mfa.Enabled
+	payload.MFAToken = token
+	if mfa.Enabled {
+		payload.Message = "Enter the code from your authenticator app."
+	} else {
+		payload.Message = "Two-factor authentication must be set up for this account."
+	}
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// pendingMFAUser returns the user whose password was accepted for mfaToken.
+// It writes an error response and returns false when the token is not valid.
+func (app *application) pendingMFAUser(w http.ResponseWriter, r *http.Request, mfaToken string) (*models.User, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	user, err := app.tokenService.GetUserForScopedToken(ctx, mfaToken, models.ScopeMFAPending)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// consumeMFAToken invalidates mfaToken. It returns false, after writing an
+// error response, when the token was used or expired meanwhile.
+func (app *application) consumeMFAToken(w http.ResponseWriter, r *http.Request, mfaToken string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	_, err := app.tokenService.ConsumeToken(ctx, mfaToken, models.ScopeMFAPending)
+	if err != nil {
+		if !errors.Is(err, repository.ErrTokenNotFound) {
+			app.errorLog.Println(err)
+		}
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return false
+	}
+	return true
+}
+
+// checkSecondFactor verifies a code from the authenticator app, or failing
+// that a recovery code, of a user with two-factor authentication enabled.
+// Each code is accepted once.
+func (app *application) checkSecondFactor(userID int, mfa models.UserMFA, code, recoveryCode string) error {
+	if !mfa.Enabled {
+		return errInvalidMFACode
+	}
+
+	if recoveryCode != "" {
+		ok, err := app.DB.UseRecoveryCode(userID, totp.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	encryptor := encryption.Encryption{Keys: app.keys}
+	secret, err := encryptor.Decrypt(mfa.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt TOTP secret of user %d: %w", userID, err)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return errInvalidMFACode
+	}
+	ok, err = app.DB.UseTOTPStep(userID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes they are stored as
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// startEnrolment gives user a new TOTP secret and writes the otpauth URI and
+// QR code to set up an authenticator app with.
+func (app *application) startEnrolment(w http.ResponseWriter, r *http.Request, user models.User) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+
+	// The secret is stored encrypted and only shown now, to set up the app
+	encryptor := encryption.Encryption{Keys: app.keys}
+	encrypted, err := encryptor.Encrypt(secret)
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+	err = app.DB.SetTOTPSecret(user.ID, encrypted)
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+
+	uri := totp.URI(totpIssuer, user.Email, secret)
+	png, err := totp.QRCode(uri)
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error  bool   `json:"error"`
+		URI    string `json:"otpauth_uri"`
+		Secret string `json:"secret"`
+		QRCode []byte `json:"qr_png"` // base64 in JSON
+	}
+	resp.URI = uri
+	resp.Secret = secret
+	resp.QRCode = png
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// completeEnrolment enables two-factor authentication for userID once code
+// proves the authenticator app was set up, and returns new recovery codes.
+func (app *application) completeEnrolment(userID int, code string) ([]string, error) {
+	mfa, err := app.DB.GetUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+	if mfa.Secret == "" {
+		return nil, errors.New("two-factor enrolment has not been started")
+	}
+
+	encryptor := encryption.Encryption{Keys: app.keys}
+	secret, err := encryptor.Decrypt(mfa.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret of user %d: %w", userID, err)
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = app.DB.EnableTOTP(userID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// mfaError writes the response for an error of a second factor check
+func (app *application) mfaError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidMFACode):
+		app.errorJSON(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, models.ErrMFAAlreadyEnabled):
+		app.errorJSON(w, http.StatusConflict, err.Error())
+	default:
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}
+}
+
+// VerifyMFA completes a sign-in that is waiting for a second factor: a code
+// from the authenticator app or a recovery code. A wrong code invalidates the
+// pending sign-in, so guessing codes requires the password every time.
+func (app *application) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		Device       string `json:"device"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	user, ok := app.pendingMFAUser(w, r, payload.MFAToken)
+	if !ok || !app.loginAllowed(w, r, user.ID) {
+		return
+	}
+
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err == nil {
+		err = app.checkSecondFactor(user.ID, mfa, payload.Code, payload.RecoveryCode)
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			app.errorLog.Printf("invalid second factor for user %d from %s", user.ID, clientIP(r))
+			app.loginFailed(r, user.Email, *user)
+			ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+			defer cancel()
+			_, _ = app.tokenService.ConsumeToken(ctx, payload.MFAToken, models.ScopeMFAPending)
+		}
+		app.mfaError(w, r, err)
+		return
+	}
+
+	if !app.consumeMFAToken(w, r, payload.MFAToken) {
+		return
+	}
+	app.issueAuthTokens(w, r, *user, payload.Device, nil)
+}
+
+// EnrolMFAPending starts two-factor enrolment for a user who must use it
+// before they can sign in.
+func (app *application) EnrolMFAPending(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	user, ok := app.pendingMFAUser(w, r, payload.MFAToken)
+	if !ok {
+		return
+	}
+	app.startEnrolment(w, r, *user)
+}
+
+// ConfirmMFAPending completes two-factor enrolment started at sign-in and
+// signs the user in, returning their recovery codes with the tokens.
+func (app *application) ConfirmMFAPending(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+		Device   string `json:"device"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	user, ok := app.pendingMFAUser(w, r, payload.MFAToken)
+	if !ok || !app.loginAllowed(w, r, user.ID) {
+		return
+	}
+
+	codes, err := app.completeEnrolment(user.ID, payload.Code)
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			app.loginFailed(r, user.Email, *user)
+		}
+		app.mfaError(w, r, err)
+		return
+	}
+
+	if !app.consumeMFAToken(w, r, payload.MFAToken) {
+		return
+	}
+	app.issueAuthTokens(w, r, *user, payload.Device, codes)
+}
+
+// MyMFA reports whether the authenticated user uses two-factor authentication
+func (app *application) MyMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		Enabled           bool `json:"enabled"`
+		Required          bool `json:"required"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+	resp.Enabled = mfa.Enabled
+	resp.Required = rbac.RequiresMFA(user.Role)
+	resp.RecoveryCodesLeft = mfa.RecoveryCodesLeft
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// EnrolMyMFA starts two-factor enrolment for the authenticated user
+func (app *application) EnrolMyMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	app.startEnrolment(w, r, *user)
+}
+
+// ConfirmMyMFA completes two-factor enrolment of the authenticated user and
+// returns their recovery codes.
+func (app *application) ConfirmMyMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	codes, err := app.completeEnrolment(user.ID, payload.Code)
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+	app.writeRecoveryCodes(w, codes)
+}
+
+// DisableMyMFA turns two-factor authentication off for the authenticated
+// user, on presenting a current code. Roles that require it cannot.
+func (app *application) DisableMyMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	if rbac.RequiresMFA(user.Role) {
+		app.errorJSON(w, http.StatusForbidden, fmt.Sprintf("two-factor authentication is required for role %s", user.Role))
+		return
+	}
+
+	var payload struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err == nil {
+		err = app.checkSecondFactor(user.ID, mfa, payload.Code, payload.RecoveryCode)
+	}
+	if err == nil {
+		err = app.DB.DisableTOTP(user.ID)
+	}
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "two-factor authentication disabled",
+		ID:      user.ID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// RegenerateMyRecoveryCodes replaces the recovery codes of the authenticated
+// user, on presenting a current code, and returns the new ones.
+func (app *application) RegenerateMyRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	mfa, err := app.DB.GetUserMFA(user.ID)
+	if err == nil {
+		err = app.checkSecondFactor(user.ID, mfa, payload.Code, "")
+	}
+	var codes []string
+	if err == nil {
+		var hashes [][]byte
+		codes, hashes, err = newRecoveryCodes()
+		if err == nil {
+			err = app.DB.ReplaceRecoveryCodes(user.ID, hashes)
+		}
+	}
+	if err != nil {
+		app.mfaError(w, r, err)
+		return
+	}
+	app.writeRecoveryCodes(w, codes)
+}
+
+func (app *application) writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	var resp struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	resp.Message = "Store these recovery codes somewhere safe. Each can be used once instead of a code from your app."
+	resp.RecoveryCodes = codes
+	err := app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/encryption"
+	"usual_store/internal/keyring"
+	"usual_store/internal/mocks"
+	"usual_store/internal/models"
+	"usual_store/internal/password"
+	"usual_store/internal/totp"
+	"usual_store/pkg/repository"
+	"usual_store/pkg/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mfaTestKey   = "0123456789abcdef0123456789abcdef"
+	mfaTestToken = "PENDINGTOKEN00000000000000"
+)
+
+func mfaTestApp(t *testing.T) (*application, sqlmock.Sqlmock, *mocks.MockTokenRepository) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	repo := mocks.NewMockTokenRepository(gomock.NewController(t))
+	app := &application{
+		config:         config{secretkey: mfaTestKey},
+		infoLog:        log.New(io.Discard, "", 0),
+		errorLog:       log.New(io.Discard, "", 0),
+		DB:             models.DBModel{DB: db},
+		tokenService:   *service.NewTokenService(repo),
+		passwordPolicy: password.NewPolicy(password.MinLength),
+		keys:           testKeyring(t, mfaTestKey),
+	}
+	app.config.passwords.params = testPasswordParams
+	return app, mock, repo
+}
+
+// testKeyring returns a keyring of secret alone, as the API makes without
+// SECRET_KEYS
+func testKeyring(t *testing.T, secret string) *keyring.Keyring {
+	keys, err := keyring.Parse("", secret)
+	require.NoError(t, err)
+	return keys
+}
+
+// testPasswordParams keep password hashing fast in tests
+var testPasswordParams = password.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// testPasswordHash returns the hash of plain as mfaTestApp makes them
+func testPasswordHash(t *testing.T, plain string) string {
+	hash, err := password.Hash(plain, testPasswordParams)
+	require.NoError(t, err)
+	return hash
+}
+
+func expectUserMFA(mock sqlmock.Sqlmock, userID int, secret string, enabled bool) {
+	mock.ExpectQuery("SELECT totp_secret, totp_enabled, totp_last_step").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled", "totp_last_step", "count"}).
+			AddRow(secret, enabled, int64(0), 10))
+}
+
+func TestCreateAuthToken_RequiresSecondFactor(t *testing.T) {
+	hash := testPasswordHash(t, "secret")
+
+	tests := []struct {
+		name         string
+		role         string
+		enabled      bool
+		wantMFA      bool
+		wantEnrolled bool
+	}{
+		{"admin without 2FA must enrol", "admin", false, true, false},
+		{"supporter with 2FA", "supporter", true, true, true},
+		{"customer who opted in", "user", true, true, true},
+		{"customer without 2FA", "user", false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, repo := mfaTestApp(t)
+
+			mock.ExpectQuery("SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users").
+				WithArgs("jane@example.com", sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "role", "created_at", "updated_at", "status"}).
+					AddRow(3, "Jane", "Doe", "jane@example.com", hash, tt.role, time.Now(), time.Now(), "active"))
+			expectLoginAllowed(mock, 3)
+			expectUserMFA(mock, 3, "", tt.enabled)
+			if !tt.wantMFA {
+				expectLoginSucceeded(mock, 3)
+			}
+
+			var scopes []string
+			repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ interface{}, token *models.Token, _ models.User) error {
+					scopes = append(scopes, token.Scope)
+					return nil
+				}).AnyTimes()
+
+			body := `{"email":"jane@example.com","password":"secret"}`
+			w := httptest.NewRecorder()
+			app.CreateAuthToken(w, authenticateRequest(body))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var resp map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			if tt.wantMFA {
+				assert.Equal(t, true, resp["mfa_required"])
+				assert.Equal(t, tt.wantEnrolled, resp["mfa_enrolled"])
+				assert.NotNil(t, resp["mfa_token"])
+				assert.Nil(t, resp["authentication_token"])
+				assert.Equal(t, []string{models.ScopeMFAPending}, scopes)
+			} else {
+				assert.Nil(t, resp["mfa_required"])
+				assert.NotNil(t, resp["authentication_token"])
+				assert.Equal(t, []string{models.ScopeAuthentication, models.ScopeRefresh}, scopes)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	secret, err := totp.NewSecret()
+	require.NoError(t, err)
+	encryptor := encryption.Encryption{Key: []byte(mfaTestKey)}
+	encrypted, err := encryptor.Encrypt(secret)
+	require.NoError(t, err)
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	admin := &models.User{ID: 3, Email: "jane@example.com", Role: "admin"}
+
+	t.Run("valid code signs in", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		repo.EXPECT().GetUserForScopedToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+		expectLoginAllowed(mock, 3)
+		expectUserMFA(mock, 3, encrypted, true)
+		mock.ExpectExec("UPDATE users SET totp_last_step").
+			WithArgs(sqlmock.AnyArg(), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		repo.EXPECT().ConsumeToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+		repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+		expectLoginSucceeded(mock, 3)
+
+		body := `{"mfa_token":"` + mfaTestToken + `","code":"` + code + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/api/mfa/verify", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:51234"
+		w := httptest.NewRecorder()
+		app.VerifyMFA(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"authentication_token"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("replayed code is rejected and ends the sign-in", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		repo.EXPECT().GetUserForScopedToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+		expectLoginAllowed(mock, 3)
+		expectUserMFA(mock, 3, encrypted, true)
+		mock.ExpectExec("UPDATE users SET totp_last_step").
+			WithArgs(sqlmock.AnyArg(), 3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectLoginFailed(mock, 3, 1)
+		repo.EXPECT().ConsumeToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+
+		body := `{"mfa_token":"` + mfaTestToken + `","code":"` + code + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/api/mfa/verify", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:51234"
+		w := httptest.NewRecorder()
+		app.VerifyMFA(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotContains(t, w.Body.String(), `"authentication_token"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("recovery code signs in", func(t *testing.T) {
+		app, mock, repo := mfaTestApp(t)
+		repo.EXPECT().GetUserForScopedToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+		expectLoginAllowed(mock, 3)
+		expectUserMFA(mock, 3, encrypted, true)
+		mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at").
+			WithArgs(sqlmock.AnyArg(), 3, totp.HashRecoveryCode("abcde-fghij")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		repo.EXPECT().ConsumeToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).Return(admin, nil)
+		repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+		expectLoginSucceeded(mock, 3)
+
+		body := `{"mfa_token":"` + mfaTestToken + `","recovery_code":"ABCDE-FGHIJ"}`
+		r := httptest.NewRequest(http.MethodPost, "/api/mfa/verify", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:51234"
+		w := httptest.NewRecorder()
+		app.VerifyMFA(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown pending token", func(t *testing.T) {
+		app, _, repo := mfaTestApp(t)
+		repo.EXPECT().GetUserForScopedToken(gomock.Any(), mfaTestToken, models.ScopeMFAPending).
+			Return(nil, repository.ErrTokenNotFound)
+
+		body := `{"mfa_token":"` + mfaTestToken + `","code":"` + code + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/api/mfa/verify", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:51234"
+		w := httptest.NewRecorder()
+		app.VerifyMFA(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/oidc"
+	"usual_store/internal/rbac"
+)
+
+const (
+	// oidcLoginTTL is how long a user has to sign in at the identity provider
+	oidcLoginTTL = 10 * time.Minute
+	// ssoOnlyPassword is stored as the password of users created by single
+	// sign-on. It is not a password hash, so no password matches it.
+	ssoOnlyPassword = "!sso"
+)
+
+// oidcRolePrecedence orders the roles a user in several groups may get; the first wins
+var oidcRolePrecedence = []string{rbac.RoleSuperAdmin, rbac.RoleAdmin, rbac.RoleSupporter, rbac.RoleUser}
+
+// parseGroupRoles parses a comma-separated list of group=role pairs
+func parseGroupRoles(s string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || !slices.Contains(oidcRolePrecedence, role) {
+			return nil, fmt.Errorf("invalid group role mapping %q", pair)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// oidcRole returns the role of a user in groups, or "" when no group may sign in
+func (app *application) oidcRole(groups []string) string {
+	granted := make(map[string]bool)
+	for _, group := range groups {
+		if role, ok := app.config.oidc.groupRoles[group]; ok {
+			granted[role] = true
+		}
+	}
+	for _, role := range oidcRolePrecedence {
+		if granted[role] {
+			return role
+		}
+	}
+	return ""
+}
+
+// oidcRedirectAllowed reports whether the identity provider may send users back to uri
+func (app *application) oidcRedirectAllowed(uri string) bool {
+	return slices.Contains(app.config.oidc.redirectURIs, uri)
+}
+
+func hashState(state string) []byte {
+	hash := sha256.Sum256([]byte(state))
+	return hash[:]
+}
+
+// StartOIDCLogin begins single sign-on with the company identity provider.
+// It returns the URL to send the user to, which redirects back to
+// redirect_uri with a state and code for FinishOIDCLogin.
+func (app *application) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	app.startOIDC(w, r, nil)
+}
+
+// StartOIDCLink begins linking the authenticated user's account to their
+// identity at the company identity provider, after which they sign in with
+// it. It works like StartOIDCLogin; FinishOIDCLogin completes the link.
+func (app *application) StartOIDCLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if app.refuseImpersonated(w, r) {
+		return
+	}
+	if _, ok := app.authenticatedAPIKey(r); ok {
+		app.errorJSON(w, http.StatusForbidden, "single sign-on can only be linked by the signed-in user")
+		return
+	}
+
+	userID := user.ID
+	app.startOIDC(w, r, &userID)
+}
+
+// startOIDC sends the user to the identity provider to sign in, or to link
+// the account of linkUserID when it is not nil
+func (app *application) startOIDC(w http.ResponseWriter, r *http.Request, linkUserID *int) {
+	if app.oidc == nil {
+		app.errorJSON(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	var payload struct {
+		RedirectURI string `json:"redirect_uri"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if !app.oidcRedirectAllowed(payload.RedirectURI) {
+		app.errorJSON(w, http.StatusBadRequest, "redirect_uri is not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	state, authURL, err := app.beginOIDCLogin(ctx, payload.RedirectURI, linkUserID)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, http.StatusBadGateway, "single sign-on is unavailable, please try again later")
+		return
+	}
+
+	var resp struct {
+		Error            bool   `json:"error"`
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+	}
+	resp.AuthorizationURL = authURL
+	resp.State = state
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// beginOIDCLogin stores a new sign-in with its own state, nonce and PKCE
+// verifier, and returns the state and the URL to send the user to
+func (app *application) beginOIDCLogin(ctx context.Context, redirectURI string, linkUserID *int) (string, string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	login := models.OIDCLogin{RedirectURI: redirectURI, ExpiresAt: time.Now().Add(oidcLoginTTL), LinkUserID: linkUserID}
+	login.Nonce, err = oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+	login.CodeVerifier = verifier
+
+	authURL, err := app.oidc.AuthCodeURL(ctx, redirectURI, state, login.Nonce, challenge)
+	if err != nil {
+		return "", "", err
+	}
+	err = app.DB.InsertOIDCLogin(hashState(state), login)
+	if err != nil {
+		return "", "", err
+	}
+	return state, authURL, nil
+}
+
+// FinishOIDCLogin completes single sign-on with the state and code the
+// identity provider redirected back with. The user is created on their first
+// sign-in and gets the role of their groups at the identity provider, which
+// also takes care of their second factor. An existing user with the same
+// email has to link the identity from their account first, with
+// StartOIDCLink; those sign-ins finish by linking instead of signing in.
+func (app *application) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	var payload struct {
+		State  string `json:"state"`
+		Code   string `json:"code"`
+		Device string `json:"device"` // optional name of the device signing in
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	login, err := app.DB.ConsumeOIDCLogin(hashState(payload.State), time.Now())
+	if err != nil {
+		if !errors.Is(err, models.ErrOIDCLoginNotFound) {
+			app.errorLog.Println(err)
+		}
+		app.errorJSON(w, http.StatusUnauthorized, "this sign-in has expired, please try again")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	claims, err := app.oidc.Exchange(ctx, payload.Code, login.RedirectURI, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		app.errorLog.Printf("single sign-on from %s failed: %v", clientIP(r), err)
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		app.errorJSON(w, http.StatusForbidden, "the identity provider did not share a verified email address")
+		return
+	}
+	role := app.oidcRole(claims.Groups)
+	if role == "" {
+		app.errorLog.Printf("single sign-on of %s refused: no group maps to a role", claims.Email)
+		app.errorJSON(w, http.StatusForbidden, "your account is not in a group allowed to sign in here")
+		return
+	}
+
+	identity := models.OIDCIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+	}
+	if login.LinkUserID != nil {
+		app.linkOIDCIdentity(w, r, *login.LinkUserID, identity)
+		return
+	}
+
+	user, previousRole, err := app.DB.ProvisionOIDCUser(identity, role, ssoOnlyPassword, time.Now())
+	switch {
+	case errors.Is(err, models.ErrOIDCEmailNotVerified):
+		app.errorJSON(w, http.StatusForbidden, "the identity provider did not share a verified email address")
+		return
+	case errors.Is(err, models.ErrOIDCUserNotActive):
+		app.errorLog.Printf("single sign-on of %s refused: account is not active", claims.Email)
+		app.errorJSON(w, http.StatusForbidden, "your account is not active")
+		return
+	case errors.Is(err, models.ErrOIDCAccountNotLinked):
+		app.errorJSON(w, http.StatusConflict,
+			"an account with this email already exists, sign in with your password and link single sign-on from your account")
+		return
+	case err != nil:
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	// The identity provider decided, the user only signed in
+	switch previousRole {
+	case "":
+		app.infoLog.Printf("created user %d (%s) with role %s on first single sign-on", user.ID, user.Email, user.Role)
+		e := app.auditEvent(r, models.AuditUserCreate, models.AuditTargetUser, user.ID)
+		e.Actor = "sso:" + claims.Issuer
+		app.recordAudit(e, nil, auditedUser(user))
+	case user.Role:
+	default:
+		e := app.auditEvent(r, models.AuditUserRoleChange, models.AuditTargetUser, user.ID)
+		e.Actor = "sso:" + claims.Issuer
+		app.recordAudit(e, map[string]interface{}{"role": previousRole}, map[string]interface{}{"role": user.Role})
+	}
+
+	app.issueAuthTokens(w, r, user, payload.Device, nil)
+}
+
+// linkOIDCIdentity links identity to the account of userID, who started
+// linking it with StartOIDCLink and stays signed in as before
+func (app *application) linkOIDCIdentity(w http.ResponseWriter, r *http.Request, userID int, identity models.OIDCIdentity) {
+	err := app.DB.LinkOIDCIdentity(userID, identity, time.Now())
+	if errors.Is(err, models.ErrOIDCIdentityLinked) {
+		app.errorJSON(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	e := app.auditEvent(r, models.AuditUserLinkIdentity, models.AuditTargetUser, userID)
+	e.Actor = "sso:" + identity.Issuer
+	app.recordAudit(e, nil, map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject})
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Message = "Single sign-on is linked to your account."
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
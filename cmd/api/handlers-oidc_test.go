@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/oidc"
+	"usual_store/internal/oidc/oidctest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oidcTestRedirect = "https://store.example.com/auth/oidc/callback"
+
+// captured is an sqlmock argument that matches anything and keeps it
+type captured struct{ value driver.Value }
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+// oidcTestApp returns an app signing in with a local identity provider
+func oidcTestApp(t *testing.T) (*application, sqlmock.Sqlmock, *oidctest.Server) {
+	app, mock, repo := mfaTestApp(t)
+	repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	srv := oidctest.NewServer("usual-store", "s3cret")
+	t.Cleanup(srv.Close)
+	srv.SetUser(oidctest.User{
+		Subject:       "00u1",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		GivenName:     "Jane",
+		FamilyName:    "Doe",
+		Groups:        []string{"everyone", "store-support"},
+	})
+
+	app.config.oidc.redirectURIs = []string{oidcTestRedirect}
+	app.config.oidc.groupRoles = map[string]string{"store-admins": "admin", "store-support": "supporter"}
+	app.oidc = oidc.NewProvider(oidc.Config{Issuer: srv.Issuer(), ClientID: "usual-store", ClientSecret: "s3cret"})
+	return app, mock, srv
+}
+
+// startOIDCLogin starts single sign-on and signs in at the identity provider.
+// It returns the state and code to finish with, after expecting the stored
+// sign-in to be consumed.
+func startOIDCLogin(t *testing.T, app *application, mock sqlmock.Sqlmock, srv *oidctest.Server) (string, string) {
+	return startOIDC(t, app, mock, srv, nil)
+}
+
+// startOIDC is startOIDCLogin, or starts linking the account of user when it
+// is not nil
+func startOIDC(t *testing.T, app *application, mock sqlmock.Sqlmock, srv *oidctest.Server, user *models.User) (string, string) {
+	nonce, verifier := &captured{}, &captured{}
+	var linkUserID interface{}
+	if user != nil {
+		linkUserID = user.ID
+	}
+	mock.ExpectExec("DELETE FROM oidc_logins WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO oidc_logins").
+		WithArgs(sqlmock.AnyArg(), nonce, verifier, oidcTestRedirect, sqlmock.AnyArg(), linkUserID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := httptest.NewRecorder()
+	body := `{"redirect_uri":"` + oidcTestRedirect + `"}`
+	if user != nil {
+		r := httptest.NewRequest(http.MethodPost, "/api/account/sso/link", strings.NewReader(body))
+		app.StartOIDCLink(w, r.WithContext(context.WithValue(r.Context(), UserKey, user)))
+	} else {
+		app.StartOIDCLogin(w, httptest.NewRequest(http.MethodPost, "/api/oidc/start", strings.NewReader(body)))
+	}
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	code, state, err := srv.Authorize(resp.AuthorizationURL)
+	require.NoError(t, err)
+	assert.Equal(t, resp.State, state)
+
+	mock.ExpectQuery("DELETE FROM oidc_logins WHERE state_hash = \\$1 RETURNING").
+		WithArgs(hashState(state)).
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier", "redirect_uri", "expires_at", "link_user_id"}).
+			AddRow(nonce.value, verifier.value, oidcTestRedirect, time.Now().Add(oidcLoginTTL), linkUserID))
+	return state, code
+}
+
+func finishOIDCLogin(app *application, state, code string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	body := `{"state":"` + state + `","code":"` + code + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/oidc/callback", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:51234"
+	app.FinishOIDCLogin(w, r)
+	return w
+}
+
+func TestOIDCLogin_ProvisionsUser(t *testing.T) {
+	app, mock, srv := oidcTestApp(t)
+	state, code := startOIDCLogin(t, app, mock, srv)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM user_identities i JOIN users u").
+		WithArgs(srv.Issuer(), "00u1").
+		WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE email_index = \\$2 OR LOWER\\(email\\)").
+		WithArgs("jane@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("Jane", "Doe", "jane@example.com", sqlmock.AnyArg(), ssoOnlyPassword, "supporter", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("INSERT INTO user_identities").
+		WithArgs(9, srv.Issuer(), "00u1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	_, created := expectAudit(mock, "sso:"+srv.Issuer(), models.AuditUserCreate, "9")
+	expectLoginSucceeded(mock, 9)
+
+	w := finishOIDCLogin(app, state, code)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotNil(t, resp["authentication_token"])
+	assert.Equal(t, "supporter", resp["role"])
+	assert.Nil(t, resp["mfa_required"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Contains(t, string(created.value.([]byte)), `"role":"supporter"`)
+
+	// The state cannot be used twice
+	mock.ExpectQuery("DELETE FROM oidc_logins WHERE state_hash").WillReturnRows(sqlmock.NewRows(nil))
+	w = finishOIDCLogin(app, state, code)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOIDCLogin_Refused(t *testing.T) {
+	t.Run("no group with a role", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		srv.SetUser(oidctest.User{Subject: "00u2", Email: "joe@example.com", EmailVerified: true, Groups: []string{"everyone"}})
+		state, code := startOIDCLogin(t, app, mock, srv)
+
+		w := finishOIDCLogin(app, state, code)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		srv.SetUser(oidctest.User{Subject: "00u2", Email: "joe@example.com", Groups: []string{"store-admins"}})
+		state, code := startOIDCLogin(t, app, mock, srv)
+
+		w := finishOIDCLogin(app, state, code)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("account with the same email not linked", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		state, code := startOIDCLogin(t, app, mock, srv)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM user_identities i JOIN users u").WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		w := finishOIDCLogin(app, state, code)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), "authentication_token")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("account not active", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		state, code := startOIDCLogin(t, app, mock, srv)
+
+		now := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM user_identities i JOIN users u").
+			WillReturnRows(sqlmock.NewRows(make([]string, 8)).
+				AddRow(3, "Jane", "Doe", "jane@example.com", "user", models.UserStatusPending, now, now))
+		mock.ExpectRollback()
+
+		w := finishOIDCLogin(app, state, code)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("forged code", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		state, _ := startOIDCLogin(t, app, mock, srv)
+
+		w := finishOIDCLogin(app, state, "forged")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("redirect URI not allowed", func(t *testing.T) {
+		app, _, _ := oidcTestApp(t)
+		w := httptest.NewRecorder()
+		body := `{"redirect_uri":"https://evil.example.com/cb"}`
+		app.StartOIDCLogin(w, httptest.NewRequest(http.MethodPost, "/api/oidc/start", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		app, _, _ := mfaTestApp(t)
+		w := httptest.NewRecorder()
+		app.StartOIDCLogin(w, httptest.NewRequest(http.MethodPost, "/api/oidc/start", strings.NewReader(`{}`)))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestOIDCLink(t *testing.T) {
+	user := &models.User{ID: 3, Email: "jane@example.com", Role: "user"}
+
+	t.Run("links the signed-in user", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		state, code := startOIDC(t, app, mock, srv, user)
+
+		mock.ExpectExec("INSERT INTO user_identities .* ON CONFLICT \\(issuer, subject\\) DO NOTHING").
+			WithArgs(3, srv.Issuer(), "00u1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		_, linked := expectAudit(mock, "sso:"+srv.Issuer(), models.AuditUserLinkIdentity, "3")
+
+		w := finishOIDCLogin(app, state, code)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), "authentication_token")
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Contains(t, string(linked.value.([]byte)), `"subject":"00u1"`)
+	})
+
+	t.Run("identity linked to someone else", func(t *testing.T) {
+		app, mock, srv := oidcTestApp(t)
+		state, code := startOIDC(t, app, mock, srv, user)
+
+		mock.ExpectExec("INSERT INTO user_identities").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w := finishOIDCLogin(app, state, code)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not while impersonating", func(t *testing.T) {
+		app, mock, _ := oidcTestApp(t)
+		expectImpersonation(mock)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/account/sso/link", strings.NewReader(`{"redirect_uri":"`+oidcTestRedirect+`"}`))
+		r.RemoteAddr = "198.51.100.61:40000"
+		r.Header.Set("User-Agent", "support-console/1.0")
+		r.Header.Set("Authorization", "Bearer "+testImpersonationToken)
+		app.routes().ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "read-only")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOIDCRole(t *testing.T) {
+	app := &application{}
+	app.config.oidc.groupRoles = map[string]string{"store-admins": "admin", "store-support": "supporter", "owners": "super_admin"}
+
+	tests := []struct {
+		groups []string
+		want   string
+	}{
+		{[]string{"store-support", "store-admins"}, "admin"},
+		{[]string{"owners", "store-support"}, "super_admin"},
+		{[]string{"store-support"}, "supporter"},
+		{[]string{"everyone"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, app.oidcRole(tt.groups), tt.groups)
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	roles, err := parseGroupRoles(" store-admins=admin, store-support = supporter,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"store-admins": "admin", "store-support": "supporter"}, roles)
+
+	_, err = parseGroupRoles("store-admins=root")
+	assert.Error(t, err)
+	_, err = parseGroupRoles("store-admins")
+	assert.Error(t, err)
+}
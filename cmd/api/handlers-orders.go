@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"usual_store/internal/models"
+)
+
+// SearchSales searches one-off orders, see orderFilter for the query parameters
+func (app *application) SearchSales(w http.ResponseWriter, r *http.Request) {
+	app.searchOrders(w, r, false)
+}
+
+// SearchSubscriptions searches subscriptions, see orderFilter for the query parameters
+func (app *application) SearchSubscriptions(w http.ResponseWriter, r *http.Request) {
+	app.searchOrders(w, r, true)
+}
+
+func (app *application) searchOrders(w http.ResponseWriter, r *http.Request, isRecurring bool) {
+	filter, err := orderFilter(r.URL.Query())
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	filter.IsRecurring = isRecurring
+
+	result, err := app.DB.SearchOrders(filter)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var response struct {
+		PageSize int `json:"page_size"`
+		models.OrderSearchResult
+	}
+	response.PageSize = filter.PageSize
+	response.OrderSearchResult = result
+
+	err = app.writeJSON(w, http.StatusOK, response)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// orderFilter reads the admin order search query parameters: q (customer name
+// or email, only the whole email once they are encrypted), from and to (RFC
+// 3339 or YYYY-MM-DD, to is inclusive for dates), status, min_amount and
+// max_amount (in cents), widget_id, last_four, sort_by (not first_name,
+// last_name or email once they are encrypted), sort_order (asc or desc),
+// page_size (1-100) and cursor (the next_cursor of the previous page).
+func orderFilter(query url.Values) (models.OrderFilter, error) {
+	filter := models.OrderFilter{
+		Query:     query.Get("q"),
+		LastFour:  query.Get("last_four"),
+		SortBy:    query.Get("sort_by"),
+		SortOrder: query.Get("sort_order"),
+		Cursor:    query.Get("cursor"),
+		PageSize:  10,
+	}
+
+	if value := query.Get("from"); value != "" {
+		from, err := parseReportTime(value, false)
+		if err != nil {
+			return filter, errors.New("invalid from date: " + err.Error())
+		}
+		filter.From = &from
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := parseReportTime(value, true)
+		if err != nil {
+			return filter, errors.New("invalid to date: " + err.Error())
+		}
+		filter.To = &to
+	}
+
+	ints := []struct {
+		name string
+		min  int
+		dest *int
+	}{
+		{"status", 1, &filter.StatusID},
+		{"widget_id", 1, &filter.WidgetID},
+		{"page_size", 1, &filter.PageSize},
+	}
+	for _, p := range ints {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < p.min {
+			return filter, errors.New(p.name + " must be a positive number")
+		}
+		*p.dest = n
+	}
+	if filter.PageSize > 100 {
+		return filter, errors.New("page_size must be between 1 and 100")
+	}
+
+	for name, dest := range map[string]**int{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return filter, errors.New(name + " must not be negative")
+		}
+		*dest = &n
+	}
+
+	if filter.LastFour != "" {
+		if _, err := strconv.Atoi(filter.LastFour); err != nil || len(filter.LastFour) != 4 {
+			return filter, errors.New("last_four must be four digits")
+		}
+	}
+	return filter, nil
+}
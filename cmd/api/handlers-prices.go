@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CreatePriceSchedule plans a price change for a widget, e.g. a weekend sale.
+// Without ends_at the new price stays in effect once applied.
+func (app *application) CreatePriceSchedule(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Price    int        `json:"price"`
+		StartsAt time.Time  `json:"starts_at"`
+		EndsAt   *time.Time `json:"ends_at"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Price > 0, "price", "must be greater than zero")
+	v.Check(!payload.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(payload.EndsAt == nil || payload.EndsAt.After(payload.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(payload.EndsAt == nil || payload.EndsAt.After(time.Now()), "ends_at", "must be in the future")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	adminID := admin.ID
+	id, err := app.DB.CreatePriceSchedule(models.PriceSchedule{
+		WidgetID:  widgetID,
+		Price:     payload.Price,
+		StartsAt:  payload.StartsAt,
+		EndsAt:    payload.EndsAt,
+		CreatedBy: &adminID,
+	})
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "price change scheduled",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetPriceSchedules lists the scheduled price changes of a widget
+func (app *application) GetPriceSchedules(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	schedules, err := app.DB.GetPriceSchedulesForWidget(widgetID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if schedules == nil {
+		schedules = []*models.PriceSchedule{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, schedules)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CancelPriceSchedule cancels a price change that has not started yet
+func (app *application) CancelPriceSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid schedule ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.DB.CancelPriceSchedule(scheduleID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("price schedule %d cancelled", scheduleID),
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetPriceHistory returns every price a widget has had
+func (app *application) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	history, err := app.DB.GetPriceHistory(widgetID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if history == nil {
+		history = []*models.PriceHistoryEntry{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, history)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// SalesPriceReport lists the orders of a period with the price in effect when each was placed.
+// from and to accept RFC 3339 timestamps or dates; a date for to includes that whole day.
+// The period defaults to the last 30 days.
+func (app *application) SalesPriceReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	lines, err := app.DB.GetSalesWithPrices(from, to)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if lines == nil {
+		lines = []*models.SalePriceLine{}
+	}
+
+	var resp struct {
+		From  time.Time               `json:"from"`
+		To    time.Time               `json:"to"`
+		Sales []*models.SalePriceLine `json:"sales"`
+	}
+	resp.From = from
+	resp.To = to
+	resp.Sales = lines
+
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// reportPeriod reads the from and to query parameters of a report, which
+// default to the last 30 days
+func reportPeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		from, err = parseReportTime(value, false)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		to, err = parseReportTime(value, true)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	return from, to, nil
+}
+
+// parseReportTime parses an RFC 3339 timestamp or a 2006-01-02 date. With
+// endOfDay a date is moved to the start of the following day.
+func parseReportTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New("use RFC 3339 or YYYY-MM-DD")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
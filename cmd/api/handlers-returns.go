@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"usual_store/internal/cards"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RequestReturn requests the return of items of one of the authenticated customer's orders
+func (app *application) RequestReturn(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.accountOrder(w, r)
+	if !ok {
+		return
+	}
+	user, _ := app.authenticatedUser(r)
+
+	var payload struct {
+		Quantity int    `json:"quantity"`
+		Reason   string `json:"reason"`
+		Note     string `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Quantity > 0 && payload.Quantity <= order.Quantity, "quantity",
+		fmt.Sprintf("must be between 1 and %d", order.Quantity))
+	v.Check(models.ReturnReasons[payload.Reason], "reason", "is not a valid return reason")
+	v.Check(len(payload.Note) <= 2000, "note", "must not be more than 2000 characters")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	userID := user.ID
+	id, err := app.DB.CreateReturn(models.Return{
+		OrderID:     order.ID,
+		Quantity:    payload.Quantity,
+		Reason:      payload.Reason,
+		Note:        payload.Note,
+		RequestedBy: &userID,
+	})
+	if err != nil {
+		app.returnError(w, r, err)
+		return
+	}
+	app.notifyReturn(id, "We received your return request",
+		"We will review it shortly and let you know how to send the items back.")
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "return requested",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// MyReturns lists the returns of the authenticated customer
+func (app *application) MyReturns(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	returns, err := app.DB.GetReturnsForCustomer(user.Email)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, returns)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// MyStoreCredit returns the store credit balance of the authenticated customer
+func (app *application) MyStoreCredit(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	balance, err := app.DB.GetStoreCreditBalance(user.Email)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := struct {
+		Balance int `json:"balance"`
+	}{
+		Balance: balance,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetReturns lists returns, optionally only those with the status in the query string
+func (app *application) GetReturns(w http.ResponseWriter, r *http.Request) {
+	returns, err := app.DB.GetReturns(r.URL.Query().Get("status"))
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, returns)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetReturn returns one return
+func (app *application) GetReturn(w http.ResponseWriter, r *http.Request) {
+	ret, ok := app.urlReturn(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, ret)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ApproveReturn approves a requested return so the customer can send the items back
+func (app *application) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	app.decideReturn(w, r, app.DB.ApproveReturn, "approved",
+		"Your return was approved. Please send the items back to us.")
+}
+
+// RejectReturn rejects a requested return
+func (app *application) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	app.decideReturn(w, r, app.DB.RejectReturn, "rejected",
+		"Unfortunately we cannot accept this return.")
+}
+
+func (app *application) decideReturn(w http.ResponseWriter, r *http.Request, decide func(id, handledBy int, note string) error, status, message string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid return ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			err = app.badRequest(w, r, err)
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+	}
+
+	err = decide(id, admin.ID, payload.Note)
+	if err != nil {
+		app.returnError(w, r, err)
+		return
+	}
+
+	if payload.Note != "" {
+		message += " " + payload.Note
+	}
+	app.notifyReturn(id, "Your return was "+status, message)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("return %d %s", id, status),
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ReceiveReturn records that the items of an approved return arrived
+func (app *application) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	app.decideReturn(w, r, func(id, handledBy int, _ string) error {
+		return app.DB.ReceiveReturn(id, handledBy)
+	}, "received", "We received the items you sent back and will settle your return shortly.")
+}
+
+// CompleteReturn settles a received return with a refund to the original
+// payment, store credit or a replacement. The amount of a refund or credit
+// defaults to what was paid for the returned items and cannot exceed it.
+// Refunds also need the orders:refund permission.
+func (app *application) CompleteReturn(w http.ResponseWriter, r *http.Request) {
+	ret, ok := app.urlReturn(w, r)
+	if !ok {
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Outcome string `json:"outcome"`
+		Amount  int    `json:"amount"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	value := ret.Value()
+	if payload.Amount == 0 {
+		payload.Amount = value
+	}
+
+	v := validator.New()
+	v.Check(payload.Outcome == models.ReturnOutcomeRefund || payload.Outcome == models.ReturnOutcomeStoreCredit ||
+		payload.Outcome == models.ReturnOutcomeReplacement, "outcome", "must be refund, store_credit or replacement")
+	if payload.Outcome != models.ReturnOutcomeReplacement {
+		v.Check(payload.Amount > 0 && payload.Amount <= value, "amount", fmt.Sprintf("must be between 1 and %d", value))
+	}
+	v.Check(ret.Status == models.ReturnStatusReceived, "status", "only received returns can be completed")
+	if payload.Outcome == models.ReturnOutcomeRefund {
+		v.Check(ret.PaymentIntent != "", "outcome", "the order has no payment to refund")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+	if payload.Outcome == models.ReturnOutcomeRefund && !app.allowed(w, r, rbac.PermOrdersRefund) {
+		return
+	}
+
+	completion := models.ReturnCompletion{
+		Outcome:   payload.Outcome,
+		HandledBy: admin.ID,
+	}
+	switch payload.Outcome {
+	case models.ReturnOutcomeRefund:
+		completion.RefundAmount = payload.Amount
+
+		// Claim the return first, so that completing it twice refunds once
+		err = app.DB.ClaimReturnRefund(ret.ID)
+		if err != nil {
+			app.returnError(w, r, err)
+			return
+		}
+
+		card := cards.Card{
+			Secret:   app.config.stripe.secret,
+			Key:      app.config.stripe.key,
+			Currency: ret.Currency,
+		}
+		err = card.Refund(ret.PaymentIntent, payload.Amount)
+		if err != nil {
+			if rerr := app.DB.ReleaseReturnRefund(ret.ID); rerr != nil {
+				app.errorLog.Printf("refunding return %d failed, and releasing it failed too: %v", ret.ID, rerr)
+			}
+			err = app.badRequest(w, r, err)
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+		app.audit(r, models.AuditOrderRefund, models.AuditTargetOrder, ret.OrderID, nil, map[string]interface{}{
+			"payment_intent": ret.PaymentIntent,
+			"amount":         payload.Amount,
+			"currency":       ret.Currency,
+			"return_id":      ret.ID,
+		})
+	case models.ReturnOutcomeStoreCredit:
+		completion.CreditAmount = payload.Amount
+	}
+
+	changes, err := app.DB.CompleteReturn(ret.ID, completion)
+	if err != nil {
+		if completion.RefundAmount > 0 {
+			app.errorLog.Printf("return %d was refunded, but completing it failed, so it stays refunding: %v", ret.ID, err)
+		}
+		app.returnError(w, r, err)
+		return
+	}
+
+	for _, change := range changes {
+		after, err := app.DB.GetWidget(change.WidgetID)
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+		before := after
+		before.InventoryLevel = change.Before
+		after.InventoryLevel = change.After
+		app.widgetChanged(before, after)
+	}
+
+	var message string
+	switch payload.Outcome {
+	case models.ReturnOutcomeRefund:
+		message = fmt.Sprintf("We refunded %s to your original payment method.", formatPrice(payload.Amount))
+	case models.ReturnOutcomeStoreCredit:
+		message = fmt.Sprintf("We added %s of store credit to your account.", formatPrice(payload.Amount))
+	case models.ReturnOutcomeReplacement:
+		message = "We are sending you a replacement."
+	}
+	app.notifyReturn(ret.ID, "Your return is complete", message)
+
+	ret, err = app.DB.GetReturn(ret.ID)
+	if err != nil {
+		app.returnError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ret)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// urlReturn loads the return in the URL. It writes the error response and returns false otherwise.
+func (app *application) urlReturn(w http.ResponseWriter, r *http.Request) (models.Return, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid return ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return models.Return{}, false
+	}
+
+	ret, err := app.DB.GetReturn(id)
+	if err != nil {
+		app.returnError(w, r, err)
+		return models.Return{}, false
+	}
+	return ret, true
+}
+
+// returnError writes the response for a failed return operation
+func (app *application) returnError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrReturnNotFound) || errors.Is(err, models.ErrOrderNotFound) {
+		app.errorJSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, models.ErrReturnNotReceived) {
+		app.errorJSON(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	err = app.badRequest(w, r, err)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// notifyReturn emails the customer about a step of their return. Failures are
+// logged; they never undo the step.
+func (app *application) notifyReturn(id int, headline, message string) {
+	ret, err := app.DB.GetReturn(id)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"FirstName": ret.CustomerFirstName,
+		"ReturnID":  ret.ID,
+		"OrderID":   ret.OrderID,
+		"Product":   ret.WidgetName,
+		"Quantity":  ret.Quantity,
+		"Headline":  headline,
+		"Message":   message,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = app.queueEmail(ctx, ret.CustomerEmail, fmt.Sprintf("Return #%d: %s", ret.ID, headline),
+		"return-update", data, messaging.PriorityNormal)
+	if err != nil {
+		app.errorLog.Printf("failed to queue email for return %d: %v", ret.ID, err)
+	}
+}
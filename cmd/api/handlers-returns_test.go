@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// fakeStripe points the Stripe client at a server answering every call with
+// status, and returns the calls it gets
+func fakeStripe(t *testing.T, status int) *[]string {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status >= http.StatusBadRequest {
+			_, _ = w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"charge already refunded"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"re_1","object":"refund","status":"succeeded"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(srv.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+	}))
+	t.Cleanup(func() { stripe.SetBackend(stripe.APIBackend, nil) })
+	return &calls
+}
+
+func expectReturn(mock sqlmock.Sqlmock, status string) {
+	now := time.Now()
+	mock.ExpectQuery("FROM returns r").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(make([]string, 28)).
+			AddRow(5, 10, 1, "damaged", "", status, "", 0, 0, nil, "", nil, nil, nil, now, nil, now, now,
+				2, 4000, 0, 3, "Widget", 7, "jane@example.com", "Jane", "pi_1", "usd"))
+}
+
+func completeReturnRequest(body string, admin *models.User) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "5")
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/returns/5/complete", strings.NewReader(body))
+	return r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), UserKey, admin))
+}
+
+const claimReturn = "UPDATE returns SET status = \\$1, updated_at = \\$2 WHERE id = \\$3 AND status = \\$4"
+
+func TestCompleteReturn_Refund(t *testing.T) {
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: "admin"}
+
+	t.Run("audited even when completing fails", func(t *testing.T) {
+		calls := fakeStripe(t, http.StatusOK)
+		app, mock, _ := mfaTestApp(t)
+		expectReturn(mock, models.ReturnStatusReceived)
+		mock.ExpectExec(claimReturn).
+			WithArgs(models.ReturnStatusRefunding, sqlmock.AnyArg(), 5, models.ReturnStatusReceived).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		_, after := expectAudit(mock, "admin@example.com", models.AuditOrderRefund, "10")
+		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+
+		w := httptest.NewRecorder()
+		app.CompleteReturn(w, completeReturnRequest(`{"outcome":"refund","amount":1500}`, admin))
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, []string{"POST /v1/refunds"}, *calls)
+		require.NotNil(t, after.value)
+		assert.JSONEq(t, `{"payment_intent":"pi_1","amount":1500,"currency":"usd","return_id":5}`, string(after.value.([]byte)))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("claimed by someone else", func(t *testing.T) {
+		calls := fakeStripe(t, http.StatusOK)
+		app, mock, _ := mfaTestApp(t)
+		expectReturn(mock, models.ReturnStatusReceived)
+		mock.ExpectExec(claimReturn).
+			WithArgs(models.ReturnStatusRefunding, sqlmock.AnyArg(), 5, models.ReturnStatusReceived).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w := httptest.NewRecorder()
+		app.CompleteReturn(w, completeReturnRequest(`{"outcome":"refund"}`, admin))
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		assert.Empty(t, *calls, "refunded without the claim")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("released when Stripe fails", func(t *testing.T) {
+		calls := fakeStripe(t, http.StatusBadRequest)
+		app, mock, _ := mfaTestApp(t)
+		expectReturn(mock, models.ReturnStatusReceived)
+		mock.ExpectExec(claimReturn).
+			WithArgs(models.ReturnStatusRefunding, sqlmock.AnyArg(), 5, models.ReturnStatusReceived).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(claimReturn).
+			WithArgs(models.ReturnStatusReceived, sqlmock.AnyArg(), 5, models.ReturnStatusRefunding).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		app.CompleteReturn(w, completeReturnRequest(`{"outcome":"refund"}`, admin))
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, []string{"POST /v1/refunds"}, *calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("API key without the orders:refund scope", func(t *testing.T) {
+		calls := fakeStripe(t, http.StatusOK)
+		app, mock, _ := mfaTestApp(t)
+		expectAPIKey(mock, 101, "admin", "{returns:manage}", "{}", 60)
+		mock.ExpectExec("UPDATE api_keys SET last_used_at").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectReturn(mock, models.ReturnStatusReceived)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/returns/5/complete", strings.NewReader(`{"outcome":"refund"}`))
+		r.RemoteAddr = "192.0.2.10:40000"
+		r.Header.Set("User-Agent", "erp-sync/1.0")
+		r.Header.Set(apiKeyHeader, testAPIKey)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "scope orders:refund required")
+		assert.Empty(t, *calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
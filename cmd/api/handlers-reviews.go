@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetWidgetReviews returns the approved reviews of a widget with its aggregate rating
+func (app *application) GetWidgetReviews(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	widget, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Error(w, "Widget not found", http.StatusNotFound)
+		return
+	}
+
+	reviews, err := app.DB.GetApprovedReviewsForWidget(widgetID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		WidgetID      int              `json:"widget_id"`
+		AverageRating float64          `json:"average_rating"`
+		ReviewCount   int              `json:"review_count"`
+		Reviews       []*models.Review `json:"reviews"`
+	}
+	resp.WidgetID = widget.ID
+	resp.AverageRating = widget.AverageRating
+	resp.ReviewCount = widget.ReviewCount
+	resp.Reviews = reviews
+
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateWidgetReview lets an authenticated customer who bought the widget leave a review.
+// The review is queued for moderation and is not published until an admin approves it.
+func (app *application) CreateWidgetReview(w http.ResponseWriter, r *http.Request) {
+	user, err := app.authenticateToken(r)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Rating int    `json:"rating"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Rating >= 1 && payload.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(payload.Title) <= 255, "title", "must not be more than 255 characters")
+	v.Check(len(payload.Body) <= 5000, "body", "must not be more than 5000 characters")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	purchased, err := app.DB.HasPurchasedWidget(ctx, user.Email, widgetID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if !purchased {
+		app.errorJSON(w, http.StatusForbidden, "only customers who purchased this product can review it")
+		return
+	}
+
+	userID := user.ID
+	review := models.Review{
+		WidgetID:      widgetID,
+		UserID:        &userID,
+		CustomerEmail: user.Email,
+		ReviewerName:  strings.TrimSpace(fmt.Sprintf("%s %s", user.FirstName, user.LastName)),
+		Rating:        payload.Rating,
+		Title:         payload.Title,
+		Body:          payload.Body,
+	}
+
+	id, err := app.DB.InsertReview(review)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "Review submitted and awaiting moderation",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ReviewModerationQueue lists reviews by moderation status (pending by default)
+func (app *application) ReviewModerationQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReviewStatusPending
+	}
+
+	if !validReviewStatus(status) {
+		err := app.badRequest(w, r, errors.New("status must be 'pending', 'approved' or 'rejected'"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	reviews, err := app.DB.GetReviewsByStatus(status)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, reviews)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ApproveReview publishes a review
+func (app *application) ApproveReview(w http.ResponseWriter, r *http.Request) {
+	app.moderateReview(w, r, models.ReviewStatusApproved)
+}
+
+// RejectReview hides a review from the catalog
+func (app *application) RejectReview(w http.ResponseWriter, r *http.Request) {
+	app.moderateReview(w, r, models.ReviewStatusRejected)
+}
+
+func (app *application) moderateReview(w http.ResponseWriter, r *http.Request, status string) {
+	reviewID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid review ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	moderator, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.DB.UpdateReviewStatus(reviewID, status, moderator.ID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = fmt.Sprintf("review %d %s", reviewID, status)
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+func validReviewStatus(status string) bool {
+	switch status {
+	case models.ReviewStatusPending, models.ReviewStatusApproved, models.ReviewStatusRejected:
+		return true
+	}
+	return false
+}
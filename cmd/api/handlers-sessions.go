@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/pkg/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxDeviceLabel is the longest device label stored with a token
+const maxDeviceLabel = 255
+
+// tokenDevice describes the device a sign-in request came from. label is the
+// name the client gave the device; without one it is derived from the user agent.
+func tokenDevice(r *http.Request, label string) models.TokenDevice {
+	userAgent := r.Header.Get("User-Agent")
+
+	label = strings.TrimSpace(label)
+	if label == "" {
+		label = deviceLabel(userAgent)
+	}
+	if len(label) > maxDeviceLabel {
+		label = label[:maxDeviceLabel]
+	}
+
+	return models.TokenDevice{
+		Label:     label,
+		IPAddress: clientIP(r),
+		UserAgent: userAgent,
+	}
+}
+
+// deviceLabel names a device after the browser and operating system in its user agent
+func deviceLabel(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+// RefreshAuthToken exchanges a refresh token for a new access and refresh
+// token. Each refresh token works once; presenting it again signs the session
+// out, since only a stolen copy would be replayed.
+func (app *application) RefreshAuthToken(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	user, access, refresh, err := app.tokenService.RefreshTokens(ctx, payload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			app.errorLog.Printf("refresh token reused from %s, session revoked", clientIP(r))
+		} else if !errors.Is(err, repository.ErrTokenNotFound) {
+			app.errorLog.Println(err)
+		}
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var resp struct {
+		Error        bool          `json:"error"`
+		Message      string        `json:"message"`
+		Token        *models.Token `json:"authentication_token"`
+		RefreshToken *models.Token `json:"refresh_token"`
+		ID           int           `json:"id"`
+	}
+	resp.Message = fmt.Sprintf("Token for user %s refreshed.", user.Email)
+	resp.Token = access
+	resp.RefreshToken = refresh
+	resp.ID = user.ID
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// MySessions lists the devices the authenticated user is signed in on
+func (app *application) MySessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	current := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sessions, err := app.tokenService.GetActiveTokens(ctx, user.ID, current)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, sessions)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// RevokeMySession signs the authenticated user out on one of their devices
+func (app *application) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid session ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	err = app.tokenService.RevokeToken(ctx, user.ID, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrTokenNotFound) {
+			app.errorJSON(w, http.StatusNotFound, "session not found")
+			return
+		}
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "signed out",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// LogOutEverywhere signs the authenticated user out on all devices, including this one
+func (app *application) LogOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.authenticatedUser(r)
+	if !ok {
+		err := app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	app.revokeAllTokens(w, r, user.ID, nil)
+}
+
+// RevokeUserTokens signs a user out on all devices
+func (app *application) RevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid user ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	app.revokeAllTokens(w, r, id, func(n int64) {
+		app.audit(r, models.AuditUserRevokeTokens, models.AuditTargetUser, id, nil, map[string]interface{}{"tokens_revoked": n})
+	})
+}
+
+// revokeAllTokens signs userID out everywhere and calls revoked, if not nil,
+// with the number of tokens revoked
+func (app *application) revokeAllTokens(w http.ResponseWriter, r *http.Request, userID int, revoked func(n int64)) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	n, err := app.tokenService.RevokeAllTokens(ctx, userID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if revoked != nil {
+		revoked(n)
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("signed out everywhere, %d tokens revoked", n),
+		ID:      userID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", "Safari on macOS"},
+		{"usual-store-cli/1.0", "Unknown device"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, deviceLabel(tt.userAgent), tt.userAgent)
+	}
+}
+
+func TestTokenDevice(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/authenticate", nil)
+	r.RemoteAddr = "[2001:db8::1]:54321"
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+
+	device := tokenDevice(r, "")
+	assert.Equal(t, "Firefox on Linux", device.Label)
+	assert.Equal(t, "2001:db8::1", device.IPAddress)
+	assert.Equal(t, r.Header.Get("User-Agent"), device.UserAgent)
+
+	assert.Equal(t, "Work laptop", tokenDevice(r, "  Work laptop ").Label)
+	assert.Len(t, tokenDevice(r, strings.Repeat("x", 300)).Label, maxDeviceLabel)
+}
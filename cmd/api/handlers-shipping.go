@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parcel reads the widget_id, quantity and order_total query parameters of a
+// shipping quote. The order total defaults to the widget price times quantity.
+func (app *application) parcel(r *http.Request) (weight, orderTotal int, err error) {
+	widgetID, err := strconv.Atoi(r.URL.Query().Get("widget_id"))
+	if err != nil {
+		return 0, 0, errors.New("invalid widget ID")
+	}
+
+	quantity := 1
+	if value := r.URL.Query().Get("quantity"); value != "" {
+		quantity, err = strconv.Atoi(value)
+		if err != nil || quantity < 1 {
+			return 0, 0, errors.New("quantity must be a positive number")
+		}
+	}
+
+	widget, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		return 0, 0, errors.New("widget not found")
+	}
+	orderTotal = widget.Price * quantity
+	if value := r.URL.Query().Get("order_total"); value != "" {
+		orderTotal, err = strconv.Atoi(value)
+		if err != nil || orderTotal < 0 {
+			return 0, 0, errors.New("order_total must not be negative")
+		}
+	}
+
+	weight, err = app.DB.GetShippingWeight(widgetID, quantity)
+	if err != nil {
+		return 0, 0, err
+	}
+	return weight, orderTotal, nil
+}
+
+// GetShippingOptions returns the shipping methods available for a widget
+// shipped to a country, with their price, cheapest first
+func (app *application) GetShippingOptions(w http.ResponseWriter, r *http.Request) {
+	country := strings.TrimSpace(r.URL.Query().Get("country"))
+	if len(country) != 2 {
+		err := app.badRequest(w, r, errors.New("country must be a two-letter country code"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	weight, orderTotal, err := app.parcel(r)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	quotes, err := app.DB.GetShippingQuotes(country, weight, orderTotal)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, quotes)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// shippingCost prices the shipping method chosen at checkout for one unit of
+// the product, so the charged amount never depends on a price sent by the browser
+func (app *application) shippingCost(payload stripePayload, amount int) (int, error) {
+	if payload.ShippingMethodID == 0 {
+		return 0, nil
+	}
+
+	widgetID, err := strconv.Atoi(payload.ProductID)
+	if err != nil {
+		return 0, errors.New("invalid product ID")
+	}
+	weight, err := app.DB.GetShippingWeight(widgetID, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	quote, err := app.DB.QuoteShippingMethod(payload.ShippingMethodID, payload.Country, weight, amount)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Price, nil
+}
+
+// GetShippingZones lists the shipping zones
+func (app *application) GetShippingZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := app.DB.GetShippingZones()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, zones)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateShippingZone creates a shipping zone. A zone without countries covers
+// every country no other zone lists.
+func (app *application) CreateShippingZone(w http.ResponseWriter, r *http.Request) {
+	var zone models.ShippingZone
+
+	err := app.readJSON(w, r, &zone)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(zone.Name) != "", "name", "must be provided")
+	for _, country := range zone.Countries {
+		v.Check(len(country) == 2, "countries", "must be two-letter country codes")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	id, err := app.DB.InsertShippingZone(zone)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "shipping zone created",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// checkShippingRates validates a rate table
+func checkShippingRates(v *validator.Validator, rates []*models.ShippingRate) {
+	v.Check(len(rates) > 0, "rates", "at least one rate is required")
+	for _, rate := range rates {
+		v.Check(rate.Price >= 0, "rates", "price must not be negative")
+		v.Check(rate.MinWeightGrams >= 0 && rate.MinOrderTotal >= 0, "rates", "minimums must not be negative")
+		v.Check(rate.MaxWeightGrams == nil || *rate.MaxWeightGrams >= rate.MinWeightGrams,
+			"rates", "max_weight_grams must not be below min_weight_grams")
+		v.Check(rate.MaxOrderTotal == nil || *rate.MaxOrderTotal >= rate.MinOrderTotal,
+			"rates", "max_order_total must not be below min_order_total")
+	}
+}
+
+// GetShippingMethods lists the shipping methods with their rate tables
+func (app *application) GetShippingMethods(w http.ResponseWriter, r *http.Request) {
+	methods, err := app.DB.GetShippingMethods()
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, methods)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateShippingMethod creates an active shipping method with its rate table
+func (app *application) CreateShippingMethod(w http.ResponseWriter, r *http.Request) {
+	var method models.ShippingMethod
+
+	err := app.readJSON(w, r, &method)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(method.ZoneID > 0, "zone_id", "must be provided")
+	v.Check(strings.TrimSpace(method.Name) != "", "name", "must be provided")
+	checkShippingRates(v, method.Rates)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	method.IsActive = true
+	id, err := app.DB.InsertShippingMethod(method)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "shipping method created",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// ReplaceShippingRates replaces the rate table of a shipping method
+func (app *application) ReplaceShippingRates(w http.ResponseWriter, r *http.Request) {
+	methodID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid shipping method ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Rates []*models.ShippingRate `json:"rates"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	checkShippingRates(v, payload.Rates)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.ReplaceShippingRates(methodID, payload.Rates)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "shipping rates updated",
+		ID:      methodID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// SetShippingMethodActive enables or disables a shipping method at checkout
+func (app *application) SetShippingMethodActive(w http.ResponseWriter, r *http.Request) {
+	methodID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid shipping method ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		IsActive bool `json:"is_active"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.DB.SetShippingMethodActive(methodID, payload.IsActive)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "shipping method updated",
+		ID:      methodID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// SetWidgetWeight sets the shipping weight of one unit of a widget
+func (app *application) SetWidgetWeight(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		WeightGrams int `json:"weight_grams"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.WeightGrams >= 0, "weight_grams", "must not be negative")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.SetWidgetWeight(widgetID, payload.WeightGrams)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "widget weight updated",
+		ID:      widgetID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// GetShipments lists the shipments of an order
+func (app *application) GetShipments(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	shipments, err := app.DB.GetShipmentsForOrder(orderID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, shipments)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateShipment records a shipment of an order and emails the customer its tracking details
+func (app *application) CreateShipment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid order ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var shipment models.Shipment
+
+	err = app.readJSON(w, r, &shipment)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(shipment.Carrier) != "", "carrier", "must be provided")
+	v.Check(strings.TrimSpace(shipment.TrackingNumber) != "", "tracking_number", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.DB.GetOrderByID(orderID)
+	if err != nil {
+		app.errorJSON(w, http.StatusNotFound, models.ErrOrderNotFound.Error())
+		return
+	}
+
+	adminID := admin.ID
+	shipment.OrderID = order.ID
+	shipment.CreatedBy = &adminID
+	shipment.ID, err = app.DB.InsertShipment(shipment)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	go app.notifyOrderShipped(order, shipment)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "shipment created",
+		ID:      shipment.ID,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// notifyOrderShipped emails the customer that their order has shipped
+func (app *application) notifyOrderShipped(order models.Order, shipment models.Shipment) {
+	data := map[string]interface{}{
+		"FirstName":      order.Customer.FirstName,
+		"OrderID":        order.ID,
+		"Product":        order.Widget.Name,
+		"Carrier":        shipment.Carrier,
+		"TrackingNumber": shipment.TrackingNumber,
+		"TrackingURL":    shipment.TrackingURL,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subject := fmt.Sprintf("Your order #%d has shipped", order.ID)
+	err := app.queueEmail(ctx, order.Customer.Email, subject, "order-shipped", data, messaging.PriorityNormal)
+	if err != nil {
+		app.errorLog.Printf("failed to queue shipping notification for order %d: %v", order.ID, err)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/internal/urlsigner"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// verificationLinkMinutes is how long the link of a verification email stays valid
+	verificationLinkMinutes = 24 * 60
+	// invitationLinkMinutes is how long the link of an invitation stays valid
+	invitationLinkMinutes = 7 * 24 * 60
+	// invitedPassword is stored until an invited user sets their own. It is
+	// not a password hash, so no password matches it.
+	invitedPassword = "!invited"
+)
+
+// errSignupLinkExpired is returned for a verification or invitation link
+// that is too old, and can be sent again
+var errSignupLinkExpired = errors.New("link has expired")
+
+// linkSentAt returns the time a link sent now is recorded with. Links carry
+// it in whole seconds.
+func linkSentAt() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// signupLink returns the signed link to page of the frontend for the user
+// with email, sent at sentAt
+func (app *application) signupLink(page, email string, sentAt time.Time) string {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+	link := fmt.Sprintf("%s/%s?email=%s&sent=%d", app.config.frontend, page, url.QueryEscape(email), sentAt.Unix())
+	return signer.GenerateTokenFromString(link)
+}
+
+// parseSignupLink checks a link made by signupLink that is valid for minutes
+// and returns the email and when it was sent
+func (app *application) parseSignupLink(link string, minutes int) (string, time.Time, error) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+	if !signer.VerifyToken(link) {
+		return "", time.Time{}, models.ErrSignupLinkInvalid
+	}
+	if signer.Expired(link, minutes) {
+		return "", time.Time{}, errSignupLinkExpired
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", time.Time{}, models.ErrSignupLinkInvalid
+	}
+	email := u.Query().Get("email")
+	sent, err := strconv.ParseInt(u.Query().Get("sent"), 10, 64)
+	if email == "" || err != nil {
+		return "", time.Time{}, models.ErrSignupLinkInvalid
+	}
+	return email, time.Unix(sent, 0).UTC(), nil
+}
+
+func (app *application) sendVerificationEmail(user models.User, sentAt time.Time) {
+	data := map[string]interface{}{
+		"FirstName": user.FirstName,
+		"Link":      app.signupLink("verify-email", user.Email, sentAt),
+		"Hours":     verificationLinkMinutes / 60,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := app.queueEmail(ctx, user.Email, "Verify your email address", "verify-email", data, messaging.PriorityHigh)
+	if err != nil {
+		app.errorLog.Printf("failed to queue verification email for user %d: %v", user.ID, err)
+	}
+}
+
+func (app *application) sendInvitationEmail(user, inviter models.User, sentAt time.Time) {
+	data := map[string]interface{}{
+		"FirstName": user.FirstName,
+		"InvitedBy": strings.TrimSpace(inviter.FirstName + " " + inviter.LastName),
+		"Role":      user.Role,
+		"Link":      app.signupLink("accept-invitation", user.Email, sentAt),
+		"Days":      invitationLinkMinutes / (24 * 60),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := app.queueEmail(ctx, user.Email, "You have been invited to Usual Store", "user-invitation", data, messaging.PriorityHigh)
+	if err != nil {
+		app.errorLog.Printf("failed to queue invitation email for user %d: %v", user.ID, err)
+	}
+}
+
+// inviteUser adds user as invited by inviter, who made r, and emails them the
+// link to set their password. It returns the ID of the user.
+func (app *application) inviteUser(r *http.Request, user, inviter models.User) (int, error) {
+	if rbac.Permissions(user.Role) == nil {
+		return 0, fmt.Errorf("invalid role %q", user.Role)
+	}
+
+	sentAt := linkSentAt()
+	user.Status = models.UserStatusInvited
+	id, err := app.DB.InsertSignupUser(user, invitedPassword, inviter.ID, sentAt)
+	if err != nil {
+		return 0, err
+	}
+	user.ID = id
+	app.audit(r, models.AuditUserInvite, models.AuditTargetUser, id, nil, auditedUser(user))
+	app.sendInvitationEmail(user, inviter, sentAt)
+	return id, nil
+}
+
+// ResendVerification sends a new verification link to a user who has not
+// verified their email address yet. It answers the same whether or not there
+// is such a user.
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	sentAt := linkSentAt()
+	user, err := app.DB.RenewSignupLink(payload.Email, models.UserStatusPending, sentAt)
+	if err == nil {
+		app.sendVerificationEmail(user, sentAt)
+	} else if !errors.Is(err, models.ErrUserNotFound) {
+		app.errorLog.Println(err)
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "if the address is waiting to be verified, a new link was sent to it",
+	}
+	err = app.writeJSON(w, http.StatusAccepted, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// InviteUser invites a user by email. They set their own password from the
+// link in the invitation.
+func (app *application) InviteUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		_ = app.invalidCredentials(w)
+		return
+	}
+
+	var payload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Role      string `json:"role"` // Optional: defaults to supporter
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	if payload.Role == "" {
+		payload.Role = rbac.RoleSupporter
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(payload.FirstName) != "", "first_name", "must be provided")
+	v.Check(strings.TrimSpace(payload.LastName) != "", "last_name", "must be provided")
+	v.Check(strings.Contains(payload.Email, "@"), "email", "must be a valid email address")
+	v.Check(rbac.Permissions(payload.Role) != nil, "role", "must be 'super_admin', 'admin', 'supporter', or 'user'")
+	v.Check(payload.Role != rbac.RoleSuperAdmin || admin.Role == rbac.RoleSuperAdmin, "role", "only super admins can invite super admins")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	if _, err := app.DB.GetUserByEmail(payload.Email); err == nil {
+		app.errorJSON(w, http.StatusConflict, "a user with this email already exists")
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(payload.FirstName),
+		LastName:  strings.TrimSpace(payload.LastName),
+		Email:     strings.ToLower(payload.Email),
+		Role:      payload.Role,
+	}
+	id, err := app.inviteUser(r, user, *admin)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.infoLog.Printf("user %d invited %s as %s", admin.ID, user.Email, user.Role)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "invitation sent",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// ResendInvitation sends an invited user a new link, which replaces the one
+// before, for example after it expired
+func (app *application) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		_ = app.invalidCredentials(w)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid user ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	user, err := app.DB.GetUserByID(id)
+	if err != nil {
+		app.errorJSON(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.Status != models.UserStatusInvited {
+		app.errorJSON(w, http.StatusConflict, "the user already accepted their invitation")
+		return
+	}
+
+	sentAt := linkSentAt()
+	user, err = app.DB.RenewSignupLink(user.Email, models.UserStatusInvited, sentAt)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			app.errorJSON(w, http.StatusConflict, "the user already accepted their invitation")
+			return
+		}
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+	app.sendInvitationEmail(user, *admin, sentAt)
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "invitation sent again",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// AcceptInvitation sets the password of an invited user from the signed link
+// of their invitation, and activates their account
+func (app *application) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	email, sentAt, err := app.parseSignupLink(payload.Token, invitationLinkMinutes)
+	if errors.Is(err, errSignupLinkExpired) {
+		app.errorJSON(w, http.StatusBadRequest, "this invitation has expired, please ask for a new one")
+		return
+	} else if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, "this invitation link is invalid")
+		return
+	}
+
+	v := validator.New()
+	app.checkPassword(v, payload.Password)
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	hash, err := app.hashPassword(payload.Password)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	user, err := app.DB.AcceptInvitation(email, sentAt, hash, time.Now())
+	if err != nil {
+		if errors.Is(err, models.ErrSignupLinkInvalid) {
+			app.errorJSON(w, http.StatusBadRequest, "this invitation was already accepted or replaced by a newer one")
+			return
+		}
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "password set, you can sign in now",
+		ID:      user.ID,
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
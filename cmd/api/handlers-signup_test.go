@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/models"
+	"usual_store/internal/password"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var signupUserColumns = []string{"id", "first_name", "last_name", "email", "password", "role", "created_at", "updated_at", "status"}
+
+func TestCreateUser_StartsPending(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("Jane", "Doe", "jane@example.com", sqlmock.AnyArg(), "supporter", models.UserStatusPending, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+	body := `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"secret-password"}`
+	w := httptest.NewRecorder()
+	app.CreateUser(w, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body)))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, float64(5), resp["id"])
+	assert.Equal(t, models.UserStatusPending, resp["status"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAuthToken_UnverifiedEmail(t *testing.T) {
+	hash := testPasswordHash(t, "secret")
+
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("FROM users WHERE email").
+		WithArgs("jane@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(signupUserColumns).
+			AddRow(3, "Jane", "Doe", "jane@example.com", hash, "user", time.Now(), time.Now(), models.UserStatusPending))
+	expectLoginAllowed(mock, 3)
+
+	w := httptest.NewRecorder()
+	app.CreateAuthToken(w, authenticateRequest(`{"email":"jane@example.com","password":"secret"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "verify your email")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAuthToken_UpgradesLegacyHash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	app, mock, repo := mfaTestApp(t)
+	mock.ExpectQuery("FROM users WHERE email").
+		WithArgs("jane@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(signupUserColumns).
+			AddRow(3, "Jane", "Doe", "jane@example.com", string(legacy), "user", time.Now(), time.Now(), models.UserStatusActive))
+	expectLoginAllowed(mock, 3)
+	var upgraded captured
+	mock.ExpectExec("UPDATE users SET password = \\$1 WHERE id = \\$2").
+		WithArgs(&upgraded, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUserMFA(mock, 3, "", false)
+	expectLoginSucceeded(mock, 3)
+	repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	w := httptest.NewRecorder()
+	app.CreateAuthToken(w, authenticateRequest(`{"email":"jane@example.com","password":"secret"}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	hash, ok := upgraded.value.(string)
+	require.True(t, ok)
+	assert.Equal(t, password.Argon2id, password.Algorithm(hash))
+	assert.False(t, password.NeedsRehash(hash, testPasswordParams))
+	matches, err := password.Verify("secret", hash)
+	require.NoError(t, err)
+	assert.True(t, matches)
+}
+
+func inviteRequest(body string, user *models.User) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/invitations", strings.NewReader(body))
+	return r.WithContext(context.WithValue(r.Context(), UserKey, user))
+}
+
+func TestInviteUser(t *testing.T) {
+	admin := &models.User{ID: 1, FirstName: "Ada", LastName: "Admin", Role: "admin"}
+
+	t.Run("sends an invitation", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("FROM users WHERE email").WithArgs("jane@example.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(signupUserColumns))
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("Jane", "Doe", "jane@example.com", invitedPassword, "supporter", models.UserStatusInvited, int64(1), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+		w := httptest.NewRecorder()
+		app.InviteUser(w, inviteRequest(`{"first_name":"Jane","last_name":"Doe","email":"Jane@example.com"}`, admin))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp jsonResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 7, resp.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("email already used", func(t *testing.T) {
+		app, mock, _ := mfaTestApp(t)
+		mock.ExpectQuery("FROM users WHERE email").
+			WillReturnRows(sqlmock.NewRows(signupUserColumns).
+				AddRow(3, "Jane", "Doe", "jane@example.com", "hash", "user", time.Now(), time.Now(), models.UserStatusActive))
+
+		w := httptest.NewRecorder()
+		app.InviteUser(w, inviteRequest(`{"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, admin))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"admin cannot invite super admins", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","role":"super_admin"}`, "role"},
+		{"unknown role", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","role":"owner"}`, "role"},
+		{"no email", `{"first_name":"Jane","last_name":"Doe"}`, "email"},
+		{"no name", `{"email":"jane@example.com"}`, "first_name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			w := httptest.NewRecorder()
+			app.InviteUser(w, inviteRequest(tt.body, admin))
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.Contains(t, w.Body.String(), `"`+tt.field+`"`)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResendInvitation(t *testing.T) {
+	admin := &models.User{ID: 1, FirstName: "Ada", Role: "admin"}
+
+	tests := []struct {
+		name   string
+		status string
+		want   int
+	}{
+		{"invited user gets a new link", models.UserStatusInvited, http.StatusOK},
+		{"active user has accepted", models.UserStatusActive, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			mock.ExpectQuery("FROM users WHERE id").
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows(signupUserColumns).
+					AddRow(7, "Jane", "Doe", "jane@example.com", invitedPassword, "supporter", time.Now(), time.Now(), tt.status))
+			if tt.status == models.UserStatusInvited {
+				mock.ExpectQuery("UPDATE users SET link_sent_at").
+					WithArgs(sqlmock.AnyArg(), "jane@example.com", models.UserStatusInvited, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "role"}).
+						AddRow(7, "Jane", "Doe", "jane@example.com", "supporter"))
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "7")
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/all-users/7/resend-invitation", nil)
+			r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), UserKey, admin))
+			w := httptest.NewRecorder()
+			app.ResendInvitation(w, r)
+			assert.Equal(t, tt.want, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	sentAt := linkSentAt()
+
+	tests := []struct {
+		name     string
+		token    func(app *application) string
+		password string
+		// rows is what accepting the invitation returns, nil when it is not tried
+		rows *sqlmock.Rows
+		want int
+	}{
+		{"sets the password", func(app *application) string {
+			return app.signupLink("accept-invitation", "jane@example.com", sentAt)
+		}, "long-enough", sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "role"}).
+			AddRow(7, "Jane", "Doe", "jane@example.com", "supporter"), http.StatusOK},
+		{"replaced by a newer invitation", func(app *application) string {
+			return app.signupLink("accept-invitation", "jane@example.com", sentAt)
+		}, "long-enough", sqlmock.NewRows([]string{"id"}), http.StatusBadRequest},
+		{"short password", func(app *application) string {
+			return app.signupLink("accept-invitation", "jane@example.com", sentAt)
+		}, "short", nil, http.StatusUnprocessableEntity},
+		{"breached password", func(app *application) string {
+			return app.signupLink("accept-invitation", "jane@example.com", sentAt)
+		}, "password123", nil, http.StatusUnprocessableEntity},
+		{"tampered link", func(app *application) string {
+			return strings.Replace(app.signupLink("accept-invitation", "jane@example.com", sentAt), "jane", "joe", 1)
+		}, "long-enough", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := mfaTestApp(t)
+			app.config.frontend = "https://store.example.com"
+			if tt.rows != nil {
+				mock.ExpectQuery("UPDATE users SET password = \\$1, status = \\$2").
+					WithArgs(sqlmock.AnyArg(), models.UserStatusActive, sqlmock.AnyArg(), "jane@example.com", models.UserStatusInvited, sentAt, sqlmock.AnyArg()).
+					WillReturnRows(tt.rows)
+			}
+
+			payload, err := json.Marshal(map[string]string{"token": tt.token(app), "password": tt.password})
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			app.AcceptInvitation(w, httptest.NewRequest(http.MethodPost, "/api/accept-invitation", strings.NewReader(string(payload))))
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestParseSignupLink(t *testing.T) {
+	app := &application{config: config{frontend: "https://store.example.com"}, keys: testKeyring(t, mfaTestKey)}
+	sentAt := linkSentAt()
+	link := app.signupLink("verify-email", "jane+shop@example.com", sentAt)
+
+	email, sent, err := app.parseSignupLink(link, verificationLinkMinutes)
+	require.NoError(t, err)
+	assert.Equal(t, "jane+shop@example.com", email)
+	assert.True(t, sentAt.Equal(sent))
+
+	_, _, err = app.parseSignupLink(link, -1)
+	assert.ErrorIs(t, err, errSignupLinkExpired)
+
+	other := &application{config: config{frontend: "https://store.example.com"}, keys: testKeyring(t, "another-secret-key-of-32-bytes!!")}
+	_, _, err = other.parseSignupLink(link, verificationLinkMinutes)
+	assert.ErrorIs(t, err, models.ErrSignupLinkInvalid)
+}
+
+func TestResendVerification_SameAnswerForUnknownEmail(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+	mock.ExpectQuery("UPDATE users SET link_sent_at").
+		WithArgs(sqlmock.AnyArg(), "nobody@example.com", models.UserStatusPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := httptest.NewRecorder()
+	body := `{"email":"nobody@example.com"}`
+	app.ResendVerification(w, httptest.NewRequest(http.MethodPost, "/api/resend-verification", strings.NewReader(body)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
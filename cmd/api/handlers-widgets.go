@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// UpdateWidget lets admins change a widget's catalog data. Only fields present
+// in the payload are changed.
+func (app *application) UpdateWidget(w http.ResponseWriter, r *http.Request) {
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err = app.badRequest(w, r, errors.New("invalid widget ID"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Name           *string `json:"name"`
+		Description    *string `json:"description"`
+		Price          *int    `json:"price"`
+		InventoryLevel *int    `json:"inventory_level"`
+		Image          *string `json:"image"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	admin, ok := app.authenticatedUser(r)
+	if !ok {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	before, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Error(w, "Widget not found", http.StatusNotFound)
+		return
+	}
+
+	after := before
+	if payload.Name != nil {
+		after.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		after.Description = *payload.Description
+	}
+	if payload.Price != nil {
+		after.Price = *payload.Price
+	}
+	if payload.InventoryLevel != nil {
+		after.InventoryLevel = *payload.InventoryLevel
+	}
+	if payload.Image != nil {
+		after.Image = *payload.Image
+	}
+
+	v := validator.New()
+	v.Check(after.Name != "", "name", "must be provided")
+	v.Check(after.Price > 0, "price", "must be greater than zero")
+	v.Check(after.InventoryLevel >= 0, "inventory_level", "must not be negative")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.UpdateWidget(after, admin.ID)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	app.widgetChanged(before, after)
+
+	err = app.writeJSON(w, http.StatusOK, after)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
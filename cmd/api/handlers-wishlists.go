@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"usual_store/internal/models"
+	"usual_store/internal/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// wishlistCookie carries the anonymous session of visitors who are not
+	// logged in. The server issues it, so a session cannot be claimed by
+	// guessing its id.
+	wishlistCookie = "wishlist_session"
+	// wishlistCookieDays is how long an anonymous session keeps its wishlists
+	wishlistCookieDays = 30
+)
+
+var errNoWishlistSession = errors.New("missing authorization or wishlist session")
+
+// wishlistOwner resolves who the request acts for: the token's user when an
+// Authorization header is present, otherwise the anonymous session of the
+// wishlist cookie.
+func (app *application) wishlistOwner(r *http.Request) (models.WishlistOwner, error) {
+	if r.Header.Get("Authorization") != "" {
+		user, err := app.authenticateToken(r)
+		if err != nil {
+			return models.WishlistOwner{}, err
+		}
+		userID := user.ID
+		return models.WishlistOwner{UserID: &userID}, nil
+	}
+
+	sessionID := anonymousSession(r)
+	if sessionID == "" {
+		return models.WishlistOwner{}, errNoWishlistSession
+	}
+	return models.WishlistOwner{SessionID: sessionID}, nil
+}
+
+// anonymousSession returns the session of the wishlist cookie, stored as the
+// hash of the cookie so that the database does not hold usable sessions, or
+// "" without one
+func anonymousSession(r *http.Request) string {
+	cookie, err := r.Cookie(wishlistCookie)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return hex.EncodeToString(models.HashAPIKey(cookie.Value))
+}
+
+// issueAnonymousSession sets a new wishlist cookie and returns its session
+func (app *application) issueAnonymousSession(w http.ResponseWriter) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     wishlistCookie,
+		Value:    value,
+		Path:     "/api",
+		MaxAge:   wishlistCookieDays * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.config.frontend, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return hex.EncodeToString(models.HashAPIKey(value)), nil
+}
+
+// clearAnonymousSession removes the wishlist cookie once its lists are merged
+func clearAnonymousSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: wishlistCookie, Path: "/api", MaxAge: -1})
+}
+
+// wishlistError writes the response for a failed wishlist operation
+func (app *application) wishlistError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrWishlistNotFound) {
+		app.errorJSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	err = app.badRequest(w, r, err)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// GetWishlists lists the wishlists of the current user or session
+func (app *application) GetWishlists(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.wishlistOwner(r)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	wishlists, err := app.DB.GetWishlistsForOwner(owner)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+	if wishlists == nil {
+		wishlists = []*models.Wishlist{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, wishlists)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// CreateWishlist creates a new named wishlist. Visitors who are not logged in
+// get an anonymous session for their first list. Price-drop emails go to the
+// verified address of the owner, once there is one.
+func (app *application) CreateWishlist(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.wishlistOwner(r)
+	if errors.Is(err, errNoWishlistSession) {
+		owner.SessionID, err = app.issueAnonymousSession(w)
+	}
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	var payload struct {
+		Name string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		err = app.badRequest(w, r, err)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		payload.Name = "My Wishlist"
+	}
+
+	v := validator.New()
+	v.Check(len(payload.Name) <= 255, "name", "must not be more than 255 characters")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	id, err := app.DB.CreateWishlist(owner, payload.Name)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "wishlist created",
+		ID:      id,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// DeleteWishlist removes a wishlist
+func (app *application) DeleteWishlist(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.wishlistOwner(r)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	wishlistID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.wishlistError(w, r, errors.New("invalid wishlist ID"))
+		return
+	}
+
+	err = app.DB.DeleteWishlist(wishlistID, owner)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "wishlist deleted",
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// AddWishlistItem saves a widget to a wishlist
+func (app *application) AddWishlistItem(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.wishlistOwner(r)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	wishlistID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.wishlistError(w, r, errors.New("invalid wishlist ID"))
+		return
+	}
+
+	var payload struct {
+		WidgetID int `json:"widget_id"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+
+	err = app.DB.AddWishlistItem(wishlistID, payload.WidgetID, owner)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "item added to wishlist",
+		ID:      payload.WidgetID,
+	}
+	err = app.writeJSON(w, http.StatusCreated, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
+
+// RemoveWishlistItem removes a widget from a wishlist
+func (app *application) RemoveWishlistItem(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.wishlistOwner(r)
+	if err != nil {
+		err = app.invalidCredentials(w)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	wishlistID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.wishlistError(w, r, errors.New("invalid wishlist ID"))
+		return
+	}
+
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "widgetID"))
+	if err != nil {
+		app.wishlistError(w, r, errors.New("invalid widget ID"))
+		return
+	}
+
+	err = app.DB.RemoveWishlistItem(wishlistID, widgetID, owner)
+	if err != nil {
+		app.wishlistError(w, r, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "item removed from wishlist",
+	}
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func wishlistCookieOf(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == wishlistCookie {
+			return c
+		}
+	}
+	t.Fatalf("no %s cookie set", wishlistCookie)
+	return nil
+}
+
+func TestCreateWishlist_AnonymousSession(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+
+	mock.ExpectQuery("INSERT INTO wishlists").
+		WithArgs(nil, sqlmock.AnyArg(), "Gifts", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+
+	w := httptest.NewRecorder()
+	app.CreateWishlist(w, httptest.NewRequest(http.MethodPost, "/api/wishlists", strings.NewReader(`{"name":"Gifts"}`)))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	cookie := wishlistCookieOf(t, w)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	session := hex.EncodeToString(models.HashAPIKey(cookie.Value))
+
+	// The next list of the visitor joins the same session, which is stored hashed
+	mock.ExpectQuery("INSERT INTO wishlists").
+		WithArgs(nil, session, "Birthday", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/wishlists", strings.NewReader(`{"name":"Birthday"}`))
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	app.CreateWishlist(w, r)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetWishlists_NeedsSession(t *testing.T) {
+	app, mock, _ := mfaTestApp(t)
+
+	// A session id of the visitor's choosing is not a session
+	r := httptest.NewRequest(http.MethodGet, "/api/wishlists", nil)
+	r.Header.Set("X-Session-ID", "sess-1")
+	w := httptest.NewRecorder()
+	app.GetWishlists(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIssueAuthTokens_MergesWishlistSession(t *testing.T) {
+	app, mock, repo := mfaTestApp(t)
+	user := models.User{ID: 3, FirstName: "Jane", Email: "jane@example.com", Role: "user"}
+
+	repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	expectLoginSucceeded(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name FROM wishlists WHERE user_id IS NULL AND session_id = \\$1").
+		WithArgs(hex.EncodeToString(models.HashAPIKey("cookie-value"))).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectCommit()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/authenticate", nil)
+	r.AddCookie(&http.Cookie{Name: wishlistCookie, Value: "cookie-value"})
+	w := httptest.NewRecorder()
+	app.issueAuthTokens(w, r, user, "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, -1, wishlistCookieOf(t, w).MaxAge)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCORS_CredentialsForFrontendOnly(t *testing.T) {
+	app, _, _ := mfaTestApp(t)
+	app.config.corsOrigins = []string{"https://store.example.com"}
+	handler := app.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		origin      string
+		credentials string
+	}{
+		{"https://store.example.com", "true"},
+		{"https://attacker.example", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "/api/wishlists/", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			// Every site may still call the API, only without the session cookie
+			assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.credentials, w.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
 	"io"
+	"net"
 	"net/http"
+	"usual_store/internal/models"
+	"usual_store/internal/password"
+	"usual_store/internal/validator"
 )
 
 // writeJSON writes arbitrary data out as JSON
@@ -84,16 +87,37 @@ func (app *application) invalidCredentials(w http.ResponseWriter) error {
 }
 
 func (app *application) passwordMatchers(hash, passhword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(passhword))
+	return password.Verify(passhword, hash)
+}
+
+// checkPassword records in v why plain may not be set as a password
+func (app *application) checkPassword(v *validator.Validator, plain string) {
+	if err := app.passwordPolicy.Check(plain); err != nil {
+		v.AddError("password", err.Error())
+	}
+}
+
+// hashPassword returns the hash a new password is stored as
+func (app *application) hashPassword(plain string) (string, error) {
+	return password.Hash(plain, app.config.passwords.params)
+}
+
+// upgradePassword replaces the stored hash of user, whose password plain was
+// just verified, when it was made with bcrypt or weaker Argon2id parameters.
+// The user is signed in either way, so a failure is only logged.
+func (app *application) upgradePassword(user models.User, plain string) {
+	if !password.NeedsRehash(user.Password, app.config.passwords.params) {
+		return
+	}
+	hash, err := app.hashPassword(plain)
+	if err == nil {
+		err = app.DB.UpdatePasswordForUser(user, hash)
+	}
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		app.errorLog.Printf("failed to upgrade the password hash of user %d: %v", user.ID, err)
+		return
 	}
-	return true, nil
+	app.infoLog.Printf("upgraded the %s password hash of user %d", password.Algorithm(user.Password), user.ID)
 }
 
 func (app *application) failedValidation(w http.ResponseWriter, r *http.Request, errors map[string]string) {
@@ -112,3 +136,47 @@ func (app *application) failedValidation(w http.ResponseWriter, r *http.Request,
 		return
 	}
 }
+
+// authenticatedUser returns the user stored in the request context by the Auth middleware
+func (app *application) authenticatedUser(r *http.Request) (*models.User, bool) {
+	user, ok := r.Context().Value(UserKey).(*models.User)
+	return user, ok && user != nil
+}
+
+// authenticatedAPIKey returns the API key the request was authenticated with,
+// and false for requests authenticated with a token
+func (app *application) authenticatedAPIKey(r *http.Request) (*models.APIKey, bool) {
+	key, ok := r.Context().Value(APIKeyKey).(*models.APIKey)
+	return key, ok && key != nil
+}
+
+// impersonation returns the impersonation the request was made under, and
+// false when the user made it themselves
+func (app *application) impersonation(r *http.Request) (*models.Impersonation, bool) {
+	imp, ok := r.Context().Value(ImpersonationKey).(*models.Impersonation)
+	return imp, ok && imp != nil
+}
+
+// clientIP returns the IP address a request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// errorJSON writes an error response with the given status code and message
+func (app *application) errorJSON(w http.ResponseWriter, status int, message string) {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	payload.Error = true
+	payload.Message = message
+
+	err := app.writeJSON(w, status, payload)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -10,6 +10,16 @@ type ContextKey string
 
 const TraceIDKey ContextKey = "TraceID"
 
+// UserKey holds the authenticated *models.User set by the Auth middleware
+const UserKey ContextKey = "User"
+
+// APIKeyKey holds the *models.APIKey a request was authenticated with, if any
+const APIKeyKey ContextKey = "APIKey"
+
+// ImpersonationKey holds the *models.Impersonation of a staff member acting
+// as the user, if any
+const ImpersonationKey ContextKey = "Impersonation"
+
 // LogWithTrace logs a message with the trace ID from the context
 func LogWithTrace(ctx context.Context, message string) {
 	traceID, _ := ctx.Value(TraceIDKey).(string)
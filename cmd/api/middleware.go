@@ -2,12 +2,41 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
 
+	"github.com/go-chi/cors"
 	"github.com/google/uuid"
 )
 
-var failedAttempts = make(map[string]int)
+// cors answers cross-origin requests. Pages of any site may call the API
+// without credentials, and those of config.corsOrigins with them, as the
+// wishlist session is a cookie.
+func (app *application) cors(next http.Handler) http.Handler {
+	options := cors.Options{
+		AllowedOrigins: []string{"https://*", "http://*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", apiKeyHeader},
+		ExposedHeaders: []string{impersonatedByHeader},
+		MaxAge:         300,
+	}
+	anyOrigin := cors.Handler(options)(next)
+
+	options.AllowedOrigins = app.config.corsOrigins
+	options.AllowCredentials = true
+	credentialed := cors.Handler(options)(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(app.config.corsOrigins, r.Header.Get("Origin")) {
+			credentialed.ServeHTTP(w, r)
+			return
+		}
+		anyOrigin.ServeHTTP(w, r)
+	})
+}
 
 func (app *application) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -15,20 +44,34 @@ func (app *application) Auth(next http.Handler) http.Handler {
 
 		app.infoLog.Printf("[TraceID: %s] %s %s", traceID, r.Method, r.URL)
 
-		_, err := app.authenticateToken(r)
-		if err != nil {
-			err = app.invalidCredentials(w)
+		// Machine-to-machine clients send an API key instead of a bearer token,
+		// and staff impersonating a customer an impersonation token
+		var key *models.APIKey
+		var imp *models.Impersonation
+		var user *models.User
+		if r.Header.Get(apiKeyHeader) != "" {
+			var ok bool
+			user, key, ok = app.authenticateAPIKey(w, r)
+			if !ok {
+				return
+			}
+		} else if token, found := impersonationToken(r); found {
+			var ok bool
+			user, imp, ok = app.authenticateImpersonation(w, r, token)
+			if !ok {
+				return
+			}
+		} else {
+			var err error
+			user, err = app.authenticateToken(r)
 			if err != nil {
-				app.errorLog.Println(err)
+				err = app.invalidCredentials(w)
+				if err != nil {
+					app.errorLog.Println(err)
+					return
+				}
 				return
 			}
-			return
-		}
-
-		ip := r.RemoteAddr
-		if failedAttempts[ip] > 5 {
-			http.Error(w, "Access Denied", http.StatusForbidden)
-			return
 		}
 
 		userAgent := r.Header.Get("User-Agent")
@@ -37,10 +80,57 @@ func (app *application) Auth(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), UserKey, user)
+		if key != nil {
+			ctx = context.WithValue(ctx, APIKeyKey, key)
+		}
+		if imp != nil {
+			ctx = context.WithValue(ctx, ImpersonationKey, imp)
+			w.Header().Set(impersonatedByHeader, fmt.Sprintf("%s (ticket %d)", imp.ActorEmail, imp.TicketID))
+			app.auditImpersonatedRequest(r.WithContext(ctx), user, imp)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requirePermission only lets through requests of users whose role has
+// permission p, and that were not made with an API key lacking the scope p.
+// It must run after Auth.
+func (app *application) requirePermission(p rbac.Permission) func(http.Handler) http.Handler {
+	role := rbac.RequirePermission(p, func(r *http.Request) (string, bool) {
+		user, ok := app.authenticatedUser(r)
+		if !ok {
+			return "", false
+		}
+		return user.Role, true
+	})
+	scope := rbac.RequireScope(p, func(r *http.Request) ([]rbac.Permission, bool) {
+		key, ok := app.authenticatedAPIKey(r)
+		if !ok {
+			return nil, false
+		}
+		scopes := make([]rbac.Permission, len(key.Scopes))
+		for i, s := range key.Scopes {
+			scopes[i] = rbac.Permission(s)
+		}
+		return scopes, true
+	})
+	return func(next http.Handler) http.Handler {
+		return role(scope(next))
+	}
+}
+
+// allowed checks permission p of the user and API key of r like
+// requirePermission, for handlers that need p only for some requests. It
+// writes the error response and returns false when they lack it.
+func (app *application) allowed(w http.ResponseWriter, r *http.Request, p rbac.Permission) bool {
+	ok := false
+	app.requirePermission(p)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		ok = true
+	})).ServeHTTP(w, r)
+	return ok
+}
+
 // TraceMiddleware adds a trace ID to each request and logs it
 func TraceMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
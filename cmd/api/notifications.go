@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+)
+
+const notificationsFrom = "noreply@usualstore.com"
+
+// queueEmail publishes an email to Kafka for the messaging service to render and send
+func (app *application) queueEmail(ctx context.Context, to, subject, tmpl string, data map[string]interface{}, priority string) error {
+	if app.producer == nil {
+		return errors.New("email producer is not configured")
+	}
+	return app.producer.SendEmail(ctx, notificationsFrom, to, subject, tmpl, data, priority)
+}
+
+// formatPrice renders an amount in cents for emails
+func formatPrice(cents int) string {
+	return fmt.Sprintf("€%.2f", float64(cents)/100)
+}
+
+// widgetChanged reacts to a catalog change of a widget, notifying wishlist
+// subscribers when its price went down or it came back in stock.
+func (app *application) widgetChanged(before, after models.Widget) {
+	var reason string
+	switch {
+	case after.Price < before.Price:
+		reason = fmt.Sprintf("dropped in price from %s to %s", formatPrice(before.Price), formatPrice(after.Price))
+	case before.InventoryLevel <= 0 && after.InventoryLevel > 0:
+		reason = "is back in stock"
+	default:
+		return
+	}
+
+	go app.notifyWishlistSubscribers(after, reason)
+}
+
+// notifyWishlistSubscribers emails everyone who saved the widget on a wishlist
+func (app *application) notifyWishlistSubscribers(widget models.Widget, reason string) {
+	subscribers, err := app.DB.GetWishlistSubscribers(widget.ID)
+	if err != nil {
+		app.errorLog.Printf("failed to load wishlist subscribers for widget %d: %v", widget.ID, err)
+		return
+	}
+
+	for _, subscriber := range subscribers {
+		data := map[string]interface{}{
+			"FirstName": subscriber.FirstName,
+			"Product":   widget.Name,
+			"Reason":    reason,
+			"Price":     formatPrice(widget.Price),
+			"Link":      fmt.Sprintf("%s/widgets/%d", app.config.frontend, widget.ID),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = app.queueEmail(ctx, subscriber.Email, fmt.Sprintf("%s %s", widget.Name, reason), "wishlist-alert", data, messaging.PriorityLow)
+		cancel()
+		if err != nil {
+			app.errorLog.Printf("failed to queue wishlist alert to %s: %v", subscriber.Email, err)
+		}
+	}
+
+	app.infoLog.Printf("queued %d wishlist alerts for widget %d", len(subscribers), widget.ID)
+}
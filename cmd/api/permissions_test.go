@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"usual_store/internal/mocks"
+	"usual_store/internal/models"
+	"usual_store/internal/rbac"
+	"usual_store/pkg/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routePermissions maps every route behind Auth to the permission it needs
+var routePermissions = map[string]rbac.Permission{
+	"GET /api/users/":        rbac.PermUsersRead,
+	"POST /api/users/":       rbac.PermUsersWrite,
+	"DELETE /api/users/{id}": rbac.PermUsersWrite,
+
+	"POST /api/messaging/send": rbac.PermMessagesSend,
+
+	"GET /api/account/me":                         rbac.PermAccount,
+	"GET /api/account/orders":                     rbac.PermAccount,
+	"GET /api/account/orders/{id}":                rbac.PermAccount,
+	"GET /api/account/orders/{id}/invoice":        rbac.PermAccount,
+	"GET /api/account/subscriptions":              rbac.PermAccount,
+	"GET /api/account/subscriptions/{id}":         rbac.PermAccount,
+	"POST /api/account/subscriptions/{id}/cancel": rbac.PermAccount,
+	"POST /api/account/orders/{id}/returns":       rbac.PermAccount,
+	"GET /api/account/returns":                    rbac.PermAccount,
+	"GET /api/account/store-credit":               rbac.PermAccount,
+	"GET /api/account/sessions":                   rbac.PermAccount,
+	"DELETE /api/account/sessions/{id}":           rbac.PermAccount,
+	"POST /api/account/sessions/logout-all":       rbac.PermAccount,
+	"GET /api/account/mfa":                        rbac.PermAccount,
+	"POST /api/account/mfa/enrol":                 rbac.PermAccount,
+	"POST /api/account/mfa/enrol/verify":          rbac.PermAccount,
+	"POST /api/account/mfa/disable":               rbac.PermAccount,
+	"POST /api/account/mfa/recovery-codes":        rbac.PermAccount,
+	"POST /api/account/sso/link":                  rbac.PermAccount,
+
+	"POST /api/admin/virtual-terminal-succeeded":       rbac.PermPaymentsCharge,
+	"POST /api/admin/all-sales":                        rbac.PermOrdersRead,
+	"POST /api/admin/all-subscriptions":                rbac.PermOrdersRead,
+	"GET /api/admin/sales":                             rbac.PermOrdersRead,
+	"GET /api/admin/subscriptions":                     rbac.PermOrdersRead,
+	"POST /api/admin/get-sale/{id}":                    rbac.PermOrdersRead,
+	"POST /api/admin/get-subscription/{id}":            rbac.PermOrdersRead,
+	"POST /api/admin/refund":                           rbac.PermOrdersRefund,
+	"POST /api/admin/cancel-subscription":              rbac.PermSubscriptionsCancel,
+	"POST /api/admin/exports":                          rbac.PermOrdersExport,
+	"GET /api/admin/exports/{id}":                      rbac.PermOrdersExport,
+	"GET /api/admin/api-keys":                          rbac.PermAPIKeysManage,
+	"POST /api/admin/api-keys":                         rbac.PermAPIKeysManage,
+	"POST /api/admin/api-keys/{id}/revoke":             rbac.PermAPIKeysManage,
+	"POST /api/admin/impersonations":                   rbac.PermUsersImpersonate,
+	"POST /api/admin/impersonations/{id}/end":          rbac.PermUsersImpersonate,
+	"GET /api/admin/audit-events":                      rbac.PermAuditRead,
+	"GET /api/admin/audit-events/verify":               rbac.PermAuditRead,
+	"POST /api/admin/gdpr/access":                      rbac.PermGDPRManage,
+	"POST /api/admin/gdpr/erasure":                     rbac.PermGDPRManage,
+	"GET /api/admin/gdpr/requests":                     rbac.PermGDPRManage,
+	"GET /api/admin/returns":                           rbac.PermReturnsManage,
+	"GET /api/admin/returns/{id}":                      rbac.PermReturnsManage,
+	"POST /api/admin/returns/{id}/approve":             rbac.PermReturnsManage,
+	"POST /api/admin/returns/{id}/reject":              rbac.PermReturnsManage,
+	"POST /api/admin/returns/{id}/receive":             rbac.PermReturnsManage,
+	"POST /api/admin/returns/{id}/complete":            rbac.PermReturnsManage,
+	"POST /api/admin/all-users":                        rbac.PermUsersRead,
+	"POST /api/admin/all-users/{id}":                   rbac.PermUsersRead,
+	"POST /api/admin/all-users/edit/{id}":              rbac.PermUsersWrite,
+	"POST /api/admin/all-users/delete/{id}":            rbac.PermUsersWrite,
+	"POST /api/admin/all-users/{id}/revoke-tokens":     rbac.PermUsersWrite,
+	"POST /api/admin/all-users/{id}/unlock":            rbac.PermUsersWrite,
+	"POST /api/admin/all-users/{id}/resend-invitation": rbac.PermUsersWrite,
+	"POST /api/admin/invitations":                      rbac.PermUsersWrite,
+	"POST /api/admin/widgets/{id}":                     rbac.PermCatalogWrite,
+	"GET /api/admin/widgets/{id}/price-history":        rbac.PermPricesManage,
+	"GET /api/admin/widgets/{id}/price-schedules":      rbac.PermPricesManage,
+	"POST /api/admin/widgets/{id}/price-schedules":     rbac.PermPricesManage,
+	"POST /api/admin/price-schedules/{id}/cancel":      rbac.PermPricesManage,
+	"GET /api/admin/reports/sales-prices":              rbac.PermReportsRead,
+	"GET /api/admin/reports/abandoned-checkouts":       rbac.PermReportsRead,
+	"POST /api/admin/widgets/{id}/stock-adjustments":   rbac.PermInventoryManage,
+	"GET /api/admin/widgets/{id}/stock-movements":      rbac.PermInventoryManage,
+	"GET /api/admin/widgets/{id}/stock-settings":       rbac.PermInventoryManage,
+	"POST /api/admin/widgets/{id}/stock-settings":      rbac.PermInventoryManage,
+	"GET /api/admin/suppliers":                         rbac.PermPurchasingManage,
+	"POST /api/admin/suppliers":                        rbac.PermPurchasingManage,
+	"GET /api/admin/purchase-orders":                   rbac.PermPurchasingManage,
+	"POST /api/admin/purchase-orders":                  rbac.PermPurchasingManage,
+	"GET /api/admin/purchase-orders/{id}":              rbac.PermPurchasingManage,
+	"POST /api/admin/purchase-orders/{id}/order":       rbac.PermPurchasingManage,
+	"POST /api/admin/purchase-orders/{id}/cancel":      rbac.PermPurchasingManage,
+	"POST /api/admin/purchase-orders/{id}/receive":     rbac.PermPurchasingManage,
+	"POST /api/admin/widgets/{id}/weight":              rbac.PermShippingManage,
+	"GET /api/admin/shipping/zones":                    rbac.PermShippingManage,
+	"POST /api/admin/shipping/zones":                   rbac.PermShippingManage,
+	"GET /api/admin/shipping/methods":                  rbac.PermShippingManage,
+	"POST /api/admin/shipping/methods":                 rbac.PermShippingManage,
+	"POST /api/admin/shipping/methods/{id}/rates":      rbac.PermShippingManage,
+	"POST /api/admin/shipping/methods/{id}/active":     rbac.PermShippingManage,
+	"GET /api/admin/orders/{id}/shipments":             rbac.PermShipmentsManage,
+	"POST /api/admin/orders/{id}/shipments":            rbac.PermShipmentsManage,
+	"GET /api/admin/reviews":                           rbac.PermReviewsModerate,
+	"POST /api/admin/reviews/{id}/approve":             rbac.PermReviewsModerate,
+	"POST /api/admin/reviews/{id}/reject":              rbac.PermReviewsModerate,
+}
+
+// permissionTestApp returns an application whose tokens resolve to a user with
+// the role named by the token
+func permissionTestApp(t *testing.T, roles []string) *application {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTokenRepository(ctrl)
+	for i, role := range roles {
+		repo.EXPECT().GetUserForToken(gomock.Any(), roleToken(role)).
+			Return(&models.User{ID: i + 1, Role: role}, nil).AnyTimes()
+	}
+	repo.EXPECT().GetUserForToken(gomock.Any(), gomock.Any()).
+		Return(nil, errors.New("no matching token")).AnyTimes()
+
+	return &application{
+		infoLog:      log.New(io.Discard, "", 0),
+		errorLog:     log.New(io.Discard, "", 0),
+		tokenService: *service.NewTokenService(repo),
+	}
+}
+
+// roleToken returns a token of the length Auth accepts that identifies role
+func roleToken(role string) string {
+	return (strings.ToUpper(role) + strings.Repeat("0", 26))[:26]
+}
+
+func TestRoutePermissions(t *testing.T) {
+	roles := []string{rbac.RoleSuperAdmin, rbac.RoleAdmin, rbac.RoleSupporter, rbac.RoleUser, "unknown"}
+	app := permissionTestApp(t, roles)
+
+	reached := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	seen := map[string]bool{}
+	requests := 0
+	err := chi.Walk(app.routes().(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/api/users") && !strings.HasPrefix(route, "/api/account/") &&
+			!strings.HasPrefix(route, "/api/admin/") && !strings.HasPrefix(route, "/api/messaging/") {
+			return nil
+		}
+
+		key := method + " " + route
+		seen[key] = true
+		permission, ok := routePermissions[key]
+		if !assert.True(t, ok, "route %s has no permission in routePermissions", key) {
+			return nil
+		}
+
+		// Run the route's middleware chain in front of a stub instead of the real handler
+		if chain, ok := handler.(*chi.ChainHandler); ok {
+			middlewares = append(middlewares, chain.Middlewares...)
+		}
+		var h http.Handler = reached
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+
+		send := func(token string) int {
+			requests++
+			req := httptest.NewRequest(method, strings.ReplaceAll(route, "{id}", "1"), nil)
+			req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", requests%250)
+			req.Header.Set("User-Agent", "permissions-test")
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			return rr.Code
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, send(""), "%s without a token", key)
+		assert.Equal(t, http.StatusUnauthorized, send(roleToken("nobody")), "%s with an unknown token", key)
+		for _, role := range roles {
+			want := http.StatusForbidden
+			if rbac.Can(role, permission) {
+				want = http.StatusTeapot
+			}
+			assert.Equal(t, want, send(roleToken(role)), "%s as %s", key, role)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for key := range routePermissions {
+		assert.True(t, seen[key], "route %s in routePermissions is not registered", key)
+	}
+}
@@ -3,11 +3,22 @@ package main
 import (
 	"golang.org/x/time/rate"
 	"net/http"
+	"sync"
 )
 
-var IpLimiter = make(map[string]*rate.Limiter)
+var (
+	IpLimiter   = make(map[string]*rate.Limiter)
+	ipLimiterMu sync.Mutex
+
+	// keyLimiters limit each API key to its own rate, by key ID
+	keyLimiters   = make(map[int]*rate.Limiter)
+	keyLimitersMu sync.Mutex
+)
 
 func GetLimiter(ip string) *rate.Limiter {
+	ipLimiterMu.Lock()
+	defer ipLimiterMu.Unlock()
+
 	if limiter, exists := IpLimiter[ip]; exists {
 		return limiter
 	}
@@ -16,9 +27,24 @@ func GetLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
+// GetKeyLimiter returns the limiter of API key id, allowing perMinute requests a minute
+func GetKeyLimiter(id, perMinute int) *rate.Limiter {
+	keyLimitersMu.Lock()
+	defer keyLimitersMu.Unlock()
+
+	limit := rate.Limit(float64(perMinute) / 60)
+	if limiter, exists := keyLimiters[id]; exists && limiter.Burst() == perMinute {
+		return limiter
+	}
+	limiter := rate.NewLimiter(limit, perMinute)
+	keyLimiters[id] = limiter
+	return limiter
+}
+
 func RateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
+		// Key on the address without the port, which changes with every connection
+		ip := clientIP(r)
 		limiter := GetLimiter(ip)
 		if !limiter.Allow() {
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
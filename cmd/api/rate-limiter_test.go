@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"golang.org/x/time/rate"
 	"net/http"
 	"net/http/httptest"
@@ -74,3 +75,22 @@ func TestRateLimitMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimitMiddleware_IgnoresPort(t *testing.T) {
+	handler := RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var lastResponseCode int
+	for port := 40000; port < 40010; port++ {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.RemoteAddr = fmt.Sprintf("192.168.1.3:%d", port)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		lastResponseCode = rec.Code
+	}
+
+	if lastResponseCode != http.StatusTooManyRequests {
+		t.Errorf("Got status %d, want %d", lastResponseCode, http.StatusTooManyRequests)
+	}
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"net/http"
+	"usual_store/internal/rbac"
 	// "os"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	// "go.opentelemetry.io/contrib/instrumentation/github.com/go-chi/chi/v5/otelchi"
 )
 
@@ -26,48 +26,240 @@ func (app *application) routes() http.Handler {
 	// Add RateLimitMiddleware
 	mux.Use(RateLimitMiddleware)
 
-	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
+	// Any site may call the API, but only the configured frontend origins with
+	// credentials, for the wishlist session cookie
+	mux.Use(app.cors)
 
 	mux.Post("/api/payment-intent", app.GetPaymentIntent)
+	mux.Get("/api/shipping/methods", app.GetShippingOptions)
+	mux.Get("/api/exports/{id}/download", app.DownloadExport)
 	mux.Get("/api/widgets", app.GetAllWidgets)
 	mux.Get("/api/products", app.GetAllWidgets) // Alias for /api/widgets
 	mux.Get("/api/widgets/{id}", app.GetWidgetByID)
 	mux.Get("/api/product/{id}", app.GetWidgetByID) // Alias for /api/widgets/{id}
+	mux.Get("/api/widgets/{id}/reviews", app.GetWidgetReviews)
+	mux.Post("/api/widgets/{id}/reviews", app.CreateWidgetReview)
+	mux.Route("/api/wishlists", func(r chi.Router) {
+		r.Get("/", app.GetWishlists)
+		r.Post("/", app.CreateWishlist)
+		r.Delete("/{id}", app.DeleteWishlist)
+		r.Post("/{id}/items", app.AddWishlistItem)
+		r.Delete("/{id}/items/{widgetID}", app.RemoveWishlistItem)
+	})
 	mux.Post("/api/create-customer-and-subscribe-to-plan", app.CreateCustomerAndSubscribeToPlan)
 
 	mux.Post("/api/authenticate", app.CreateAuthToken)
+	mux.Post("/api/refresh-token", app.RefreshAuthToken)
+	// Second step of signing in for users with two-factor authentication, using the mfa_token
+	mux.Post("/api/mfa/verify", app.VerifyMFA)
+	mux.Post("/api/mfa/enrol", app.EnrolMFAPending)
+	mux.Post("/api/mfa/enrol/verify", app.ConfirmMFAPending)
+
+	// Single sign-on for staff through the company identity provider
+	mux.Post("/api/oidc/start", app.StartOIDCLogin)
+	mux.Post("/api/oidc/callback", app.FinishOIDCLogin)
+
 	mux.Post("/api/is-authenticated", app.CheckAuthentication)
 	mux.Post("/api/forgot-password", app.SendPasswordResetEmail)
 	mux.Post("/api/reset-password", app.ResetPassword)
 
-	// Messaging endpoint (publishes to Kafka)
-	mux.Post("/api/messaging/send", app.SendMessageViaKafka)
+	// New users verify their email address; invited users set their password
+	mux.Post("/api/resend-verification", app.ResendVerification)
+	mux.Post("/api/accept-invitation", app.AcceptInvitation)
+
+	// Customers can sign in with a single-use link emailed to them instead of their password
+	mux.Post("/api/magic-link", app.RequestMagicLink)
+	mux.Post("/api/magic-link/login", app.MagicLinkLogin)
 
-	mux.Post("/api/users", app.CreateUser)
-	mux.Get("/api/users", app.GetAllUsers)
-	mux.Delete("/api/users/{id}", app.DeleteUserByID)
+	// Messaging endpoint (publishes to Kafka), limited to staff who may email
+	// anyone, such as the welcome emails of the users they create
+	mux.Route("/api/messaging", func(r chi.Router) {
+		r.Use(app.Auth)
+		r.With(app.requirePermission(rbac.PermMessagesSend)).Post("/send", app.SendMessageViaKafka)
+	})
+
+	// User management, limited to roles allowed to manage users
+	mux.Route("/api/users", func(r chi.Router) {
+		r.Use(app.Auth)
+		r.With(app.requirePermission(rbac.PermUsersRead)).Get("/", app.GetAllUsers)
+		r.With(app.requirePermission(rbac.PermUsersWrite)).Post("/", app.CreateUser)
+		r.With(app.requirePermission(rbac.PermUsersWrite)).Delete("/{id}", app.DeleteUserByID)
+	})
 
-	// Admin routes with authentication middleware
+	// Self-service account routes, limited to the authenticated customer's own orders
+	mux.Route("/api/account", func(r chi.Router) {
+		r.Use(app.Auth)
+		r.Use(app.requirePermission(rbac.PermAccount))
+		r.Get("/me", app.MyAccount)
+		r.Get("/orders", app.MyOrders)
+		r.Get("/orders/{id}", app.MyOrder)
+		r.Get("/orders/{id}/invoice", app.MyOrderInvoice)
+		r.Get("/subscriptions", app.MySubscriptions)
+		r.Get("/subscriptions/{id}", app.MyOrder)
+		r.Post("/subscriptions/{id}/cancel", app.CancelMySubscription)
+		r.Post("/orders/{id}/returns", app.RequestReturn)
+		r.Get("/returns", app.MyReturns)
+		r.Get("/store-credit", app.MyStoreCredit)
+		r.Get("/sessions", app.MySessions)
+		r.Delete("/sessions/{id}", app.RevokeMySession)
+		r.Post("/sessions/logout-all", app.LogOutEverywhere)
+		r.Get("/mfa", app.MyMFA)
+		r.Post("/mfa/enrol", app.EnrolMyMFA)
+		r.Post("/mfa/enrol/verify", app.ConfirmMyMFA)
+		r.Post("/mfa/disable", app.DisableMyMFA)
+		r.Post("/mfa/recovery-codes", app.RegenerateMyRecoveryCodes)
+		r.Post("/sso/link", app.StartOIDCLink)
+	})
+
+	// Admin routes, each group limited to the roles with its permission
 	mux.Route("/api/admin", func(r chi.Router) {
 		r.Use(app.Auth) // Apply Auth middleware to this subrouter
-		r.Post("/virtual-terminal-succeeded", app.VirtualTerminalPaymentSucceeded)
-		r.Post("/all-sales", app.AllSales)
-		r.Post("/all-subscriptions", app.AllSubscriptions)
-		r.Post("/get-sale/{id}", app.GetSale)
-		r.Post("/get-subscription/{id}", app.GetSale)
-		r.Post("/refund", app.RefundCharge)
-		r.Post("/cancel-subscription", app.CancelSubscription)
-		r.Post("/all-users", app.AllUsers)
-		r.Post("/all-users/{id}", app.ShowUser)
-		r.Post("/all-users/{id}", app.ShowUser)
-		r.Post("/all-users/edit/{id}", app.EditUser)
-		r.Post("/all-users/delete/{id}", app.DeleteUser)
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermPaymentsCharge))
+			r.Post("/virtual-terminal-succeeded", app.VirtualTerminalPaymentSucceeded)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermOrdersRead))
+			r.Post("/all-sales", app.AllSales)
+			r.Post("/all-subscriptions", app.AllSubscriptions)
+			r.Get("/sales", app.SearchSales)
+			r.Get("/subscriptions", app.SearchSubscriptions)
+			r.Post("/get-sale/{id}", app.GetSale)
+			r.Post("/get-subscription/{id}", app.GetSale)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermOrdersRefund))
+			r.Post("/refund", app.RefundCharge)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermSubscriptionsCancel))
+			r.Post("/cancel-subscription", app.CancelSubscription)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermOrdersExport))
+			r.Post("/exports", app.CreateExport)
+			r.Get("/exports/{id}", app.GetExport)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermAPIKeysManage))
+			r.Get("/api-keys", app.AllAPIKeys)
+			r.Post("/api-keys", app.CreateAPIKey)
+			r.Post("/api-keys/{id}/revoke", app.RevokeAPIKey)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermAuditRead))
+			r.Get("/audit-events", app.GetAuditEvents)
+			r.Get("/audit-events/verify", app.VerifyAuditEvents)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermGDPRManage))
+			r.Post("/gdpr/access", app.SubjectAccess)
+			r.Post("/gdpr/erasure", app.SubjectErasure)
+			r.Get("/gdpr/requests", app.GetGDPRRequests)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermReturnsManage))
+			r.Get("/returns", app.GetReturns)
+			r.Get("/returns/{id}", app.GetReturn)
+			r.Post("/returns/{id}/approve", app.ApproveReturn)
+			r.Post("/returns/{id}/reject", app.RejectReturn)
+			r.Post("/returns/{id}/receive", app.ReceiveReturn)
+			r.Post("/returns/{id}/complete", app.CompleteReturn)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermUsersRead))
+			r.Post("/all-users", app.AllUsers)
+			r.Post("/all-users/{id}", app.ShowUser)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermUsersWrite))
+			r.Post("/all-users/edit/{id}", app.EditUser)
+			r.Post("/all-users/delete/{id}", app.DeleteUser)
+			r.Post("/all-users/{id}/revoke-tokens", app.RevokeUserTokens)
+			r.Post("/all-users/{id}/unlock", app.UnlockUserAccount)
+			r.Post("/all-users/{id}/resend-invitation", app.ResendInvitation)
+			r.Post("/invitations", app.InviteUser)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermUsersImpersonate))
+			r.Post("/impersonations", app.StartImpersonation)
+			r.Post("/impersonations/{id}/end", app.EndImpersonation)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermCatalogWrite))
+			r.Post("/widgets/{id}", app.UpdateWidget)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermPricesManage))
+			r.Get("/widgets/{id}/price-history", app.GetPriceHistory)
+			r.Get("/widgets/{id}/price-schedules", app.GetPriceSchedules)
+			r.Post("/widgets/{id}/price-schedules", app.CreatePriceSchedule)
+			r.Post("/price-schedules/{id}/cancel", app.CancelPriceSchedule)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermReportsRead))
+			r.Get("/reports/sales-prices", app.SalesPriceReport)
+			r.Get("/reports/abandoned-checkouts", app.CheckoutRecoveryReport)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermInventoryManage))
+			r.Post("/widgets/{id}/stock-adjustments", app.AdjustStock)
+			r.Get("/widgets/{id}/stock-movements", app.GetStockMovements)
+			r.Get("/widgets/{id}/stock-settings", app.GetStockSettings)
+			r.Post("/widgets/{id}/stock-settings", app.SaveStockSettings)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermPurchasingManage))
+			r.Get("/suppliers", app.GetSuppliers)
+			r.Post("/suppliers", app.CreateSupplier)
+			r.Get("/purchase-orders", app.GetPurchaseOrders)
+			r.Post("/purchase-orders", app.CreatePurchaseOrder)
+			r.Get("/purchase-orders/{id}", app.GetPurchaseOrder)
+			r.Post("/purchase-orders/{id}/order", app.MarkPurchaseOrderOrdered)
+			r.Post("/purchase-orders/{id}/cancel", app.CancelPurchaseOrder)
+			r.Post("/purchase-orders/{id}/receive", app.ReceivePurchaseOrder)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermShippingManage))
+			r.Post("/widgets/{id}/weight", app.SetWidgetWeight)
+			r.Get("/shipping/zones", app.GetShippingZones)
+			r.Post("/shipping/zones", app.CreateShippingZone)
+			r.Get("/shipping/methods", app.GetShippingMethods)
+			r.Post("/shipping/methods", app.CreateShippingMethod)
+			r.Post("/shipping/methods/{id}/rates", app.ReplaceShippingRates)
+			r.Post("/shipping/methods/{id}/active", app.SetShippingMethodActive)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermShipmentsManage))
+			r.Get("/orders/{id}/shipments", app.GetShipments)
+			r.Post("/orders/{id}/shipments", app.CreateShipment)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requirePermission(rbac.PermReviewsModerate))
+			r.Get("/reviews", app.ReviewModerationQueue)
+			r.Post("/reviews/{id}/approve", app.ApproveReview)
+			r.Post("/reviews/{id}/reject", app.RejectReview)
+		})
 	})
 	return mux
 }
@@ -85,6 +85,15 @@ func createWidgets(t *testing.T, db *sql.DB) bool {
 		return false
 	}
 
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS reviews (id SERIAL PRIMARY KEY,
+     widget_id INTEGER NOT NULL,
+     rating    INTEGER NOT NULL,
+     status    VARCHAR(20) NOT NULL DEFAULT 'pending')`)
+	if err != nil {
+		t.Logf("Warning: Failed to create reviews table: %v", err)
+		return false
+	}
+
 	_, err = db.Exec(`INSERT INTO widgets (
     id, name, description, inventory_level, price, image, 
     is_recurring, plan_id, created_at, updated_at
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"usual_store/internal/messaging"
+	"usual_store/internal/models"
+	"usual_store/internal/urlsigner"
+)
+
+// priceSchedulerInterval is how often scheduled price changes are checked
+const priceSchedulerInterval = time.Minute
+
+// runEvery calls job every interval until ctx is cancelled. Errors are logged
+// and the job is retried on the next tick.
+func (app *application) runEvery(ctx context.Context, name string, interval time.Duration, job func(now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.infoLog.Printf("Starting %s every %s", name, interval)
+	for {
+		if err := job(time.Now()); err != nil {
+			app.errorLog.Printf("%s: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			app.infoLog.Printf("Stopped %s", name)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyPriceSchedules starts due price schedules and reverts expired ones,
+// then lets wishlist subscribers know about price drops.
+func (app *application) applyPriceSchedules(now time.Time) error {
+	applied, err := app.DB.ApplyDuePriceSchedules(now)
+	app.priceScheduleChanged(applied)
+	if err != nil {
+		return err
+	}
+
+	reverted, err := app.DB.RevertExpiredPriceSchedules(now)
+	app.priceScheduleChanged(reverted)
+	return err
+}
+
+func (app *application) priceScheduleChanged(changes []models.PriceChange) {
+	for _, change := range changes {
+		app.infoLog.Printf("price schedule %d: widget %d price changed from %d to %d",
+			change.ScheduleID, change.WidgetID, change.OldPrice, change.NewPrice)
+
+		after, err := app.DB.GetWidget(change.WidgetID)
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+		after.Price = change.NewPrice
+		before := after
+		before.Price = change.OldPrice
+		app.widgetChanged(before, after)
+	}
+}
+
+// lowStockCheckInterval is how often queued low-stock alerts are sent
+const lowStockCheckInterval = time.Minute
+
+// sendLowStockAlerts emails admins about widgets whose inventory fell below
+// their threshold. Alerts stay queued until they could be sent.
+func (app *application) sendLowStockAlerts(now time.Time) error {
+	alerts, err := app.DB.GetPendingLowStockAlerts()
+	if err != nil || len(alerts) == 0 {
+		return err
+	}
+
+	recipients, err := app.DB.GetAdminEmails()
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return errors.New("no admin to notify about low stock")
+	}
+
+	for _, alert := range alerts {
+		data := map[string]interface{}{
+			"Product":        alert.WidgetName,
+			"InventoryLevel": alert.InventoryLevel,
+			"Threshold":      alert.Threshold,
+			"Link":           fmt.Sprintf("%s/admin/widgets/%d", app.config.frontend, alert.WidgetID),
+		}
+		if alert.PurchaseOrderID != nil {
+			data["PurchaseOrderID"] = *alert.PurchaseOrderID
+		}
+
+		subject := fmt.Sprintf("Low stock: %s (%d left)", alert.WidgetName, alert.InventoryLevel)
+		sent := true
+		for _, to := range recipients {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = app.queueEmail(ctx, to, subject, "low-stock-alert", data, messaging.PriorityHigh)
+			cancel()
+			if err != nil {
+				app.errorLog.Printf("failed to queue low-stock alert to %s: %v", to, err)
+				sent = false
+			}
+		}
+		if !sent {
+			continue
+		}
+
+		if err = app.DB.MarkLowStockAlertNotified(alert.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// checkoutRecoveryInterval is how often abandoned checkouts are looked for
+	checkoutRecoveryInterval = 15 * time.Minute
+	// checkoutRecoveryWindow is how old an abandoned checkout may be to still get a recovery email
+	checkoutRecoveryWindow = 7 * 24 * time.Hour
+)
+
+// sendCheckoutRecoveryEmails emails customers who started a checkout at least
+// abandonedCheckoutAfter ago without completing it, with a signed link back to it.
+// Checkouts are claimed before they are emailed, so that several instances of
+// the API do not email the same one.
+func (app *application) sendCheckoutRecoveryEmails(now time.Time) error {
+	checkouts, err := app.DB.ClaimAbandonedCheckouts(now.Add(-app.config.abandonedCheckoutAfter), now.Add(-checkoutRecoveryWindow), now)
+	if err != nil {
+		return err
+	}
+
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	for _, checkout := range checkouts {
+		data := map[string]interface{}{
+			"FirstName": checkout.FirstName,
+			"Product":   checkout.WidgetName,
+			"Amount":    formatPrice(checkout.Amount),
+			"Link":      signer.GenerateTokenFromString(fmt.Sprintf("%s/checkout/%d/resume", app.config.frontend, checkout.ID)),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = app.queueEmail(ctx, checkout.Email, "You left something behind", "checkout-recovery", data, messaging.PriorityNormal)
+		cancel()
+		if err != nil {
+			app.errorLog.Printf("failed to queue checkout recovery email for checkout %d: %v", checkout.ID, err)
+			if err = app.DB.ReleaseCheckout(checkout.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	// loginAttemptPruneInterval is how often old sign-in attempts are deleted
+	loginAttemptPruneInterval = time.Hour
+	// loginAttemptRetention is how long sign-in attempts are kept for investigating attacks
+	loginAttemptRetention = 30 * 24 * time.Hour
+)
+
+// pruneLoginAttempts deletes sign-in attempts older than loginAttemptRetention
+func (app *application) pruneLoginAttempts(now time.Time) error {
+	n, err := app.DB.DeleteLoginAttemptsBefore(now.Add(-loginAttemptRetention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		app.infoLog.Printf("Deleted %d old sign-in attempts", n)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"usual_store/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Admin audit log",
+	Long:  "Search the audit log of security-sensitive admin actions and check it was not tampered with",
+}
+
+var auditListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List audit events, newest first",
+	Long: `List audit events, newest first. For example, who deleted user 7:
+
+  usual-store-cli audit list --action user.delete --target-type user --target-id 7`,
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		filter, err := auditListFilter(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db := openModels()
+		defer db.DB.Close()
+
+		events, err := db.GetAuditEvents(filter)
+		if err != nil {
+			log.Fatalf("Error fetching audit events: %v", err)
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err = enc.Encode(events); err != nil {
+				log.Fatalf("Error writing audit events: %v", err)
+			}
+			return
+		}
+
+		fmt.Printf("%-7s %-20s %-30s %-20s %-16s\n", "ID", "Time", "Actor", "Action", "Target")
+		fmt.Println("──────────────────────────────────────────────────────────────────────────────────────────────")
+		for _, e := range events {
+			fmt.Printf("%-7d %-20s %-30s %-20s %-16s\n",
+				e.ID, e.CreatedAt.Format("2006-01-02 15:04:05"), e.Actor, e.Action, e.TargetType+" "+e.TargetID)
+			if len(e.Before) > 0 || len(e.After) > 0 {
+				before, _ := json.Marshal(e.Before)
+				after, _ := json.Marshal(e.After)
+				fmt.Printf("        %s → %s\n", before, after)
+			}
+		}
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the hash chain of the audit log",
+	Long: `Recompute the hash of every audit event to find events that were changed,
+removed or inserted. Removing the newest events is only caught against a head
+hash kept from an earlier run:
+
+  usual-store-cli audit verify --head 3f9a...`,
+	Run: func(cmd *cobra.Command, args []string) {
+		head, _ := cmd.Flags().GetString("head")
+
+		db := openModels()
+		defer db.DB.Close()
+
+		report, err := db.VerifyAuditChain()
+		if err != nil {
+			log.Fatalf("Error verifying audit log: %v", err)
+		}
+
+		if !report.Valid {
+			fmt.Printf("✗ Audit log tampered with: event %d %s\n", report.BrokenAt, report.Problem)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ %d audit events form an unbroken chain\n", report.Events)
+		fmt.Printf("  Head: %s\n", report.Head)
+
+		if head != "" && head != report.Head {
+			found, err := db.AuditEventExists(head)
+			if err != nil {
+				log.Fatalf("Error looking up head: %v", err)
+			}
+			if !found {
+				fmt.Println("✗ The head kept earlier is no longer in the audit log, events were removed")
+				os.Exit(1)
+			}
+			fmt.Println("  The head kept earlier is still in the chain")
+		}
+	},
+}
+
+// auditListFilter builds the audit filter from the list flags
+func auditListFilter(cmd *cobra.Command) (models.AuditFilter, error) {
+	var filter models.AuditFilter
+
+	filter.ActorID, _ = cmd.Flags().GetInt("actor-id")
+	filter.Action, _ = cmd.Flags().GetString("action")
+	filter.TargetType, _ = cmd.Flags().GetString("target-type")
+	filter.TargetID, _ = cmd.Flags().GetString("target-id")
+	filter.Limit, _ = cmd.Flags().GetInt("limit")
+
+	for name, dest := range map[string]*time.Time{"from": &filter.Since, "to": &filter.Until} {
+		value, _ := cmd.Flags().GetString(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return filter, fmt.Errorf("--%s must be a date like 2026-09-30", name)
+		}
+		if name == "to" {
+			t = t.AddDate(0, 0, 1) // include the whole day
+		}
+		*dest = t
+	}
+	return filter, nil
+}
+
+func init() {
+	auditListCmd.Flags().Int("actor-id", 0, "ID of the user who acted")
+	auditListCmd.Flags().String("action", "", "Action, such as order.refund or user.delete")
+	auditListCmd.Flags().String("target-type", "", "Kind of target: order, user or api_key")
+	auditListCmd.Flags().String("target-id", "", "ID of the target")
+	auditListCmd.Flags().String("from", "", "Events on or after this date (YYYY-MM-DD)")
+	auditListCmd.Flags().String("to", "", "Events on or before this date (YYYY-MM-DD)")
+	auditListCmd.Flags().Int("limit", 50, "Number of events, at most 500")
+	auditListCmd.Flags().Bool("json", false, "Print the events as JSON")
+
+	auditVerifyCmd.Flags().String("head", "", "Head hash printed by an earlier run")
+
+	auditCmd.AddCommand(auditListCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"usual_store/internal/driver"
+	"usual_store/internal/password"
 
 	"github.com/spf13/cobra"
 )
@@ -67,6 +68,11 @@ var dbSeedCmd = &cobra.Command{
 
 		if users > 0 {
 			fmt.Printf("Creating %d test users...\n", users)
+			// Every test user signs in with "qwerty"
+			hashedPassword, err := password.Hash("qwerty", password.DefaultParams)
+			if err != nil {
+				log.Fatalf("Error hashing password: %v", err)
+			}
 			for i := 1; i <= users; i++ {
 				query := `INSERT INTO users (first_name, last_name, email, password) 
 						  VALUES ($1, $2, $3, $4) ON CONFLICT (email) DO NOTHING`
@@ -74,7 +80,7 @@ var dbSeedCmd = &cobra.Command{
 					fmt.Sprintf("User%d", i),
 					fmt.Sprintf("Test%d", i),
 					fmt.Sprintf("user%d@test.com", i),
-					"$2a$12$VR1wDmweaF3ZTVgEHiJrNOSi8VcS4j0eamr96A/7iOe8vlum3O3/q", // "qwerty"
+					hashedPassword,
 				)
 				if err != nil {
 					log.Printf("Warning: Failed to create user %d: %v", i, err)
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"usual_store/internal/encryption"
+	"usual_store/internal/keyring"
+	"usual_store/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Secret key rotation",
+	Long:  "Manage the keys that sign links and encrypt stored data",
+}
+
+var keysReencryptCmd = &cobra.Command{
+	Use:   "reencrypt",
+	Short: "Re-encrypt stored data with the active key",
+	Long: `Re-encrypt every encrypted value in the database that was made with an older
+key, so that key can be removed from SECRET_KEYS, including the personal data
+encrypted when PII_INDEX_KEY is set. Run it after making a new key active in
+the API:
+
+  SECRET_KEYS=2025b:<new secret>,0:<old secret> usual-store-cli keys reencrypt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		if batchSize < 1 {
+			log.Fatal("Batch size must be at least 1")
+		}
+
+		keys, err := keyring.Parse(os.Getenv("SECRET_KEYS"), os.Getenv("SECRET_FOR_FRONT"))
+		if err != nil {
+			log.Fatalf("Error loading secret keys: %v", err)
+		}
+		encryptor := encryption.Encryption{Keys: keys}
+
+		db := openModels()
+		defer db.DB.Close()
+
+		fmt.Printf("Re-encrypting with key %s\n", keys.Active().ID)
+		for _, col := range models.EncryptedColumns {
+			changed, err := db.ReencryptColumn(col, batchSize, encryptor.Reencrypt)
+			if err != nil {
+				log.Fatalf("Error re-encrypting %s.%s after %d rows: %v", col.Table, col.Column, changed, err)
+			}
+			fmt.Printf("✓ %s.%s: %d rows re-encrypted\n", col.Table, col.Column, changed)
+		}
+		if db.PII != nil {
+			encryptPII(db, batchSize)
+		}
+	},
+}
+
+func init() {
+	keysReencryptCmd.Flags().Int("batch-size", 100, "Rows read and updated at a time")
+
+	keysCmd.AddCommand(keysReencryptCmd)
+}
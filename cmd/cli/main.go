@@ -19,6 +19,10 @@ Provides tools for:
   - Database operations (migrate, seed, backup)
   - Testing utilities (generate test data)
   - Health checks and monitoring
+  - Order, transaction and customer exports
+  - Admin audit log search and verification
+  - Secret key rotation
+  - Personal data encryption
   - Stripe integration testing`,
 	Version: version,
 }
@@ -29,6 +33,11 @@ func init() {
 	rootCmd.AddCommand(dbCmd)
 	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(healthCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(gdprCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(keysCmd)
+	rootCmd.AddCommand(piiCmd)
 }
 
 func main() {
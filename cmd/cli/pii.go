@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"usual_store/internal/keyring"
+	"usual_store/internal/models"
+	"usual_store/internal/pii"
+
+	"github.com/spf13/cobra"
+)
+
+var piiCmd = &cobra.Command{
+	Use:   "pii",
+	Short: "Personal data encryption",
+	Long:  "Manage the encryption of the names and emails of users, customers and support tickets",
+}
+
+var piiEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt personal data stored in plain text",
+	Long: `Encrypt the names and emails of users, customers and support tickets that
+are stored in plain text, or with a key that is no longer active, and index
+their emails. The API looks rows up by email before and after they are
+encrypted, so it can run while the API serves requests, once the API itself
+has PII_INDEX_KEY:
+
+  PII_INDEX_KEY=<at least 32 bytes> usual-store-cli pii encrypt
+
+Emails of encrypted rows can only be searched for in whole, and names and
+emails no longer sort in the admin user and order lists.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		if batchSize < 1 {
+			log.Fatal("Batch size must be at least 1")
+		}
+
+		db := openModels()
+		defer db.DB.Close()
+		if db.PII == nil {
+			log.Fatal("PII_INDEX_KEY is not set")
+		}
+		encryptPII(db, batchSize)
+	},
+}
+
+// loadPII returns the cipher of personal data, configured like the API with
+// SECRET_KEYS and PII_INDEX_KEY, or nil when PII_INDEX_KEY is not set
+func loadPII() *pii.Cipher {
+	indexKey := os.Getenv("PII_INDEX_KEY")
+	if indexKey == "" {
+		return nil
+	}
+	keys, err := keyring.Parse(os.Getenv("SECRET_KEYS"), os.Getenv("SECRET_FOR_FRONT"))
+	if err != nil {
+		log.Fatalf("Error loading secret keys: %v", err)
+	}
+	cipher, err := pii.New(keys, []byte(indexKey))
+	if err != nil {
+		log.Fatalf("Error loading PII key: %v", err)
+	}
+	return cipher
+}
+
+// encryptPII encrypts the personal data of every table, batchSize rows at a time
+func encryptPII(db *models.DBModel, batchSize int) {
+	for _, table := range models.PIITables {
+		changed, err := db.EncryptPII(table, batchSize)
+		if err != nil {
+			log.Fatalf("Error encrypting %s after %d values: %v", table.Table, changed, err)
+		}
+		fmt.Printf("✓ %s: %d values encrypted\n", table.Table, changed)
+	}
+}
+
+func init() {
+	piiEncryptCmd.Flags().Int("batch-size", 100, "Rows read and updated at a time")
+
+	piiCmd.AddCommand(piiEncryptCmd)
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log"
 	"usual_store/internal/driver"
+	"usual_store/internal/password"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var testCmd = &cobra.Command{
@@ -108,11 +108,11 @@ func generateMinimalData(db *sql.DB) {
 	fmt.Println("Creating minimal test data...")
 
 	// Create a test user
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpass123"), bcrypt.DefaultCost)
+	hashedPassword, _ := password.Hash("testpass123", password.DefaultParams)
 	query := `INSERT INTO users (first_name, last_name, email, password) 
 			  VALUES ($1, $2, $3, $4) ON CONFLICT (email) DO NOTHING RETURNING id`
 	var userID int
-	err := db.QueryRow(query, "Test", "User", "test@example.com", string(hashedPassword)).Scan(&userID)
+	err := db.QueryRow(query, "Test", "User", "test@example.com", hashedPassword).Scan(&userID)
 	if err != nil {
 		log.Printf("Test user might already exist")
 	} else {
@@ -19,6 +19,8 @@ func (app *application) routes() http.Handler {
 	}))
 
 	mux.Get("/invoice/create-and-send", app.CreateAndSendInvoice)
+	mux.Post("/invoice/create-and-send", app.CreateAndSendInvoice)
+	mux.Get("/invoice/{id}", app.GetInvoice)
 
 	return mux
 }
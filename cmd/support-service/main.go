@@ -10,10 +10,15 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"usual_store/internal/driver"
+	"usual_store/internal/keyring"
+	"usual_store/internal/pii"
+	"usual_store/internal/rbac"
 	"usual_store/internal/support"
+	"usual_store/pkg/repository"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -24,6 +29,8 @@ import (
 var (
 	hub      *support.Hub
 	db       *sql.DB
+	cipher   *pii.Cipher
+	tokens   repository.TokenRepository
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
@@ -52,37 +59,29 @@ func main() {
 	defer db.Close()
 	log.Println("Connected to database")
 
+	// Names and emails are encrypted when PII_INDEX_KEY is set, with the
+	// same keys as the API
+	if indexKey := os.Getenv("PII_INDEX_KEY"); indexKey != "" {
+		keys, err := keyring.Parse(os.Getenv("SECRET_KEYS"), os.Getenv("SECRET_FOR_FRONT"))
+		if err != nil {
+			log.Fatalf("Error loading secret keys: %v", err)
+		}
+		cipher, err = pii.New(keys, []byte(indexKey))
+		if err != nil {
+			log.Fatalf("Error loading PII key: %v", err)
+		}
+	}
+	tokens = &repository.DBModel{DB: db, PII: cipher}
+
 	// Initialize WebSocket hub
 	hub = support.NewHub()
 	go hub.Run()
 	log.Println("WebSocket hub started")
 
-	// Setup router
-	router := chi.NewRouter()
-
-	// Middleware
-	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
-
-	// Routes
-	router.Post("/api/support/ticket", handleCreateTicket)
-	router.Get("/api/support/tickets", handleGetOpenTickets)
-	router.Get("/api/support/ticket/{sessionID}", handleGetTicket)
-	router.Get("/api/support/ticket/{sessionID}/messages", handleGetMessages)
-	router.Post("/api/support/ticket/{ticketID}/assign", handleAssignTicket)
-	router.Get("/ws/support/user/{sessionID}", handleUserWebSocket)
-	router.Get("/ws/support/supporter/{sessionID}", handleSupporterWebSocket)
-	router.Get("/api/support/health", handleHealthCheck)
-
 	// Start server
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%s", port),
-		Handler:           router,
+		Handler:           routes(),
 		ReadTimeout:       15 * time.Second,
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
@@ -112,6 +111,35 @@ func main() {
 	log.Println("Support Chat Service stopped")
 }
 
+// routes registers the service's routes. Listing and assigning tickets and
+// joining chats as a supporter need a token whose role grants the permission;
+// customers open tickets and chat without an account.
+func routes() http.Handler {
+	router := chi.NewRouter()
+
+	// Middleware
+	router.Use(cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}))
+	router.Use(authenticate)
+
+	// Routes
+	router.Post("/api/support/ticket", handleCreateTicket)
+	router.With(requirePermission(rbac.PermSupportTicketsRead)).Get("/api/support/tickets", handleGetOpenTickets)
+	router.Get("/api/support/ticket/{sessionID}", handleGetTicket)
+	router.Get("/api/support/ticket/{sessionID}/messages", handleGetMessages)
+	router.With(requirePermission(rbac.PermSupportTicketAssign)).Post("/api/support/ticket/{ticketID}/assign", handleAssignTicket)
+	router.Get("/ws/support/user/{sessionID}", handleUserWebSocket)
+	router.With(requirePermission(rbac.PermSupportChat)).Get("/ws/support/supporter/{sessionID}", handleSupporterWebSocket)
+	router.Get("/api/support/health", handleHealthCheck)
+
+	return router
+}
+
 // handleCreateTicket creates a new support ticket
 func handleCreateTicket(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -146,7 +174,7 @@ func handleCreateTicket(w http.ResponseWriter, r *http.Request) {
 		UserName:  req.UserName,
 	}
 
-	if err := support.CreateTicket(db, ticket); err != nil {
+	if err := support.CreateTicket(db, cipher, ticket); err != nil {
 		log.Printf("Error creating ticket: %v", err)
 		http.Error(w, "Failed to create ticket", http.StatusInternalServerError)
 		return
@@ -160,7 +188,7 @@ func handleCreateTicket(w http.ResponseWriter, r *http.Request) {
 
 // handleGetOpenTickets retrieves all open tickets
 func handleGetOpenTickets(w http.ResponseWriter, r *http.Request) {
-	tickets, err := support.GetAllOpenTickets(db)
+	tickets, err := support.GetAllOpenTickets(db, cipher)
 	if err != nil {
 		log.Printf("Error getting tickets: %v", err)
 		http.Error(w, "Failed to get tickets", http.StatusInternalServerError)
@@ -177,7 +205,7 @@ func handleGetOpenTickets(w http.ResponseWriter, r *http.Request) {
 func handleGetTicket(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "sessionID")
 
-	ticket, err := support.GetTicketBySessionID(db, sessionID)
+	ticket, err := support.GetTicketBySessionID(db, cipher, sessionID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Ticket not found", http.StatusNotFound)
@@ -198,7 +226,7 @@ func handleGetTicket(w http.ResponseWriter, r *http.Request) {
 func handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "sessionID")
 
-	ticket, err := support.GetTicketBySessionID(db, sessionID)
+	ticket, err := support.GetTicketBySessionID(db, cipher, sessionID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Ticket not found", http.StatusNotFound)
@@ -222,7 +250,7 @@ func handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleAssignTicket assigns a ticket to a supporter
+// handleAssignTicket assigns a ticket to a supporter, by default the one making the request
 func handleAssignTicket(w http.ResponseWriter, r *http.Request) {
 	ticketIDStr := chi.URLParam(r, "ticketID")
 	ticketID, err := strconv.Atoi(ticketIDStr)
@@ -235,9 +263,15 @@ func handleAssignTicket(w http.ResponseWriter, r *http.Request) {
 		SupporterID int `json:"supporter_id"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.SupporterID == 0 {
+		user, _ := currentUser(r)
+		req.SupporterID = user.ID
 	}
 
 	if err := support.UpdateTicketStatus(db, ticketID, "assigned", &req.SupporterID); err != nil {
@@ -279,6 +313,7 @@ func handleUserWebSocket(w http.ResponseWriter, r *http.Request) {
 		UserID:     nil,
 		UserName:   userName,
 		DB:         db,
+		PII:        cipher,
 	}
 
 	client.Hub.Register <- client
@@ -303,21 +338,15 @@ func handleUserWebSocket(w http.ResponseWriter, r *http.Request) {
 func handleSupporterWebSocket(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "sessionID")
 
-	// Get supporter info from query parameters (in production, this should come from JWT)
-	supporterIDStr := r.URL.Query().Get("supporter_id")
-	supporterName := r.URL.Query().Get("name")
+	// The supporter is the authenticated user, never the query string
+	user, _ := currentUser(r)
+	supporterID := user.ID
+	supporterName := strings.TrimSpace(user.FirstName + " " + user.LastName)
 
 	if supporterName == "" {
 		supporterName = "Support Agent"
 	}
 
-	var supporterID *int
-	if supporterIDStr != "" {
-		if id, err := strconv.Atoi(supporterIDStr); err == nil {
-			supporterID = &id
-		}
-	}
-
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -330,9 +359,10 @@ func handleSupporterWebSocket(w http.ResponseWriter, r *http.Request) {
 		Send:       make(chan []byte, 256),
 		SessionID:  sessionID,
 		ClientType: "supporter",
-		UserID:     supporterID,
+		UserID:     &supporterID,
 		UserName:   supporterName,
 		DB:         db,
+		PII:        cipher,
 	}
 
 	client.Hub.Register <- client
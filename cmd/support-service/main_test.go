@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"usual_store/internal/mocks"
+	"usual_store/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type route struct {
+	method string
+	path   string
+}
+
+// protectedRoutes lists the routes that need a role with a support permission
+var protectedRoutes = []route{
+	{http.MethodGet, "/api/support/tickets"},
+	{http.MethodPost, "/api/support/ticket/{ticketID}/assign"},
+	{http.MethodGet, "/ws/support/supporter/{sessionID}"},
+}
+
+// publicRoutes lists the routes customers use without an account
+var publicRoutes = []route{
+	{http.MethodPost, "/api/support/ticket"},
+	{http.MethodGet, "/api/support/ticket/{sessionID}"},
+	{http.MethodGet, "/api/support/ticket/{sessionID}/messages"},
+	{http.MethodGet, "/ws/support/user/{sessionID}"},
+	{http.MethodGet, "/api/support/health"},
+}
+
+func TestRoutesAreClassified(t *testing.T) {
+	known := map[route]bool{}
+	for _, rt := range append(append([]route{}, protectedRoutes...), publicRoutes...) {
+		known[rt] = true
+	}
+
+	err := chi.Walk(routes().(chi.Routes), func(method, path string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		assert.True(t, known[route{method, path}], "route %s %s is neither protected nor public", method, path)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestProtectedRoutes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTokenRepository(ctrl)
+	tokens = repo
+
+	var mock sqlmock.Sqlmock
+	var err error
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	roleTokens := map[string]string{
+		"super_admin": "TOKENSUPERADMIN00000000000",
+		"admin":       "TOKENADMIN0000000000000000",
+		"supporter":   "TOKENSUPPORTER000000000000",
+		"user":        "TOKENUSER00000000000000000",
+		"unknown":     "TOKENUNKNOWN00000000000000",
+	}
+	for role, token := range roleTokens {
+		repo.EXPECT().GetUserForToken(gomock.Any(), token).
+			Return(&models.User{ID: 7, FirstName: "Sam", Role: role}, nil).AnyTimes()
+	}
+	repo.EXPECT().GetUserForToken(gomock.Any(), "expired").
+		Return(nil, errors.New("no matching token")).AnyTimes()
+
+	handler := routes()
+
+	allowed := map[string]bool{"super_admin": true, "admin": true, "supporter": true}
+
+	for _, rt := range protectedRoutes {
+		path := strings.NewReplacer("{ticketID}", "1", "{sessionID}", "abc").Replace(rt.path)
+
+		t.Run(rt.method+" "+rt.path+" anonymous", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(rt.method, path, nil))
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		})
+
+		t.Run(rt.method+" "+rt.path+" invalid token", func(t *testing.T) {
+			req := httptest.NewRequest(rt.method, path, nil)
+			req.Header.Set("Authorization", "Bearer expired")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		})
+
+		for role, token := range roleTokens {
+			t.Run(rt.method+" "+rt.path+" "+role, func(t *testing.T) {
+				req := httptest.NewRequest(rt.method, path, nil)
+				if strings.HasPrefix(rt.path, "/ws/") {
+					req.URL.RawQuery = "token=" + token
+				} else {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				if allowed[role] {
+					assert.NotEqual(t, http.StatusUnauthorized, rr.Code)
+					assert.NotEqual(t, http.StatusForbidden, rr.Code)
+				} else {
+					assert.Equal(t, http.StatusForbidden, rr.Code)
+				}
+			})
+		}
+	}
+}
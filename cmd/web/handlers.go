@@ -3,9 +3,11 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 	"usual_store/internal/cards"
 	"usual_store/internal/encryption"
 	"usual_store/internal/models"
@@ -26,6 +28,21 @@ func (app *application) VirtualTerminal(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// checkoutResumeLinkMinutes is how long the link of a checkout recovery email stays valid
+const checkoutResumeLinkMinutes = 7 * 24 * 60
+
+// unlockLinkMinutes is how long the link of an account locked email stays valid
+const unlockLinkMinutes = 60
+
+// verificationLinkMinutes is how long the link of a verification email stays valid
+const verificationLinkMinutes = 24 * 60
+
+// invitationLinkMinutes is how long the link of an invitation stays valid
+const invitationLinkMinutes = 7 * 24 * 60
+
+// magicLinkMinutes is how long a magic sign-in link stays valid
+const magicLinkMinutes = 15
+
 type TransactionData struct {
 	FirstName       string
 	LastName        string
@@ -144,16 +161,78 @@ func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request)
 		Amount:        txnData.PaymentAmount,
 	}
 
-	_, err = app.SaveOrder(order)
+	billing, shipping := formAddresses(r)
+	if methodID, _ := strconv.Atoi(r.Form.Get("shipping_method_id")); methodID > 0 {
+		quote, err := app.quoteShipping(widgetID, methodID, shipping.Country)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+		order.ShippingMethodID = &quote.MethodID
+		order.ShippingAmount = quote.Price
+	}
+
+	orderID, err := app.SaveOrder(order)
 	if err != nil {
 		app.errorLog.Println(err)
 		return
 	}
 
+	if billing.Line1 != "" {
+		err = app.DB.SaveOrderAddresses(orderID, billing, &shipping)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+	}
+
+	err = app.DB.CompleteCheckout(txnData.PaymentIntent, orderID, time.Now())
+	if err != nil && !errors.Is(err, models.ErrCheckoutNotFound) {
+		app.errorLog.Println(err)
+	}
+
 	app.Session.Put(r.Context(), "receipt", txnData)
 	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
 }
 
+// formAddresses reads the billing and shipping address fields of the checkout
+// form. The shipping address is the billing address unless the customer gave
+// a different one.
+func formAddresses(r *http.Request) (models.Address, models.Address) {
+	address := func(prefix string) models.Address {
+		return models.Address{
+			Name:       r.Form.Get(prefix + "_name"),
+			Line1:      r.Form.Get(prefix + "_line1"),
+			Line2:      r.Form.Get(prefix + "_line2"),
+			City:       r.Form.Get(prefix + "_city"),
+			Region:     r.Form.Get(prefix + "_region"),
+			PostalCode: r.Form.Get(prefix + "_postal_code"),
+			Country:    r.Form.Get(prefix + "_country"),
+			Phone:      r.Form.Get(prefix + "_phone"),
+		}
+	}
+
+	billing := address("billing")
+	if r.Form.Get("shipping_same_as_billing") != "" || r.Form.Get("shipping_line1") == "" {
+		return billing, billing
+	}
+	return billing, address("shipping")
+}
+
+// quoteShipping prices a shipping method for one widget shipped to country, so
+// the stored shipping amount never depends on what the browser sent.
+func (app *application) quoteShipping(widgetID, methodID int, country string) (models.ShippingQuote, error) {
+	widget, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		return models.ShippingQuote{}, err
+	}
+	weight, err := app.DB.GetShippingWeight(widgetID, 1)
+	if err != nil {
+		return models.ShippingQuote{}, err
+	}
+	return app.DB.QuoteShippingMethod(methodID, country, weight, widget.Price)
+}
+
 // VirtualTerminalPaymentSucceeded displays the receipt page for virtual terminal transactions
 func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r *http.Request) {
 	txnData, err := app.GetTransactionData(r)
@@ -263,6 +342,9 @@ func (app *application) ChargeOnce(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["widget"] = widget
+	// prefill the form of a checkout resumed from a recovery email
+	data["email"] = app.Session.PopString(r.Context(), "checkout_email")
+	data["first_name"] = app.Session.PopString(r.Context(), "checkout_first_name")
 	if err := app.renderTemplate(w, r, "buy-once", &templateData{
 		Data: data,
 	}, "stripe-js"); err != nil {
@@ -298,8 +380,12 @@ func (app *application) GoldenPlanReceipt(w http.ResponseWriter, r *http.Request
 func (app *application) LoginPage(w http.ResponseWriter, r *http.Request) {
 	nonce := generateNonce()
 
+	data := make(map[string]interface{})
+	data["sso"] = app.config.sso
+
 	if err := app.renderTemplate(w, r, "login", &templateData{
 		Nonce: nonce,
+		Data:  data,
 	}); err != nil {
 		app.errorLog.Println(err)
 		return
@@ -327,10 +413,54 @@ func (app *application) PostLoginPage(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+
+	// The password alone is not enough: the login page signs in through the
+	// API first, which asks for the second factor, and posts the token it got
+	user, err := app.tokens().GetUserForToken(r.Context(), r.Form.Get("token"))
+	if err != nil || user.ID != id {
+		app.errorLog.Printf("login of user %d without a valid API token", id)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
 	app.Session.Put(r.Context(), "userID", id)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// OIDCCallbackPage is where the identity provider sends staff back to after
+// single sign-on; the page finishes signing in through the API
+func (app *application) OIDCCallbackPage(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "oidc-callback", &templateData{
+		Nonce: generateNonce(),
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// PostSSOLoginPage signs in the user of the API token the callback page got
+// through single sign-on, or the magic-link page got for a sign-in link,
+// without their password
+func (app *application) PostSSOLoginPage(w http.ResponseWriter, r *http.Request) {
+	err := app.Session.RenewToken(r.Context())
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	user, err := app.tokens().GetUserForToken(r.Context(), r.Form.Get("token"))
+	if err != nil {
+		app.errorLog.Println("single sign-on without a valid API token")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	app.Session.Put(r.Context(), "userID", user.ID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // LogoutPage destroys session and redirect to login page
 func (app *application) LogoutPage(w http.ResponseWriter, r *http.Request) {
 	err := app.Session.Destroy(r.Context())
@@ -347,6 +477,32 @@ func (app *application) LogoutPage(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// ResumeCheckout follows the signed link of a checkout recovery email back to the product page
+func (app *application) ResumeCheckout(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	testUrl := fmt.Sprintf("%s%s", app.config.frontend, r.RequestURI)
+	if !signer.VerifyToken(testUrl) || signer.Expired(testUrl, checkoutResumeLinkMinutes) {
+		app.errorLog.Println("Invalid or expired checkout link")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	checkoutID, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	checkout, err := app.DB.ResumeCheckout(checkoutID, time.Now())
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "checkout_email", checkout.Email)
+	app.Session.Put(r.Context(), "checkout_first_name", checkout.FirstName)
+	http.Redirect(w, r, fmt.Sprintf("/widgets/%d", checkout.WidgetID), http.StatusSeeOther)
+}
+
 // ForgotPassword restore login
 func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "forgot-password", &templateData{}); err != nil {
@@ -364,7 +520,7 @@ func (app *application) ShowResetPassword(w http.ResponseWriter, r *http.Request
 	testUrl := fmt.Sprintf("%s%s", app.config.frontend, url)
 
 	signer := urlsigner.Signer{
-		Secret: []byte(app.config.secretkey),
+		Keys: app.keys,
 	}
 
 	valid := signer.VerifyToken(testUrl)
@@ -381,7 +537,7 @@ func (app *application) ShowResetPassword(w http.ResponseWriter, r *http.Request
 	}
 
 	encryptor := encryption.Encryption{
-		Key: []byte(app.config.secretkey),
+		Keys: app.keys,
 	}
 
 	encryptedEmail, err := encryptor.Encrypt(email)
@@ -399,6 +555,128 @@ func (app *application) ShowResetPassword(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// UnlockAccount unlocks an account locked after failed sign-ins, from the
+// signed link emailed to its owner
+func (app *application) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	data := make(map[string]interface{})
+	testUrl := fmt.Sprintf("%s%s", app.config.frontend, r.RequestURI)
+	if !signer.VerifyToken(testUrl) || signer.Expired(testUrl, unlockLinkMinutes) {
+		app.errorLog.Println("Invalid or expired unlock link")
+		data["unlocked"] = false
+	} else {
+		err := app.DB.UnlockUserByEmail(r.URL.Query().Get("email"))
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		data["unlocked"] = err == nil
+	}
+
+	if err := app.renderTemplate(w, r, "unlock-account", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// VerifyEmail activates a new account from the signed link emailed to its
+// owner. Only the latest link sent works.
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	email := r.URL.Query().Get("email")
+	data := make(map[string]interface{})
+	data["email"] = email
+
+	testUrl := fmt.Sprintf("%s%s", app.config.frontend, r.RequestURI)
+	sent, err := strconv.ParseInt(r.URL.Query().Get("sent"), 10, 64)
+	switch {
+	case err != nil || !signer.VerifyToken(testUrl):
+		app.errorLog.Println("Invalid verification link")
+		data["status"] = "invalid"
+	case signer.Expired(testUrl, verificationLinkMinutes):
+		data["status"] = "expired"
+	default:
+		_, err = app.DB.VerifyEmail(email, time.Unix(sent, 0).UTC(), time.Now())
+		if err != nil {
+			if !errors.Is(err, models.ErrSignupLinkInvalid) {
+				app.errorLog.Println(err)
+			}
+			data["status"] = "invalid"
+		} else {
+			data["status"] = "verified"
+		}
+	}
+
+	if err := app.renderTemplate(w, r, "verify-email", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// AcceptInvitation shows the page where an invited user chooses their
+// password, from the signed link of their invitation
+func (app *application) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	data := make(map[string]interface{})
+	testUrl := fmt.Sprintf("%s%s", app.config.frontend, r.RequestURI)
+	switch {
+	case !signer.VerifyToken(testUrl):
+		app.errorLog.Println("Invalid invitation link")
+		data["status"] = "invalid"
+	case signer.Expired(testUrl, invitationLinkMinutes):
+		data["status"] = "expired"
+	default:
+		// The API checks the link again when the password is set
+		data["status"] = "valid"
+		data["token"] = testUrl
+	}
+
+	if err := app.renderTemplate(w, r, "accept-invitation", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// MagicLinkPage shows the page that signs a customer in from the magic
+// link emailed to them. The page sends the link to the API, which consumes
+// it, so opening the link alone does not use it up.
+func (app *application) MagicLinkPage(w http.ResponseWriter, r *http.Request) {
+	signer := urlsigner.Signer{
+		Keys: app.keys,
+	}
+
+	data := make(map[string]interface{})
+	testUrl := fmt.Sprintf("%s%s", app.config.frontend, r.RequestURI)
+	switch {
+	case !signer.VerifyToken(testUrl):
+		app.errorLog.Println("Invalid sign-in link")
+		data["status"] = "invalid"
+	case signer.Expired(testUrl, magicLinkMinutes):
+		data["status"] = "expired"
+	default:
+		data["status"] = "valid"
+		data["token"] = testUrl
+	}
+
+	if err := app.renderTemplate(w, r, "magic-link", &templateData{
+		Nonce: generateNonce(),
+		Data:  data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
 // AllSales show all sales
 func (app *application) AllSales(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "all-sales", &templateData{}); err != nil {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/keyring"
+	"usual_store/internal/models"
+	passwords "usual_store/internal/password"
+	"usual_store/internal/pii"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexedwards/scs/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptedTestApp(t *testing.T) (*application, sqlmock.Sqlmock, *pii.Cipher) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	keys, err := keyring.Parse("", "secret-key-of-exactly-32-bytes!!")
+	require.NoError(t, err)
+	cipher, err := pii.New(keys, []byte("blind-index-key-of-at-least-32-bytes"))
+	require.NoError(t, err)
+
+	app := &application{
+		infoLog:  log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+		DB:       models.DBModel{DB: db, PII: cipher},
+		Session:  scs.New(),
+		keys:     keys,
+	}
+	return app, mock, cipher
+}
+
+// expectEncryptedTokenUser expects the user of an API token, with their name
+// and email stored encrypted
+func expectEncryptedTokenUser(t *testing.T, mock sqlmock.Sqlmock, cipher *pii.Cipher) {
+	var sealed []string
+	for _, value := range []string{"Doe", "jane@example.com", "Jane"} {
+		s, err := cipher.Seal(value)
+		require.NoError(t, err)
+		sealed = append(sealed, s)
+	}
+	mock.ExpectQuery("FROM users u INNER JOIN tokens t").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), models.ScopeAuthentication).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "id", "last_used_at"}).
+			AddRow(3, sealed[0], sealed[1], sealed[2], "user", 9, time.Now()))
+}
+
+func postForm(app *application, handler http.HandlerFunc, form url.Values) (*httptest.ResponseRecorder, int) {
+	var userID int
+	h := app.Session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		userID = app.Session.GetInt(r.Context(), "userID")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w, userID
+}
+
+func TestPostLoginPage_EncryptedUser(t *testing.T) {
+	app, mock, cipher := encryptedTestApp(t)
+	hash, err := passwords.Hash("secret", passwords.DefaultParams)
+	require.NoError(t, err)
+
+	mock.ExpectQuery("SELECT id, password FROM users WHERE email_index = \\$2").
+		WithArgs("jane@example.com", cipher.Index("jane@example.com")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password"}).AddRow(3, hash))
+	expectEncryptedTokenUser(t, mock, cipher)
+
+	w, userID := postForm(app, app.PostLoginPage, url.Values{
+		"email": {"jane@example.com"}, "password": {"secret"}, "token": {"api-token"},
+	})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+	assert.Equal(t, 3, userID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostSSOLoginPage_EncryptedUser(t *testing.T) {
+	app, mock, cipher := encryptedTestApp(t)
+	expectEncryptedTokenUser(t, mock, cipher)
+
+	w, userID := postForm(app, app.PostSSOLoginPage, url.Values{"token": {"api-token"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+	assert.Equal(t, 3, userID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
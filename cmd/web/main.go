@@ -13,7 +13,10 @@ import (
 	"os"
 	"time"
 	"usual_store/internal/driver"
+	"usual_store/internal/keyring"
 	"usual_store/internal/models"
+	"usual_store/internal/pii"
+	"usual_store/pkg/repository"
 )
 
 const version = "1.0.0"
@@ -32,7 +35,14 @@ type config struct {
 		key    string
 	}
 	secretkey string
-	frontend  string
+	// secretKeys lists the keys of signed links as id:secret pairs, the active key first
+	secretKeys string
+	// piiIndexKey is the key of the blind index of encrypted emails, empty
+	// when names and emails are stored in plain text
+	piiIndexKey string
+	frontend    string
+	// sso shows single sign-on on the login page, when the API has an identity provider
+	sso bool
 }
 
 type application struct {
@@ -43,6 +53,13 @@ type application struct {
 	version       string
 	DB            models.DBModel
 	Session       *scs.SessionManager
+	keys          *keyring.Keyring
+}
+
+// tokens returns the token repository, reading users with the PII cipher of
+// the models so that encrypted names and emails open
+func (app *application) tokens() *repository.DBModel {
+	return &repository.DBModel{DB: app.DB.DB, PII: app.DB.PII, ErrorLog: app.errorLog}
 }
 
 func (app *application) serve() error {
@@ -72,6 +89,15 @@ func main() {
 	// Parse command-line flags
 	cfg := parseFlags(secretKeyForFront, defaultDSN, apiUrl, frontUrl)
 
+	keys, err := keyring.Parse(cfg.secretKeys, cfg.secretkey)
+	if err != nil {
+		log.Fatalf("Error loading secret keys: %v", err)
+	}
+	piiCipher, err := pii.Load(keys, cfg.piiIndexKey)
+	if err != nil {
+		log.Fatalf("Error loading PII key: %v", err)
+	}
+
 	// Setup loggers
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
@@ -96,9 +122,11 @@ func main() {
 		templateCache: make(map[string]*template.Template),
 		version:       version,
 		DB: models.DBModel{
-			DB: conn,
+			DB:  conn,
+			PII: piiCipher,
 		},
 		Session: session,
+		keys:    keys,
 	}
 
 	go app.ListenToWsChannel()
@@ -127,8 +155,11 @@ func parseFlags(secretKey, dsn, apiUrl, frontUrl string) config {
 	flag.StringVar(&cfg.env, "env", "development", "Application environment {development|production}")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", dsn, "Database DSN")
 	flag.StringVar(&cfg.secretkey, "secret", secretKey, "Secret key")
+	flag.StringVar(&cfg.secretKeys, "secret-keys", os.Getenv("SECRET_KEYS"), "Keys for signed links and encrypted data as id:secret pairs, comma-separated, the active key first (default: the secret key as key 0)")
+	flag.StringVar(&cfg.piiIndexKey, "pii-index-key", os.Getenv("PII_INDEX_KEY"), "Key of the blind index of encrypted emails, at least 32 bytes; empty to store names and emails in plain text")
 	flag.StringVar(&cfg.api, "api", apiUrl, "URL to API")
 	flag.StringVar(&cfg.frontend, "frontend", frontUrl, "URL to frontend")
+	flag.BoolVar(&cfg.sso, "sso", os.Getenv("OIDC_ISSUER") != "", "Offer single sign-on on the login page")
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
@@ -16,15 +16,22 @@ func (app *application) routes() http.Handler {
 	mux.Get("/widgets/{id}", app.ChargeOnce)
 	mux.Post("/payment-succeeded", app.PaymentSucceeded)
 	mux.Get("/receipt", app.Receipt)
+	mux.Get("/checkout/{id}/resume", app.ResumeCheckout)
 
 	mux.Get("/plans/golden", app.GoldenPlan)
 	mux.Get("/receipt/golden", app.GoldenPlanReceipt)
 
 	mux.Get("/login", app.LoginPage)
 	mux.Post("/login", app.PostLoginPage)
+	mux.Get("/auth/oidc/callback", app.OIDCCallbackPage)
+	mux.Post("/login/sso", app.PostSSOLoginPage)
 	mux.Get("/logout", app.LogoutPage)
 	mux.Get("/forgot-password", app.ForgotPassword)
 	mux.Get("/reset-password", app.ShowResetPassword)
+	mux.Get("/unlock-account", app.UnlockAccount)
+	mux.Get("/verify-email", app.VerifyEmail)
+	mux.Get("/accept-invitation", app.AcceptInvitation)
+	mux.Get("/magic-link", app.MagicLinkPage)
 
 	mux.Route("/admin", func(r chi.Router) {
 		r.Use(app.Auth)
@@ -25,6 +25,7 @@ require (
 	go.opentelemetry.io/otel/trace v1.39.0
 	golang.org/x/crypto v0.44.0
 	golang.org/x/time v0.9.0
+	rsc.io/qr v0.2.0
 )
 
 require (
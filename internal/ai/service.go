@@ -250,11 +250,15 @@ func (s *Service) createMessage(msg *Message) error {
 		msg.ResponseTimeMs, msg.Model, msg.Temperature, msg.Metadata, msg.CreatedAt).Scan(&msg.ID)
 }
 
-// getProductContext retrieves product information for AI context
+// getProductContext retrieves product information for AI context,
+// including the average rating of approved reviews so the assistant can cite it
 func (s *Service) getProductContext() (string, error) {
-	query := `SELECT id, name, description, price, is_recurring 
-	          FROM widgets 
-	          WHERE inventory_level > 0 
+	query := `SELECT id, name, description, price, is_recurring,
+	                 COALESCE((SELECT AVG(r.rating)::FLOAT FROM reviews r
+	                           WHERE r.widget_id = widgets.id AND r.status = 'approved'), 0),
+	                 (SELECT COUNT(*) FROM reviews r
+	                  WHERE r.widget_id = widgets.id AND r.status = 'approved')
+	          FROM widgets WHERE inventory_level > 0 
 	          ORDER BY price ASC 
 	          LIMIT 20`
 
@@ -272,8 +276,10 @@ func (s *Service) getProductContext() (string, error) {
 		var name, description string
 		var price float64
 		var isRecurring bool
+		var averageRating float64
+		var reviewCount int
 
-		if err := rows.Scan(&id, &name, &description, &price, &isRecurring); err != nil {
+		if err := rows.Scan(&id, &name, &description, &price, &isRecurring, &averageRating, &reviewCount); err != nil {
 			continue
 		}
 
@@ -282,7 +288,12 @@ func (s *Service) getProductContext() (string, error) {
 			productType = "subscription"
 		}
 
-		context.WriteString(fmt.Sprintf("- %s ($%.2f, %s): %s\n", name, price/100.0, productType, description))
+		rating := "no reviews yet"
+		if reviewCount > 0 {
+			rating = fmt.Sprintf("rated %.1f/5 from %d reviews", averageRating, reviewCount)
+		}
+
+		context.WriteString(fmt.Sprintf("- %s ($%.2f, %s, %s): %s\n", name, price/100.0, productType, rating, description))
 	}
 
 	return context.String(), nil
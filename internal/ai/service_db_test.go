@@ -365,9 +365,9 @@ func TestGetProductContext(t *testing.T) {
 		{
 			name: "retrieve multiple products",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring"}).
-					AddRow(1, "Widget", "A great widget", 1000, false).
-					AddRow(2, "Premium Plan", "Monthly subscription", 3000, true)
+				rows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring", "average_rating", "review_count"}).
+					AddRow(1, "Widget", "A great widget", 1000, false, 4.5, 2).
+					AddRow(2, "Premium Plan", "Monthly subscription", 3000, true, 0, 0)
 
 				mock.ExpectQuery("SELECT (.+) FROM widgets WHERE inventory_level").
 					WillReturnRows(rows)
@@ -377,16 +377,18 @@ func TestGetProductContext(t *testing.T) {
 				"Widget",
 				"$10.00",
 				"one-time",
+				"rated 4.5/5 from 2 reviews",
 				"Premium Plan",
 				"$30.00",
 				"subscription",
+				"no reviews yet",
 			},
 			expectError: false,
 		},
 		{
 			name: "no products available",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring"})
+				rows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring", "average_rating", "review_count"})
 
 				mock.ExpectQuery("SELECT (.+) FROM widgets WHERE inventory_level").
 					WillReturnRows(rows)
@@ -57,8 +57,8 @@ func TestHandleChat(t *testing.T) {
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
 
 				// getProductContext
-				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring"}).
-					AddRow(1, "Widget", "A great widget", 1000, false)
+				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring", "average_rating", "review_count"}).
+					AddRow(1, "Widget", "A great widget", 1000, false, 4.0, 3)
 				mock.ExpectQuery("SELECT (.+) FROM widgets WHERE inventory_level").
 					WillReturnRows(productRows)
 
@@ -143,8 +143,8 @@ func TestHandleChat(t *testing.T) {
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
 
 				// getProductContext
-				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring"}).
-					AddRow(1, "Widget", "Great", 1000, false)
+				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring", "average_rating", "review_count"}).
+					AddRow(1, "Widget", "Great", 1000, false, 0, 0)
 				mock.ExpectQuery("SELECT (.+) FROM widgets WHERE inventory_level").
 					WillReturnRows(productRows)
 
@@ -286,7 +286,7 @@ func TestHandleChat(t *testing.T) {
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
 
 				// getProductContext
-				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring"})
+				productRows := sqlmock.NewRows([]string{"id", "name", "description", "price", "is_recurring", "average_rating", "review_count"})
 				mock.ExpectQuery("SELECT (.+) FROM widgets WHERE inventory_level").
 					WillReturnRows(productRows)
 
@@ -6,17 +6,33 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"usual_store/internal/keyring"
 )
 
+// Encryption encrypts with Keys when set, or else with Key. Ciphertexts made
+// with a keyring start with the ID of the key and a colon, and decrypt as long
+// as that key is in the ring; ciphertexts without one use the legacy key.
 type Encryption struct {
-	Key []byte
+	Key  []byte
+	Keys *keyring.Keyring
 }
 
 // Encrypt encrypts plaintext using AES-GCM (AEAD mode)
 func (e *Encryption) Encrypt(plaintext string) (string, error) {
+	key, prefix := e.Key, ""
+	if e.Keys != nil {
+		active := e.Keys.Active()
+		if !active.CanEncrypt() {
+			return "", fmt.Errorf("key %s cannot encrypt: it must be 16, 24 or 32 bytes long", active.ID)
+		}
+		key, prefix = active.Secret, active.ID+":"
+	}
+
 	plainBytes := []byte(plaintext)
-	block, err := aes.NewCipher(e.Key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -36,17 +52,30 @@ func (e *Encryption) Encrypt(plaintext string) (string, error) {
 	// Encrypt and authenticate
 	ciphertext := aesGCM.Seal(nonce, nonce, plainBytes, nil)
 
-	return base64.URLEncoding.EncodeToString(ciphertext), nil
+	return prefix + base64.URLEncoding.EncodeToString(ciphertext), nil
 }
 
 // Decrypt decrypts ciphertext using AES-GCM (AEAD mode)
 func (e *Encryption) Decrypt(ciphertext string) (string, error) {
+	key := e.Key
+	if e.Keys != nil {
+		id, _ := KeyID(ciphertext)
+		k, err := e.Keys.Key(id)
+		if err != nil {
+			return "", err
+		}
+		key = k.Secret
+	}
+	if _, rest, found := strings.Cut(ciphertext, ":"); found {
+		ciphertext = rest
+	}
+
 	cipherBytes, err := base64.URLEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(e.Key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -73,3 +102,35 @@ func (e *Encryption) Decrypt(ciphertext string) (string, error) {
 
 	return string(plainBytes), nil
 }
+
+// KeyID returns the ID of the key ciphertext was made with, and false for a
+// ciphertext made before key IDs, with the legacy key. Base64 never contains
+// a colon, so the two cannot be confused.
+func KeyID(ciphertext string) (string, bool) {
+	id, _, found := strings.Cut(ciphertext, ":")
+	if !found {
+		return "", false
+	}
+	return id, true
+}
+
+// Reencrypt returns ciphertext encrypted with the active key of Keys, and
+// whether it changed. Ciphertexts already made with the active key are
+// returned as they are.
+func (e *Encryption) Reencrypt(ciphertext string) (string, bool, error) {
+	if e.Keys == nil {
+		return "", false, errors.New("re-encrypting needs a keyring")
+	}
+	if id, _ := KeyID(ciphertext); id == e.Keys.Active().ID {
+		return ciphertext, false, nil
+	}
+	plaintext, err := e.Decrypt(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	ciphertext, err = e.Encrypt(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return ciphertext, true, nil
+}
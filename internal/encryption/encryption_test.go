@@ -0,0 +1,60 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+	"usual_store/internal/keyring"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldSecret = "old-secret-key-of-exactly-32-by!"
+	newSecret = "new-secret-key-of-exactly-32-by!"
+)
+
+func TestEncryption_Rotation(t *testing.T) {
+	after, err := keyring.Parse("1:"+newSecret+",0:"+oldSecret, "")
+	require.NoError(t, err)
+
+	legacy := Encryption{Key: []byte(oldSecret)}
+	stored, err := legacy.Encrypt("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	_, tagged := KeyID(stored)
+	assert.False(t, tagged)
+
+	e := Encryption{Keys: after}
+	plain, err := e.Decrypt(stored)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", plain)
+
+	rotated, changed, err := e.Reencrypt(stored)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(rotated, "1:"), rotated)
+
+	again, changed, err := e.Reencrypt(rotated)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, rotated, again)
+
+	// Once the old key is retired, only what was re-encrypted still decrypts
+	retired, err := keyring.Parse("1:"+newSecret, "")
+	require.NoError(t, err)
+	e = Encryption{Keys: retired}
+	plain, err = e.Decrypt(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", plain)
+	_, err = e.Decrypt(stored)
+	assert.ErrorIs(t, err, keyring.ErrUnknownKey)
+}
+
+func TestEncryption_LegacySecretOfWrongLength(t *testing.T) {
+	keys, err := keyring.Parse("", "your-secret-key-change-in-production")
+	require.NoError(t, err)
+
+	e := Encryption{Keys: keys}
+	_, err = e.Encrypt("JBSWY3DPEHPK3PXP")
+	assert.ErrorContains(t, err, "key 0 cannot encrypt")
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"usual_store/internal/models"
+)
+
+// Run writes every row of an export of the given kind and format to w and
+// returns the number of rows.
+func Run(ctx context.Context, db *models.DBModel, kind, format string, filter models.OrderFilter, w io.Writer) (int, error) {
+	columns, err := models.ExportColumns(kind)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := NewWriter(format, w, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := db.StreamExport(ctx, kind, filter, writer.WriteRow)
+	if err != nil {
+		return count, err
+	}
+	return count, writer.Close()
+}
+
+// Job runs a recorded export on the worker pool and stores the file in Dir.
+// It implements workerpool.Job.
+type Job struct {
+	Export models.Export
+	DB     *models.DBModel
+	Dir    string
+}
+
+// ID identifies the job in the worker pool results
+func (j *Job) ID() string {
+	return fmt.Sprintf("export-%d", j.Export.ID)
+}
+
+// Execute writes the export file and records the outcome on the export.
+func (j *Job) Execute(ctx context.Context) error {
+	err := j.DB.MarkExportRunning(j.Export.ID)
+	if err != nil {
+		return err
+	}
+
+	fileName := FileName(j.Export.ID, j.Export.Kind, j.Export.Format)
+	count, err := j.writeFile(ctx, fileName)
+	if err != nil {
+		if failErr := j.DB.FailExport(j.Export.ID, err.Error()); failErr != nil {
+			return fmt.Errorf("%w (and could not record the failure: %v)", err, failErr)
+		}
+		return err
+	}
+	return j.DB.CompleteExport(j.Export.ID, fileName, count)
+}
+
+// writeFile streams the export into a temporary file which is renamed into
+// place once complete, so a download never sees a partial file.
+func (j *Job) writeFile(ctx context.Context, fileName string) (int, error) {
+	if err := os.MkdirAll(j.Dir, 0o750); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(j.Dir, fileName+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	count, err := Run(ctx, j.DB, j.Export.Kind, j.Export.Format, j.Export.Filter, f)
+	if err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	return count, os.Rename(f.Name(), filepath.Join(j.Dir, fileName))
+}
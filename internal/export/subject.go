@@ -0,0 +1,60 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// SubjectArchiveContentType is the content type of a data subject access archive
+const SubjectArchiveContentType = "application/zip"
+
+// subjectManifest describes the contents of a data subject access archive
+type subjectManifest struct {
+	Subject     string           `json:"subject"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Tables      map[string]int64 `json:"tables"`
+}
+
+// WriteSubjectArchive writes the records held about a data subject as a zip
+// archive with a manifest.json and one JSON file per table. It returns the
+// number of records by table.
+func WriteSubjectArchive(w io.Writer, email string, data map[string][]map[string]interface{}, generatedAt time.Time) (map[string]int64, error) {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	manifest := subjectManifest{
+		Subject:     email,
+		GeneratedAt: generatedAt.UTC(),
+		Tables:      make(map[string]int64, len(data)),
+	}
+	for _, name := range names {
+		manifest.Tables[name] = int64(len(data[name]))
+	}
+
+	archive := zip.NewWriter(w)
+	if err := writeJSONFile(archive, "manifest.json", manifest, generatedAt); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeJSONFile(archive, name+".json", data[name], generatedAt); err != nil {
+			return nil, err
+		}
+	}
+	return manifest.Tables, archive.Close()
+}
+
+func writeJSONFile(archive *zip.Writer, name string, v interface{}, modified time.Time) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
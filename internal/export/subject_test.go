@@ -0,0 +1,42 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSubjectArchive(t *testing.T) {
+	data := map[string][]map[string]interface{}{
+		"customers": {{"id": 3, "email": "jane@example.com"}},
+		"orders":    {{"id": 10, "amount": 1000}, {"id": 11, "amount": 500}},
+		"tokens":    {},
+	}
+
+	var buf bytes.Buffer
+	summary, err := WriteSubjectArchive(&buf, "jane@example.com", data, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"customers": 1, "orders": 2, "tokens": 0}, summary)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"manifest.json", "customers.json", "orders.json", "tokens.json"}, names)
+
+	rc, err := archive.File[0].Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	var manifest subjectManifest
+	require.NoError(t, json.NewDecoder(rc).Decode(&manifest))
+	assert.Equal(t, "jane@example.com", manifest.Subject)
+	assert.Equal(t, int64(2), manifest.Tables["orders"])
+}
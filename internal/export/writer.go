@@ -0,0 +1,237 @@
+// Package export writes admin data exports as CSV, JSON or XLSX files.
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatXLSX = "xlsx"
+)
+
+// ContentTypes maps the export formats to their MIME types
+var ContentTypes = map[string]string{
+	FormatCSV:  "text/csv",
+	FormatJSON: "application/json",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Writer writes the rows of an export one at a time.
+type Writer interface {
+	WriteRow(row []interface{}) error
+	// Close finishes the file; it does not close the underlying io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer of the given format with the column header already written.
+func NewWriter(format string, w io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatJSON:
+		return newJSONWriter(w, columns)
+	case FormatXLSX:
+		return newXLSXWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// cell renders a database value as text
+func cell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	return cw, cw.w.Write(columns)
+}
+
+func (cw *csvWriter) WriteRow(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = cell(v)
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonWriter writes an array of objects keyed by column, one row at a time
+type jsonWriter struct {
+	w       *bufio.Writer
+	columns []string
+	rows    int
+}
+
+func newJSONWriter(w io.Writer, columns []string) (*jsonWriter, error) {
+	jw := &jsonWriter{w: bufio.NewWriter(w), columns: columns}
+	_, err := jw.w.WriteString("[")
+	return jw, err
+}
+
+func (jw *jsonWriter) WriteRow(row []interface{}) error {
+	object := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		object[jw.columns[i]] = v
+	}
+	b, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if jw.rows > 0 {
+		if err = jw.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	jw.rows++
+	_, err = jw.w.Write(append([]byte("\n"), b...))
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	if _, err := jw.w.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	return jw.w.Flush()
+}
+
+// xlsxWriter streams a single-sheet workbook. Numbers are written as numeric
+// cells and everything else as inline strings, so no shared string table has
+// to be kept in memory.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+func newXLSXWriter(w io.Writer, columns []string) (*xlsxWriter, error) {
+	xw := &xlsxWriter{zip: zip.NewWriter(w)}
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := xw.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := xw.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw.sheet = sheet
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return xw, xw.WriteRow(header)
+}
+
+func (xw *xlsxWriter) WriteRow(row []interface{}) error {
+	xw.rows++
+	if _, err := fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.rows); err != nil {
+		return err
+	}
+	for _, v := range row {
+		var err error
+		switch v := v.(type) {
+		case int, int32, int64, float64:
+			_, err = fmt.Fprintf(xw.sheet, `<c t="n"><v>%v</v></c>`, v)
+		default:
+			if _, err = io.WriteString(xw.sheet, `<c t="inlineStr"><is><t>`); err != nil {
+				return err
+			}
+			if err = xml.EscapeText(xw.sheet, []byte(cell(v))); err != nil {
+				return err
+			}
+			_, err = io.WriteString(xw.sheet, `</t></is></c>`)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(xw.sheet, `</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) Close() error {
+	if _, err := io.WriteString(xw.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return xw.zip.Close()
+}
+
+// FileName is the name of the file of an export
+func FileName(exportID int, kind, format string) string {
+	return "export-" + strconv.Itoa(exportID) + "-" + kind + "." + format
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testColumns = []string{"order_id", "created_at", "email", "amount"}
+	testRows    = [][]interface{}{
+		{int64(1), time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC), "jane@example.com", int64(1000)},
+		{int64(2), time.Date(2026, 9, 2, 11, 0, 0, 0, time.UTC), `O'Brien & "Sons" <b>`, nil},
+	}
+)
+
+func writeAll(t *testing.T, format string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, testColumns)
+	require.NoError(t, err)
+	for _, row := range testRows {
+		require.NoError(t, w.WriteRow(row))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestWriter_CSV(t *testing.T) {
+	out := writeAll(t, FormatCSV)
+
+	assert.Equal(t, "order_id,created_at,email,amount\n"+
+		"1,2026-09-01T10:00:00Z,jane@example.com,1000\n"+
+		`2,2026-09-02T11:00:00Z,"O'Brien & ""Sons"" <b>",`+"\n", string(out))
+}
+
+func TestWriter_JSON(t *testing.T) {
+	out := writeAll(t, FormatJSON)
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &rows))
+	require.Len(t, rows, 2)
+	assert.Equal(t, "jane@example.com", rows[0]["email"])
+	assert.Equal(t, float64(1000), rows[0]["amount"])
+	assert.Nil(t, rows[1]["amount"])
+}
+
+func TestWriter_JSONWithoutRows(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatJSON, &buf, testColumns)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	assert.Empty(t, rows)
+}
+
+func TestWriter_XLSX(t *testing.T) {
+	out := writeAll(t, FormatXLSX)
+
+	archive, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+
+	var sheet string
+	for _, f := range archive.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			b, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			sheet = string(b)
+		}
+	}
+	require.NotEmpty(t, sheet, "workbook has no sheet")
+
+	assert.Equal(t, 3, strings.Count(sheet, "<row "))
+	assert.Contains(t, sheet, `<c t="n"><v>1000</v></c>`)
+	assert.Contains(t, sheet, `O&#39;Brien &amp; &#34;Sons&#34; &lt;b&gt;`)
+}
+
+func TestNewWriter_UnknownFormat(t *testing.T) {
+	_, err := NewWriter("pdf", io.Discard, testColumns)
+	assert.Error(t, err)
+}
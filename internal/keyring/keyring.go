@@ -0,0 +1,113 @@
+// Package keyring holds the secret keys that sign URLs and encrypt data. One
+// key is active and makes all new signatures and ciphertexts, which carry its
+// ID. Retired keys stay in the ring until nothing made with them is left, so
+// keys can be rotated without breaking outstanding links or stored data.
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LegacyID is the ID of the key that signed and encrypted before key IDs
+// were added. Tokens and ciphertexts without an ID are checked with it.
+const LegacyID = "0"
+
+// ErrUnknownKey is returned for a token or ciphertext made with a key that is
+// not in the ring, for example one retired too early
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// Key is a secret and the ID it is known by
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring is an active key and the retired keys that still verify and
+// decrypt
+type Keyring struct {
+	active Key
+	keys   map[string]Key
+}
+
+// CanEncrypt reports whether the secret of k is usable as an AES key, which
+// takes 16, 24 or 32 bytes. Secrets of other lengths still sign.
+func (k Key) CanEncrypt() bool {
+	n := len(k.Secret)
+	return n == 16 || n == 24 || n == 32
+}
+
+// New returns a keyring that signs and encrypts with active. Every key must
+// have a unique ID of letters, digits, - and _. A secret that cannot encrypt
+// is accepted, so that a legacy secret of the wrong length keeps signing, and
+// fails only when data is encrypted with it.
+func New(active Key, retired ...Key) (*Keyring, error) {
+	k := &Keyring{active: active, keys: make(map[string]Key)}
+	for _, key := range append([]Key{active}, retired...) {
+		if !validID(key.ID) {
+			return nil, fmt.Errorf("invalid key ID %q", key.ID)
+		}
+		if _, found := k.keys[key.ID]; found {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// Parse returns the keyring of spec, a comma-separated list of id:secret
+// pairs with the active key first, such as "2025b:new-secret,2025a:old-secret".
+// The active key of spec must be able to encrypt, while retired keys, such as
+// the legacy secret, may have any length. When spec is empty, legacySecret is
+// the only key, with LegacyID.
+func Parse(spec, legacySecret string) (*Keyring, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return New(Key{ID: LegacyID, Secret: []byte(legacySecret)})
+	}
+
+	var keys []Key
+	for _, pair := range strings.Split(spec, ",") {
+		id, secret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return nil, fmt.Errorf("key %q is not in the form id:secret", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	if !keys[0].CanEncrypt() {
+		return nil, fmt.Errorf("active key %s must be 16, 24 or 32 bytes long, not %d", keys[0].ID, len(keys[0].Secret))
+	}
+	return New(keys[0], keys[1:]...)
+}
+
+// Active returns the key new signatures and ciphertexts are made with
+func (k *Keyring) Active() Key {
+	return k.active
+}
+
+// Key returns the key with id. An empty id is the legacy key.
+func (k *Keyring) Key(id string) (Key, error) {
+	if id == "" {
+		id = LegacyID
+	}
+	key, found := k.keys[id]
+	if !found {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package keyring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldSecret = "old-secret-key-of-exactly-32-by!"
+	newSecret = "new-secret-key-of-exactly-32-by!"
+)
+
+func TestParse(t *testing.T) {
+	keys, err := Parse("2025b:"+newSecret+", 0:"+oldSecret, "ignored")
+	require.NoError(t, err)
+	assert.Equal(t, "2025b", keys.Active().ID)
+
+	key, err := keys.Key("")
+	require.NoError(t, err)
+	assert.Equal(t, []byte(oldSecret), key.Secret)
+
+	_, err = keys.Key("2024")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestParse_Legacy(t *testing.T) {
+	keys, err := Parse("", oldSecret)
+	require.NoError(t, err)
+	assert.Equal(t, Key{ID: LegacyID, Secret: []byte(oldSecret)}, keys.Active())
+	assert.True(t, keys.Active().CanEncrypt())
+
+	// A legacy secret of the wrong length still signs
+	keys, err = Parse("", "your-secret-key-change-in-production")
+	require.NoError(t, err)
+	assert.False(t, keys.Active().CanEncrypt())
+
+	keys, err = Parse("2025b:"+newSecret+",0:your-secret-key-change-in-production", "ignored")
+	require.NoError(t, err)
+	assert.True(t, keys.Active().CanEncrypt())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{newSecret, "not in the form id:secret"},
+		{"2025b:short", "active key 2025b must be 16, 24 or 32 bytes long"},
+		{"a b:" + newSecret, "invalid key ID"},
+		{"1:" + newSecret + ",1:" + oldSecret, "duplicate key ID"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.spec, oldSecret)
+		assert.ErrorContains(t, err, tt.wantErr, tt.spec)
+	}
+}
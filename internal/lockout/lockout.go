@@ -0,0 +1,80 @@
+// Package lockout decides how long sign-in attempts are held back after
+// failed ones: a few free attempts, then delays that double with every
+// failure, and finally a lockout.
+package lockout
+
+import "time"
+
+// Policy throttles the sign-in attempts of one account or one IP address
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before delays start
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts
+	MaxDelay time.Duration
+	// LockAfter is the number of failures that locks out further attempts
+	LockAfter int
+	// LockFor is how long a lockout lasts
+	LockFor time.Duration
+}
+
+var (
+	// Account throttles attempts on one account; failures count until a
+	// successful sign-in or an unlock
+	Account = Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     30 * time.Second,
+		LockAfter:    10,
+		LockFor:      30 * time.Minute,
+	}
+	// IP throttles attempts from one address on any account; failures within
+	// the last LockFor count
+	IP = Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockAfter:    100,
+		LockFor:      15 * time.Minute,
+	}
+)
+
+// Delay returns how long to wait after failures failed attempts, before lockout
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Locked reports whether failures failed attempts lock out further ones
+func (p Policy) Locked(failures int) bool {
+	return failures >= p.LockAfter
+}
+
+// RetryAfter returns how long from now the next attempt must wait, given
+// failures failed attempts of which the last was at lastFailure. It returns
+// zero when an attempt may be made now.
+func (p Policy) RetryAfter(failures int, lastFailure, now time.Time) time.Duration {
+	wait := p.Delay(failures)
+	if p.Locked(failures) {
+		wait = p.LockFor
+	}
+	if wait == 0 {
+		return 0
+	}
+
+	remaining := lastFailure.Add(wait).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,60 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 10, LockFor: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{8, 16 * time.Second},
+		{9, 30 * time.Second},
+		{50, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.Delay(tt.failures), "%d failures", tt.failures)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	p := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 10, LockFor: time.Hour}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		failures    int
+		lastFailure time.Time
+		want        time.Duration
+	}{
+		{"free attempts", 3, now, 0},
+		{"delay still running", 6, now.Add(-time.Second), 3 * time.Second},
+		{"delay passed", 6, now.Add(-5 * time.Second), 0},
+		{"locked", 10, now.Add(-10 * time.Minute), 50 * time.Minute},
+		{"lock expired", 12, now.Add(-2 * time.Hour), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.RetryAfter(tt.failures, tt.lastFailure, now))
+		})
+	}
+}
+
+func TestLocked(t *testing.T) {
+	assert.False(t, Account.Locked(Account.LockAfter-1))
+	assert.True(t, Account.Locked(Account.LockAfter))
+	assert.True(t, IP.Locked(IP.LockAfter+1))
+}
@@ -111,6 +111,28 @@ func determineEmailType(template string) string {
 		return TypeWelcome
 	case "order-confirmation":
 		return TypeOrderConfirm
+	case "wishlist-alert":
+		return TypeWishlistAlert
+	case "low-stock-alert":
+		return TypeLowStockAlert
+	case "order-shipped":
+		return TypeOrderShipped
+	case "checkout-recovery":
+		return TypeCheckoutRecovery
+	case "return-update":
+		return TypeReturnUpdate
+	case "account-locked":
+		return TypeAccountLocked
+	case "suspicious-login":
+		return TypeSuspiciousLogin
+	case "login-attack-alert":
+		return TypeLoginAttackAlert
+	case "verify-email":
+		return TypeEmailVerification
+	case "user-invitation":
+		return TypeUserInvitation
+	case "magic-link":
+		return TypeMagicLink
 	default:
 		return TypeNotification
 	}
@@ -40,8 +40,19 @@ const (
 	StatusFailed  = "failed"
 
 	// Email Types
-	TypePasswordReset = "password_reset"
-	TypeWelcome       = "welcome"
-	TypeNotification  = "notification"
-	TypeOrderConfirm  = "order_confirmation"
+	TypePasswordReset     = "password_reset"
+	TypeWelcome           = "welcome"
+	TypeNotification      = "notification"
+	TypeOrderConfirm      = "order_confirmation"
+	TypeWishlistAlert     = "wishlist_alert"
+	TypeLowStockAlert     = "low_stock_alert"
+	TypeOrderShipped      = "order_shipped"
+	TypeCheckoutRecovery  = "checkout_recovery"
+	TypeReturnUpdate      = "return_update"
+	TypeAccountLocked     = "account_locked"
+	TypeSuspiciousLogin   = "suspicious_login"
+	TypeLoginAttackAlert  = "login_attack_alert"
+	TypeEmailVerification = "email_verification"
+	TypeUserInvitation    = "user_invitation"
+	TypeMagicLink         = "magic_link"
 )
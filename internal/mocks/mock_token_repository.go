@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: internal/pkg/repository/token_repository.go
+// Source: pkg/repository/token_repository.go
 
 // Package mocks is a generated GoMock package.
 package mocks
@@ -35,6 +35,80 @@ func (m *MockTokenRepository) EXPECT() *MockTokenRepositoryMockRecorder {
 	return m.recorder
 }
 
+// ConsumeToken mocks base method.
+func (m *MockTokenRepository) ConsumeToken(ctx context.Context, token, scope string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeToken", ctx, token, scope)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeToken indicates an expected call of ConsumeToken.
+func (mr *MockTokenRepositoryMockRecorder) ConsumeToken(ctx, token, scope interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeToken", reflect.TypeOf((*MockTokenRepository)(nil).ConsumeToken), ctx, token, scope)
+}
+
+// DeleteToken mocks base method.
+func (m *MockTokenRepository) DeleteToken(ctx context.Context, userID, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteToken", ctx, userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteToken indicates an expected call of DeleteToken.
+func (mr *MockTokenRepositoryMockRecorder) DeleteToken(ctx, userID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteToken", reflect.TypeOf((*MockTokenRepository)(nil).DeleteToken), ctx, userID, id)
+}
+
+// DeleteTokensForUser mocks base method.
+func (m *MockTokenRepository) DeleteTokensForUser(ctx context.Context, userID int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTokensForUser", ctx, userID)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteTokensForUser indicates an expected call of DeleteTokensForUser.
+func (mr *MockTokenRepositoryMockRecorder) DeleteTokensForUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTokensForUser", reflect.TypeOf((*MockTokenRepository)(nil).DeleteTokensForUser), ctx, userID)
+}
+
+// GetActiveTokens mocks base method.
+func (m *MockTokenRepository) GetActiveTokens(ctx context.Context, userID int, currentToken string) ([]models.ActiveToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActiveTokens", ctx, userID, currentToken)
+	ret0, _ := ret[0].([]models.ActiveToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActiveTokens indicates an expected call of GetActiveTokens.
+func (mr *MockTokenRepositoryMockRecorder) GetActiveTokens(ctx, userID, currentToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActiveTokens", reflect.TypeOf((*MockTokenRepository)(nil).GetActiveTokens), ctx, userID, currentToken)
+}
+
+// GetUserForScopedToken mocks base method.
+func (m *MockTokenRepository) GetUserForScopedToken(ctx context.Context, token, scope string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserForScopedToken", ctx, token, scope)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserForScopedToken indicates an expected call of GetUserForScopedToken.
+func (mr *MockTokenRepositoryMockRecorder) GetUserForScopedToken(ctx, token, scope interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserForScopedToken", reflect.TypeOf((*MockTokenRepository)(nil).GetUserForScopedToken), ctx, token, scope)
+}
+
 // GetUserForToken mocks base method.
 func (m *MockTokenRepository) GetUserForToken(ctx context.Context, token string) (*models.User, error) {
 	m.ctrl.T.Helper()
@@ -63,3 +137,18 @@ func (mr *MockTokenRepositoryMockRecorder) InsertToken(ctx, token, user interfac
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertToken", reflect.TypeOf((*MockTokenRepository)(nil).InsertToken), ctx, token, user)
 }
+
+// RotateRefreshToken mocks base method.
+func (m *MockTokenRepository) RotateRefreshToken(ctx context.Context, refreshToken string, access, refresh *models.Token) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, refreshToken, access, refresh)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockTokenRepositoryMockRecorder) RotateRefreshToken(ctx, refreshToken, access, refresh interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockTokenRepository)(nil).RotateRefreshToken), ctx, refreshToken, access, refresh)
+}
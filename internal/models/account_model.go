@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrOrderNotFound is returned when an order does not exist or belongs to another customer
+var ErrOrderNotFound = errors.New("order not found")
+
+// customerOrderFields are the columns read by scanCustomerOrder.
+const customerOrderFields = `SELECT o.id, o.widget_id, o.transaction_id, o.customer_id,
+		o.status_id, o.quantity, o.amount, o.created_at,
+		o.updated_at, w.id, w.name, w.is_recurring, t.id, t.amount, t.currency,
+		t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
+		t.bank_return_code, c.id, c.first_name, c.last_name, c.email`
+
+// customerOrderSelect selects orders with their widget, transaction and customer.
+const customerOrderSelect = customerOrderFields + `
+	FROM orders o
+		INNER JOIN widgets w ON (o.widget_id = w.id)
+		LEFT JOIN transactions t ON (o.transaction_id = t.id)
+		INNER JOIN customers c ON (o.customer_id = c.id)`
+
+// GetOrdersForCustomer returns one page of the orders (or subscriptions when
+// isRecurring) placed with the given email, newest first, with the last page
+// number and the total number of records.
+func (m *DBModel) GetOrdersForCustomer(email string, isRecurring bool, pageSize, page int) ([]*Order, int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(email)
+	offset := (page - 1) * pageSize
+
+	query := customerOrderSelect + `
+		WHERE (c.email_index = $5 OR LOWER(c.email) = $1) AND w.is_recurring = $2
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT $3 OFFSET $4`
+
+	rows, err := m.DB.QueryContext(ctx, query, email, isRecurring, pageSize, offset, m.PII.Index(email))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		if err = m.scanCustomerOrder(rows, &order); err != nil {
+			return nil, 0, 0, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	var totalRecords int
+	err = m.DB.QueryRowContext(ctx,
+		`SELECT COUNT(o.id) FROM orders o
+			INNER JOIN widgets w ON (o.widget_id = w.id)
+			INNER JOIN customers c ON (o.customer_id = c.id)
+		 WHERE (c.email_index = $3 OR LOWER(c.email) = $1) AND w.is_recurring = $2`,
+		email, isRecurring, m.PII.Index(email)).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := (totalRecords + pageSize - 1) / pageSize
+	return orders, lastPage, totalRecords, nil
+}
+
+// GetOrderForCustomer returns an order only if it was placed with the given
+// email; otherwise it returns ErrOrderNotFound.
+func (m *DBModel) GetOrderForCustomer(id int, email string) (Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+	row := m.DB.QueryRowContext(ctx, customerOrderSelect+`
+		WHERE o.id = $1 AND (c.email_index = $3 OR LOWER(c.email) = $2)`, id, strings.ToLower(email), m.PII.Index(email))
+	err := m.scanCustomerOrder(row, &order)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, ErrOrderNotFound
+	}
+	return order, err
+}
+
+func (m *DBModel) scanCustomerOrder(row rowScanner, order *Order) error {
+	return row.Scan(
+		&order.ID,
+		&order.WidgetID,
+		&order.TransactionID,
+		&order.CustomerID,
+		&order.StatusID,
+		&order.Quantity,
+		&order.Amount,
+		&order.CreatedAt,
+		&order.UpdatedAt,
+		&order.Widget.ID,
+		&order.Widget.Name,
+		&order.Widget.IsRecurring,
+		&order.Transaction.ID,
+		&order.Transaction.Amount,
+		&order.Transaction.Currency,
+		&order.Transaction.LastFour,
+		&order.Transaction.ExpiryMonth,
+		&order.Transaction.ExpiryYear,
+		&order.Transaction.PaymentIntent,
+		&order.Transaction.BankReturnCode,
+		&order.Customer.ID,
+		m.PII.Field(&order.Customer.FirstName),
+		m.PII.Field(&order.Customer.LastName),
+		m.PII.Field(&order.Customer.Email),
+	)
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var customerOrderColumns = []string{
+	"id", "widget_id", "transaction_id", "customer_id", "status_id", "quantity", "amount", "created_at",
+	"updated_at", "w_id", "name", "is_recurring", "t_id", "t_amount", "currency",
+	"last_four", "expiry_month", "expiry_year", "payment_intent",
+	"bank_return_code", "c_id", "first_name", "last_name", "email",
+}
+
+func customerOrderRow(rows *sqlmock.Rows, id int) *sqlmock.Rows {
+	return rows.AddRow(id, 1, 3, 4, OrderStatusCleared, 1, 1000, time.Now(),
+		time.Now(), 1, "Widget", false, 3, 1000, "eur",
+		"4242", 12, 2030, "pi_123",
+		"ch_123", 4, "Jane", "Doe", "jane@example.com")
+}
+
+func TestDBModel_GetOrderForCustomer(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{"own order", customerOrderRow(sqlmock.NewRows(customerOrderColumns), 7), nil},
+		{"someone else's order", sqlmock.NewRows(customerOrderColumns), ErrOrderNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("WHERE o.id = \\$1 AND \\(c.email_index = \\$3 OR LOWER\\(c.email\\) = \\$2\\)").
+				WithArgs(7, "jane@example.com", sqlmock.AnyArg()).
+				WillReturnRows(tt.rows)
+
+			model := DBModel{DB: db}
+			order, err := model.GetOrderForCustomer(7, "Jane@Example.com")
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, 7, order.ID)
+				assert.Equal(t, "pi_123", order.Transaction.PaymentIntent)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_GetOrdersForCustomer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows := sqlmock.NewRows(customerOrderColumns)
+	customerOrderRow(rows, 9)
+	customerOrderRow(rows, 8)
+
+	mock.ExpectQuery("WHERE \\(c.email_index = \\$5 OR LOWER\\(c.email\\) = \\$1\\) AND w.is_recurring = \\$2").
+		WithArgs("jane@example.com", false, 2, 2, sqlmock.AnyArg()).
+		WillReturnRows(rows)
+	mock.ExpectQuery("SELECT COUNT\\(o.id\\) FROM orders o").
+		WithArgs("jane@example.com", false, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	model := DBModel{DB: db}
+	orders, lastPage, total, err := model.GetOrdersForCustomer("jane@example.com", false, 2, 2)
+	require.NoError(t, err)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, 3, lastPage)
+	assert.Equal(t, 5, total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognise
+const APIKeyPrefix = "usk_"
+
+// ErrAPIKeyNotFound is returned when an API key does not exist, or can no longer be used
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a long-lived key of a machine-to-machine client. It acts as the
+// user who created it, limited to its scopes.
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, to tell keys apart without storing them
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// AllowedIPs are the addresses and CIDR ranges the key may be used from; any when empty
+	AllowedIPs []string `json:"allowed_ips"`
+	// RateLimit is how many requests a minute the key may make
+	RateLimit  int        `json:"rate_limit"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AllowsIP reports whether the key may be used from ip
+func (k APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowedAddr := net.ParseIP(allowed); allowedAddr != nil && allowedAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAPIKey returns a new random API key and the hash it is stored as
+func GenerateAPIKey() (string, []byte, error) {
+	return generateSecret(APIKeyPrefix)
+}
+
+// generateSecret returns a new random secret starting with prefix and the
+// hash it is stored as
+func generateSecret(prefix string) (string, []byte, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret := prefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return secret, HashAPIKey(secret), nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// InsertAPIKey stores key under hash and returns its id
+func (m *DBModel) InsertAPIKey(key APIKey, hash []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, rate_limit, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`
+	err := m.DB.QueryRowContext(ctx, stmt, key.UserID, key.Name, key.Prefix, hash, pq.Array(key.Scopes),
+		pq.Array(key.AllowedIPs), key.RateLimit, key.ExpiresAt, time.Now()).Scan(&id)
+	return id, err
+}
+
+const apiKeyColumns = `k.id, k.user_id, k.name, k.prefix, k.scopes, k.allowed_ips, k.rate_limit, k.expires_at,
+	k.last_used_at, COALESCE(k.last_used_ip, ''), k.revoked_at, k.created_at`
+
+func (k *APIKey) scanArgs() []interface{} {
+	return []interface{}{&k.ID, &k.UserID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), pq.Array(&k.AllowedIPs),
+		&k.RateLimit, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP, &k.RevokedAt, &k.CreatedAt}
+}
+
+// GetUserForAPIKey returns the key stored under hash and the user it acts as.
+// It returns ErrAPIKeyNotFound when there is no such key, or it was revoked
+// or expired at time now.
+func (m *DBModel) GetUserForAPIKey(hash []byte, now time.Time) (APIKey, User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+	query := `SELECT ` + apiKeyColumns + `,
+			u.id, u.first_name, u.last_name, u.email, u.role, u.created_at, u.updated_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND k.expires_at > $2`
+	dest := append(key.scanArgs(), &user.ID, m.PII.Field(&user.FirstName), m.PII.Field(&user.LastName), m.PII.Field(&user.Email), &user.Role,
+		&user.CreatedAt, &user.UpdatedAt)
+	err := m.DB.QueryRowContext(ctx, query, hash, now).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, user, ErrAPIKeyNotFound
+	}
+	return key, user, err
+}
+
+// GetAPIKeys returns every API key, newest first
+func (m *DBModel) GetAPIKeys() ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys k ORDER BY k.created_at DESC, k.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var k APIKey
+		err = rows.Scan(k.scanArgs()...)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey stops a key from being used. It returns ErrAPIKeyNotFound when
+// there is no such key or it was already revoked.
+func (m *DBModel) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		time.Now(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used from ip at time now
+func (m *DBModel) TouchAPIKey(id int, ip string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1, last_used_ip = $2 WHERE id = $3`, now, ip, id)
+	return err
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKey_AllowsIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		ip      string
+		want    bool
+	}{
+		{"no allowlist", nil, "203.0.113.9", true},
+		{"listed address", []string{"203.0.113.9"}, "203.0.113.9", true},
+		{"in range", []string{"198.51.100.0/24"}, "198.51.100.77", true},
+		{"outside range", []string{"198.51.100.0/24", "203.0.113.9"}, "203.0.113.10", false},
+		{"IPv6 range", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"not an address", []string{"198.51.100.0/24"}, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, APIKey{AllowedIPs: tt.allowed}.AllowsIP(tt.ip))
+		})
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+	assert.Len(t, key, len(APIKeyPrefix)+52)
+	assert.Equal(t, HashAPIKey(key), hash)
+
+	other, _, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestDBModel_GetUserForAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	hash := HashAPIKey("usk_TEST")
+	mock.ExpectQuery("FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.key_hash = \\$1 AND k.revoked_at IS NULL AND k.expires_at > \\$2").
+		WithArgs(hash, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "scopes", "allowed_ips", "rate_limit",
+			"expires_at", "last_used_at", "last_used_ip", "revoked_at", "created_at",
+			"id", "first_name", "last_name", "email", "role", "created_at", "updated_at"}).
+			AddRow(7, 3, "ERP", "usk_ABCDEFGH", "{orders:read,catalog:write}", "{198.51.100.0/24}", 120,
+				now.Add(time.Hour), nil, "", nil, now,
+				3, "Jane", "Doe", "jane@example.com", "admin", now, now))
+
+	model := DBModel{DB: db}
+	key, user, err := model.GetUserForAPIKey(hash, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders:read", "catalog:write"}, key.Scopes)
+	assert.Equal(t, []string{"198.51.100.0/24"}, key.AllowedIPs)
+	assert.Equal(t, 120, key.RateLimit)
+	assert.Nil(t, key.LastUsedAt)
+	assert.Equal(t, "admin", user.Role)
+	assert.Empty(t, user.Password)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetUserForAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM api_keys k").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	model := DBModel{DB: db}
+	_, _, err = model.GetUserForAPIKey(HashAPIKey("usk_REVOKED"), time.Now())
+	assert.Equal(t, ErrAPIKeyNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_RevokeAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"revoked", 1, nil},
+		{"unknown or already revoked", 0, ErrAPIKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("UPDATE api_keys SET revoked_at = \\$1 WHERE id = \\$2 AND revoked_at IS NULL").
+				WithArgs(sqlmock.AnyArg(), 7).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			assert.Equal(t, tt.wantErr, model.RevokeAPIKey(7))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditOrderRefund        = "order.refund"
+	AuditSubscriptionCancel = "subscription.cancel"
+	AuditUserCreate         = "user.create"
+	AuditUserInvite         = "user.invite"
+	AuditUserEdit           = "user.edit"
+	AuditUserDelete         = "user.delete"
+	AuditUserRoleChange     = "user.role_change"
+	AuditUserLinkIdentity   = "user.link_identity"
+	AuditUserUnlock         = "user.unlock"
+	AuditUserRevokeTokens   = "user.revoke_tokens"
+	AuditUserImpersonate    = "user.impersonate"
+	AuditUserImpersonateEnd = "user.impersonate_end"
+	// AuditImpersonatedRequest is every request made while impersonating a user
+	AuditImpersonatedRequest = "user.impersonated_request"
+	AuditAPIKeyCreate        = "api_key.create"
+	AuditAPIKeyRevoke        = "api_key.revoke"
+)
+
+// Kinds of targets of audit events
+const (
+	AuditTargetOrder  = "order"
+	AuditTargetUser   = "user"
+	AuditTargetAPIKey = "api_key"
+)
+
+const (
+	defaultAuditEventsLimit = 50
+	maxAuditEventsLimit     = 500
+	// auditRedacted replaces the values of auditRedactedFields
+	auditRedacted = "[redacted]"
+)
+
+// auditRedactedFields are recorded as changed, but never with their values
+var auditRedactedFields = map[string]bool{
+	"password": true,
+}
+
+// AuditEvent is one security-sensitive action in the append-only audit log.
+// Before and After only hold the fields the action changed.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	ActorID    *int                   `json:"actor_id"`
+	Actor      string                 `json:"actor"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	Before     map[string]interface{} `json:"before"`
+	After      map[string]interface{} `json:"after"`
+	IPAddress  string                 `json:"ip_address"`
+	TraceID    string                 `json:"trace_id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	// PrevHash and Hash chain each event to the one before, hex encoded
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditFilter narrows down the audit events returned by GetAuditEvents.
+// Zero fields do not filter.
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	// BeforeID pages back through the log: only older events are returned
+	BeforeID int64
+	Limit    int
+}
+
+// AuditChainReport is the result of checking the hash chain of the audit log
+type AuditChainReport struct {
+	Events int64 `json:"events"`
+	Valid  bool  `json:"valid"`
+	// Head is the hash of the last event; keeping a copy elsewhere also
+	// catches events removed from the end
+	Head string `json:"head"`
+	// BrokenAt is the first event that does not match the chain, and
+	// Problem says how
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// AuditDiff returns the fields of before and after, both JSON objects or nil,
+// whose values differ. Passwords and the like are only marked as changed.
+func AuditDiff(before, after interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	old, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := map[string]interface{}{}
+	a := map[string]interface{}{}
+	for field, value := range old {
+		if v, ok := changed[field]; !ok || !reflect.DeepEqual(v, value) {
+			b[field] = value
+		}
+	}
+	for field, value := range changed {
+		if v, ok := old[field]; !ok || !reflect.DeepEqual(v, value) {
+			a[field] = value
+		}
+	}
+	for field := range auditRedactedFields {
+		if _, ok := b[field]; ok {
+			b[field] = auditRedacted
+		}
+		if _, ok := a[field]; ok {
+			a[field] = auditRedacted
+		}
+	}
+	return b, a, nil
+}
+
+func auditFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("audited values must be JSON objects: %w", err)
+	}
+	return fields, nil
+}
+
+// canonicalAuditJSON encodes fields the same way however they were encoded
+// before, which PostgreSQL does not keep for JSONB
+func canonicalAuditJSON(fields map[string]interface{}) ([]byte, error) {
+	if fields == nil {
+		return []byte("{}"), nil
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
+
+// auditHash returns the hash of e chained to prev. before and after are the
+// canonical encodings of e.Before and e.After.
+func auditHash(prev []byte, e AuditEvent, before, after []byte) ([]byte, error) {
+	content, err := json.Marshal(struct {
+		ActorID    *int            `json:"actor_id"`
+		Actor      string          `json:"actor"`
+		Action     string          `json:"action"`
+		TargetType string          `json:"target_type"`
+		TargetID   string          `json:"target_id"`
+		Before     json.RawMessage `json:"before"`
+		After      json.RawMessage `json:"after"`
+		IPAddress  string          `json:"ip_address"`
+		TraceID    string          `json:"trace_id"`
+		CreatedAt  string          `json:"created_at"`
+	}{
+		e.ActorID, e.Actor, e.Action, e.TargetType, e.TargetID, before, after,
+		e.IPAddress, e.TraceID, e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	sum.Write(prev)
+	sum.Write(content)
+	return sum.Sum(nil), nil
+}
+
+// InsertAuditEvent appends e to the audit log, chained to the last event.
+// It returns e as it was stored.
+func (m *DBModel) InsertAuditEvent(e AuditEvent) (AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	// Stored without a time zone and to the microsecond
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	before, err := canonicalAuditJSON(e.Before)
+	if err != nil {
+		return e, err
+	}
+	after, err := canonicalAuditJSON(e.After)
+	if err != nil {
+		return e, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// One event at a time, so that each is chained to the one stored before
+	_, err = tx.ExecContext(ctx, `LOCK TABLE audit_events IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return e, err
+	}
+
+	prev := make([]byte, sha256.Size)
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return e, err
+	}
+
+	hash, err := auditHash(prev, e, before, after)
+	if err != nil {
+		return e, err
+	}
+
+	stmt := `INSERT INTO audit_events (actor_id, actor, action, target_type, target_id, before, after,
+			ip_address, trace_id, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	err = tx.QueryRowContext(ctx, stmt, e.ActorID, e.Actor, e.Action, e.TargetType, e.TargetID, before, after,
+		e.IPAddress, e.TraceID, e.CreatedAt, prev, hash).Scan(&e.ID)
+	if err != nil {
+		return e, err
+	}
+
+	e.PrevHash = hex.EncodeToString(prev)
+	e.Hash = hex.EncodeToString(hash)
+	return e, tx.Commit()
+}
+
+const auditEventColumns = `id, actor_id, actor, action, target_type, target_id, before, after,
+	ip_address, trace_id, created_at, prev_hash, hash`
+
+// scanAuditEvent reads a row of auditEventColumns. It also returns the raw
+// hashes and the canonical encodings of Before and After, to check the chain.
+func scanAuditEvent(rows *sql.Rows) (e AuditEvent, prev, hash, before, after []byte, err error) {
+	var actorID sql.NullInt64
+	var rawBefore, rawAfter []byte
+	err = rows.Scan(&e.ID, &actorID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, &rawBefore, &rawAfter,
+		&e.IPAddress, &e.TraceID, &e.CreatedAt, &prev, &hash)
+	if err != nil {
+		return
+	}
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		e.ActorID = &id
+	}
+	if err = json.Unmarshal(rawBefore, &e.Before); err != nil {
+		return
+	}
+	if err = json.Unmarshal(rawAfter, &e.After); err != nil {
+		return
+	}
+	if before, err = canonicalAuditJSON(e.Before); err != nil {
+		return
+	}
+	if after, err = canonicalAuditJSON(e.After); err != nil {
+		return
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.PrevHash = hex.EncodeToString(prev)
+	e.Hash = hex.EncodeToString(hash)
+	return
+}
+
+// GetAuditEvents returns the audit events matching filter, newest first
+func (m *DBModel) GetAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditEventsLimit
+	}
+	if filter.Limit > maxAuditEventsLimit {
+		filter.Limit = maxAuditEventsLimit
+	}
+
+	var where []string
+	args := []interface{}{filter.Limit}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, clause+" $"+strconv.Itoa(len(args)))
+	}
+	if filter.ActorID != 0 {
+		add("actor_id =", filter.ActorID)
+	}
+	if filter.Action != "" {
+		add("action =", filter.Action)
+	}
+	if filter.TargetType != "" {
+		add("target_type =", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id =", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >=", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		add("created_at <", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		add("id <", filter.BeforeID)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e, _, _, _, _, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AuditEventExists reports whether the event with the hex encoded hash is in
+// the audit log
+func (m *DBModel) AuditEventExists(hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	b, err := hex.DecodeString(hash)
+	if err != nil {
+		return false, fmt.Errorf("invalid hash: %w", err)
+	}
+	var exists bool
+	err = m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM audit_events WHERE hash = $1)`, b).Scan(&exists)
+	return exists, err
+}
+
+// VerifyAuditChain recomputes the hash of every audit event in order and
+// reports the first one that was changed, removed or inserted out of chain
+func (m *DBModel) VerifyAuditChain() (AuditChainReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report := AuditChainReport{Valid: true}
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	expected := make([]byte, sha256.Size)
+	for rows.Next() {
+		e, prev, hash, before, after, err := scanAuditEvent(rows)
+		if err != nil {
+			return report, err
+		}
+		report.Events++
+
+		if !bytes.Equal(prev, expected) {
+			report.Valid = false
+			report.BrokenAt = e.ID
+			report.Problem = "does not follow the event before it, which was changed or removed"
+			break
+		}
+		computed, err := auditHash(prev, e, before, after)
+		if err != nil {
+			return report, err
+		}
+		if !bytes.Equal(computed, hash) {
+			report.Valid = false
+			report.BrokenAt = e.ID
+			report.Problem = "was changed after it was recorded"
+			break
+		}
+		expected = hash
+	}
+	if err = rows.Err(); err != nil {
+		return report, err
+	}
+	if report.Valid {
+		report.Head = hex.EncodeToString(expected)
+	}
+	return report, nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := User{ID: 7, FirstName: "Jane", Email: "jane@example.com", Password: "old", Role: "user"}
+	after := before
+	after.Email = "jane.doe@example.com"
+	after.Password = "new"
+
+	b, a, err := AuditDiff(before, after)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"email": "jane@example.com", "password": auditRedacted}, b)
+	assert.Equal(t, map[string]interface{}{"email": "jane.doe@example.com", "password": auditRedacted}, a)
+
+	// Nothing before a user was created
+	b, a, err = AuditDiff(nil, map[string]interface{}{"role": "admin"})
+	require.NoError(t, err)
+	assert.Empty(t, b)
+	assert.Equal(t, map[string]interface{}{"role": "admin"}, a)
+
+	var deleted *User
+	b, a, err = AuditDiff(&before, deleted)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", b["email"])
+	assert.Empty(t, a)
+
+	_, _, err = AuditDiff("not an object", nil)
+	assert.Error(t, err)
+}
+
+func TestAuditHash(t *testing.T) {
+	adminID := 1
+	e := AuditEvent{ActorID: &adminID, Actor: "admin@example.com", Action: AuditUserDelete, TargetType: "user",
+		TargetID: "7", CreatedAt: time.Date(2026, 10, 18, 9, 30, 0, 123000, time.UTC)}
+	prev := make([]byte, sha256.Size)
+
+	hash, err := auditHash(prev, e, []byte(`{"role":"user"}`), []byte("{}"))
+	require.NoError(t, err)
+	assert.Len(t, hash, sha256.Size)
+
+	same, err := auditHash(prev, e, []byte(`{"role":"user"}`), []byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, hash, same)
+
+	// Any change to the event or the one before gives another hash
+	changed := e
+	changed.TargetID = "8"
+	other, err := auditHash(prev, changed, []byte(`{"role":"user"}`), []byte("{}"))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	other, err = auditHash(hash, e, []byte(`{"role":"user"}`), []byte("{}"))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
+
+// recorded is an sqlmock argument that keeps the value it was matched with
+type recorded struct{ value driver.Value }
+
+func (r *recorded) Match(v driver.Value) bool {
+	r.value = v
+	return true
+}
+
+func TestDBModel_InsertAuditEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	prev := sha256.Sum256([]byte("event before"))
+	createdAt := time.Date(2026, 10, 18, 9, 30, 0, 123456789, time.UTC)
+	var before, hash recorded
+
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE audit_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow(prev[:]))
+	mock.ExpectQuery("INSERT INTO audit_events").
+		WithArgs(1, "admin@example.com", AuditUserEdit, "user", "7", &before, []byte(`{"email":"jane.doe@example.com"}`),
+			"10.0.0.1", "trace-1", createdAt.Truncate(time.Microsecond), prev[:], &hash).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	adminID := 1
+	model := DBModel{DB: db}
+	e, err := model.InsertAuditEvent(AuditEvent{
+		ActorID: &adminID, Actor: "admin@example.com", Action: AuditUserEdit, TargetType: "user", TargetID: "7",
+		Before: map[string]interface{}{"email": "jane@example.com"}, After: map[string]interface{}{"email": "jane.doe@example.com"},
+		IPAddress: "10.0.0.1", TraceID: "trace-1", CreatedAt: createdAt,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), e.ID)
+	assert.Equal(t, []byte(`{"email":"jane@example.com"}`), before.value)
+	assert.Equal(t, hex.EncodeToString(prev[:]), e.PrevHash)
+	assert.Equal(t, hex.EncodeToString(hash.value.([]byte)), e.Hash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_InsertAuditEvent_FirstEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE audit_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events").WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	mock.ExpectQuery("INSERT INTO audit_events").
+		WithArgs(nil, "cli:root", AuditUserDelete, "user", "7", []byte(`{}`), []byte(`{}`), "", "",
+			sqlmock.AnyArg(), make([]byte, sha256.Size), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	e, err := model.InsertAuditEvent(AuditEvent{Actor: "cli:root", Action: AuditUserDelete, TargetType: "user", TargetID: "7"})
+	require.NoError(t, err)
+	assert.False(t, e.CreatedAt.IsZero())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+var auditEventTestColumns = []string{"id", "actor_id", "actor", "action", "target_type", "target_id", "before", "after",
+	"ip_address", "trace_id", "created_at", "prev_hash", "hash"}
+
+// auditChain returns rows of a valid chain of events as PostgreSQL returns
+// them, with JSONB spacing. change is applied to the stored row of each
+// event after its hash was taken, and leaves the row out if it returns false.
+func auditChain(t *testing.T, n int, change func(i int, row []driver.Value) bool) *sqlmock.Rows {
+	rows := sqlmock.NewRows(auditEventTestColumns)
+	prev := make([]byte, sha256.Size)
+	for i := 1; i <= n; i++ {
+		e := AuditEvent{Actor: "admin@example.com", Action: AuditOrderRefund, TargetType: "order", TargetID: "12",
+			CreatedAt: time.Date(2026, 10, 18, 9, i, 0, 0, time.UTC)}
+		hash, err := auditHash(prev, e, []byte(`{"status_id":1}`), []byte(`{"status_id":2}`))
+		require.NoError(t, err)
+
+		row := []driver.Value{int64(i), nil, e.Actor, e.Action, e.TargetType, e.TargetID,
+			[]byte(`{"status_id": 1}`), []byte(`{"status_id": 2}`), "", "", e.CreatedAt, prev, hash}
+		if change == nil || change(i, row) {
+			rows.AddRow(row...)
+		}
+		prev = hash
+	}
+	return rows
+}
+
+func TestDBModel_VerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(i int, row []driver.Value) bool
+		valid    bool
+		brokenAt int64
+	}{
+		{"untouched", nil, true, 0},
+		{"edited event", func(i int, row []driver.Value) bool {
+			if i == 2 {
+				row[7] = []byte(`{"status_id": 3}`)
+			}
+			return true
+		}, false, 2},
+		// The event after a removed one still points at it
+		{"removed event", func(i int, row []driver.Value) bool { return i != 2 }, false, 3},
+		{"event before the first", func(i int, row []driver.Value) bool {
+			if i == 1 {
+				row[11] = []byte("forged")
+			}
+			return true
+		}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT id, actor_id, .* FROM audit_events ORDER BY id").
+				WillReturnRows(auditChain(t, 3, tt.change))
+
+			model := DBModel{DB: db}
+			report, err := model.VerifyAuditChain()
+			require.NoError(t, err)
+			assert.Equal(t, tt.valid, report.Valid)
+			assert.Equal(t, tt.brokenAt, report.BrokenAt)
+			if tt.valid {
+				assert.Equal(t, int64(3), report.Events)
+				assert.Len(t, report.Head, 2*sha256.Size)
+			} else {
+				assert.NotEmpty(t, report.Problem)
+				assert.Empty(t, report.Head)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Checkout statuses
+const (
+	CheckoutStatusOpen      = "open"
+	CheckoutStatusCompleted = "completed"
+)
+
+// ErrCheckoutNotFound is returned when a checkout does not exist
+var ErrCheckoutNotFound = errors.New("checkout not found")
+
+// Checkout is a checkout started with a payment intent
+type Checkout struct {
+	ID               int        `json:"id"`
+	PaymentIntent    string     `json:"payment_intent"`
+	Email            string     `json:"email"`
+	FirstName        string     `json:"first_name"`
+	WidgetID         int        `json:"widget_id"`
+	WidgetName       string     `json:"widget_name,omitempty"`
+	Quantity         int        `json:"quantity"`
+	Amount           int        `json:"amount"`
+	Currency         string     `json:"currency"`
+	Status           string     `json:"status"`
+	OrderID          *int       `json:"order_id,omitempty"`
+	ReminderSentAt   *time.Time `json:"reminder_sent_at,omitempty"`
+	ResumedAt        *time.Time `json:"resumed_at,omitempty"`
+	RecoveredOrderID *int       `json:"recovered_order_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// CheckoutRecoveryReport summarises the checkouts started in a period and how
+// many abandoned ones the recovery emails brought back
+type CheckoutRecoveryReport struct {
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Started         int       `json:"started"`
+	Completed       int       `json:"completed"`
+	Reminded        int       `json:"reminded"`
+	Resumed         int       `json:"resumed"`
+	Recovered       int       `json:"recovered"`
+	RecoveredAmount int       `json:"recovered_amount"`
+	RecoveryRate    float64   `json:"recovery_rate"`
+}
+
+// InsertCheckout records a checkout when its payment intent is created
+func (m *DBModel) InsertCheckout(c Checkout) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx,
+		`INSERT INTO checkout_sessions
+			(payment_intent, email, first_name, widget_id, quantity, amount, currency, status, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		 RETURNING id`,
+		c.PaymentIntent, c.Email, c.FirstName, c.WidgetID, c.Quantity, c.Amount, c.Currency,
+		CheckoutStatusOpen, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert checkout: %w", err)
+	}
+	return id, nil
+}
+
+// CompleteCheckout marks the checkout of a payment intent as completed by an
+// order. An earlier abandoned checkout of the same email and widget that was
+// sent a recovery email is credited with the order as recovered.
+func (m *DBModel) CompleteCheckout(paymentIntent string, orderID int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var email string
+	var widgetID int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE checkout_sessions
+		 SET status = $1, order_id = $2, completed_at = $3, updated_at = $3
+		 WHERE payment_intent = $4 AND status = $5
+		 RETURNING email, widget_id`,
+		CheckoutStatusCompleted, orderID, now, paymentIntent, CheckoutStatusOpen).Scan(&email, &widgetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCheckoutNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete checkout: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE checkout_sessions
+		 SET recovered_order_id = $1, recovered_at = $2, updated_at = $2
+		 WHERE LOWER(email) = LOWER($3) AND widget_id = $4 AND status = $5
+		   AND reminder_sent_at IS NOT NULL AND recovered_order_id IS NULL`,
+		orderID, now, email, widgetID, CheckoutStatusOpen)
+	if err != nil {
+		return fmt.Errorf("failed to credit recovered checkout: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ClaimAbandonedCheckouts claims the open checkouts started between since
+// and cutoff that have not been sent a recovery email, marking them reminded
+// at now, and returns them. A checkout is skipped when the same email started
+// a newer checkout of the widget, or was sent a recovery email for it since
+// since. Rows another instance is claiming are skipped, so every checkout is
+// claimed once.
+func (m *DBModel) ClaimAbandonedCheckouts(cutoff, since, now time.Time) ([]Checkout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`UPDATE checkout_sessions c
+		 SET reminder_sent_at = $4, updated_at = $4
+		 FROM widgets w
+		 WHERE w.id = c.widget_id AND c.reminder_sent_at IS NULL AND c.id IN (
+			SELECT a.id FROM checkout_sessions a
+			WHERE a.status = $1 AND a.reminder_sent_at IS NULL
+			  AND a.created_at < $2 AND a.created_at >= $3
+			  AND NOT EXISTS (
+				SELECT 1 FROM checkout_sessions n
+				WHERE n.id <> a.id AND LOWER(n.email) = LOWER(a.email) AND n.widget_id = a.widget_id
+				  AND (n.created_at > a.created_at OR n.reminder_sent_at >= $3))
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING c.id, c.payment_intent, c.email, c.first_name, c.widget_id, w.name,
+			c.quantity, c.amount, c.currency, c.status, c.created_at`,
+		CheckoutStatusOpen, cutoff, since, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkouts []Checkout
+	for rows.Next() {
+		var c Checkout
+		err = rows.Scan(&c.ID, &c.PaymentIntent, &c.Email, &c.FirstName, &c.WidgetID, &c.WidgetName,
+			&c.Quantity, &c.Amount, &c.Currency, &c.Status, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		checkouts = append(checkouts, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(checkouts, func(i, j int) bool { return checkouts[i].CreatedAt.Before(checkouts[j].CreatedAt) })
+	return checkouts, nil
+}
+
+// ReleaseCheckout gives up the claim on a checkout whose recovery email could
+// not be sent, so that a later run tries again
+func (m *DBModel) ReleaseCheckout(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE checkout_sessions SET reminder_sent_at = NULL, updated_at = $1 WHERE id = $2`,
+		time.Now(), id)
+	return err
+}
+
+// ResumeCheckout records that a recovery link was followed and returns the checkout
+func (m *DBModel) ResumeCheckout(id int, now time.Time) (Checkout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c Checkout
+	err := m.DB.QueryRowContext(ctx,
+		`UPDATE checkout_sessions SET resumed_at = COALESCE(resumed_at, $1), updated_at = $1
+		 WHERE id = $2
+		 RETURNING id, payment_intent, email, first_name, widget_id, quantity, amount, currency, status, created_at`,
+		now, id).Scan(&c.ID, &c.PaymentIntent, &c.Email, &c.FirstName, &c.WidgetID,
+		&c.Quantity, &c.Amount, &c.Currency, &c.Status, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCheckoutNotFound
+	}
+	return c, err
+}
+
+// GetCheckoutRecoveryReport reports on the checkouts started in [from, to)
+func (m *DBModel) GetCheckoutRecoveryReport(from, to time.Time) (CheckoutRecoveryReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	report := CheckoutRecoveryReport{From: from, To: to}
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE c.status = $1),
+			COUNT(*) FILTER (WHERE c.reminder_sent_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE c.resumed_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE c.recovered_order_id IS NOT NULL),
+			COALESCE(SUM(o.amount), 0)
+		 FROM checkout_sessions c
+		 LEFT JOIN orders o ON (o.id = c.recovered_order_id)
+		 WHERE c.created_at >= $2 AND c.created_at < $3`,
+		CheckoutStatusCompleted, from, to).Scan(&report.Started, &report.Completed, &report.Reminded,
+		&report.Resumed, &report.Recovered, &report.RecoveredAmount)
+	if err != nil {
+		return report, err
+	}
+
+	if report.Reminded > 0 {
+		report.RecoveryRate = float64(report.Recovered) / float64(report.Reminded)
+	}
+	return report, nil
+}
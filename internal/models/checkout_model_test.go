@@ -0,0 +1,91 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_CompleteCheckout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE checkout_sessions SET status = \\$1, order_id = \\$2").
+		WithArgs(CheckoutStatusCompleted, 42, now, "pi_123", CheckoutStatusOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "widget_id"}).AddRow("jane@example.com", 3))
+	mock.ExpectExec("UPDATE checkout_sessions SET recovered_order_id = \\$1").
+		WithArgs(42, now, "jane@example.com", 3, CheckoutStatusOpen).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	require.NoError(t, model.CompleteCheckout("pi_123", 42, now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_CompleteCheckout_Untracked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE checkout_sessions").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "widget_id"}))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	err = model.CompleteCheckout("pi_unknown", 42, time.Now())
+	assert.ErrorIs(t, err, ErrCheckoutNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_ClaimAbandonedCheckouts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	cutoff, since := now.Add(-24*time.Hour), now.Add(-7*24*time.Hour)
+	mock.ExpectQuery("UPDATE checkout_sessions c SET reminder_sent_at = \\$4.* NOT EXISTS .* FOR UPDATE SKIP LOCKED\\) RETURNING").
+		WithArgs(CheckoutStatusOpen, cutoff, since, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "payment_intent", "email", "first_name", "widget_id", "name",
+			"quantity", "amount", "currency", "status", "created_at"}).
+			AddRow(2, "pi_2", "john@example.com", "John", 3, "Widget", 1, 2000, "usd", CheckoutStatusOpen, now.Add(-26*time.Hour)).
+			AddRow(1, "pi_1", "jane@example.com", "Jane", 3, "Widget", 1, 1000, "usd", CheckoutStatusOpen, now.Add(-30*time.Hour)))
+
+	model := DBModel{DB: db}
+	checkouts, err := model.ClaimAbandonedCheckouts(cutoff, since, now)
+	require.NoError(t, err)
+	require.Len(t, checkouts, 2)
+	assert.Equal(t, 1, checkouts[0].ID)
+	assert.Equal(t, "Widget", checkouts[0].WidgetName)
+	assert.Equal(t, 1000, checkouts[0].Amount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetCheckoutRecoveryReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\),").
+		WithArgs(CheckoutStatusCompleted, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"started", "completed", "reminded", "resumed", "recovered", "amount"}).
+			AddRow(20, 12, 8, 4, 2, 3000))
+
+	model := DBModel{DB: db}
+	report, err := model.GetCheckoutRecoveryReport(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Recovered)
+	assert.Equal(t, 3000, report.RecoveredAmount)
+	assert.InDelta(t, 0.25, report.RecoveryRate, 1e-9)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -21,13 +21,19 @@ func (m *DBModel) InsertCustomer(customer Customer) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	err := m.CheckPlainEmail(ctx, "customers", customer.Email, 0)
+	if err != nil {
+		return fmt.Errorf("failed to insert customer: %w", err)
+	}
+
 	// Insert the customer into the database
 	const insertQuery = `
-		INSERT INTO customers (first_name, last_name, email)
-		VALUES ($1, $2, $3)
+		INSERT INTO customers (first_name, last_name, email, email_index)
+		VALUES ($1, $2, $3, $4)
 	`
 
-	_, err := m.DB.ExecContext(ctx, insertQuery, customer.FirstName, customer.LastName, customer.Email)
+	_, err = m.DB.ExecContext(ctx, insertQuery, m.PII.Value(customer.FirstName), m.PII.Value(customer.LastName),
+		m.PII.Value(customer.Email), m.PII.Index(customer.Email))
 	if err != nil {
 		return fmt.Errorf("failed to insert customer: %w", err)
 	}
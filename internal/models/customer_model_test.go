@@ -27,7 +27,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -44,7 +44,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(2, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -61,7 +61,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -78,7 +78,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -95,7 +95,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnError(errors.New("duplicate key value violates unique constraint"))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -113,7 +113,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnError(errors.New("connection refused"))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -131,7 +131,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnError(context.DeadlineExceeded)
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -148,7 +148,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -165,7 +165,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -182,7 +182,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnError(errors.New("invalid email format"))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -199,7 +199,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnError(sql.ErrTxDone)
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -216,7 +216,7 @@ func TestDBModel_InsertCustomer(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -255,7 +255,7 @@ func TestDBModel_InsertCustomer_ContextTimeout(t *testing.T) {
 
 	// Simulate a timeout by expecting an error
 	mock.ExpectExec("INSERT INTO customers").
-		WithArgs(customer.FirstName, customer.LastName, customer.Email).
+		WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 		WillDelayFor(5 * time.Second).
 		WillReturnError(context.DeadlineExceeded)
 
@@ -344,7 +344,7 @@ func BenchmarkInsertCustomer(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		mock.ExpectExec("INSERT INTO customers").
-			WithArgs(customer.FirstName, customer.LastName, customer.Email).
+			WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 	}
 
@@ -372,7 +372,7 @@ func TestCustomer_EdgeCases(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -389,7 +389,7 @@ func TestCustomer_EdgeCases(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -406,7 +406,7 @@ func TestCustomer_EdgeCases(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -423,7 +423,7 @@ func TestCustomer_EdgeCases(t *testing.T) {
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, customer Customer) {
 				mock.ExpectExec("INSERT INTO customers").
-					WithArgs(customer.FirstName, customer.LastName, customer.Email).
+					WithArgs(customer.FirstName, customer.LastName, customer.Email, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
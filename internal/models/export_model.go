@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Export kinds
+const (
+	ExportKindOrders       = "orders"
+	ExportKindTransactions = "transactions"
+	ExportKindCustomers    = "customers"
+)
+
+// Export statuses
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// ErrExportNotFound is returned when an export does not exist
+var ErrExportNotFound = errors.New("export not found")
+
+// Export is an asynchronous export of orders, transactions or customers.
+type Export struct {
+	ID          int         `json:"id"`
+	Kind        string      `json:"kind"`
+	Format      string      `json:"format"`
+	Filter      OrderFilter `json:"filters"`
+	Status      string      `json:"status"`
+	FileName    string      `json:"file_name"`
+	RowCount    int         `json:"row_count"`
+	Error       string      `json:"error,omitempty"`
+	RequestedBy *int        `json:"requested_by,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+}
+
+// exportQuery is the column header and SELECT list of an export kind.
+type exportQuery struct {
+	columns []string
+	fields  string
+	groupBy string
+}
+
+// exportQueries are the export kinds. Every kind selects from the orders
+// matching the filter, so transactions and customers honour the same filters
+// as the sales list.
+var exportQueries = map[string]exportQuery{
+	ExportKindOrders: {
+		columns: []string{"order_id", "created_at", "status", "widget", "quantity", "amount", "shipping_amount",
+			"currency", "first_name", "last_name", "email", "last_four", "payment_intent"},
+		fields: `o.id, o.created_at, s.name, w.name, o.quantity, o.amount, o.shipping_amount,
+			t.currency, c.first_name, c.last_name, c.email, t.last_four, t.payment_intent`,
+	},
+	ExportKindTransactions: {
+		columns: []string{"transaction_id", "order_id", "created_at", "amount", "currency", "last_four",
+			"expiry_month", "expiry_year", "payment_intent", "payment_method", "bank_return_code"},
+		fields: `t.id, o.id, t.created_at, t.amount, t.currency, t.last_four,
+			t.expiry_month, t.expiry_year, t.payment_intent, t.payment_method, t.bank_return_code`,
+	},
+	ExportKindCustomers: {
+		columns: []string{"customer_id", "first_name", "last_name", "email", "orders", "total_amount",
+			"first_order_at", "last_order_at"},
+		fields: `c.id, c.first_name, c.last_name, c.email, COUNT(o.id), SUM(o.amount),
+			MIN(o.created_at), MAX(o.created_at)`,
+		// Encrypted names and emails differ between rows of the same person, so
+		// customers are grouped by id only
+		groupBy: `c.id`,
+	},
+}
+
+// personalColumns are the columns of exports and subject access reports that
+// may hold encrypted personal data
+var personalColumns = map[string]bool{
+	"first_name": true, "last_name": true, "email": true, "user_email": true, "user_name": true,
+}
+
+// openPersonalData decrypts the values of a row in personalColumns
+func (m *DBModel) openPersonalData(columns []string, values []interface{}) error {
+	for i, column := range columns {
+		if !personalColumns[column] {
+			continue
+		}
+		var value string
+		switch v := values[i].(type) {
+		case string:
+			value = v
+		case []byte:
+			value = string(v)
+		default:
+			continue
+		}
+		opened, err := m.PII.Open(value)
+		if err != nil {
+			return err
+		}
+		values[i] = opened
+	}
+	return nil
+}
+
+// ExportColumns returns the column header of an export kind.
+func ExportColumns(kind string) ([]string, error) {
+	q, ok := exportQueries[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown export kind %q", kind)
+	}
+	return q.columns, nil
+}
+
+// StreamExport runs an export query and calls fn for every row, in the order
+// of the export columns, without loading the result into memory. Personal
+// data is decrypted, and orders are not sorted by it once encrypted. It
+// returns the number of rows.
+func (m *DBModel) StreamExport(ctx context.Context, kind string, f OrderFilter, fn func(row []interface{}) error) (int, error) {
+	q, ok := exportQueries[kind]
+	if !ok {
+		return 0, fmt.Errorf("unknown export kind %q", kind)
+	}
+
+	where, args := m.orderFilterWhere(f)
+	query := `SELECT ` + q.fields + `
+		FROM orders o
+			INNER JOIN widgets w ON (o.widget_id = w.id)
+			INNER JOIN statuses s ON (o.status_id = s.id)
+			LEFT JOIN transactions t ON (o.transaction_id = t.id)
+			INNER JOIN customers c ON (o.customer_id = c.id)
+		WHERE ` + where
+
+	switch {
+	case q.groupBy != "":
+		query += ` GROUP BY ` + q.groupBy + ` ORDER BY c.id`
+	case kind == ExportKindTransactions:
+		query += ` AND t.id IS NOT NULL ORDER BY t.id`
+	default:
+		sort, ok := orderSortFields[f.SortBy]
+		if !ok || (sort.personal && m.PII != nil) {
+			sort = orderSortFields["created_at"]
+		}
+		direction := "ASC"
+		if f.SortOrder == "desc" {
+			direction = "DESC"
+		}
+		query += fmt.Sprintf(` ORDER BY %[1]s %[2]s, o.id %[2]s`, sort.expr, direction)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run %s export: %w", kind, err)
+	}
+	defer rows.Close()
+
+	count := 0
+	values := make([]interface{}, len(q.columns))
+	pointers := make([]interface{}, len(q.columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+		if err = m.openPersonalData(q.columns, values); err != nil {
+			return count, err
+		}
+		if err = fn(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// InsertExport records a pending export and returns its id.
+func (m *DBModel) InsertExport(e Export) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	filters, err := json.Marshal(e.Filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = m.DB.QueryRowContext(ctx,
+		`INSERT INTO exports (kind, format, filters, status, requested_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id`,
+		e.Kind, e.Format, filters, ExportStatusPending, e.RequestedBy, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert export: %w", err)
+	}
+	return id, nil
+}
+
+// GetExport returns an export by id.
+func (m *DBModel) GetExport(id int) (Export, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e Export
+	var filters []byte
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT id, kind, format, filters, status, file_name, row_count, error, requested_by, created_at, completed_at
+		 FROM exports WHERE id = $1`, id).
+		Scan(&e.ID, &e.Kind, &e.Format, &filters, &e.Status, &e.FileName, &e.RowCount, &e.Error,
+			&e.RequestedBy, &e.CreatedAt, &e.CompletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, ErrExportNotFound
+	}
+	if err != nil {
+		return e, err
+	}
+	if err = json.Unmarshal(filters, &e.Filter); err != nil {
+		return e, fmt.Errorf("invalid filters of export %d: %w", id, err)
+	}
+	return e, nil
+}
+
+// MarkExportRunning marks a pending export as picked up by a worker.
+func (m *DBModel) MarkExportRunning(id int) error {
+	return m.updateExport(id, `status = $2`, ExportStatusRunning)
+}
+
+// CompleteExport records the file and row count of a finished export.
+func (m *DBModel) CompleteExport(id int, fileName string, rowCount int) error {
+	return m.updateExport(id, `status = $2, file_name = $3, row_count = $4, completed_at = $5`,
+		ExportStatusCompleted, fileName, rowCount, time.Now())
+}
+
+// FailExport records why an export failed.
+func (m *DBModel) FailExport(id int, reason string) error {
+	return m.updateExport(id, `status = $2, error = $3, completed_at = $4`,
+		ExportStatusFailed, reason, time.Now())
+}
+
+func (m *DBModel) updateExport(id int, set string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE exports SET `+set+` WHERE id = $1`, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update export %d: %w", id, err)
+	}
+	return nil
+}
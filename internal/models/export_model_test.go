@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_StreamExport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT c.id, .* WHERE w.is_recurring = \\$1 AND o.status_id = \\$2 GROUP BY c.id ORDER BY c.id").
+		WithArgs(false, OrderStatusCleared).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "count", "sum", "min", "max"}).
+			AddRow(1, "Jane", "Doe", "jane@example.com", 2, 3000, nil, nil).
+			AddRow(2, "John", "Roe", "john@example.com", 1, 500, nil, nil))
+
+	model := DBModel{DB: db}
+	var emails []interface{}
+	count, err := model.StreamExport(context.Background(), ExportKindCustomers,
+		OrderFilter{StatusID: OrderStatusCleared},
+		func(row []interface{}) error {
+			emails = append(emails, row[3])
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []interface{}{"jane@example.com", "john@example.com"}, emails)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_StreamExport_UnknownKind(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	model := DBModel{DB: db}
+	_, err = model.StreamExport(context.Background(), "users", OrderFilter{}, func([]interface{}) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestDBModel_StreamExport_Encrypted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	cipher := testPII(t)
+	seal := func(value string) string {
+		sealed, err := cipher.Seal(value)
+		require.NoError(t, err)
+		return sealed
+	}
+
+	mock.ExpectQuery("SELECT o.id, .* ORDER BY o.created_at DESC, o.id DESC").
+		WithArgs(false).
+		WillReturnRows(sqlmock.NewRows(make([]string, 13)).
+			AddRow(9, nil, "Cleared", "Widget", 1, 1000, 0, "usd", seal("Jane"), seal("Doe"), seal("jane@example.com"), "4242", "pi_1"))
+	mock.ExpectQuery("SELECT c.id, .* GROUP BY c.id ORDER BY c.id").
+		WithArgs(false).
+		WillReturnRows(sqlmock.NewRows(make([]string, 8)).
+			AddRow(1, seal("Jane"), seal("Doe"), []byte(seal("jane@example.com")), 2, 3000, nil, nil))
+
+	model := DBModel{DB: db, PII: cipher}
+	var rows [][]interface{}
+	collect := func(row []interface{}) error {
+		rows = append(rows, append([]interface{}(nil), row...))
+		return nil
+	}
+
+	// Sorting by email falls back to the creation date, as for no sort
+	_, err = model.StreamExport(context.Background(), ExportKindOrders, OrderFilter{SortBy: "email", SortOrder: "desc"}, collect)
+	require.NoError(t, err)
+	_, err = model.StreamExport(context.Background(), ExportKindCustomers, OrderFilter{}, collect)
+	require.NoError(t, err)
+
+	require.Len(t, rows, 2)
+	assert.Equal(t, []interface{}{"Jane", "Doe", "jane@example.com"}, rows[0][8:11])
+	assert.Equal(t, []interface{}{"Jane", "Doe", "jane@example.com"}, rows[1][1:4])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,323 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GDPR request types
+const (
+	GDPRRequestAccess  = "access"
+	GDPRRequestErasure = "erasure"
+)
+
+// GDPRRequest is an entry of the data subject request audit trail.
+type GDPRRequest struct {
+	ID          int              `json:"id"`
+	Type        string           `json:"request_type"`
+	SubjectHash string           `json:"subject_hash"`
+	Actor       string           `json:"actor"`
+	RequestedBy *int             `json:"requested_by,omitempty"`
+	Summary     map[string]int64 `json:"summary"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// SubjectHash identifies a data subject in the audit trail without storing their email.
+func SubjectHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Subqueries selecting the records of the data subject whose lower-cased email
+// is $1, and the blind index of that email $2
+const (
+	subjectUsers     = `SELECT id FROM users WHERE email_index = $2 OR LOWER(email) = $1`
+	subjectCustomers = `SELECT id FROM customers WHERE email_index = $2 OR LOWER(email) = $1`
+	subjectOrders    = `SELECT id FROM orders WHERE customer_id IN (` + subjectCustomers + `)`
+	// scs stores session values gob-encoded, in which strings appear verbatim
+	subjectSessions = `position(convert_to($1, 'UTF8') in data) > 0`
+)
+
+// subjectTable is one table of a data subject access report.
+type subjectTable struct {
+	name  string
+	query string
+}
+
+// subjectTables lists everything held about a data subject. Secrets such as
+// password, token and recovery code hashes, TOTP secrets and raw session data
+// are left out.
+var subjectTables = []subjectTable{
+	{"users", `SELECT id, first_name, last_name, email, role, totp_enabled, created_at, updated_at
+		FROM users WHERE email_index = $2 OR LOWER(email) = $1`},
+	{"customers", `SELECT id, first_name, last_name, email, created_at, updated_at
+		FROM customers WHERE email_index = $2 OR LOWER(email) = $1`},
+	{"orders", `SELECT id, widget_id, transaction_id, customer_id, status_id, quantity, amount,
+			shipping_method_id, shipping_amount, created_at, updated_at
+		FROM orders WHERE id IN (` + subjectOrders + `) ORDER BY id`},
+	{"order_addresses", `SELECT order_id, address_type, name, line1, line2, city, region, postal_code, country, phone
+		FROM order_addresses WHERE order_id IN (` + subjectOrders + `) ORDER BY order_id, address_type`},
+	{"shipments", `SELECT order_id, carrier, tracking_number, tracking_url, shipped_at
+		FROM shipments WHERE order_id IN (` + subjectOrders + `) ORDER BY order_id, id`},
+	{"transactions", `SELECT id, amount, currency, last_four, expiry_month, expiry_year, payment_intent,
+			payment_method, bank_return_code, created_at
+		FROM transactions WHERE id IN (SELECT transaction_id FROM orders WHERE id IN (` + subjectOrders + `)) ORDER BY id`},
+	{"returns", `SELECT id, order_id, quantity, reason, note, status, outcome, refund_amount, credit_amount, created_at
+		FROM returns WHERE order_id IN (` + subjectOrders + `) ORDER BY id`},
+	{"store_credits", `SELECT amount, reason, return_id, created_at
+		FROM store_credits WHERE customer_id IN (` + subjectCustomers + `) ORDER BY id`},
+	{"checkout_sessions", `SELECT id, email, first_name, widget_id, quantity, amount, currency, status, created_at
+		FROM checkout_sessions WHERE LOWER(email) = $1 ORDER BY id`},
+	{"tokens", `SELECT id, user_id, name, email, device, ip_address, user_agent, created_at, last_used_at, expiry
+		FROM tokens WHERE LOWER(email) = $1 OR user_id IN (` + subjectUsers + `) ORDER BY id`},
+	{"mfa_recovery_codes", `SELECT created_at, used_at
+		FROM mfa_recovery_codes WHERE user_id IN (` + subjectUsers + `) ORDER BY id`},
+	{"login_attempts", `SELECT ip_address, user_agent, succeeded, created_at
+		FROM login_attempts WHERE LOWER(email) = $1 OR user_id IN (` + subjectUsers + `) ORDER BY id`},
+	{"user_identities", `SELECT issuer, subject, created_at, last_login_at
+		FROM user_identities WHERE user_id IN (` + subjectUsers + `) ORDER BY id`},
+	{"sessions", `SELECT expiry FROM sessions WHERE ` + subjectSessions},
+	{"ai_conversations", `SELECT id, session_id, started_at, ended_at, total_messages, resulted_in_purchase,
+			user_agent, ip_address, created_at
+		FROM ai_conversations WHERE user_id IN (` + subjectUsers + `) ORDER BY id`},
+	{"ai_messages", `SELECT conversation_id, role, content, created_at
+		FROM ai_messages WHERE conversation_id IN (SELECT id FROM ai_conversations WHERE user_id IN (` + subjectUsers + `))
+		ORDER BY conversation_id, id`},
+	{"ai_user_preferences", `SELECT preferred_categories, budget_min, budget_max, interaction_count,
+			last_products_viewed, last_products_purchased, conversation_style, preferred_language, created_at, updated_at
+		FROM ai_user_preferences WHERE user_id IN (` + subjectUsers + `)`},
+	{"support_tickets", `SELECT id, subject, status, priority, user_email, user_name, created_at, closed_at
+		FROM support_tickets WHERE user_id IN (` + subjectUsers + `) OR user_email_index = $2 OR LOWER(user_email) = $1 ORDER BY id`},
+	{"support_messages", `SELECT ticket_id, sender_type, sender_name, message, created_at
+		FROM support_messages
+		WHERE sender_id IN (` + subjectUsers + `)
+		   OR ticket_id IN (SELECT id FROM support_tickets
+			WHERE user_id IN (` + subjectUsers + `) OR user_email_index = $2 OR LOWER(user_email) = $1)
+		ORDER BY ticket_id, id`},
+	{"reviews", `SELECT widget_id, customer_email, reviewer_name, rating, title, body, status, created_at
+		FROM reviews WHERE user_id IN (` + subjectUsers + `) OR LOWER(customer_email) = $1 ORDER BY id`},
+	{"wishlists", `SELECT l.id, l.name, l.notify_email, i.widget_id, i.price_when_added, l.created_at
+		FROM wishlists l LEFT JOIN wishlist_items i ON (i.wishlist_id = l.id)
+		WHERE l.user_id IN (` + subjectUsers + `) OR LOWER(l.notify_email) = $1 ORDER BY l.id, i.id`},
+}
+
+// subjectErasures anonymise or delete a data subject's records, in order:
+// the email-based subqueries must run before users and customers lose their
+// email. Orders, transaction amounts and payment references, and the country
+// of order addresses are kept for bookkeeping.
+var subjectErasures = []subjectTable{
+	{"sessions", `DELETE FROM sessions WHERE ` + subjectSessions},
+	{"tokens", `DELETE FROM tokens WHERE LOWER(email) = $1 OR user_id IN (` + subjectUsers + `)`},
+	{"login_attempts", `DELETE FROM login_attempts WHERE LOWER(email) = $1 OR user_id IN (` + subjectUsers + `)`},
+	{"mfa_recovery_codes", `DELETE FROM mfa_recovery_codes WHERE user_id IN (` + subjectUsers + `)`},
+	{"user_identities", `DELETE FROM user_identities WHERE user_id IN (` + subjectUsers + `)`},
+	{"ai_conversations", `DELETE FROM ai_conversations WHERE user_id IN (` + subjectUsers + `)`},
+	{"ai_user_preferences", `DELETE FROM ai_user_preferences WHERE user_id IN (` + subjectUsers + `)`},
+	{"support_messages", `UPDATE support_messages SET sender_name = 'Erased user' WHERE sender_id IN (` + subjectUsers + `)`},
+	{"support_tickets", `DELETE FROM support_tickets
+		WHERE user_id IN (` + subjectUsers + `) OR user_email_index = $2 OR LOWER(user_email) = $1`},
+	{"reviews", `DELETE FROM reviews WHERE user_id IN (` + subjectUsers + `) OR LOWER(customer_email) = $1`},
+	{"wishlists", `DELETE FROM wishlists WHERE user_id IN (` + subjectUsers + `) OR LOWER(notify_email) = $1`},
+	{"checkout_sessions", `DELETE FROM checkout_sessions WHERE LOWER(email) = $1`},
+	{"order_addresses", `UPDATE order_addresses
+		SET name = '', line1 = '', line2 = '', city = '', region = '', postal_code = '', phone = ''
+		WHERE order_id IN (` + subjectOrders + `)`},
+	{"returns", `UPDATE returns SET note = '' WHERE order_id IN (` + subjectOrders + `)`},
+	{"transactions", `UPDATE transactions SET last_four = '', expiry_month = 0, expiry_year = 0, updated_at = NOW()
+		WHERE id IN (SELECT transaction_id FROM orders WHERE id IN (` + subjectOrders + `))`},
+	{"customers", `UPDATE customers
+		SET first_name = 'Erased', last_name = 'Customer', email = 'erased-customer-' || id || '@erased.invalid',
+		    email_index = NULL, updated_at = NOW()
+		WHERE email_index = $2 OR LOWER(email) = $1`},
+	{"users", `UPDATE users
+		SET first_name = 'Erased', last_name = 'User', email = 'erased-user-' || id || '@erased.invalid',
+		    email_index = NULL, password = '', totp_secret = '', totp_enabled = false, updated_at = NOW()
+		WHERE email_index = $2 OR LOWER(email) = $1`},
+}
+
+// CollectSubjectData returns every record held about the data subject with the given email, by table.
+func (m *DBModel) CollectSubjectData(email string) (map[string][]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	data := make(map[string][]map[string]interface{}, len(subjectTables))
+	for _, table := range subjectTables {
+		rows, err := m.DB.QueryContext(ctx, table.query, subjectArgs(table.query, email, m.PII.Index(email))...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", table.name, err)
+		}
+		records, err := m.scanRecords(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", table.name, err)
+		}
+		data[table.name] = records
+	}
+	return data, nil
+}
+
+// subjectArgs returns the arguments of a subject query: the email, and the
+// blind index of the email when the query uses it
+func subjectArgs(query, email string, index []byte) []interface{} {
+	if strings.Contains(query, "$2") {
+		return []interface{}{email, index}
+	}
+	return []interface{}{email}
+}
+
+// scanRecords reads rows into maps keyed by column name, with personal data
+// decrypted, and closes them.
+func (m *DBModel) scanRecords(rows *sql.Rows) ([]map[string]interface{}, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		if err = m.openPersonalData(columns, values); err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			record[column] = values[i]
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// RecordSubjectAccess adds a fulfilled access request to the audit trail.
+func (m *DBModel) RecordSubjectAccess(email, actor string, requestedBy *int, summary map[string]int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertGDPRRequest(ctx, m.DB, GDPRRequest{
+		Type:        GDPRRequestAccess,
+		SubjectHash: SubjectHash(email),
+		Actor:       actor,
+		RequestedBy: requestedBy,
+		Summary:     summary,
+	})
+}
+
+// EraseSubject anonymises the personal data of the data subject with the
+// given email and records the erasure in the audit trail, all in one
+// transaction. It returns the number of affected rows by table.
+func (m *DBModel) EraseSubject(email, actor string, requestedBy *int) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	summary := make(map[string]int64, len(subjectErasures))
+	for _, erasure := range subjectErasures {
+		result, err := tx.ExecContext(ctx, erasure.query, subjectArgs(erasure.query, email, m.PII.Index(email))...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to erase %s: %w", erasure.name, err)
+		}
+		summary[erasure.name], err = result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = insertGDPRRequest(ctx, tx, GDPRRequest{
+		Type:        GDPRRequestErasure,
+		SubjectHash: SubjectHash(email),
+		Actor:       actor,
+		RequestedBy: requestedBy,
+		Summary:     summary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, tx.Commit()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertGDPRRequest(ctx context.Context, db execer, r GDPRRequest) error {
+	summary, err := json.Marshal(r.Summary)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO gdpr_requests (request_type, subject_hash, actor, requested_by, summary, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		r.Type, r.SubjectHash, r.Actor, r.RequestedBy, summary, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record %s request: %w", r.Type, err)
+	}
+	return nil
+}
+
+// GetGDPRRequests returns the audit trail, newest first, optionally only for one email.
+func (m *DBModel) GetGDPRRequests(email string, limit int) ([]*GDPRRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, request_type, subject_hash, actor, requested_by, summary, created_at FROM gdpr_requests`
+	args := []interface{}{limit}
+	if email != "" {
+		query += ` WHERE subject_hash = $2`
+		args = append(args, SubjectHash(email))
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*GDPRRequest{}
+	for rows.Next() {
+		var r GDPRRequest
+		var summary []byte
+		err = rows.Scan(&r.ID, &r.Type, &r.SubjectHash, &r.Actor, &r.RequestedBy, &summary, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(summary, &r.Summary); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subjectValues returns the arguments of a subject query for jane@example.com
+func subjectValues(query string) []driver.Value {
+	values := []driver.Value{"jane@example.com"}
+	if strings.Contains(query, "$2") {
+		values = append(values, sqlmock.AnyArg())
+	}
+	return values
+}
+
+func TestSubjectHash(t *testing.T) {
+	assert.Equal(t, SubjectHash("jane@example.com"), SubjectHash(" Jane@Example.com "))
+	assert.Len(t, SubjectHash("jane@example.com"), 64)
+}
+
+func TestDBModel_CollectSubjectData(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, table := range subjectTables {
+		rows := sqlmock.NewRows([]string{"id", "email"})
+		if table.name == "users" {
+			rows.AddRow(7, []byte("jane@example.com"))
+		}
+		mock.ExpectQuery("SELECT .* FROM " + table.name).
+			WithArgs(subjectValues(table.query)...).
+			WillReturnRows(rows)
+	}
+
+	model := DBModel{DB: db}
+	data, err := model.CollectSubjectData(" Jane@Example.com")
+	require.NoError(t, err)
+	assert.Len(t, data, len(subjectTables))
+	require.Len(t, data["users"], 1)
+	assert.Equal(t, "jane@example.com", data["users"][0]["email"])
+	assert.Empty(t, data["orders"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_EraseSubject(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	for _, erasure := range subjectErasures {
+		mock.ExpectExec("(DELETE FROM|UPDATE) " + erasure.name).
+			WithArgs(subjectValues(erasure.query)...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec("INSERT INTO gdpr_requests").
+		WithArgs(GDPRRequestErasure, SubjectHash("jane@example.com"), "admin@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	adminID := 1
+	model := DBModel{DB: db}
+	summary, err := model.EraseSubject("jane@example.com", "admin@example.com", &adminID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), summary["customers"])
+	assert.Equal(t, int64(1), summary["transactions"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_EraseSubject_RollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM sessions").
+		WithArgs("jane@example.com").
+		WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	_, err = model.EraseSubject("jane@example.com", "admin@example.com", nil)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ImpersonationTokenPrefix starts every impersonation token, to tell them
+// apart from sign-in tokens
+const ImpersonationTokenPrefix = "usi_"
+
+// ErrImpersonationNotFound is returned when an impersonation does not exist,
+// or can no longer be used
+var ErrImpersonationNotFound = errors.New("impersonation not found")
+
+// Impersonation lets a staff member see what a customer sees, read-only, while
+// the customer has a support ticket open
+type Impersonation struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// ActorID is the staff member acting as the user
+	ActorID    int        `json:"actor_id"`
+	ActorEmail string     `json:"actor_email"`
+	TicketID   int        `json:"ticket_id"`
+	Reason     string     `json:"reason"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// openTicketStatuses are the support ticket statuses still being worked on
+const openTicketStatuses = `('open', 'assigned', 'in_progress')`
+
+// GenerateImpersonationToken returns a new random impersonation token and the
+// hash it is stored as
+func GenerateImpersonationToken() (string, []byte, error) {
+	return generateSecret(ImpersonationTokenPrefix)
+}
+
+// HasOpenTicket reports whether support ticket ticketID belongs to user userID
+// and is still open
+func (m *DBModel) HasOpenTicket(ticketID, userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var open bool
+	query := `SELECT EXISTS (SELECT 1 FROM support_tickets
+		WHERE id = $1 AND user_id = $2 AND status IN ` + openTicketStatuses + `)`
+	err := m.DB.QueryRowContext(ctx, query, ticketID, userID).Scan(&open)
+	return open, err
+}
+
+// InsertImpersonation stores imp under the hash of its token and returns its id
+func (m *DBModel) InsertImpersonation(imp Impersonation, hash []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `INSERT INTO impersonations (token_hash, user_id, actor_id, ticket_id, reason, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	err := m.DB.QueryRowContext(ctx, stmt, hash, imp.UserID, imp.ActorID, imp.TicketID, imp.Reason,
+		imp.ExpiresAt, time.Now()).Scan(&id)
+	return id, err
+}
+
+// GetUserForImpersonation returns the impersonation stored under hash and the
+// user it acts as. It returns ErrImpersonationNotFound when there is no such
+// impersonation, it ended or expired at time now, or its ticket was closed.
+func (m *DBModel) GetUserForImpersonation(hash []byte, now time.Time) (Impersonation, User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var imp Impersonation
+	var user User
+	query := `SELECT i.id, i.user_id, i.actor_id, a.email, i.ticket_id, i.reason, i.expires_at, i.created_at,
+			u.id, u.first_name, u.last_name, u.email, u.role, u.status, u.created_at, u.updated_at
+		FROM impersonations i
+		JOIN users u ON u.id = i.user_id
+		JOIN users a ON a.id = i.actor_id
+		WHERE i.token_hash = $1 AND i.ended_at IS NULL AND i.expires_at > $2
+		  AND EXISTS (SELECT 1 FROM support_tickets t
+			WHERE t.id = i.ticket_id AND t.user_id = i.user_id AND t.status IN ` + openTicketStatuses + `)`
+	err := m.DB.QueryRowContext(ctx, query, hash, now).Scan(
+		&imp.ID, &imp.UserID, &imp.ActorID, m.PII.Field(&imp.ActorEmail), &imp.TicketID, &imp.Reason, &imp.ExpiresAt, &imp.CreatedAt,
+		&user.ID, m.PII.Field(&user.FirstName), m.PII.Field(&user.LastName), m.PII.Field(&user.Email), &user.Role, &user.Status, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return imp, user, ErrImpersonationNotFound
+	}
+	return imp, user, err
+}
+
+// EndImpersonation stops impersonation id, started by actorID, from being
+// used and returns it. It returns ErrImpersonationNotFound when there is no
+// such impersonation of actorID or it already ended.
+func (m *DBModel) EndImpersonation(id, actorID int) (Impersonation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	imp := Impersonation{ID: id, ActorID: actorID}
+	now := time.Now()
+	err := m.DB.QueryRowContext(ctx,
+		`UPDATE impersonations SET ended_at = $1 WHERE id = $2 AND actor_id = $3 AND ended_at IS NULL
+		RETURNING user_id, ticket_id, reason, expires_at, created_at`,
+		now, id, actorID).Scan(&imp.UserID, &imp.TicketID, &imp.Reason, &imp.ExpiresAt, &imp.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return imp, ErrImpersonationNotFound
+	}
+	if err != nil {
+		return imp, err
+	}
+	imp.EndedAt = &now
+	return imp, nil
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateImpersonationToken(t *testing.T) {
+	token, hash, err := GenerateImpersonationToken()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, ImpersonationTokenPrefix))
+	assert.Equal(t, HashAPIKey(token), hash)
+}
+
+func TestDBModel_HasOpenTicket(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM support_tickets WHERE id = \\$1 AND user_id = \\$2 AND status IN \\('open', 'assigned', 'in_progress'\\)").
+		WithArgs(12, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	model := DBModel{DB: db}
+	open, err := model.HasOpenTicket(12, 7)
+	require.NoError(t, err)
+	assert.True(t, open)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetUserForImpersonation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	hash := HashAPIKey("usi_TEST")
+	query := "FROM impersonations i JOIN users u ON u.id = i.user_id JOIN users a ON a.id = i.actor_id " +
+		"WHERE i.token_hash = \\$1 AND i.ended_at IS NULL AND i.expires_at > \\$2 AND EXISTS"
+	mock.ExpectQuery(query).
+		WithArgs(hash, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "actor_id", "email", "ticket_id", "reason", "expires_at",
+			"created_at", "id", "first_name", "last_name", "email", "role", "status", "created_at", "updated_at"}).
+			AddRow(4, 7, 2, "support@example.com", 12, "checkout shows the wrong total", now.Add(time.Minute), now,
+				7, "Jane", "Doe", "jane@example.com", "user", UserStatusActive, now, now))
+
+	model := DBModel{DB: db}
+	imp, user, err := model.GetUserForImpersonation(hash, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imp.ActorID)
+	assert.Equal(t, "support@example.com", imp.ActorEmail)
+	assert.Equal(t, 12, imp.TicketID)
+	assert.Equal(t, "jane@example.com", user.Email)
+
+	// Ended, expired, or the ticket was closed
+	mock.ExpectQuery(query).WithArgs(hash, now).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, _, err = model.GetUserForImpersonation(hash, now)
+	assert.ErrorIs(t, err, ErrImpersonationNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_EndImpersonation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	stmt := "UPDATE impersonations SET ended_at = \\$1 WHERE id = \\$2 AND actor_id = \\$3 AND ended_at IS NULL RETURNING"
+	now := time.Now()
+	mock.ExpectQuery(stmt).WithArgs(sqlmock.AnyArg(), 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "ticket_id", "reason", "expires_at", "created_at"}).
+			AddRow(7, 12, "checkout shows the wrong total", now.Add(time.Minute), now))
+	mock.ExpectQuery(stmt).WithArgs(sqlmock.AnyArg(), 4, 3).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	model := DBModel{DB: db}
+	imp, err := model.EndImpersonation(4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 7, imp.UserID)
+	assert.Equal(t, 12, imp.TicketID)
+	assert.NotNil(t, imp.EndedAt)
+
+	// Someone else's impersonation
+	_, err = model.EndImpersonation(4, 3)
+	assert.ErrorIs(t, err, ErrImpersonationNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
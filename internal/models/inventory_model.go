@@ -0,0 +1,350 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reasons recorded for stock movements
+const (
+	StockReasonSale                 = "sale"
+	StockReasonPurchaseOrderReceipt = "purchase_order_receipt"
+	StockReasonManualAdjustment     = "manual_adjustment"
+	StockReasonReturn               = "return"
+)
+
+// ErrInsufficientStock is returned when an adjustment other than a sale would
+// take a widget's inventory below zero
+var ErrInsufficientStock = errors.New("not enough stock for this adjustment")
+
+// StockAdjustment describes a change of a widget's inventory and what caused it.
+type StockAdjustment struct {
+	WidgetID      int
+	Change        int
+	Reason        string
+	ReferenceType string
+	ReferenceID   *int
+	Note          string
+	CreatedBy     *int
+}
+
+// StockLevelChange is the outcome of an inventory adjustment.
+type StockLevelChange struct {
+	WidgetID int
+	Before   int
+	After    int
+}
+
+// StockMovement is an entry of the inventory ledger.
+type StockMovement struct {
+	ID              int       `json:"id"`
+	WidgetID        int       `json:"widget_id"`
+	QuantityChange  int       `json:"quantity_change"`
+	InventoryBefore int       `json:"inventory_before"`
+	InventoryAfter  int       `json:"inventory_after"`
+	Reason          string    `json:"reason"`
+	ReferenceType   string    `json:"reference_type"`
+	ReferenceID     *int      `json:"reference_id,omitempty"`
+	Note            string    `json:"note"`
+	CreatedBy       *int      `json:"created_by,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// StockSettings holds the low-stock threshold and restock configuration of a widget.
+// A threshold of 0 disables low-stock alerts.
+type StockSettings struct {
+	WidgetID          int  `json:"widget_id"`
+	LowStockThreshold int  `json:"low_stock_threshold"`
+	ReorderQuantity   int  `json:"reorder_quantity"`
+	SupplierID        *int `json:"supplier_id"`
+}
+
+// LowStockAlert is raised when a widget's inventory falls below its threshold.
+type LowStockAlert struct {
+	ID              int       `json:"id"`
+	WidgetID        int       `json:"widget_id"`
+	WidgetName      string    `json:"widget_name"`
+	InventoryLevel  int       `json:"inventory_level"`
+	Threshold       int       `json:"threshold"`
+	PurchaseOrderID *int      `json:"purchase_order_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// AdjustInventory changes a widget's inventory and records the movement.
+func (m *DBModel) AdjustInventory(adj StockAdjustment) (StockLevelChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return StockLevelChange{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	change, err := adjustInventory(ctx, tx, adj)
+	if err != nil {
+		return StockLevelChange{}, err
+	}
+	return change, tx.Commit()
+}
+
+// adjustInventory changes a widget's inventory inside tx and records the
+// requested change in the ledger. Sales are already paid for, so they may take
+// the level below zero and the negative level shows what was oversold; any
+// other decrement that would do so fails with ErrInsufficientStock. When the
+// level falls below the widget's low-stock threshold an alert is queued and, if
+// a supplier is configured, a draft purchase order is created.
+func adjustInventory(ctx context.Context, tx *sql.Tx, adj StockAdjustment) (StockLevelChange, error) {
+	change := StockLevelChange{WidgetID: adj.WidgetID}
+
+	err := tx.QueryRowContext(ctx, `SELECT inventory_level FROM widgets WHERE id = $1 FOR UPDATE`, adj.WidgetID).Scan(&change.Before)
+	if errors.Is(err, sql.ErrNoRows) {
+		return change, fmt.Errorf("widget_id %d does not exist", adj.WidgetID)
+	}
+	if err != nil {
+		return change, err
+	}
+
+	change.After = change.Before + adj.Change
+	if adj.Change < 0 && change.After < 0 && adj.Reason != StockReasonSale {
+		return change, fmt.Errorf("widget_id %d has %d in stock: %w", adj.WidgetID, change.Before, ErrInsufficientStock)
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `UPDATE widgets SET inventory_level = $1, updated_at = $2 WHERE id = $3`,
+		change.After, now, adj.WidgetID)
+	if err != nil {
+		return change, fmt.Errorf("failed to update inventory: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO stock_movements
+			(widget_id, quantity_change, inventory_before, inventory_after, reason, reference_type, reference_id, note, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		adj.WidgetID, adj.Change, change.Before, change.After,
+		adj.Reason, adj.ReferenceType, adj.ReferenceID, adj.Note, adj.CreatedBy, now)
+	if err != nil {
+		return change, fmt.Errorf("failed to record stock movement: %w", err)
+	}
+
+	err = checkLowStock(ctx, tx, change)
+	if err != nil {
+		return change, err
+	}
+	return change, nil
+}
+
+// checkLowStock queues a low-stock alert, and drafts a restock purchase order,
+// when change crossed the widget's threshold.
+func checkLowStock(ctx context.Context, tx *sql.Tx, change StockLevelChange) error {
+	var settings StockSettings
+	err := tx.QueryRowContext(ctx,
+		`SELECT widget_id, low_stock_threshold, reorder_quantity, supplier_id FROM widget_stock_settings WHERE widget_id = $1`,
+		change.WidgetID).Scan(&settings.WidgetID, &settings.LowStockThreshold, &settings.ReorderQuantity, &settings.SupplierID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	threshold := settings.LowStockThreshold
+	if threshold == 0 || change.Before < threshold || change.After >= threshold {
+		return nil
+	}
+
+	var purchaseOrderID *int
+	if settings.SupplierID != nil && settings.ReorderQuantity > 0 {
+		var open bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS(
+				SELECT 1 FROM purchase_order_items i
+					INNER JOIN purchase_orders p ON (i.purchase_order_id = p.id)
+				WHERE i.widget_id = $1 AND p.status IN ('draft', 'ordered', 'partially_received'))`,
+			change.WidgetID).Scan(&open)
+		if err != nil {
+			return err
+		}
+
+		if !open {
+			id, err := createPurchaseOrder(ctx, tx, PurchaseOrder{
+				SupplierID: *settings.SupplierID,
+				Notes:      fmt.Sprintf("Drafted automatically: stock fell to %d (threshold %d)", change.After, threshold),
+				Items: []*PurchaseOrderItem{
+					{WidgetID: change.WidgetID, QuantityOrdered: settings.ReorderQuantity},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			purchaseOrderID = &id
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO low_stock_alerts (widget_id, inventory_level, threshold, purchase_order_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		change.WidgetID, change.After, threshold, purchaseOrderID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to queue low-stock alert: %w", err)
+	}
+	return nil
+}
+
+// GetStockMovements returns the latest inventory movements of a widget, newest first.
+func (m *DBModel) GetStockMovements(widgetID, limit int) ([]*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, widget_id, quantity_change, inventory_before, inventory_after, reason,
+					 reference_type, reference_id, note, created_by, created_at
+			  FROM stock_movements
+			  WHERE widget_id = $1
+			  ORDER BY created_at DESC, id DESC
+			  LIMIT $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, widgetID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movements []*StockMovement
+	for rows.Next() {
+		var sm StockMovement
+		err = rows.Scan(
+			&sm.ID,
+			&sm.WidgetID,
+			&sm.QuantityChange,
+			&sm.InventoryBefore,
+			&sm.InventoryAfter,
+			&sm.Reason,
+			&sm.ReferenceType,
+			&sm.ReferenceID,
+			&sm.Note,
+			&sm.CreatedBy,
+			&sm.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, &sm)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// GetStockSettings returns the stock settings of a widget, or the defaults if none were saved.
+func (m *DBModel) GetStockSettings(widgetID int) (StockSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	settings := StockSettings{WidgetID: widgetID}
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT low_stock_threshold, reorder_quantity, supplier_id FROM widget_stock_settings WHERE widget_id = $1`,
+		widgetID).Scan(&settings.LowStockThreshold, &settings.ReorderQuantity, &settings.SupplierID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// SaveStockSettings creates or replaces the stock settings of a widget.
+func (m *DBModel) SaveStockSettings(settings StockSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.CheckWidgetExistence(ctx, settings.WidgetID); err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO widget_stock_settings (widget_id, low_stock_threshold, reorder_quantity, supplier_id, updated_at)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (widget_id) DO UPDATE
+			 SET low_stock_threshold = EXCLUDED.low_stock_threshold,
+			 	 reorder_quantity = EXCLUDED.reorder_quantity,
+			 	 supplier_id = EXCLUDED.supplier_id,
+			 	 updated_at = EXCLUDED.updated_at`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		settings.WidgetID, settings.LowStockThreshold, settings.ReorderQuantity, settings.SupplierID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save stock settings: %w", err)
+	}
+	return nil
+}
+
+// GetPendingLowStockAlerts returns the low-stock alerts that have not been sent yet, oldest first.
+func (m *DBModel) GetPendingLowStockAlerts() ([]*LowStockAlert, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT a.id, a.widget_id, w.name, a.inventory_level, a.threshold, a.purchase_order_id, a.created_at
+			  FROM low_stock_alerts a
+			  	INNER JOIN widgets w ON (a.widget_id = w.id)
+			  WHERE a.notified_at IS NULL
+			  ORDER BY a.created_at ASC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []*LowStockAlert
+	for rows.Next() {
+		var a LowStockAlert
+		err = rows.Scan(&a.ID, &a.WidgetID, &a.WidgetName, &a.InventoryLevel, &a.Threshold, &a.PurchaseOrderID, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// MarkLowStockAlertNotified records that a low-stock alert was sent.
+func (m *DBModel) MarkLowStockAlertNotified(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE low_stock_alerts SET notified_at = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
+
+// GetAdminEmails returns the email addresses of admins and super admins.
+func (m *DBModel) GetAdminEmails() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT email FROM users WHERE role IN ('super_admin', 'admin') ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err = rows.Scan(m.PII.Field(&email)); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
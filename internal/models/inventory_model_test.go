@@ -0,0 +1,224 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectStockChange expects the statements adjustInventory issues before the low-stock check
+func expectStockChange(mock sqlmock.Sqlmock, widgetID, before, after int, reason string) {
+	mock.ExpectQuery("SELECT inventory_level FROM widgets WHERE id = \\$1 FOR UPDATE").
+		WithArgs(widgetID).
+		WillReturnRows(sqlmock.NewRows([]string{"inventory_level"}).AddRow(before))
+	mock.ExpectExec("UPDATE widgets SET inventory_level = \\$1").
+		WithArgs(after, sqlmock.AnyArg(), widgetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO stock_movements").
+		WithArgs(widgetID, after-before, before, after, reason, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestDBModel_AdjustInventory(t *testing.T) {
+	supplierID := 4
+	settingsColumns := []string{"widget_id", "low_stock_threshold", "reorder_quantity", "supplier_id"}
+
+	tests := []struct {
+		name      string
+		before    int
+		change    int
+		wantAfter int
+		settings  *sqlmock.Rows
+		openPO    bool
+		wantAlert bool
+		wantDraft bool
+	}{
+		{
+			name:      "no settings",
+			before:    10,
+			change:    -3,
+			wantAfter: 7,
+			settings:  sqlmock.NewRows(settingsColumns),
+		},
+		{
+			name:      "stays above threshold",
+			before:    10,
+			change:    -3,
+			wantAfter: 7,
+			settings:  sqlmock.NewRows(settingsColumns).AddRow(1, 5, 20, supplierID),
+		},
+		{
+			name:      "falls below threshold drafts purchase order",
+			before:    6,
+			change:    -3,
+			wantAfter: 3,
+			settings:  sqlmock.NewRows(settingsColumns).AddRow(1, 5, 20, supplierID),
+			wantAlert: true,
+			wantDraft: true,
+		},
+		{
+			name:      "falls below threshold with open purchase order",
+			before:    6,
+			change:    -3,
+			wantAfter: 3,
+			settings:  sqlmock.NewRows(settingsColumns).AddRow(1, 5, 20, supplierID),
+			openPO:    true,
+			wantAlert: true,
+		},
+		{
+			name:      "already below threshold",
+			before:    4,
+			change:    -1,
+			wantAfter: 3,
+			settings:  sqlmock.NewRows(settingsColumns).AddRow(1, 5, 20, supplierID),
+		},
+		{
+			name:      "oversold sale goes below zero",
+			before:    2,
+			change:    -5,
+			wantAfter: -3,
+			settings:  sqlmock.NewRows(settingsColumns),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			expectStockChange(mock, 1, tt.before, tt.wantAfter, StockReasonSale)
+			mock.ExpectQuery("SELECT widget_id, low_stock_threshold, reorder_quantity, supplier_id FROM widget_stock_settings").
+				WithArgs(1).
+				WillReturnRows(tt.settings)
+			if tt.wantAlert {
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.openPO))
+				if tt.wantDraft {
+					mock.ExpectQuery("INSERT INTO purchase_orders").
+						WithArgs(supplierID, PurchaseOrderStatusDraft, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+					mock.ExpectExec("INSERT INTO purchase_order_items").
+						WithArgs(12, 1, 20, 0).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+				mock.ExpectExec("INSERT INTO low_stock_alerts").
+					WithArgs(1, tt.wantAfter, 5, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			mock.ExpectCommit()
+
+			model := DBModel{DB: db}
+			change, err := model.AdjustInventory(StockAdjustment{WidgetID: 1, Change: tt.change, Reason: StockReasonSale})
+			require.NoError(t, err)
+			assert.Equal(t, StockLevelChange{WidgetID: 1, Before: tt.before, After: tt.wantAfter}, change)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_AdjustInventory_InsufficientStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT inventory_level FROM widgets WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"inventory_level"}).AddRow(2))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	_, err = model.AdjustInventory(StockAdjustment{WidgetID: 1, Change: -5, Reason: StockReasonManualAdjustment})
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_ReceivePurchaseOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		lines      []ReceiptLine
+		wantStatus string
+		wantErr    bool
+	}{
+		{
+			name:       "partial receipt",
+			status:     PurchaseOrderStatusOrdered,
+			lines:      []ReceiptLine{{WidgetID: 1, Quantity: 5}},
+			wantStatus: PurchaseOrderStatusPartiallyReceived,
+		},
+		{
+			name:       "receive everything outstanding",
+			status:     PurchaseOrderStatusPartiallyReceived,
+			lines:      nil,
+			wantStatus: PurchaseOrderStatusReceived,
+		},
+		{
+			name:    "more than outstanding",
+			status:  PurchaseOrderStatusOrdered,
+			lines:   []ReceiptLine{{WidgetID: 1, Quantity: 20}},
+			wantErr: true,
+		},
+		{
+			name:    "draft cannot be received",
+			status:  PurchaseOrderStatusDraft,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT status FROM purchase_orders WHERE id = \\$1 FOR UPDATE").
+				WithArgs(12).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+
+			if tt.status != PurchaseOrderStatusDraft {
+				// 10 ordered, 0 received
+				mock.ExpectQuery("SELECT widget_id, quantity_ordered, quantity_received FROM purchase_order_items").
+					WithArgs(12).
+					WillReturnRows(sqlmock.NewRows([]string{"widget_id", "quantity_ordered", "quantity_received"}).AddRow(1, 10, 0))
+			}
+
+			if !tt.wantErr {
+				quantity := 10
+				if len(tt.lines) > 0 {
+					quantity = tt.lines[0].Quantity
+				}
+				mock.ExpectExec("UPDATE purchase_order_items SET quantity_received").
+					WithArgs(quantity, 12, 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectStockChange(mock, 1, 2, 2+quantity, StockReasonPurchaseOrderReceipt)
+				mock.ExpectQuery("SELECT widget_id, low_stock_threshold").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"widget_id", "low_stock_threshold", "reorder_quantity", "supplier_id"}))
+				mock.ExpectExec("UPDATE purchase_orders SET status = \\$1").
+					WithArgs(tt.wantStatus, sqlmock.AnyArg(), sqlmock.AnyArg(), 12).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			model := DBModel{DB: db}
+			changes, err := model.ReceivePurchaseOrder(12, tt.lines, 9)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, changes, 1)
+				assert.Equal(t, 2, changes[0].Before)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EncryptedColumn is a column holding values encrypted with the keyring, in
+// a table with an integer id
+type EncryptedColumn struct {
+	Table  string
+	Column string
+}
+
+// EncryptedColumns lists every column encrypted with the keyring, so all of
+// them are re-encrypted when keys are rotated
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "users", Column: "totp_secret"},
+}
+
+// ReencryptFunc returns a value encrypted with the active key, and whether it
+// changed
+type ReencryptFunc func(ciphertext string) (string, bool, error)
+
+// ReencryptColumn re-encrypts the non-empty values of col with reencrypt,
+// batchSize rows at a time, and returns how many rows changed. A value that
+// changed since it was read is left for the next run.
+func (m *DBModel) ReencryptColumn(col EncryptedColumn, batchSize int, reencrypt ReencryptFunc) (int, error) {
+	query := fmt.Sprintf(`SELECT id, %[2]s FROM %[1]s WHERE id > $1 AND %[2]s <> '' ORDER BY id LIMIT $2`, col.Table, col.Column)
+	stmt := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = $1 WHERE id = $2 AND %[2]s = $3`, col.Table, col.Column)
+
+	return m.updateInBatches(query, stmt, batchSize, func(value string) (interface{}, bool, error) {
+		return reencrypt(value)
+	})
+}
+
+// updateFunc returns the new value of a column, from the value read, and
+// whether it changed
+type updateFunc func(value string) (interface{}, bool, error)
+
+// updateInBatches reads the id and a value of rows with query, batchSize rows
+// at a time, and writes the values update changed with stmt. It returns how
+// many rows changed.
+func (m *DBModel) updateInBatches(query, stmt string, batchSize int, update updateFunc) (int, error) {
+	changed, lastID := 0, 0
+	for {
+		n, err := m.updateBatch(query, stmt, &lastID, batchSize, update)
+		changed += n
+		if err != nil || lastID == 0 {
+			return changed, err
+		}
+	}
+}
+
+// updateBatch updates the batch of rows after *lastID, which it moves to the
+// last row read. *lastID is 0 when there were no rows left.
+func (m *DBModel) updateBatch(query, stmt string, lastID *int, batchSize int, update updateFunc) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, *lastID, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	type value struct {
+		id    int
+		value string
+	}
+	var values []value
+	for rows.Next() {
+		var v value
+		if err = rows.Scan(&v.id, &v.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	*lastID = 0
+	changed := 0
+	for _, v := range values {
+		*lastID = v.id
+		updated, ok, err := update(v.value)
+		if err != nil {
+			return changed, fmt.Errorf("row %d: %w", v.id, err)
+		}
+		if !ok {
+			continue
+		}
+		result, err := m.DB.ExecContext(ctx, stmt, updated, v.id, v.value)
+		if err != nil {
+			return changed, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return changed, err
+		}
+		changed += int(n)
+	}
+	return changed, nil
+}
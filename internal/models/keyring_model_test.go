@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_ReencryptColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Values of the active key "1" are left alone
+	reencrypt := func(ciphertext string) (string, bool, error) {
+		if strings.HasPrefix(ciphertext, "1:") {
+			return ciphertext, false, nil
+		}
+		return "1:" + ciphertext, true, nil
+	}
+
+	mock.ExpectQuery("SELECT id, totp_secret FROM users WHERE id > \\$1 AND totp_secret <> '' ORDER BY id LIMIT \\$2").
+		WithArgs(0, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).AddRow(3, "legacy").AddRow(5, "1:current"))
+	mock.ExpectExec("UPDATE users SET totp_secret = \\$1 WHERE id = \\$2 AND totp_secret = \\$3").
+		WithArgs("1:legacy", 3, "legacy").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, totp_secret FROM users").
+		WithArgs(5, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).AddRow(8, "0:old"))
+	mock.ExpectExec("UPDATE users SET totp_secret").
+		WithArgs("1:0:old", 8, "0:old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, totp_secret FROM users").
+		WithArgs(8, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}))
+
+	model := DBModel{DB: db}
+	changed, err := model.ReencryptColumn(EncryptedColumn{Table: "users", Column: "totp_secret"}, 2, reencrypt)
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_ReencryptColumn_UnknownKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, totp_secret FROM users").
+		WithArgs(0, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).AddRow(3, "9:gone"))
+
+	model := DBModel{DB: db}
+	_, err = model.ReencryptColumn(EncryptedColumn{Table: "users", Column: "totp_secret"}, 100,
+		func(string) (string, bool, error) { return "", false, errors.New("unknown key ID \"9\"") })
+	assert.ErrorContains(t, err, "row 3: unknown key ID")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
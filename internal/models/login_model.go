@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrUserNotFound is returned when a user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// LoginState is the record of failed sign-ins of an account
+type LoginState struct {
+	FailedLogins int
+	// LastFailedAt is zero when there were no failures
+	LastFailedAt time.Time
+	// LockedUntil is zero when the account was never locked
+	LockedUntil time.Time
+}
+
+// Locked reports whether the account is locked at time now
+func (s LoginState) Locked(now time.Time) bool {
+	return s.LockedUntil.After(now)
+}
+
+// LoginAttempt is one attempt to sign in
+type LoginAttempt struct {
+	Email string
+	// UserID is zero when no account has the email
+	UserID    int
+	IPAddress string
+	UserAgent string
+}
+
+// IPLoginFailures summarises the failed sign-ins from one IP address
+type IPLoginFailures struct {
+	Failures    int
+	LastFailure time.Time
+	// Accounts is how many different emails the failures were for
+	Accounts int
+}
+
+// GetLoginState returns the failed sign-ins of a user
+func (m *DBModel) GetLoginState(userID int) (LoginState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var state LoginState
+	var lastFailed, lockedUntil sql.NullTime
+	query := `SELECT failed_logins, last_failed_login_at, locked_until FROM users WHERE id = $1`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&state.FailedLogins, &lastFailed, &lockedUntil)
+	if err != nil {
+		return state, err
+	}
+	state.LastFailedAt = lastFailed.Time
+	state.LockedUntil = lockedUntil.Time
+	return state, nil
+}
+
+// GetIPLoginFailures returns the failed sign-ins from ip since the given time
+func (m *DBModel) GetIPLoginFailures(ip string, since time.Time) (IPLoginFailures, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures IPLoginFailures
+	var last sql.NullTime
+	query := `SELECT COUNT(*), MAX(created_at), COUNT(DISTINCT email)
+		FROM login_attempts
+		WHERE ip_address = $1 AND NOT succeeded AND created_at > $2`
+	err := m.DB.QueryRowContext(ctx, query, ip, since).Scan(&failures.Failures, &last, &failures.Accounts)
+	if err != nil {
+		return failures, err
+	}
+	failures.LastFailure = last.Time
+	return failures, nil
+}
+
+// RecordLoginFailure stores a failed attempt and, for an existing account,
+// counts the failure. When it is failure number lockAfter or later the account
+// is locked until lockUntil. It returns the new state of the account and
+// whether this failure locked it.
+func (m *DBModel) RecordLoginFailure(attempt LoginAttempt, now time.Time, lockAfter int, lockUntil time.Time) (LoginState, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var state LoginState
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return state, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = insertLoginAttempt(ctx, tx, attempt, false, now)
+	if err != nil {
+		return state, false, err
+	}
+	if attempt.UserID == 0 {
+		return state, false, tx.Commit()
+	}
+
+	var lockedUntil, wasLockedUntil sql.NullTime
+	stmt := `UPDATE users u
+		SET failed_logins = u.failed_logins + 1, last_failed_login_at = $1,
+			locked_until = CASE WHEN u.failed_logins + 1 >= $2 THEN $3 ELSE u.locked_until END
+		FROM (SELECT id, locked_until FROM users WHERE id = $4 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING u.failed_logins, u.locked_until, old.locked_until`
+	err = tx.QueryRowContext(ctx, stmt, now, lockAfter, lockUntil, attempt.UserID).Scan(
+		&state.FailedLogins, &lockedUntil, &wasLockedUntil,
+	)
+	if err != nil {
+		return state, false, err
+	}
+	state.LastFailedAt = now
+	state.LockedUntil = lockedUntil.Time
+
+	err = tx.Commit()
+	if err != nil {
+		return state, false, err
+	}
+	locked := state.Locked(now) && !wasLockedUntil.Time.After(now)
+	return state, locked, nil
+}
+
+// RecordLoginSuccess stores a successful sign-in and clears the failures of
+// the account. It returns how many failures preceded it.
+func (m *DBModel) RecordLoginSuccess(attempt LoginAttempt, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = insertLoginAttempt(ctx, tx, attempt, true, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	stmt := `UPDATE users u
+		SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL
+		FROM (SELECT id, failed_logins FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING old.failed_logins`
+	err = tx.QueryRowContext(ctx, stmt, attempt.UserID).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+func insertLoginAttempt(ctx context.Context, tx *sql.Tx, attempt LoginAttempt, succeeded bool, now time.Time) error {
+	var userID *int
+	if attempt.UserID != 0 {
+		userID = &attempt.UserID
+	}
+	stmt := `INSERT INTO login_attempts (email, user_id, ip_address, user_agent, succeeded, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.ExecContext(ctx, stmt, strings.ToLower(attempt.Email), userID, attempt.IPAddress,
+		attempt.UserAgent, succeeded, now)
+	return err
+}
+
+// UnlockUser clears the failed sign-ins and lockout of a user. It returns
+// ErrUserNotFound when there is no such user.
+func (m *DBModel) UnlockUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL WHERE id = $1`
+	return unlockUser(ctx, m.DB, stmt, id)
+}
+
+// UnlockUserByEmail clears the failed sign-ins and lockout of the user with
+// the given email. It returns ErrUserNotFound when there is no such user.
+func (m *DBModel) UnlockUserByEmail(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE email_index = $2 OR LOWER(email) = $1`
+	return unlockUser(ctx, m.DB, stmt, strings.ToLower(email), m.PII.Index(email))
+}
+
+func unlockUser(ctx context.Context, db *sql.DB, stmt string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteLoginAttemptsBefore removes sign-in attempts older than t and returns how many
+func (m *DBModel) DeleteLoginAttemptsBefore(t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM login_attempts WHERE created_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
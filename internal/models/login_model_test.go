@@ -0,0 +1,142 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_RecordLoginFailure(t *testing.T) {
+	now := time.Now()
+	lockUntil := now.Add(30 * time.Minute)
+
+	tests := []struct {
+		name           string
+		failures       int
+		lockedUntil    interface{}
+		wasLockedUntil interface{}
+		wantLocked     bool
+	}{
+		{"counted", 4, nil, nil, false},
+		{"locks the account", 10, lockUntil, nil, true},
+		{"locks again after the lock expired", 11, lockUntil, now.Add(-time.Minute), true},
+		{"already locked", 11, lockUntil, lockUntil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO login_attempts").
+				WithArgs("jane@example.com", sqlmock.AnyArg(), "192.0.2.1", "curl/8", false, now).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("UPDATE users u SET failed_logins = u.failed_logins \\+ 1").
+				WithArgs(now, 10, lockUntil, 3).
+				WillReturnRows(sqlmock.NewRows([]string{"failed_logins", "locked_until", "locked_until"}).
+					AddRow(tt.failures, tt.lockedUntil, tt.wasLockedUntil))
+			mock.ExpectCommit()
+
+			model := DBModel{DB: db}
+			attempt := LoginAttempt{Email: "Jane@example.com", UserID: 3, IPAddress: "192.0.2.1", UserAgent: "curl/8"}
+			state, locked, err := model.RecordLoginFailure(attempt, now, 10, lockUntil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.failures, state.FailedLogins)
+			assert.Equal(t, tt.wantLocked, locked)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_RecordLoginFailure_UnknownEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("nobody@example.com", nil, "192.0.2.1", "", false, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	state, locked, err := model.RecordLoginFailure(LoginAttempt{Email: "nobody@example.com", IPAddress: "192.0.2.1"},
+		now, 10, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, state.FailedLogins)
+	assert.False(t, locked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_RecordLoginSuccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("jane@example.com", sqlmock.AnyArg(), "192.0.2.1", "", true, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE users u SET failed_logins = 0").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_logins"}).AddRow(5))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	failures, err := model.RecordLoginSuccess(LoginAttempt{Email: "jane@example.com", UserID: 3, IPAddress: "192.0.2.1"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 5, failures)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetIPLoginFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	since := time.Now().Add(-15 * time.Minute)
+	last := time.Now().Add(-time.Minute)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), MAX\\(created_at\\), COUNT\\(DISTINCT email\\) FROM login_attempts").
+		WithArgs("192.0.2.1", since).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max", "count"}).AddRow(12, last, 7))
+
+	model := DBModel{DB: db}
+	failures, err := model.GetIPLoginFailures("192.0.2.1", since)
+	require.NoError(t, err)
+	assert.Equal(t, IPLoginFailures{Failures: 12, LastFailure: last, Accounts: 7}, failures)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_UnlockUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"unlocked", 1, nil},
+		{"no such user", 0, ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("UPDATE users SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL WHERE id = \\$1").
+				WithArgs(3).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			assert.Equal(t, tt.wantErr, model.UnlockUser(3))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMagicLinkUsed is returned when the nonce of a magic sign-in link was
+// already consumed
+var ErrMagicLinkUsed = errors.New("sign-in link already used")
+
+// ConsumeMagicLinkNonce records that the magic sign-in link with nonce was
+// used by the user with userID at now, so it cannot be used again before it
+// expires at expiresAt. Nonces of expired links are removed. It returns
+// ErrMagicLinkUsed when the nonce was consumed before.
+func (m *DBModel) ConsumeMagicLinkNonce(nonce string, userID int, expiresAt, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM magic_link_nonces WHERE expires_at < $1`, now)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO magic_link_nonces (nonce, user_id, consumed_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (nonce) DO NOTHING`
+	result, err := m.DB.ExecContext(ctx, stmt, nonce, userID, now, expiresAt)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMagicLinkUsed
+	}
+	return nil
+}
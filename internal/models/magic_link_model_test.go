@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_ConsumeMagicLinkNonce(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(15 * time.Minute)
+
+	tests := []struct {
+		name     string
+		inserted int64
+		wantErr  error
+	}{
+		{"first use", 1, nil},
+		{"used before", 0, ErrMagicLinkUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("DELETE FROM magic_link_nonces WHERE expires_at < \\$1").
+				WithArgs(now).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec("INSERT INTO magic_link_nonces .* ON CONFLICT \\(nonce\\) DO NOTHING").
+				WithArgs("abc", 7, now, expiresAt).
+				WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+
+			model := DBModel{DB: db}
+			err = model.ConsumeMagicLinkNonce("abc", 7, expiresAt, now)
+			assert.Equal(t, tt.wantErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
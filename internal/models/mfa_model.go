@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrMFAAlreadyEnabled is returned when enrolling a user who already uses two-factor authentication
+var ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+// UserMFA is the two-factor authentication state of a user
+type UserMFA struct {
+	// Secret is the TOTP secret, encrypted; empty until enrolment starts
+	Secret string
+	// Enabled is set once the user proved they can generate codes
+	Enabled bool
+	// LastStep is the time step of the last accepted code
+	LastStep          int64
+	RecoveryCodesLeft int
+}
+
+// GetUserMFA returns the two-factor authentication state of a user
+func (m *DBModel) GetUserMFA(userID int) (UserMFA, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mfa UserMFA
+	query := `SELECT totp_secret, totp_enabled, totp_last_step,
+			(SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		FROM users WHERE id = $1`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.Secret, &mfa.Enabled, &mfa.LastStep, &mfa.RecoveryCodesLeft)
+	return mfa, err
+}
+
+// SetTOTPSecret stores the encrypted secret of an enrolment that has not been
+// confirmed yet. It fails with ErrMFAAlreadyEnabled once enrolment completed,
+// so that a stolen session cannot swap the secret.
+func (m *DBModel) SetTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_secret = $1, updated_at = $2 WHERE id = $3 AND NOT totp_enabled`
+	res, err := m.DB.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTOTP completes enrolment: the secret becomes active, step is recorded
+// as used and the recovery codes are replaced by codeHashes.
+func (m *DBModel) EnableTOTP(userID int, step int64, codeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt := `UPDATE users SET totp_enabled = true, totp_last_step = $1, updated_at = $2
+		WHERE id = $3 AND totp_secret <> '' AND NOT totp_enabled`
+	res, err := tx.ExecContext(ctx, stmt, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP turns two-factor authentication off, forgetting the secret and recovery codes
+func (m *DBModel) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt := `UPDATE users SET totp_secret = '', totp_enabled = false, totp_last_step = 0, updated_at = $1
+		WHERE id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of time step step was accepted. It reports
+// false when that step or a later one was used already, i.e. the code is replayed.
+func (m *DBModel) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_enabled AND totp_last_step < $1`
+	res, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks the unused recovery code with the given hash as used.
+// It reports false when the user has no such code.
+func (m *DBModel) UseRecoveryCode(userID int, codeHash []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE mfa_recovery_codes SET used_at = $1
+		WHERE id = (SELECT id FROM mfa_recovery_codes
+			WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL LIMIT 1)`
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes invalidates the recovery codes of a user and stores codeHashes instead
+func (m *DBModel) ReplaceRecoveryCodes(userID int, codeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes [][]byte) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`,
+			userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
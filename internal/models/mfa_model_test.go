@@ -0,0 +1,172 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_GetUserMFA(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT totp_secret, totp_enabled, totp_last_step, (.+) FROM users WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled", "totp_last_step", "count"}).
+			AddRow("encrypted", true, int64(55), 8))
+
+	model := DBModel{DB: db}
+	mfa, err := model.GetUserMFA(4)
+	require.NoError(t, err)
+	assert.Equal(t, UserMFA{Secret: "encrypted", Enabled: true, LastStep: 55, RecoveryCodesLeft: 8}, mfa)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_SetTOTPSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"enrolment started", 1, nil},
+		{"already enabled", 0, ErrMFAAlreadyEnabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("UPDATE users SET totp_secret = \\$1, updated_at = \\$2 WHERE id = \\$3 AND NOT totp_enabled").
+				WithArgs("encrypted", sqlmock.AnyArg(), 4).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			err = model.SetTOTPSecret(4, "encrypted")
+			assert.Equal(t, tt.wantErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_EnableTOTP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	hashes := [][]byte{[]byte("hash-1"), []byte("hash-2")}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_enabled = true, totp_last_step = \\$1").
+		WithArgs(int64(55), sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM mfa_recovery_codes WHERE user_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, hash := range hashes {
+		mock.ExpectExec("INSERT INTO mfa_recovery_codes").
+			WithArgs(4, hash, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	require.NoError(t, model.EnableTOTP(4, 55, hashes))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_EnableTOTP_NotEnrolling(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_enabled = true").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	err = model.EnableTOTP(4, 55, [][]byte{[]byte("hash-1")})
+	assert.Equal(t, ErrMFAAlreadyEnabled, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_UseTOTPStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		want     bool
+	}{
+		{"new step", 1, true},
+		{"replayed step", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("UPDATE users SET totp_last_step = \\$1 WHERE id = \\$2 AND totp_enabled AND totp_last_step < \\$1").
+				WithArgs(int64(56), 4).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			ok, err := model.UseTOTPStep(4, 56)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_UseRecoveryCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		want     bool
+	}{
+		{"unused code", 1, true},
+		{"used or unknown code", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = \\$1 (.+) WHERE user_id = \\$2 AND code_hash = \\$3 AND used_at IS NULL").
+				WithArgs(sqlmock.AnyArg(), 4, []byte("hash-1")).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			ok, err := model.UseRecoveryCode(4, []byte("hash-1"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_DisableTOTP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET totp_secret = '', totp_enabled = false").
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM mfa_recovery_codes WHERE user_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	require.NoError(t, model.DisableTOTP(4))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
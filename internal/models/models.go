@@ -6,15 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
+	passwords "usual_store/internal/password"
+	"usual_store/internal/pii"
 )
 
-// DBModel represents a model with a database connection.
+// DBModel represents a model with a database connection. With PII set, the
+// names and emails of users, customers and support tickets are stored
+// encrypted.
 type DBModel struct {
-	DB *sql.DB
+	DB  *sql.DB
+	PII *pii.Cipher
 }
 
 // Models is the type for all models
@@ -43,8 +47,25 @@ type Order struct {
 	Customer      Customer    `json:"customer"`
 	CreatedAt     time.Time   `json:"-"`
 	UpdatedAt     time.Time   `json:"-"`
+
+	ShippingMethodID *int        `json:"shipping_method_id,omitempty"`
+	ShippingAmount   int         `json:"shipping_amount"`
+	BillingAddress   *Address    `json:"billing_address,omitempty"`
+	ShippingAddress  *Address    `json:"shipping_address,omitempty"`
+	Shipments        []*Shipment `json:"shipments,omitempty"`
 }
 
+// Order statuses
+const (
+	OrderStatusCleared         = 1
+	OrderStatusRefunded        = 2
+	OrderStatusCancelled       = 3
+	OrderStatusReturnRequested = 4
+	OrderStatusReturnApproved  = 5
+	OrderStatusReturnReceived  = 6
+	OrderStatusReturned        = 7
+)
+
 // Status is the type for statuses
 type Status struct {
 	ID        int       `json:"id"`
@@ -85,6 +106,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
 	Role      string    `json:"role"`
+	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -141,7 +163,9 @@ func (m *DBModel) GetTransactionIDByPaymentIntent(ctx context.Context, paymentIn
 	return id, nil
 }
 
-// InsertOrder insert a new order and returns new id
+// InsertOrder insert a new order and returns new id. The quantity sold is
+// taken out of the widget's inventory in the same transaction, except for
+// subscriptions.
 func (m *DBModel) InsertOrder(order Order) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -152,37 +176,59 @@ func (m *DBModel) InsertOrder(order Order) (int, error) {
 		return 0, fmt.Errorf("widget does not exist: %w", err)
 	}
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
 	// Insert the order into the database
 	stmt := `
         INSERT INTO orders 
-        (widget_id, transaction_id, status_id, quantity, customer_id, amount)
-        VALUES($1, $2, $3, $4, $5, $6)
+        (widget_id, transaction_id, status_id, quantity, customer_id, amount,
+         shipping_method_id, shipping_amount)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id
     `
-	_, err = m.DB.ExecContext(ctx, stmt,
+	var orderID int
+	err = tx.QueryRowContext(ctx, stmt,
 		order.WidgetID,
 		order.TransactionID,
 		order.StatusID,
 		order.Quantity,
 		order.CustomerID,
 		order.Amount,
-	)
+		order.ShippingMethodID,
+		order.ShippingAmount,
+	).Scan(&orderID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to insert order: %w", err)
 	}
 
-	// Retrieve the latest inserted ID
-	var orderID int
-	query := `
-        SELECT id FROM orders
-        WHERE widget_id = $1 AND transaction_id = $2 AND customer_id = $3
-        ORDER BY created_at DESC
-        LIMIT 1
-    `
-	err = m.DB.QueryRowContext(ctx, query, order.WidgetID, order.TransactionID, order.CustomerID).Scan(&orderID)
+	var isRecurring bool
+	err = tx.QueryRowContext(ctx, `SELECT is_recurring FROM widgets WHERE id = $1`, order.WidgetID).Scan(&isRecurring)
 	if err != nil {
-		return 0, fmt.Errorf("failed to retrieve order ID: %w", err)
+		return 0, err
 	}
 
+	if !isRecurring && order.Quantity > 0 {
+		_, err = adjustInventory(ctx, tx, StockAdjustment{
+			WidgetID:      order.WidgetID,
+			Change:        -order.Quantity,
+			Reason:        StockReasonSale,
+			ReferenceType: "order",
+			ReferenceID:   &orderID,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
 	return orderID, nil
 }
 
@@ -224,7 +270,8 @@ func (m *DBModel) GetLastInsertedCustomerID() (int, error) {
 // CheckCustomerExistence checks if a customer exists based on their email.
 func (m *DBModel) CheckCustomerExistence(ctx context.Context, email string) (bool, error) {
 	var exists bool
-	err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM customers WHERE email = $1)", email).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM customers WHERE email_index = $2 OR email = $1)",
+		email, m.PII.Index(email)).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("could not check customer existence: %v", err)
 	}
@@ -239,16 +286,18 @@ func (m *DBModel) GetUserByEmail(email string) (User, error) {
 	email = strings.ToLower(email)
 	var user User
 	// Update query to use $1 for parameterized query in PostgreSQL
-	row := m.DB.QueryRowContext(ctx, `SELECT id, first_name, last_name, email, password, role, created_at, updated_at FROM users WHERE email=$1`, email)
+	row := m.DB.QueryRowContext(ctx, `SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status
+		FROM users WHERE email_index = $2 OR email = $1`, email, m.PII.Index(email))
 	err := row.Scan(
 		&user.ID,
-		&user.FirstName,
-		&user.LastName,
-		&user.Email,
+		m.PII.Field(&user.FirstName),
+		m.PII.Field(&user.LastName),
+		m.PII.Field(&user.Email),
 		&user.Password,
 		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Status,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -267,7 +316,7 @@ func (m *DBModel) Authenticate(email, password string) (int, error) {
 	var id int
 	var hashedPassword string
 
-	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email=$1", email)
+	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email_index = $2 OR email = $1", email, m.PII.Index(email))
 	err := row.Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -275,11 +324,11 @@ func (m *DBModel) Authenticate(email, password string) (int, error) {
 		}
 		return id, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-		return 0, fmt.Errorf("invalid password")
-	} else if err != nil {
+	ok, err := passwords.Verify(password, hashedPassword)
+	if err != nil {
 		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("invalid password")
 	}
 	return id, nil
 }
@@ -357,9 +406,9 @@ func (m *DBModel) GetOrdersPaginated(isRecurring bool, pageSize, page int) ([]*O
 			&order.Transaction.PaymentIntent,
 			&order.Transaction.BankReturnCode,
 			&order.Customer.ID,
-			&order.Customer.FirstName,
-			&order.Customer.LastName,
-			&order.Customer.Email,
+			m.PII.Field(&order.Customer.FirstName),
+			m.PII.Field(&order.Customer.LastName),
+			m.PII.Field(&order.Customer.Email),
 		)
 		if err != nil {
 			fmt.Println("scanning error:", err)
@@ -435,9 +484,9 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 		&order.Transaction.PaymentIntent,
 		&order.Transaction.BankReturnCode,
 		&order.Customer.ID,
-		&order.Customer.FirstName,
-		&order.Customer.LastName,
-		&order.Customer.Email,
+		m.PII.Field(&order.Customer.FirstName),
+		m.PII.Field(&order.Customer.LastName),
+		m.PII.Field(&order.Customer.Email),
 	)
 	if err != nil {
 		fmt.Println("scanning error:", err)
@@ -478,9 +527,9 @@ func (m *DBModel) GetAllUsers() ([]*User, error) {
 		var user User
 		err = rows.Scan(
 			&user.ID,
-			&user.FirstName,
-			&user.LastName,
-			&user.Email,
+			m.PII.Field(&user.FirstName),
+			m.PII.Field(&user.LastName),
+			m.PII.Field(&user.Email),
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -490,6 +539,16 @@ func (m *DBModel) GetAllUsers() ([]*User, error) {
 		users = append(users, &user)
 	}
 
+	// Encrypted names sort in the database in no useful order
+	if m.PII != nil {
+		sort.SliceStable(users, func(i, j int) bool {
+			if users[i].LastName != users[j].LastName {
+				return users[i].LastName < users[j].LastName
+			}
+			return users[i].FirstName > users[j].FirstName
+		})
+	}
+
 	return users, nil
 }
 
@@ -499,17 +558,18 @@ func (m *DBModel) GetUserByID(id int) (User, error) {
 	defer cancel()
 	var user User
 
-	query := `SELECT id, first_name, last_name, email, password, role, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, first_name, last_name, email, password, role, created_at, updated_at, status FROM users WHERE id = $1`
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&user.ID,
-		&user.FirstName,
-		&user.LastName,
-		&user.Email,
+		m.PII.Field(&user.FirstName),
+		m.PII.Field(&user.LastName),
+		m.PII.Field(&user.Email),
 		&user.Password,
 		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Status,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -525,8 +585,14 @@ func (m *DBModel) EditUser(user User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `UPDATE users SET first_name = $1, last_name = $2, email = $3, updated_at = $4 WHERE id = $5`
-	_, err := m.DB.ExecContext(ctx, stmt, user.FirstName, user.LastName, user.Email, time.Now(), user.ID)
+	err := m.CheckPlainEmail(ctx, "users", user.Email, user.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET first_name = $1, last_name = $2, email = $3, email_index = $4, updated_at = $5 WHERE id = $6`
+	_, err = m.DB.ExecContext(ctx, stmt, m.PII.Value(user.FirstName), m.PII.Value(user.LastName), m.PII.Value(user.Email),
+		m.PII.Index(user.Email), time.Now(), user.ID)
 
 	if err != nil {
 		return err
@@ -545,10 +611,16 @@ func (m *DBModel) AddUser(user User, hash string) error {
 		role = "user"
 	}
 
-	stmt := `INSERT INTO users (first_name, last_name, email, password, role, created_at, updated_at)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	err := m.CheckPlainEmail(ctx, "users", user.Email, 0)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, stmt, user.FirstName, user.LastName, user.Email, hash, role, time.Now(), time.Now())
+	stmt := `INSERT INTO users (first_name, last_name, email, email_index, password, role, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, m.PII.Value(user.FirstName), m.PII.Value(user.LastName), m.PII.Value(user.Email),
+		m.PII.Index(user.Email), hash, role, time.Now(), time.Now())
 
 	if err != nil {
 		return err
@@ -576,28 +648,44 @@ func (m *DBModel) DeleteUser(id int) error {
 	return nil
 }
 
-// GetAllUsersPaginated returns paginated list of all users with optional search and sorting
+// userSearch returns the condition matching users to search, over the
+// arguments $1 and $2. Names and emails cannot be searched for in part once
+// they are encrypted, so users are then found only by their whole email,
+// whether their row is encrypted yet or not.
+func (m *DBModel) userSearch(search string) (string, []interface{}) {
+	args := []interface{}{"%" + strings.ToLower(search) + "%", m.PII.Index(search)}
+	if m.PII != nil {
+		args = append(args, strings.ToLower(search))
+		return `CAST(id AS TEXT) LIKE $1 OR LOWER(role) LIKE $1 OR email_index = $2 OR
+			(email_index IS NULL AND LOWER(email) = $3)`, args
+	}
+	return `CAST(id AS TEXT) LIKE $1 OR
+			LOWER(first_name) LIKE $1 OR
+			LOWER(last_name) LIKE $1 OR
+			LOWER(email) LIKE $1 OR
+			LOWER(role) LIKE $1 OR
+			email_index = $2`, args
+}
+
+// GetAllUsersPaginated returns paginated list of all users with optional search and sorting.
+// Encrypted names and emails are found only by the whole email, and users
+// cannot be sorted by them.
 func (m *DBModel) GetAllUsersPaginated(offset, limit int, search, sortBy, sortOrder string) ([]User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	// Build base query
-	query := `SELECT id, first_name, last_name, email, role, created_at, updated_at FROM users`
+	query := `SELECT id, first_name, last_name, email, role, created_at, updated_at, status FROM users`
 
 	// Add search filter if provided
 	args := []interface{}{}
-	argIndex := 1
 
 	if search != "" {
-		query += ` WHERE 
-			CAST(id AS TEXT) LIKE $` + fmt.Sprintf("%d", argIndex) + ` OR
-			LOWER(first_name) LIKE $` + fmt.Sprintf("%d", argIndex) + ` OR
-			LOWER(last_name) LIKE $` + fmt.Sprintf("%d", argIndex) + ` OR
-			LOWER(email) LIKE $` + fmt.Sprintf("%d", argIndex) + ` OR
-			LOWER(role) LIKE $` + fmt.Sprintf("%d", argIndex)
-		args = append(args, "%"+strings.ToLower(search)+"%")
-		argIndex++
+		var where string
+		where, args = m.userSearch(search)
+		query += ` WHERE ` + where
 	}
+	argIndex := len(args) + 1
 
 	// Add sorting
 	orderClause := " ORDER BY "
@@ -608,6 +696,10 @@ func (m *DBModel) GetAllUsersPaginated(offset, limit int, search, sortBy, sortOr
 		"role":       "CASE role WHEN 'super_admin' THEN 1 WHEN 'admin' THEN 2 WHEN 'supporter' THEN 3 ELSE 4 END",
 		"created_at": "created_at",
 	}
+	if m.PII != nil {
+		delete(validSortFields, "name")
+		delete(validSortFields, "email")
+	}
 
 	if sortField, ok := validSortFields[sortBy]; ok {
 		orderClause += sortField
@@ -642,12 +734,13 @@ func (m *DBModel) GetAllUsersPaginated(offset, limit int, search, sortBy, sortOr
 		var user User
 		err := rows.Scan(
 			&user.ID,
-			&user.FirstName,
-			&user.LastName,
-			&user.Email,
+			m.PII.Field(&user.FirstName),
+			m.PII.Field(&user.LastName),
+			m.PII.Field(&user.Email),
 			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Status,
 		)
 		if err != nil {
 			return nil, err
@@ -672,13 +765,9 @@ func (m *DBModel) GetUserCount(search string) (int, error) {
 	args := []interface{}{}
 
 	if search != "" {
-		query += ` WHERE 
-			CAST(id AS TEXT) LIKE $1 OR
-			LOWER(first_name) LIKE $1 OR
-			LOWER(last_name) LIKE $1 OR
-			LOWER(email) LIKE $1 OR
-			LOWER(role) LIKE $1`
-		args = append(args, "%"+strings.ToLower(search)+"%")
+		var where string
+		where, args = m.userSearch(search)
+		query += ` WHERE ` + where
 	}
 
 	var count int
@@ -19,7 +19,7 @@ func TestCheckCustomerExistence(t *testing.T) {
 	models := NewModels(db)
 
 	// Define test cases
-	sqlQuery := "SELECT EXISTS\\(SELECT 1 FROM customers WHERE email = \\$1\\)"
+	sqlQuery := "SELECT EXISTS\\(SELECT 1 FROM customers WHERE email_index = \\$2 OR email = \\$1\\)"
 	tests := []struct {
 		name       string
 		email      string
@@ -33,7 +33,7 @@ func TestCheckCustomerExistence(t *testing.T) {
 			email: "customer@example.com",
 			mockQuery: func() {
 				mock.ExpectQuery(sqlQuery).
-					WithArgs("customer@example.com").
+					WithArgs("customer@example.com", sqlmock.AnyArg()).
 					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
 			},
 			expected: true,
@@ -43,7 +43,7 @@ func TestCheckCustomerExistence(t *testing.T) {
 			email: "nonexistent@example.com",
 			mockQuery: func() {
 				mock.ExpectQuery(sqlQuery).
-					WithArgs("nonexistent@example.com").
+					WithArgs("nonexistent@example.com", sqlmock.AnyArg()).
 					WillReturnRows(sqlmock.NewRows([]string{"does not exists"}).AddRow(false))
 			},
 		},
@@ -52,7 +52,7 @@ func TestCheckCustomerExistence(t *testing.T) {
 			email: "error@example.com",
 			mockQuery: func() {
 				mock.ExpectQuery(sqlQuery).
-					WithArgs("error@example.com").
+					WithArgs("error@example.com", sqlmock.AnyArg()).
 					WillReturnError(errors.New("database error"))
 			},
 			shouldFail: true,
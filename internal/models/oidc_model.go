@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrOIDCLoginNotFound is returned when a single sign-on comes back with an
+// unknown or expired state
+var ErrOIDCLoginNotFound = errors.New("sign-in not found or expired")
+
+// ErrOIDCEmailNotVerified is returned when the identity provider has not
+// verified the email address of an identity
+var ErrOIDCEmailNotVerified = errors.New("email address is not verified by the identity provider")
+
+// ErrOIDCUserNotActive is returned when an identity signs in as a user who
+// cannot sign in
+var ErrOIDCUserNotActive = errors.New("account is not active")
+
+// ErrOIDCAccountNotLinked is returned when the first sign-in of an identity
+// has the email of an existing user, who has to link it from their account
+var ErrOIDCAccountNotLinked = errors.New("an account with this email already exists and is not linked to single sign-on")
+
+// ErrOIDCIdentityLinked is returned when linking an identity that is already
+// linked to a user
+var ErrOIDCIdentityLinked = errors.New("identity is already linked to an account")
+
+// OIDCLogin is a single sign-on sent to the identity provider that has not
+// come back yet
+type OIDCLogin struct {
+	Nonce        string
+	CodeVerifier string
+	RedirectURI  string
+	ExpiresAt    time.Time
+	// LinkUserID is the signed-in user linking the identity to their account,
+	// nil for a sign-in
+	LinkUserID *int
+}
+
+// OIDCIdentity is who the identity provider says signed in
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// InsertOIDCLogin stores a sign-in under the hash of its state, and removes
+// expired ones
+func (m *DBModel) InsertOIDCLogin(stateHash []byte, login OIDCLogin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO oidc_logins (state_hash, nonce, code_verifier, redirect_uri, expires_at, link_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = m.DB.ExecContext(ctx, stmt, stateHash, login.Nonce, login.CodeVerifier, login.RedirectURI, login.ExpiresAt, login.LinkUserID)
+	return err
+}
+
+// ConsumeOIDCLogin removes and returns the sign-in stored under stateHash, so
+// that each can only come back once. It returns ErrOIDCLoginNotFound when
+// there is none or it expired before now.
+func (m *DBModel) ConsumeOIDCLogin(stateHash []byte, now time.Time) (OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var login OIDCLogin
+	stmt := `DELETE FROM oidc_logins WHERE state_hash = $1
+		RETURNING nonce, code_verifier, redirect_uri, expires_at, link_user_id`
+	err := m.DB.QueryRowContext(ctx, stmt, stateHash).Scan(&login.Nonce, &login.CodeVerifier, &login.RedirectURI, &login.ExpiresAt, &login.LinkUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return login, ErrOIDCLoginNotFound
+	}
+	if err != nil {
+		return login, err
+	}
+	if !login.ExpiresAt.After(now) {
+		return login, ErrOIDCLoginNotFound
+	}
+	return login, nil
+}
+
+// ProvisionOIDCUser returns the user identity signs in as and gives them
+// role, which the identity provider decides. On the first sign-in a user is
+// created with passwordHash; an existing user with the same email is not
+// taken over, they link the identity from their account with
+// LinkOIDCIdentity. Only identities with a verified email sign in, and only as
+// active users. It also returns the role the user had before, empty when the
+// user was created.
+func (m *DBModel) ProvisionOIDCUser(identity OIDCIdentity, role, passwordHash string, now time.Time) (User, string, error) {
+	var user User
+	if !identity.EmailVerified {
+		return user, "", ErrOIDCEmailNotVerified
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return user, "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	created := false
+	query := `SELECT u.id, u.first_name, u.last_name, u.email, u.role, u.status, u.created_at, u.updated_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2
+		FOR UPDATE OF u`
+	err = tx.QueryRowContext(ctx, query, identity.Issuer, identity.Subject).Scan(
+		&user.ID, m.PII.Field(&user.FirstName), m.PII.Field(&user.LastName), m.PII.Field(&user.Email), &user.Role, &user.Status, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		query = `SELECT EXISTS(SELECT 1 FROM users WHERE email_index = $2 OR LOWER(email) = LOWER($1))`
+		err = tx.QueryRowContext(ctx, query, identity.Email, m.PII.Index(identity.Email)).Scan(&exists)
+		if err != nil {
+			return user, "", err
+		}
+		if exists {
+			return user, "", ErrOIDCAccountNotLinked
+		}
+
+		user = User{FirstName: identity.FirstName, LastName: identity.LastName, Email: identity.Email, Role: role,
+			Status: UserStatusActive, CreatedAt: now, UpdatedAt: now}
+		stmt := `INSERT INTO users (first_name, last_name, email, email_index, password, role, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id`
+		err = tx.QueryRowContext(ctx, stmt, m.PII.Value(user.FirstName), m.PII.Value(user.LastName), m.PII.Value(user.Email),
+			m.PII.Index(user.Email), passwordHash, role, now, now).Scan(&user.ID)
+		if err != nil {
+			return user, "", err
+		}
+		created = true
+
+		stmt = `INSERT INTO user_identities (user_id, issuer, subject, created_at, last_login_at) VALUES ($1, $2, $3, $4, $4)`
+		_, err = tx.ExecContext(ctx, stmt, user.ID, identity.Issuer, identity.Subject, now)
+		if err != nil {
+			return user, "", err
+		}
+	} else if err != nil {
+		return user, "", err
+	} else {
+		if user.Status != UserStatusActive {
+			return user, "", ErrOIDCUserNotActive
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE user_identities SET last_login_at = $1 WHERE issuer = $2 AND subject = $3`,
+			now, identity.Issuer, identity.Subject)
+		if err != nil {
+			return user, "", err
+		}
+	}
+
+	previousRole := user.Role
+	if created {
+		previousRole = ""
+	}
+	if user.Role != role {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET role = $1, updated_at = $2 WHERE id = $3`, role, now, user.ID)
+		if err != nil {
+			return user, "", err
+		}
+		user.Role = role
+		user.UpdatedAt = now
+	}
+
+	return user, previousRole, tx.Commit()
+}
+
+// LinkOIDCIdentity links identity to the signed-in user userID, who signs in
+// with it from then on and gets the role of their groups at the identity
+// provider. It returns ErrOIDCIdentityLinked when the identity already belongs
+// to a user.
+func (m *DBModel) LinkOIDCIdentity(userID int, identity OIDCIdentity, now time.Time) error {
+	if !identity.EmailVerified {
+		return ErrOIDCEmailNotVerified
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO user_identities (user_id, issuer, subject, created_at, last_login_at) VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING`
+	result, err := m.DB.ExecContext(ctx, stmt, userID, identity.Issuer, identity.Subject, now)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOIDCIdentityLinked
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var identityUserColumns = []string{"id", "first_name", "last_name", "email", "role", "status", "created_at", "updated_at"}
+
+func TestDBModel_ProvisionOIDCUser(t *testing.T) {
+	now := time.Now()
+	identity := OIDCIdentity{Issuer: "https://idp.example.com", Subject: "00u1", Email: "Jane@example.com",
+		EmailVerified: true, FirstName: "Jane", LastName: "Doe"}
+
+	t.Run("known identity gets the role of its groups", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM user_identities i JOIN users u ON u.id = i.user_id").
+			WithArgs("https://idp.example.com", "00u1").
+			WillReturnRows(sqlmock.NewRows(identityUserColumns).AddRow(3, "Jane", "Doe", "jane@example.com", "admin", UserStatusActive, now, now))
+		mock.ExpectExec("UPDATE user_identities SET last_login_at").
+			WithArgs(now, "https://idp.example.com", "00u1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE users SET role = \\$1").
+			WithArgs("supporter", now, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		model := DBModel{DB: db}
+		user, previousRole, err := model.ProvisionOIDCUser(identity, "supporter", "hash", now)
+		require.NoError(t, err)
+		assert.Equal(t, "admin", previousRole)
+		assert.Equal(t, 3, user.ID)
+		assert.Equal(t, "supporter", user.Role)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("first sign-in does not take over the user with the same email", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM user_identities i").WillReturnRows(sqlmock.NewRows(identityUserColumns))
+		mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE email_index = \\$2 OR LOWER\\(email\\) = LOWER\\(\\$1\\)\\)").
+			WithArgs("Jane@example.com", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		model := DBModel{DB: db}
+		_, _, err = model.ProvisionOIDCUser(identity, "admin", "hash", now)
+		assert.ErrorIs(t, err, ErrOIDCAccountNotLinked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("user who is not active", func(t *testing.T) {
+		for _, status := range []string{UserStatusPending, UserStatusInvited} {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM user_identities i JOIN users u ON u.id = i.user_id").
+				WithArgs("https://idp.example.com", "00u1").
+				WillReturnRows(sqlmock.NewRows(identityUserColumns).AddRow(3, "Jane", "Doe", "jane@example.com", "user", status, now, now))
+			mock.ExpectRollback()
+
+			model := DBModel{DB: db}
+			_, _, err = model.ProvisionOIDCUser(identity, "admin", "hash", now)
+			assert.ErrorIs(t, err, ErrOIDCUserNotActive, status)
+			assert.NoError(t, mock.ExpectationsWereMet(), status)
+		}
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		unverified := identity
+		unverified.EmailVerified = false
+		model := DBModel{DB: db}
+		_, _, err = model.ProvisionOIDCUser(unverified, "admin", "hash", now)
+		assert.ErrorIs(t, err, ErrOIDCEmailNotVerified)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("first sign-in creates the user", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM user_identities i").WillReturnRows(sqlmock.NewRows(identityUserColumns))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("Jane", "Doe", "Jane@example.com", sqlmock.AnyArg(), "hash", "admin", now, now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectExec("INSERT INTO user_identities").
+			WithArgs(9, "https://idp.example.com", "00u1", now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		model := DBModel{DB: db}
+		user, previousRole, err := model.ProvisionOIDCUser(identity, "admin", "hash", now)
+		require.NoError(t, err)
+		assert.Empty(t, previousRole)
+		assert.Equal(t, User{ID: 9, FirstName: "Jane", LastName: "Doe", Email: "Jane@example.com", Role: "admin",
+			Status: UserStatusActive, CreatedAt: now, UpdatedAt: now}, user)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBModel_LinkOIDCIdentity(t *testing.T) {
+	now := time.Now()
+	identity := OIDCIdentity{Issuer: "https://idp.example.com", Subject: "00u1", Email: "jane@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name     string
+		verified bool
+		inserted int64
+		wantErr  error
+	}{
+		{"links", true, 1, nil},
+		{"linked to a user already", true, 0, ErrOIDCIdentityLinked},
+		{"unverified email", false, 0, ErrOIDCEmailNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			if tt.verified {
+				mock.ExpectExec("INSERT INTO user_identities .* ON CONFLICT \\(issuer, subject\\) DO NOTHING").
+					WithArgs(3, "https://idp.example.com", "00u1", now).
+					WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+			}
+
+			model := DBModel{DB: db}
+			linked := identity
+			linked.EmailVerified = tt.verified
+			err = model.LinkOIDCIdentity(3, linked, now)
+			assert.Equal(t, tt.wantErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_ConsumeOIDCLogin(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{"pending", sqlmock.NewRows([]string{"nonce", "code_verifier", "redirect_uri", "expires_at", "link_user_id"}).
+			AddRow("n", "v", "https://store.example.com/cb", now.Add(time.Minute), nil), nil},
+		{"expired", sqlmock.NewRows([]string{"nonce", "code_verifier", "redirect_uri", "expires_at", "link_user_id"}).
+			AddRow("n", "v", "https://store.example.com/cb", now.Add(-time.Minute), nil), ErrOIDCLoginNotFound},
+		{"unknown state", sqlmock.NewRows([]string{"nonce"}), ErrOIDCLoginNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("DELETE FROM oidc_logins WHERE state_hash = \\$1 RETURNING").
+				WithArgs([]byte("hash")).
+				WillReturnRows(tt.rows)
+
+			model := DBModel{DB: db}
+			login, err := model.ConsumeOIDCLogin([]byte("hash"), now)
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, "v", login.CodeVerifier)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a search cursor is malformed or was issued for another sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrSortEncrypted is returned for a sort by customer name or email once they
+// are encrypted, as ciphertext sorts in no useful order
+var ErrSortEncrypted = errors.New("customer names and emails are encrypted and cannot be sorted by")
+
+// cursorTimeLayout keeps the microsecond precision of PostgreSQL timestamps
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+// orderSortField is a sort key of the admin order search: the SQL expression,
+// the type its cursor value is cast to and how to read that value off an order.
+// Personal ones cannot be sorted by once encrypted.
+type orderSortField struct {
+	expr     string
+	cast     string
+	value    func(o *Order) string
+	personal bool
+}
+
+// orderSortFields maps the admin order search sort keys to their columns.
+var orderSortFields = map[string]orderSortField{
+	"id":         {"o.id", "integer", func(o *Order) string { return strconv.Itoa(o.ID) }, false},
+	"created_at": {"o.created_at", "timestamp", func(o *Order) string { return o.CreatedAt.Format(cursorTimeLayout) }, false},
+	"amount":     {"o.amount", "integer", func(o *Order) string { return strconv.Itoa(o.Amount) }, false},
+	"status":     {"o.status_id", "integer", func(o *Order) string { return strconv.Itoa(o.StatusID) }, false},
+	"quantity":   {"o.quantity", "integer", func(o *Order) string { return strconv.Itoa(o.Quantity) }, false},
+	"first_name": {"c.first_name", "text", func(o *Order) string { return o.Customer.FirstName }, true},
+	"last_name":  {"c.last_name", "text", func(o *Order) string { return o.Customer.LastName }, true},
+	"email":      {"c.email", "text", func(o *Order) string { return o.Customer.Email }, true},
+	"widget":     {"w.name", "text", func(o *Order) string { return o.Widget.Name }, false},
+}
+
+// OrderFilter holds the admin order search criteria. Zero values are ignored.
+type OrderFilter struct {
+	IsRecurring bool       `json:"is_recurring"`
+	Query       string     `json:"q,omitempty"` // part of the customer's first name, last name or email, or all of the email once encrypted
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+	StatusID    int        `json:"status,omitempty"`
+	MinAmount   *int       `json:"min_amount,omitempty"`
+	MaxAmount   *int       `json:"max_amount,omitempty"`
+	WidgetID    int        `json:"widget_id,omitempty"`
+	LastFour    string     `json:"last_four,omitempty"`
+	SortBy      string     `json:"sort_by,omitempty"`
+	SortOrder   string     `json:"sort_order,omitempty"`
+	Cursor      string     `json:"-"`
+	PageSize    int        `json:"-"`
+}
+
+// OrderSearchResult is one page of an admin order search. NextCursor is empty on the last page.
+type OrderSearchResult struct {
+	Orders       []*Order `json:"orders"`
+	TotalRecords int      `json:"total_records"`
+	NextCursor   string   `json:"next_cursor"`
+}
+
+// orderCursor is the position after the last order of a page
+type orderCursor struct {
+	SortBy    string `json:"s"`
+	SortOrder string `json:"o"`
+	Value     string `json:"v"`
+	ID        int    `json:"id"`
+}
+
+func encodeOrderCursor(c orderCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// escapeLike escapes the LIKE wildcards of a search term
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchOrders returns one page of the orders (or subscriptions) matching the
+// filter with the total number of matches. Pages are keyed on the sort column
+// and the order id rather than an offset, so orders placed while an admin is
+// paging do not shift the following pages.
+func (m *DBModel) SearchOrders(f OrderFilter) (OrderSearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var result OrderSearchResult
+
+	if f.SortBy == "" {
+		f.SortBy = "created_at"
+	}
+	sort, ok := orderSortFields[f.SortBy]
+	if !ok {
+		return result, fmt.Errorf("cannot sort by %q", f.SortBy)
+	}
+	if sort.personal && m.PII != nil {
+		return result, ErrSortEncrypted
+	}
+	f.SortOrder = strings.ToLower(f.SortOrder)
+	if f.SortOrder != "asc" {
+		f.SortOrder = "desc"
+	}
+	if f.PageSize < 1 {
+		f.PageSize = 10
+	}
+
+	var cursor *orderCursor
+	if f.Cursor != "" {
+		c, err := decodeOrderCursor(f.Cursor)
+		if err != nil || c.SortBy != f.SortBy || c.SortOrder != f.SortOrder {
+			return result, ErrInvalidCursor
+		}
+		cursor = &c
+	}
+
+	where, args := m.orderFilterWhere(f)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	from := `
+		FROM orders o
+			INNER JOIN widgets w ON (o.widget_id = w.id)
+			LEFT JOIN transactions t ON (o.transaction_id = t.id)
+			INNER JOIN customers c ON (o.customer_id = c.id)
+		WHERE ` + where
+
+	err := m.DB.QueryRowContext(ctx, `SELECT COUNT(o.id)`+from, args...).Scan(&result.TotalRecords)
+	if err != nil {
+		return result, fmt.Errorf("failed to count orders: %w", err)
+	}
+
+	comparison := "<"
+	if f.SortOrder == "asc" {
+		comparison = ">"
+	}
+	if cursor != nil {
+		from += fmt.Sprintf(" AND (%s, o.id) %s (%s::%s, %s)",
+			sort.expr, comparison, arg(cursor.Value), sort.cast, arg(cursor.ID))
+	}
+
+	// one extra row tells whether there is a next page
+	query := customerOrderFields + from +
+		fmt.Sprintf(" ORDER BY %[1]s %[2]s, o.id %[2]s LIMIT %[3]s", sort.expr, f.SortOrder, arg(f.PageSize+1))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to search orders: %w", err)
+	}
+	defer rows.Close()
+
+	result.Orders = []*Order{}
+	for rows.Next() {
+		var order Order
+		if err = m.scanCustomerOrder(rows, &order); err != nil {
+			return result, err
+		}
+		result.Orders = append(result.Orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	if len(result.Orders) > f.PageSize {
+		result.Orders = result.Orders[:f.PageSize]
+		last := result.Orders[f.PageSize-1]
+		result.NextCursor = encodeOrderCursor(orderCursor{
+			SortBy:    f.SortBy,
+			SortOrder: f.SortOrder,
+			Value:     sort.value(last),
+			ID:        last.ID,
+		})
+	}
+	return result, nil
+}
+
+// orderFilterWhere builds the WHERE condition of an order filter over orders o,
+// widgets w, transactions t and customers c, with its arguments. Once names
+// and emails are encrypted, customers match the query only by their whole
+// email, whether their row is encrypted yet or not.
+func (m *DBModel) orderFilterWhere(f OrderFilter) (string, []interface{}) {
+	where := []string{"w.is_recurring = $1"}
+	args := []interface{}{f.IsRecurring}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q := strings.TrimSpace(f.Query); q != "" {
+		if m.PII != nil {
+			where = append(where, fmt.Sprintf("(c.email_index = %s OR (c.email_index IS NULL AND LOWER(c.email) = %s))",
+				arg(m.PII.Index(q)), arg(strings.ToLower(q))))
+		} else {
+			p := arg("%" + escapeLike(q) + "%")
+			where = append(where, fmt.Sprintf(
+				"(c.first_name ILIKE %[1]s OR c.last_name ILIKE %[1]s OR c.email ILIKE %[1]s OR c.first_name || ' ' || c.last_name ILIKE %[1]s OR c.email_index = %[2]s)",
+				p, arg(m.PII.Index(q))))
+		}
+	}
+	if f.From != nil {
+		where = append(where, "o.created_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "o.created_at < "+arg(*f.To))
+	}
+	if f.StatusID > 0 {
+		where = append(where, "o.status_id = "+arg(f.StatusID))
+	}
+	if f.MinAmount != nil {
+		where = append(where, "o.amount >= "+arg(*f.MinAmount))
+	}
+	if f.MaxAmount != nil {
+		where = append(where, "o.amount <= "+arg(*f.MaxAmount))
+	}
+	if f.WidgetID > 0 {
+		where = append(where, "o.widget_id = "+arg(f.WidgetID))
+	}
+	if f.LastFour != "" {
+		where = append(where, "t.last_four = "+arg(f.LastFour))
+	}
+
+	return strings.Join(where, " AND "), args
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_SearchOrders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	minAmount := 500
+
+	mock.ExpectQuery("SELECT COUNT\\(o.id\\).*c.email ILIKE \\$2.*c.email_index = \\$3.*o.created_at >= \\$4.*o.amount >= \\$5.*t.last_four = \\$6").
+		WithArgs(false, "%jane\\_d%", sqlmock.AnyArg(), from, 500, "4242").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	rows := sqlmock.NewRows(customerOrderColumns)
+	customerOrderRow(rows, 9)
+	customerOrderRow(rows, 8)
+	customerOrderRow(rows, 7)
+	mock.ExpectQuery("ORDER BY o.amount asc, o.id asc LIMIT \\$7").
+		WithArgs(false, "%jane\\_d%", sqlmock.AnyArg(), from, 500, "4242", 3).
+		WillReturnRows(rows)
+
+	model := DBModel{DB: db}
+	result, err := model.SearchOrders(OrderFilter{
+		Query:     "jane_d",
+		From:      &from,
+		MinAmount: &minAmount,
+		LastFour:  "4242",
+		SortBy:    "amount",
+		SortOrder: "ASC",
+		PageSize:  2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.TotalRecords)
+	assert.Len(t, result.Orders, 2)
+	require.NotEmpty(t, result.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	cursor, err := decodeOrderCursor(result.NextCursor)
+	require.NoError(t, err)
+	assert.Equal(t, orderCursor{SortBy: "amount", SortOrder: "asc", Value: "1000", ID: 8}, cursor)
+
+	// the next page continues after the last order of this one
+	mock.ExpectQuery("SELECT COUNT\\(o.id\\)").
+		WithArgs(false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("AND \\(o.amount, o.id\\) > \\(\\$2::integer, \\$3\\) ORDER BY o.amount asc, o.id asc LIMIT \\$4").
+		WithArgs(false, "1000", 8, 3).
+		WillReturnRows(customerOrderRow(sqlmock.NewRows(customerOrderColumns), 7))
+
+	result, err = model.SearchOrders(OrderFilter{SortBy: "amount", SortOrder: "asc", PageSize: 2, Cursor: result.NextCursor})
+	require.NoError(t, err)
+	assert.Len(t, result.Orders, 1)
+	assert.Empty(t, result.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_SearchOrders_InvalidInput(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	model := DBModel{DB: db}
+
+	_, err = model.SearchOrders(OrderFilter{SortBy: "password"})
+	assert.Error(t, err)
+
+	cursor := encodeOrderCursor(orderCursor{SortBy: "amount", SortOrder: "asc", Value: "1000", ID: 8})
+	_, err = model.SearchOrders(OrderFilter{SortBy: "created_at", Cursor: cursor})
+	assert.Equal(t, ErrInvalidCursor, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"usual_store/internal/pii"
+)
+
+// ErrDuplicateEmail is returned when another user or customer has the email
+var ErrDuplicateEmail = errors.New("email is already in use")
+
+// PIITable is a table whose personal data is encrypted when PII is set: the
+// values of Columns are sealed, and the blind index of EmailColumn is kept in
+// IndexColumn for lookups
+type PIITable struct {
+	Table       string
+	Columns     []string
+	EmailColumn string
+	IndexColumn string
+}
+
+// PIITables lists every table with encrypted personal data
+var PIITables = []PIITable{
+	{Table: "users", Columns: []string{"first_name", "last_name", "email"}, EmailColumn: "email", IndexColumn: "email_index"},
+	{Table: "customers", Columns: []string{"first_name", "last_name", "email"}, EmailColumn: "email", IndexColumn: "email_index"},
+	{Table: "support_tickets", Columns: []string{"user_name", "user_email"}, EmailColumn: "user_email", IndexColumn: "user_email_index"},
+}
+
+// CheckPlainEmail returns ErrDuplicateEmail when a row of table, users or
+// customers, other than id still stores email in plain text. Once PII is set,
+// the unique email_index tells encrypted rows apart, but rows written before
+// have no index until they are encrypted. No plain rows are written meanwhile,
+// so checking before writing is enough.
+func (m *DBModel) CheckPlainEmail(ctx context.Context, table, email string, id int) error {
+	if m.PII == nil {
+		return nil
+	}
+
+	var taken bool
+	query := `SELECT EXISTS(SELECT 1 FROM ` + table + ` WHERE email_index IS NULL AND LOWER(email) = LOWER($1) AND id <> $2)`
+	err := m.DB.QueryRowContext(ctx, query, email, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicateEmail
+	}
+	return nil
+}
+
+// EncryptPII encrypts the personal data of table stored in plain text, or
+// with a key that is no longer active, batchSize rows at a time, and returns
+// how many values it encrypted. The blind index of every row is written before
+// its email is encrypted, so rows can be looked up by email throughout.
+func (m *DBModel) EncryptPII(table PIITable, batchSize int) (int, error) {
+	if m.PII == nil {
+		return 0, pii.ErrNoCipher
+	}
+
+	query := fmt.Sprintf(`SELECT id, %[2]s FROM %[1]s WHERE id > $1 AND %[2]s <> '' AND %[3]s IS NULL ORDER BY id LIMIT $2`,
+		table.Table, table.EmailColumn, table.IndexColumn)
+	stmt := fmt.Sprintf(`UPDATE %[1]s SET %[3]s = $1 WHERE id = $2 AND %[2]s = $3`,
+		table.Table, table.EmailColumn, table.IndexColumn)
+	changed, err := m.updateInBatches(query, stmt, batchSize, func(email string) (interface{}, bool, error) {
+		email, err := m.PII.Open(email)
+		return m.PII.Index(email), err == nil, err
+	})
+	if err != nil {
+		return changed, fmt.Errorf("failed to index %s.%s: %w", table.Table, table.EmailColumn, err)
+	}
+
+	changed = 0
+	for _, column := range table.Columns {
+		n, err := m.ReencryptColumn(EncryptedColumn{Table: table.Table, Column: column}, batchSize, m.PII.Reseal)
+		changed += n
+		if err != nil {
+			return changed, fmt.Errorf("failed to encrypt %s.%s: %w", table.Table, column, err)
+		}
+	}
+	return changed, nil
+}
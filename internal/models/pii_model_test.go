@@ -0,0 +1,154 @@
+package models
+
+import (
+	"testing"
+	"time"
+	"usual_store/internal/keyring"
+	"usual_store/internal/pii"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPII(t *testing.T) *pii.Cipher {
+	keys, err := keyring.Parse("", "secret-key-of-exactly-32-bytes!!")
+	require.NoError(t, err)
+	cipher, err := pii.New(keys, []byte("blind-index-key-of-at-least-32-bytes"))
+	require.NoError(t, err)
+	return cipher
+}
+
+func TestDBModel_EncryptPII(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	cipher := testPII(t)
+	sealed, err := cipher.Seal("Jane Doe")
+	require.NoError(t, err)
+
+	mock.ExpectQuery("SELECT id, user_email FROM support_tickets WHERE id > \\$1 AND user_email <> '' AND user_email_index IS NULL").
+		WithArgs(0, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_email"}).AddRow(4, "Jane@Example.com"))
+	mock.ExpectExec("UPDATE support_tickets SET user_email_index = \\$1 WHERE id = \\$2 AND user_email = \\$3").
+		WithArgs(cipher.Index("jane@example.com"), 4, "Jane@Example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, user_email FROM support_tickets").
+		WithArgs(4, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_email"}))
+
+	// The name was sealed already, with the active key
+	mock.ExpectQuery("SELECT id, user_name FROM support_tickets").
+		WithArgs(0, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name"}).AddRow(4, sealed))
+	mock.ExpectQuery("SELECT id, user_name FROM support_tickets").
+		WithArgs(4, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name"}))
+	mock.ExpectQuery("SELECT id, user_email FROM support_tickets WHERE id > \\$1 AND user_email <> '' ORDER BY id").
+		WithArgs(0, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_email"}).AddRow(4, "Jane@Example.com"))
+	mock.ExpectExec("UPDATE support_tickets SET user_email").
+		WithArgs(sqlmock.AnyArg(), 4, "Jane@Example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, user_email FROM support_tickets").
+		WithArgs(4, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_email"}))
+
+	model := DBModel{DB: db, PII: cipher}
+	changed, err := model.EncryptPII(PIITables[2], 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = (&DBModel{DB: db}).EncryptPII(PIITables[2], 100)
+	assert.ErrorIs(t, err, pii.ErrNoCipher)
+}
+
+func TestDBModel_GetUserByEmail_Encrypted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	cipher := testPII(t)
+	firstName, err := cipher.Seal("Jane")
+	require.NoError(t, err)
+	email, err := cipher.Seal("jane@example.com")
+	require.NoError(t, err)
+
+	mock.ExpectQuery("FROM users WHERE email_index = \\$2").
+		WithArgs("jane@example.com", cipher.Index("Jane@example.com")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "role", "created_at", "updated_at", "status"}).
+			AddRow(3, firstName, "Doe", email, "hash", "user", time.Now(), time.Now(), UserStatusActive))
+
+	model := DBModel{DB: db, PII: cipher}
+	user, err := model.GetUserByEmail("jane@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", user.FirstName)
+	assert.Equal(t, "Doe", user.LastName)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_AddUser_PlainDuplicate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// A row not encrypted yet has no email_index for the unique index to compare
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE email_index IS NULL AND LOWER\\(email\\) = LOWER\\(\\$1\\) AND id <> \\$2\\)").
+		WithArgs("Jane@example.com", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	model := DBModel{DB: db, PII: testPII(t)}
+	err = model.AddUser(User{FirstName: "Jane", LastName: "Doe", Email: "Jane@example.com"}, "hash")
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Without PII, UNIQUE(email) is enough
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	err = (&DBModel{DB: db}).AddUser(User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"}, "hash")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetAllUsersPaginated_Encrypted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	cipher := testPII(t)
+	mock.ExpectQuery("WHERE CAST\\(id AS TEXT\\) LIKE \\$1 OR LOWER\\(role\\) LIKE \\$1 OR email_index = \\$2 OR\\s+\\(email_index IS NULL AND LOWER\\(email\\) = \\$3\\) ORDER BY CASE role .* LIMIT \\$4 OFFSET \\$5").
+		WithArgs("%jane@example.com%", cipher.Index("jane@example.com"), "jane@example.com", 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "role", "created_at", "updated_at", "status"}))
+
+	// Names and emails are not searched for in part nor sorted by
+	model := DBModel{DB: db, PII: cipher}
+	_, err = model.GetAllUsersPaginated(0, 10, "Jane@example.com", "email", "asc")
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_SearchOrders_Encrypted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	cipher := testPII(t)
+	model := DBModel{DB: db, PII: cipher}
+	for _, sortBy := range []string{"first_name", "last_name", "email"} {
+		_, err = model.SearchOrders(OrderFilter{SortBy: sortBy})
+		assert.ErrorIs(t, err, ErrSortEncrypted, sortBy)
+	}
+
+	mock.ExpectQuery("SELECT COUNT\\(o.id\\).*\\(c.email_index = \\$2 OR \\(c.email_index IS NULL AND LOWER\\(c.email\\) = \\$3\\)\\)").
+		WithArgs(false, cipher.Index("jane@example.com"), "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("ORDER BY o.created_at desc").
+		WithArgs(false, cipher.Index("jane@example.com"), "jane@example.com", 11).
+		WillReturnRows(sqlmock.NewRows(customerOrderColumns))
+
+	_, err = model.SearchOrders(OrderFilter{Query: "Jane@example.com", PageSize: 10})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
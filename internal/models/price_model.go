@@ -0,0 +1,461 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Price schedule statuses
+const (
+	PriceScheduleStatusPending   = "pending"
+	PriceScheduleStatusActive    = "active"
+	PriceScheduleStatusCompleted = "completed"
+	PriceScheduleStatusCancelled = "cancelled"
+)
+
+// Reasons recorded in the price history
+const (
+	PriceReasonManual        = "manual"
+	PriceReasonScheduled     = "scheduled"
+	PriceReasonScheduleEnded = "schedule_ended"
+)
+
+// ErrPriceScheduleOverlap is returned when a schedule overlaps another pending or active one for the same widget
+var ErrPriceScheduleOverlap = errors.New("price schedule overlaps an existing schedule for this widget")
+
+// PriceSchedule is a temporary or permanent price change planned for a widget.
+// A schedule without EndsAt keeps its price once applied.
+type PriceSchedule struct {
+	ID            int        `json:"id"`
+	WidgetID      int        `json:"widget_id"`
+	Price         int        `json:"price"`
+	StartsAt      time.Time  `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at,omitempty"`
+	PreviousPrice *int       `json:"previous_price,omitempty"`
+	Status        string     `json:"status"`
+	CreatedBy     *int       `json:"created_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// PriceHistoryEntry is a period during which a widget was sold at Price.
+// EffectiveTo is nil for the current price.
+type PriceHistoryEntry struct {
+	ID            int        `json:"id"`
+	WidgetID      int        `json:"widget_id"`
+	Price         int        `json:"price"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	Reason        string     `json:"reason"`
+	ScheduleID    *int       `json:"schedule_id,omitempty"`
+	ChangedBy     *int       `json:"changed_by,omitempty"`
+}
+
+// PriceChange describes a price applied or reverted by the scheduler.
+type PriceChange struct {
+	ScheduleID int
+	WidgetID   int
+	OldPrice   int
+	NewPrice   int
+}
+
+// SalePriceLine is an order together with the unit price in effect when it was placed.
+type SalePriceLine struct {
+	OrderID      int       `json:"order_id"`
+	WidgetID     int       `json:"widget_id"`
+	WidgetName   string    `json:"widget_name"`
+	Quantity     int       `json:"quantity"`
+	Amount       int       `json:"amount"`
+	PriceAtOrder *int      `json:"price_at_order"`
+	CurrentPrice int       `json:"current_price"`
+	StatusID     int       `json:"status_id"`
+	OrderedAt    time.Time `json:"ordered_at"`
+}
+
+// setWidgetPrice changes a widget's price inside tx, closing the open price
+// history period and starting a new one.
+func setWidgetPrice(ctx context.Context, tx *sql.Tx, widgetID, price int, reason string, scheduleID, changedBy *int) error {
+	now := time.Now()
+
+	_, err := tx.ExecContext(ctx, `UPDATE widgets SET price = $1, updated_at = $2 WHERE id = $3`, price, now, widgetID)
+	if err != nil {
+		return fmt.Errorf("failed to update widget price: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE widget_price_history SET effective_to = $1 WHERE widget_id = $2 AND effective_to IS NULL`,
+		now, widgetID)
+	if err != nil {
+		return fmt.Errorf("failed to close price history: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO widget_price_history (widget_id, price, effective_from, reason, schedule_id, changed_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		widgetID, price, now, reason, scheduleID, changedBy, now)
+	if err != nil {
+		return fmt.Errorf("failed to record price history: %w", err)
+	}
+	return nil
+}
+
+// CreatePriceSchedule plans a price change and returns its id.
+func (m *DBModel) CreatePriceSchedule(s PriceSchedule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Lock the widget so concurrent requests cannot create overlapping schedules
+	var widgetID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM widgets WHERE id = $1 FOR UPDATE`, s.WidgetID).Scan(&widgetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("widget not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var overlaps bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM price_schedules
+			WHERE widget_id = $1 AND status IN ('pending', 'active')
+			  AND starts_at < COALESCE($3::TIMESTAMP, 'infinity')
+			  AND COALESCE(ends_at, 'infinity') > $2)`,
+		s.WidgetID, s.StartsAt, s.EndsAt).Scan(&overlaps)
+	if err != nil {
+		return 0, err
+	}
+	if overlaps {
+		return 0, ErrPriceScheduleOverlap
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO price_schedules (widget_id, price, starts_at, ends_at, status, created_by, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING id`,
+		s.WidgetID, s.Price, s.StartsAt, s.EndsAt, PriceScheduleStatusPending, s.CreatedBy, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create price schedule: %w", err)
+	}
+
+	return id, tx.Commit()
+}
+
+// GetPriceSchedulesForWidget returns the schedules of a widget, most recent start first.
+func (m *DBModel) GetPriceSchedulesForWidget(widgetID int) ([]*PriceSchedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, widget_id, price, starts_at, ends_at, previous_price, status, created_by, created_at, updated_at
+			  FROM price_schedules
+			  WHERE widget_id = $1
+			  ORDER BY starts_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, widgetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*PriceSchedule
+	for rows.Next() {
+		var s PriceSchedule
+		err = rows.Scan(
+			&s.ID,
+			&s.WidgetID,
+			&s.Price,
+			&s.StartsAt,
+			&s.EndsAt,
+			&s.PreviousPrice,
+			&s.Status,
+			&s.CreatedBy,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// CancelPriceSchedule cancels a schedule that has not been applied yet.
+func (m *DBModel) CancelPriceSchedule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE price_schedules SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		PriceScheduleStatusCancelled, time.Now(), id, PriceScheduleStatusPending)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("no pending price schedule found with ID %d", id)
+	}
+	return nil
+}
+
+// ApplyDuePriceSchedules applies every pending schedule whose start time has
+// passed. Each schedule is applied in its own transaction together with the
+// widget price and the price history, so it either takes effect completely or
+// not at all. Rows locked by another instance of the scheduler are skipped.
+func (m *DBModel) ApplyDuePriceSchedules(now time.Time) ([]PriceChange, error) {
+	var changes []PriceChange
+	for {
+		change, ok, err := m.applyNextDueSchedule(now)
+		if err != nil {
+			return changes, err
+		}
+		if !ok {
+			return changes, nil
+		}
+		changes = append(changes, change)
+	}
+}
+
+func (m *DBModel) applyNextDueSchedule(now time.Time) (PriceChange, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var change PriceChange
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, widget_id, price FROM price_schedules
+		 WHERE status = $1 AND starts_at <= $2
+		 ORDER BY starts_at ASC, id ASC
+		 LIMIT 1
+		 FOR UPDATE SKIP LOCKED`,
+		PriceScheduleStatusPending, now).Scan(&change.ScheduleID, &change.WidgetID, &change.NewPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PriceChange{}, false, nil
+	}
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT price FROM widgets WHERE id = $1 FOR UPDATE`, change.WidgetID).Scan(&change.OldPrice)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE price_schedules SET status = $1, previous_price = $2, updated_at = $3 WHERE id = $4`,
+		PriceScheduleStatusActive, change.OldPrice, time.Now(), change.ScheduleID)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	err = setWidgetPrice(ctx, tx, change.WidgetID, change.NewPrice, PriceReasonScheduled, &change.ScheduleID, nil)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return PriceChange{}, false, err
+	}
+	return change, true, nil
+}
+
+// RevertExpiredPriceSchedules ends every active schedule whose end time has
+// passed and restores the price the widget had before it. If the price was
+// changed by hand while the schedule was active, the manual price is kept.
+func (m *DBModel) RevertExpiredPriceSchedules(now time.Time) ([]PriceChange, error) {
+	var changes []PriceChange
+	for {
+		change, ok, err := m.revertNextExpiredSchedule(now)
+		if err != nil {
+			return changes, err
+		}
+		if !ok {
+			return changes, nil
+		}
+		if change.OldPrice != change.NewPrice {
+			changes = append(changes, change)
+		}
+	}
+}
+
+func (m *DBModel) revertNextExpiredSchedule(now time.Time) (PriceChange, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var change PriceChange
+	var schedulePrice, previousPrice int
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, widget_id, price, previous_price FROM price_schedules
+		 WHERE status = $1 AND ends_at <= $2
+		 ORDER BY ends_at ASC, id ASC
+		 LIMIT 1
+		 FOR UPDATE SKIP LOCKED`,
+		PriceScheduleStatusActive, now).Scan(&change.ScheduleID, &change.WidgetID, &schedulePrice, &previousPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PriceChange{}, false, nil
+	}
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT price FROM widgets WHERE id = $1 FOR UPDATE`, change.WidgetID).Scan(&change.OldPrice)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+	change.NewPrice = change.OldPrice
+
+	if change.OldPrice == schedulePrice {
+		change.NewPrice = previousPrice
+		err = setWidgetPrice(ctx, tx, change.WidgetID, previousPrice, PriceReasonScheduleEnded, &change.ScheduleID, nil)
+		if err != nil {
+			return PriceChange{}, false, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE price_schedules SET status = $1, updated_at = $2 WHERE id = $3`,
+		PriceScheduleStatusCompleted, time.Now(), change.ScheduleID)
+	if err != nil {
+		return PriceChange{}, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return PriceChange{}, false, err
+	}
+	return change, true, nil
+}
+
+// GetPriceHistory returns the price periods of a widget, newest first.
+func (m *DBModel) GetPriceHistory(widgetID int) ([]*PriceHistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, widget_id, price, effective_from, effective_to, reason, schedule_id, changed_by
+			  FROM widget_price_history
+			  WHERE widget_id = $1
+			  ORDER BY effective_from DESC, id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, widgetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*PriceHistoryEntry
+	for rows.Next() {
+		var h PriceHistoryEntry
+		err = rows.Scan(
+			&h.ID,
+			&h.WidgetID,
+			&h.Price,
+			&h.EffectiveFrom,
+			&h.EffectiveTo,
+			&h.Reason,
+			&h.ScheduleID,
+			&h.ChangedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetPriceAt returns the price of a widget at the given moment, e.g. when an order was placed.
+func (m *DBModel) GetPriceAt(ctx context.Context, widgetID int, at time.Time) (int, error) {
+	query := `SELECT price FROM widget_price_history
+			  WHERE widget_id = $1 AND effective_from <= $2 AND (effective_to IS NULL OR effective_to > $2)
+			  ORDER BY effective_from DESC
+			  LIMIT 1`
+
+	var price int
+	err := m.DB.QueryRowContext(ctx, query, widgetID, at).Scan(&price)
+	if err != nil {
+		return 0, fmt.Errorf("no price recorded for widget %d at %s: %w", widgetID, at.Format(time.RFC3339), err)
+	}
+	return price, nil
+}
+
+// GetSalesWithPrices returns the orders placed in [from, to) together with the
+// unit price that was in effect at the time of each order.
+func (m *DBModel) GetSalesWithPrices(from, to time.Time) ([]*SalePriceLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT o.id, o.widget_id, w.name, o.quantity, o.amount, h.price, w.price, o.status_id, o.created_at
+			  FROM orders o
+			  	INNER JOIN widgets w ON (o.widget_id = w.id)
+			  	LEFT JOIN widget_price_history h ON (
+			  		h.widget_id = o.widget_id
+			  		AND h.effective_from <= o.created_at
+			  		AND (h.effective_to IS NULL OR h.effective_to > o.created_at))
+			  WHERE o.created_at >= $1 AND o.created_at < $2
+			  ORDER BY o.created_at ASC, o.id ASC`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*SalePriceLine
+	for rows.Next() {
+		var l SalePriceLine
+		err = rows.Scan(
+			&l.OrderID,
+			&l.WidgetID,
+			&l.WidgetName,
+			&l.Quantity,
+			&l.Amount,
+			&l.PriceAtOrder,
+			&l.CurrentPrice,
+			&l.StatusID,
+			&l.OrderedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, &l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
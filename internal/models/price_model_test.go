@@ -0,0 +1,175 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectSetWidgetPrice expects the statements issued by setWidgetPrice
+func expectSetWidgetPrice(mock sqlmock.Sqlmock, widgetID, price int, reason string) {
+	mock.ExpectExec("UPDATE widgets SET price = \\$1").
+		WithArgs(price, sqlmock.AnyArg(), widgetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE widget_price_history SET effective_to").
+		WithArgs(sqlmock.AnyArg(), widgetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO widget_price_history").
+		WithArgs(widgetID, price, sqlmock.AnyArg(), reason, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestDBModel_ApplyDuePriceSchedules(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, widget_id, price FROM price_schedules").
+		WithArgs(PriceScheduleStatusPending, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "widget_id", "price"}).AddRow(7, 1, 1900))
+	mock.ExpectQuery("SELECT price FROM widgets WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(2500))
+	mock.ExpectExec("UPDATE price_schedules SET status = \\$1, previous_price = \\$2").
+		WithArgs(PriceScheduleStatusActive, 2500, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSetWidgetPrice(mock, 1, 1900, PriceReasonScheduled)
+	mock.ExpectCommit()
+
+	// No more due schedules
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, widget_id, price FROM price_schedules").
+		WithArgs(PriceScheduleStatusPending, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "widget_id", "price"}))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	changes, err := model.ApplyDuePriceSchedules(now)
+	require.NoError(t, err)
+	assert.Equal(t, []PriceChange{{ScheduleID: 7, WidgetID: 1, OldPrice: 2500, NewPrice: 1900}}, changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_RevertExpiredPriceSchedules(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentPrice int
+		wantRevert   bool
+		wantChanges  []PriceChange
+	}{
+		{
+			name:         "scheduled price restored",
+			currentPrice: 1900,
+			wantRevert:   true,
+			wantChanges:  []PriceChange{{ScheduleID: 7, WidgetID: 1, OldPrice: 1900, NewPrice: 2500}},
+		},
+		{
+			name:         "manual change during schedule kept",
+			currentPrice: 2200,
+			wantRevert:   false,
+			wantChanges:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			now := time.Now()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, widget_id, price, previous_price FROM price_schedules").
+				WithArgs(PriceScheduleStatusActive, now).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "widget_id", "price", "previous_price"}).AddRow(7, 1, 1900, 2500))
+			mock.ExpectQuery("SELECT price FROM widgets WHERE id = \\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(tt.currentPrice))
+			if tt.wantRevert {
+				expectSetWidgetPrice(mock, 1, 2500, PriceReasonScheduleEnded)
+			}
+			mock.ExpectExec("UPDATE price_schedules SET status = \\$1").
+				WithArgs(PriceScheduleStatusCompleted, sqlmock.AnyArg(), 7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, widget_id, price, previous_price FROM price_schedules").
+				WithArgs(PriceScheduleStatusActive, now).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "widget_id", "price", "previous_price"}))
+			mock.ExpectRollback()
+
+			model := DBModel{DB: db}
+			changes, err := model.RevertExpiredPriceSchedules(now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanges, changes)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_CreatePriceSchedule_Overlap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	starts := time.Now().Add(time.Hour)
+	ends := starts.Add(48 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM widgets WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(1, starts, &ends).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	_, err = model.CreatePriceSchedule(PriceSchedule{WidgetID: 1, Price: 1900, StartsAt: starts, EndsAt: &ends})
+	assert.ErrorIs(t, err, ErrPriceScheduleOverlap)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_UpdateWidget_RecordsPriceChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentPrice int
+		newPrice     int
+	}{
+		{"price changed", 2500, 2000},
+		{"price unchanged", 2500, 2500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT price, inventory_level FROM widgets WHERE id = \\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"price", "inventory_level"}).AddRow(tt.currentPrice, 3))
+			mock.ExpectExec("UPDATE widgets").
+				WithArgs("Widget", "desc", "widget.png", sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			if tt.newPrice != tt.currentPrice {
+				expectSetWidgetPrice(mock, 1, tt.newPrice, PriceReasonManual)
+			}
+			mock.ExpectCommit()
+
+			model := DBModel{DB: db}
+			err = model.UpdateWidget(Widget{ID: 1, Name: "Widget", Description: "desc", InventoryLevel: 3, Price: tt.newPrice, Image: "widget.png"}, 9)
+			require.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
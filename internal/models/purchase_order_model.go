@@ -0,0 +1,411 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Purchase order statuses
+const (
+	PurchaseOrderStatusDraft             = "draft"
+	PurchaseOrderStatusOrdered           = "ordered"
+	PurchaseOrderStatusPartiallyReceived = "partially_received"
+	PurchaseOrderStatusReceived          = "received"
+	PurchaseOrderStatusCancelled         = "cancelled"
+)
+
+// ErrPurchaseOrderNotFound is returned when a purchase order does not exist
+var ErrPurchaseOrderNotFound = errors.New("purchase order not found")
+
+// Supplier is a company widgets are restocked from.
+type Supplier struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	Phone        string    `json:"phone"`
+	LeadTimeDays int       `json:"lead_time_days"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PurchaseOrder is an order of widgets from a supplier.
+type PurchaseOrder struct {
+	ID           int                  `json:"id"`
+	SupplierID   int                  `json:"supplier_id"`
+	SupplierName string               `json:"supplier_name"`
+	Status       string               `json:"status"`
+	Notes        string               `json:"notes"`
+	CreatedBy    *int                 `json:"created_by,omitempty"`
+	OrderedAt    *time.Time           `json:"ordered_at,omitempty"`
+	ReceivedAt   *time.Time           `json:"received_at,omitempty"`
+	Items        []*PurchaseOrderItem `json:"items"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+}
+
+// PurchaseOrderItem is a widget line of a purchase order.
+type PurchaseOrderItem struct {
+	ID               int    `json:"id"`
+	PurchaseOrderID  int    `json:"purchase_order_id"`
+	WidgetID         int    `json:"widget_id"`
+	WidgetName       string `json:"widget_name"`
+	QuantityOrdered  int    `json:"quantity_ordered"`
+	QuantityReceived int    `json:"quantity_received"`
+	UnitCost         int    `json:"unit_cost"`
+}
+
+// ReceiptLine is a quantity of a widget delivered against a purchase order.
+type ReceiptLine struct {
+	WidgetID int `json:"widget_id"`
+	Quantity int `json:"quantity"`
+}
+
+// InsertSupplier creates a supplier and returns its id.
+func (m *DBModel) InsertSupplier(s Supplier) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO suppliers (name, email, phone, lead_time_days, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 RETURNING id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt, s.Name, s.Email, s.Phone, s.LeadTimeDays, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert supplier: %w", err)
+	}
+	return id, nil
+}
+
+// GetAllSuppliers returns every supplier ordered by name.
+func (m *DBModel) GetAllSuppliers() ([]*Supplier, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, name, email, phone, lead_time_days, created_at, updated_at FROM suppliers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppliers []*Supplier
+	for rows.Next() {
+		var s Supplier
+		err = rows.Scan(&s.ID, &s.Name, &s.Email, &s.Phone, &s.LeadTimeDays, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suppliers, nil
+}
+
+// CreatePurchaseOrder creates a draft purchase order with its items and returns its id.
+func (m *DBModel) CreatePurchaseOrder(po PurchaseOrder) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	id, err := createPurchaseOrder(ctx, tx, po)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func createPurchaseOrder(ctx context.Context, tx *sql.Tx, po PurchaseOrder) (int, error) {
+	now := time.Now()
+
+	var id int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO purchase_orders (supplier_id, status, notes, created_by, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id`,
+		po.SupplierID, PurchaseOrderStatusDraft, po.Notes, po.CreatedBy, now, now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create purchase order: %w", err)
+	}
+
+	for _, item := range po.Items {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO purchase_order_items (purchase_order_id, widget_id, quantity_ordered, unit_cost)
+			 VALUES ($1, $2, $3, $4)`,
+			id, item.WidgetID, item.QuantityOrdered, item.UnitCost)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add purchase order item: %w", err)
+		}
+	}
+	return id, nil
+}
+
+// GetPurchaseOrders returns purchase orders, optionally filtered by status, newest first.
+func (m *DBModel) GetPurchaseOrders(status string) ([]*PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT p.id, p.supplier_id, s.name, p.status, p.notes, p.created_by, p.ordered_at, p.received_at,
+					 p.created_at, p.updated_at
+			  FROM purchase_orders p
+			  	INNER JOIN suppliers s ON (p.supplier_id = s.id)
+			  WHERE $1 = '' OR p.status = $1
+			  ORDER BY p.created_at DESC, p.id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*PurchaseOrder
+	for rows.Next() {
+		var po PurchaseOrder
+		err = scanPurchaseOrder(rows, &po)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &po)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetPurchaseOrder returns a purchase order with its items.
+func (m *DBModel) GetPurchaseOrder(id int) (PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var po PurchaseOrder
+	row := m.DB.QueryRowContext(ctx,
+		`SELECT p.id, p.supplier_id, s.name, p.status, p.notes, p.created_by, p.ordered_at, p.received_at,
+				p.created_at, p.updated_at
+		 FROM purchase_orders p
+		 	INNER JOIN suppliers s ON (p.supplier_id = s.id)
+		 WHERE p.id = $1`, id)
+	err := scanPurchaseOrder(row, &po)
+	if errors.Is(err, sql.ErrNoRows) {
+		return po, ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		return po, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT i.id, i.purchase_order_id, i.widget_id, w.name, i.quantity_ordered, i.quantity_received, i.unit_cost
+		 FROM purchase_order_items i
+		 	INNER JOIN widgets w ON (i.widget_id = w.id)
+		 WHERE i.purchase_order_id = $1
+		 ORDER BY i.id`, id)
+	if err != nil {
+		return po, err
+	}
+	defer rows.Close()
+
+	po.Items = []*PurchaseOrderItem{}
+	for rows.Next() {
+		var item PurchaseOrderItem
+		err = rows.Scan(
+			&item.ID,
+			&item.PurchaseOrderID,
+			&item.WidgetID,
+			&item.WidgetName,
+			&item.QuantityOrdered,
+			&item.QuantityReceived,
+			&item.UnitCost,
+		)
+		if err != nil {
+			return po, err
+		}
+		po.Items = append(po.Items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return po, err
+	}
+	return po, nil
+}
+
+func scanPurchaseOrder(row rowScanner, po *PurchaseOrder) error {
+	return row.Scan(
+		&po.ID,
+		&po.SupplierID,
+		&po.SupplierName,
+		&po.Status,
+		&po.Notes,
+		&po.CreatedBy,
+		&po.OrderedAt,
+		&po.ReceivedAt,
+		&po.CreatedAt,
+		&po.UpdatedAt,
+	)
+}
+
+// MarkPurchaseOrderOrdered records that a draft purchase order was sent to the supplier.
+func (m *DBModel) MarkPurchaseOrderOrdered(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE purchase_orders SET status = $1, ordered_at = $2, updated_at = $2 WHERE id = $3 AND status = $4`,
+		PurchaseOrderStatusOrdered, time.Now(), id, PurchaseOrderStatusDraft)
+	if err != nil {
+		return err
+	}
+	return purchaseOrderTransition(result, id, "only draft purchase orders can be ordered")
+}
+
+// CancelPurchaseOrder cancels a purchase order nothing has been received for.
+func (m *DBModel) CancelPurchaseOrder(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE purchase_orders SET status = $1, updated_at = $2 WHERE id = $3 AND status IN ($4, $5)`,
+		PurchaseOrderStatusCancelled, time.Now(), id, PurchaseOrderStatusDraft, PurchaseOrderStatusOrdered)
+	if err != nil {
+		return err
+	}
+	return purchaseOrderTransition(result, id, "only draft or ordered purchase orders can be cancelled")
+}
+
+func purchaseOrderTransition(result sql.Result, id int, message string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("purchase order %d: %s", id, message)
+	}
+	return nil
+}
+
+// ReceivePurchaseOrder books a delivery against an ordered purchase order. Each
+// line increases the widget's inventory and is recorded as a stock movement.
+// Without lines, everything still outstanding is received. The order becomes
+// received once every item is complete, and partially received otherwise.
+func (m *DBModel) ReceivePurchaseOrder(id int, lines []ReceiptLine, receivedBy int) ([]StockLevelChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM purchase_orders WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != PurchaseOrderStatusOrdered && status != PurchaseOrderStatusPartiallyReceived {
+		return nil, fmt.Errorf("purchase order %d is %s and cannot be received", id, status)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT widget_id, quantity_ordered, quantity_received FROM purchase_order_items WHERE purchase_order_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	outstanding := make(map[int]int)
+	var widgetIDs []int
+	for rows.Next() {
+		var widgetID, ordered, received int
+		if err = rows.Scan(&widgetID, &ordered, &received); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		outstanding[widgetID] = ordered - received
+		widgetIDs = append(widgetIDs, widgetID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		for _, widgetID := range widgetIDs {
+			if outstanding[widgetID] > 0 {
+				lines = append(lines, ReceiptLine{WidgetID: widgetID, Quantity: outstanding[widgetID]})
+			}
+		}
+	}
+
+	var changes []StockLevelChange
+	for _, line := range lines {
+		remaining, ok := outstanding[line.WidgetID]
+		if !ok {
+			return nil, fmt.Errorf("widget %d is not on purchase order %d", line.WidgetID, id)
+		}
+		if line.Quantity <= 0 || line.Quantity > remaining {
+			return nil, fmt.Errorf("quantity for widget %d must be between 1 and %d", line.WidgetID, remaining)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE purchase_order_items SET quantity_received = quantity_received + $1
+			 WHERE purchase_order_id = $2 AND widget_id = $3`,
+			line.Quantity, id, line.WidgetID)
+		if err != nil {
+			return nil, err
+		}
+		outstanding[line.WidgetID] = remaining - line.Quantity
+
+		poID := id
+		change, err := adjustInventory(ctx, tx, StockAdjustment{
+			WidgetID:      line.WidgetID,
+			Change:        line.Quantity,
+			Reason:        StockReasonPurchaseOrderReceipt,
+			ReferenceType: "purchase_order",
+			ReferenceID:   &poID,
+			CreatedBy:     &receivedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	status = PurchaseOrderStatusReceived
+	for _, remaining := range outstanding {
+		if remaining > 0 {
+			status = PurchaseOrderStatusPartiallyReceived
+			break
+		}
+	}
+
+	now := time.Now()
+	var receivedAt *time.Time
+	if status == PurchaseOrderStatusReceived {
+		receivedAt = &now
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE purchase_orders SET status = $1, received_at = $2, updated_at = $3 WHERE id = $4`,
+		status, receivedAt, now, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,503 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Return statuses
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunding = "refunding"
+	ReturnStatusCompleted = "completed"
+)
+
+// Return outcomes
+const (
+	ReturnOutcomeRefund      = "refund"
+	ReturnOutcomeStoreCredit = "store_credit"
+	ReturnOutcomeReplacement = "replacement"
+)
+
+// ReturnReasons are the reasons a customer can give for a return
+var ReturnReasons = map[string]bool{
+	"damaged":          true,
+	"defective":        true,
+	"wrong_item":       true,
+	"not_as_described": true,
+	"no_longer_needed": true,
+	"other":            true,
+}
+
+// ErrReturnNotFound is returned when a return does not exist
+var ErrReturnNotFound = errors.New("return not found")
+
+// ErrReturnNotReceived is returned when a return is not waiting to be completed,
+// or is being refunded by someone else
+var ErrReturnNotReceived = errors.New("only received returns can be completed")
+
+// Return is a return merchandise authorisation for items of an order.
+type Return struct {
+	ID                 int        `json:"id"`
+	OrderID            int        `json:"order_id"`
+	Quantity           int        `json:"quantity"`
+	Reason             string     `json:"reason"`
+	Note               string     `json:"note"`
+	Status             string     `json:"status"`
+	Outcome            string     `json:"outcome,omitempty"`
+	RefundAmount       int        `json:"refund_amount"`
+	CreditAmount       int        `json:"credit_amount"`
+	ReplacementOrderID *int       `json:"replacement_order_id,omitempty"`
+	DecisionNote       string     `json:"decision_note"`
+	RequestedBy        *int       `json:"requested_by,omitempty"`
+	HandledBy          *int       `json:"handled_by,omitempty"`
+	DecidedAt          *time.Time `json:"decided_at,omitempty"`
+	ReceivedAt         *time.Time `json:"received_at,omitempty"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+
+	OrderQuantity     int    `json:"order_quantity"`
+	OrderAmount       int    `json:"order_amount"`
+	ShippingAmount    int    `json:"shipping_amount"`
+	WidgetID          int    `json:"widget_id"`
+	WidgetName        string `json:"widget_name"`
+	CustomerID        int    `json:"customer_id"`
+	CustomerEmail     string `json:"customer_email"`
+	CustomerFirstName string `json:"customer_first_name"`
+	PaymentIntent     string `json:"-"`
+	Currency          string `json:"currency"`
+}
+
+// Value is the share of the order amount, without shipping, paid for the returned items
+func (r Return) Value() int {
+	if r.OrderQuantity == 0 {
+		return 0
+	}
+	return (r.OrderAmount - r.ShippingAmount) * r.Quantity / r.OrderQuantity
+}
+
+// ReturnCompletion is how a received return is settled
+type ReturnCompletion struct {
+	Outcome      string
+	RefundAmount int
+	CreditAmount int
+	HandledBy    int
+}
+
+const returnSelect = `
+	SELECT r.id, r.order_id, r.quantity, r.reason, r.note, r.status, COALESCE(r.outcome, ''),
+		r.refund_amount, r.credit_amount, r.replacement_order_id, r.decision_note,
+		r.requested_by, r.handled_by, r.decided_at, r.received_at, r.completed_at, r.created_at, r.updated_at,
+		o.quantity, o.amount, o.shipping_amount, o.widget_id, w.name, o.customer_id, c.email, c.first_name,
+		COALESCE(t.payment_intent, ''), COALESCE(t.currency, '')
+	FROM returns r
+	JOIN orders o ON (o.id = r.order_id)
+	JOIN widgets w ON (w.id = o.widget_id)
+	JOIN customers c ON (c.id = o.customer_id)
+	LEFT JOIN transactions t ON (t.id = o.transaction_id)`
+
+func (m *DBModel) scanReturn(row rowScanner, r *Return) error {
+	return row.Scan(
+		&r.ID, &r.OrderID, &r.Quantity, &r.Reason, &r.Note, &r.Status, &r.Outcome,
+		&r.RefundAmount, &r.CreditAmount, &r.ReplacementOrderID, &r.DecisionNote,
+		&r.RequestedBy, &r.HandledBy, &r.DecidedAt, &r.ReceivedAt, &r.CompletedAt, &r.CreatedAt, &r.UpdatedAt,
+		&r.OrderQuantity, &r.OrderAmount, &r.ShippingAmount, &r.WidgetID, &r.WidgetName,
+		&r.CustomerID, m.PII.Field(&r.CustomerEmail), m.PII.Field(&r.CustomerFirstName), &r.PaymentIntent, &r.Currency,
+	)
+}
+
+// CreateReturn requests the return of items of an order. The quantity is
+// limited to the items of the order not already being returned.
+func (m *DBModel) CreateReturn(ret Return) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var quantity, statusID int
+	var isRecurring bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT o.quantity, o.status_id, w.is_recurring
+		 FROM orders o JOIN widgets w ON (w.id = o.widget_id)
+		 WHERE o.id = $1 FOR UPDATE OF o`,
+		ret.OrderID).Scan(&quantity, &statusID, &isRecurring)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrOrderNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if isRecurring {
+		return 0, errors.New("subscriptions cannot be returned")
+	}
+	if statusID == OrderStatusRefunded || statusID == OrderStatusCancelled {
+		return 0, errors.New("order was refunded or cancelled")
+	}
+
+	var returned int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(quantity), 0) FROM returns WHERE order_id = $1 AND status <> $2`,
+		ret.OrderID, ReturnStatusRejected).Scan(&returned)
+	if err != nil {
+		return 0, err
+	}
+	if ret.Quantity > quantity-returned {
+		return 0, fmt.Errorf("only %d items of this order can still be returned", quantity-returned)
+	}
+
+	now := time.Now()
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO returns (order_id, quantity, reason, note, status, requested_by, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		 RETURNING id`,
+		ret.OrderID, ret.Quantity, ret.Reason, ret.Note, ReturnStatusRequested, ret.RequestedBy, now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert return: %w", err)
+	}
+
+	err = setOrderStatus(ctx, tx, ret.OrderID, OrderStatusReturnRequested, now)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func setOrderStatus(ctx context.Context, tx *sql.Tx, orderID, statusID int, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `UPDATE orders SET status_id = $1, updated_at = $2 WHERE id = $3`,
+		statusID, now, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+	return nil
+}
+
+// GetReturn returns one return
+func (m *DBModel) GetReturn(id int) (Return, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var r Return
+	err := m.scanReturn(m.DB.QueryRowContext(ctx, returnSelect+` WHERE r.id = $1`, id), &r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrReturnNotFound
+	}
+	return r, err
+}
+
+// GetReturns returns the returns with status, or all returns when status is empty, newest first
+func (m *DBModel) GetReturns(status string) ([]*Return, error) {
+	query := returnSelect
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE r.status = $1`
+		args = append(args, status)
+	}
+	return m.queryReturns(query+` ORDER BY r.created_at DESC, r.id DESC`, args...)
+}
+
+// GetReturnsForCustomer returns the returns of the customer with email, newest first
+func (m *DBModel) GetReturnsForCustomer(email string) ([]*Return, error) {
+	return m.queryReturns(returnSelect+` WHERE c.email_index = $2 OR LOWER(c.email) = LOWER($1) ORDER BY r.created_at DESC, r.id DESC`,
+		email, m.PII.Index(email))
+}
+
+func (m *DBModel) queryReturns(query string, args ...interface{}) ([]*Return, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := []*Return{}
+	for rows.Next() {
+		var r Return
+		if err = m.scanReturn(rows, &r); err != nil {
+			return nil, err
+		}
+		returns = append(returns, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return returns, nil
+}
+
+// ApproveReturn approves a requested return
+func (m *DBModel) ApproveReturn(id, handledBy int, note string) error {
+	return m.decideReturn(id, handledBy, note, ReturnStatusApproved)
+}
+
+// RejectReturn rejects a requested return. The order goes back to the state
+// it had before the return was requested.
+func (m *DBModel) RejectReturn(id, handledBy int, note string) error {
+	return m.decideReturn(id, handledBy, note, ReturnStatusRejected)
+}
+
+func (m *DBModel) decideReturn(id, handledBy int, note, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	now := time.Now()
+	var orderID int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE returns SET status = $1, handled_by = $2, decision_note = $3, decided_at = $4, updated_at = $4
+		 WHERE id = $5 AND status = $6
+		 RETURNING order_id`,
+		status, handledBy, note, now, id, ReturnStatusRequested).Scan(&orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("return %d: only requested returns can be %s", id, status)
+	}
+	if err != nil {
+		return err
+	}
+
+	orderStatus := OrderStatusReturnApproved
+	if status == ReturnStatusRejected {
+		orderStatus = OrderStatusCleared
+		var completed bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS(SELECT 1 FROM returns WHERE order_id = $1 AND status = $2)`,
+			orderID, ReturnStatusCompleted).Scan(&completed)
+		if err != nil {
+			return err
+		}
+		if completed {
+			orderStatus = OrderStatusReturned
+		}
+	}
+
+	if err = setOrderStatus(ctx, tx, orderID, orderStatus, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReceiveReturn records that the items of an approved return arrived
+func (m *DBModel) ReceiveReturn(id, handledBy int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	now := time.Now()
+	var orderID int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE returns SET status = $1, handled_by = $2, received_at = $3, updated_at = $3
+		 WHERE id = $4 AND status = $5
+		 RETURNING order_id`,
+		ReturnStatusReceived, handledBy, now, id, ReturnStatusApproved).Scan(&orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("return %d: only approved returns can be received", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = setOrderStatus(ctx, tx, orderID, OrderStatusReturnReceived, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimReturnRefund moves a received return to refunding before the caller
+// refunds it, so that only one caller does. It returns ErrReturnNotReceived
+// when the return is not received.
+func (m *DBModel) ClaimReturnRefund(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx,
+		`UPDATE returns SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		ReturnStatusRefunding, time.Now(), id, ReturnStatusReceived)
+	if err != nil {
+		return err
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if claimed == 0 {
+		return ErrReturnNotReceived
+	}
+	return nil
+}
+
+// ReleaseReturnRefund moves a return claimed by ClaimReturnRefund back to
+// received, once its refund failed
+func (m *DBModel) ReleaseReturnRefund(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE returns SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		ReturnStatusReceived, time.Now(), id, ReturnStatusRefunding)
+	return err
+}
+
+// CompleteReturn settles a received return: the items go back into inventory
+// and the customer gets store credit or a replacement order. Refunds are
+// issued by the caller before completing the return, between
+// ClaimReturnRefund and CompleteReturn. The order becomes refunded once all
+// its items were refunded, and returned otherwise.
+func (m *DBModel) CompleteReturn(id int, c ReturnCompletion) ([]StockLevelChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var orderID, quantity, widgetID, customerID, orderQuantity int
+	var transactionID *int
+	var status string
+	err = tx.QueryRowContext(ctx,
+		`SELECT r.order_id, r.quantity, r.status, o.widget_id, o.customer_id, o.transaction_id, o.quantity
+		 FROM returns r JOIN orders o ON (o.id = r.order_id)
+		 WHERE r.id = $1 FOR UPDATE`,
+		id).Scan(&orderID, &quantity, &status, &widgetID, &customerID, &transactionID, &orderQuantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReturnNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	want := ReturnStatusReceived
+	if c.Outcome == ReturnOutcomeRefund {
+		want = ReturnStatusRefunding
+	}
+	if status != want {
+		return nil, fmt.Errorf("return %d: %w", id, ErrReturnNotReceived)
+	}
+
+	handledBy := c.HandledBy
+	change, err := adjustInventory(ctx, tx, StockAdjustment{
+		WidgetID:      widgetID,
+		Change:        quantity,
+		Reason:        StockReasonReturn,
+		ReferenceType: "return",
+		ReferenceID:   &id,
+		CreatedBy:     &handledBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	changes := []StockLevelChange{change}
+
+	now := time.Now()
+	var replacementOrderID *int
+	switch c.Outcome {
+	case ReturnOutcomeRefund:
+	case ReturnOutcomeStoreCredit:
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO store_credits (customer_id, amount, reason, return_id, created_at) VALUES ($1, $2, $3, $4, $5)`,
+			customerID, c.CreditAmount, fmt.Sprintf("Return %d of order %d", id, orderID), id, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue store credit: %w", err)
+		}
+	case ReturnOutcomeReplacement:
+		var replacementID int
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO orders (widget_id, transaction_id, status_id, quantity, customer_id, amount, shipping_amount, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, 0, 0, $6, $6)
+			 RETURNING id`,
+			widgetID, transactionID, OrderStatusCleared, quantity, customerID, now).Scan(&replacementID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create replacement order: %w", err)
+		}
+		replacementOrderID = &replacementID
+
+		change, err = adjustInventory(ctx, tx, StockAdjustment{
+			WidgetID:      widgetID,
+			Change:        -quantity,
+			Reason:        StockReasonSale,
+			ReferenceType: "order",
+			ReferenceID:   &replacementID,
+			CreatedBy:     &handledBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	default:
+		return nil, fmt.Errorf("unknown return outcome %q", c.Outcome)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE returns
+		 SET status = $1, outcome = $2, refund_amount = $3, credit_amount = $4, replacement_order_id = $5,
+		     handled_by = $6, completed_at = $7, updated_at = $7
+		 WHERE id = $8`,
+		ReturnStatusCompleted, c.Outcome, c.RefundAmount, c.CreditAmount, replacementOrderID, c.HandledBy, now, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete return: %w", err)
+	}
+
+	var refunded int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(quantity), 0) FROM returns WHERE order_id = $1 AND status = $2 AND outcome = $3`,
+		orderID, ReturnStatusCompleted, ReturnOutcomeRefund).Scan(&refunded)
+	if err != nil {
+		return nil, err
+	}
+	orderStatus := OrderStatusReturned
+	if refunded >= orderQuantity {
+		orderStatus = OrderStatusRefunded
+	}
+	if err = setOrderStatus(ctx, tx, orderID, orderStatus, now); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetStoreCreditBalance returns the store credit of the customer with email, in cents
+func (m *DBModel) GetStoreCreditBalance(email string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var balance int
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(s.amount), 0)
+		 FROM store_credits s JOIN customers c ON (c.id = s.customer_id)
+		 WHERE c.email_index = $2 OR LOWER(c.email) = LOWER($1)`,
+		email, m.PII.Index(email)).Scan(&balance)
+	return balance, err
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReturn_Value(t *testing.T) {
+	r := Return{Quantity: 1, OrderQuantity: 3, OrderAmount: 3500, ShippingAmount: 500}
+	assert.Equal(t, 1000, r.Value())
+	assert.Equal(t, 0, Return{}.Value())
+}
+
+func TestDBModel_CreateReturn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT o.quantity, o.status_id, w.is_recurring .* FOR UPDATE OF o").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity", "status_id", "is_recurring"}).AddRow(3, OrderStatusCleared, false))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM returns").
+		WithArgs(10, ReturnStatusRejected).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO returns").
+		WithArgs(10, 2, "damaged", "", ReturnStatusRequested, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("UPDATE orders SET status_id = \\$1").
+		WithArgs(OrderStatusReturnRequested, sqlmock.AnyArg(), 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	id, err := model.CreateReturn(Return{OrderID: 10, Quantity: 2, Reason: "damaged"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_CreateReturn_TooMany(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT o.quantity, o.status_id, w.is_recurring").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity", "status_id", "is_recurring"}).AddRow(2, OrderStatusReturnRequested, false))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM returns").
+		WithArgs(10, ReturnStatusRejected).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(2))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	_, err = model.CreateReturn(Return{OrderID: 10, Quantity: 1, Reason: "damaged"})
+	assert.EqualError(t, err, "only 0 items of this order can still be returned")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_RejectReturn_RestoresOrderStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE returns SET status = \\$1, handled_by = \\$2, decision_note = \\$3").
+		WithArgs(ReturnStatusRejected, 1, "worn", sqlmock.AnyArg(), 5, ReturnStatusRequested).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(10))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(10, ReturnStatusCompleted).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("UPDATE orders SET status_id = \\$1").
+		WithArgs(OrderStatusCleared, sqlmock.AnyArg(), 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	require.NoError(t, model.RejectReturn(5, 1, "worn"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_CompleteReturn_StoreCredit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT r.order_id, r.quantity, r.status").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "quantity", "status", "widget_id", "customer_id", "transaction_id", "quantity"}).
+			AddRow(10, 1, ReturnStatusReceived, 3, 7, 20, 2))
+	mock.ExpectQuery("SELECT inventory_level FROM widgets WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"inventory_level"}).AddRow(4))
+	mock.ExpectExec("UPDATE widgets SET inventory_level").
+		WithArgs(5, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO stock_movements").
+		WithArgs(3, 1, 4, 5, StockReasonReturn, "return", sqlmock.AnyArg(), "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT widget_id, low_stock_threshold").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"widget_id", "low_stock_threshold", "reorder_quantity", "supplier_id"}))
+	mock.ExpectExec("INSERT INTO store_credits").
+		WithArgs(7, 1500, "Return 5 of order 10", 5, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE returns SET status = \\$1, outcome = \\$2").
+		WithArgs(ReturnStatusCompleted, ReturnOutcomeStoreCredit, 0, 1500, nil, 1, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(quantity\\), 0\\) FROM returns").
+		WithArgs(10, ReturnStatusCompleted, ReturnOutcomeRefund).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectExec("UPDATE orders SET status_id = \\$1").
+		WithArgs(OrderStatusReturned, sqlmock.AnyArg(), 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	changes, err := model.CompleteReturn(5, ReturnCompletion{Outcome: ReturnOutcomeStoreCredit, CreditAmount: 1500, HandledBy: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []StockLevelChange{{WidgetID: 3, Before: 4, After: 5}}, changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_CompleteReturn_NotReceived(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT r.order_id, r.quantity, r.status").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "quantity", "status", "widget_id", "customer_id", "transaction_id", "quantity"}).
+			AddRow(10, 1, ReturnStatusApproved, 3, 7, 20, 2))
+	mock.ExpectRollback()
+
+	model := DBModel{DB: db}
+	_, err = model.CompleteReturn(5, ReturnCompletion{Outcome: ReturnOutcomeRefund, RefundAmount: 1000, HandledBy: 1})
+	assert.ErrorIs(t, err, ErrReturnNotReceived)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Refunds complete only returns claimed with ClaimReturnRefund
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT r.order_id, r.quantity, r.status").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "quantity", "status", "widget_id", "customer_id", "transaction_id", "quantity"}).
+			AddRow(10, 1, ReturnStatusReceived, 3, 7, 20, 2))
+	mock.ExpectRollback()
+
+	_, err = model.CompleteReturn(5, ReturnCompletion{Outcome: ReturnOutcomeRefund, RefundAmount: 1000, HandledBy: 1})
+	assert.ErrorIs(t, err, ErrReturnNotReceived)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_ClaimReturnRefund(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	claim := "UPDATE returns SET status = \\$1, updated_at = \\$2 WHERE id = \\$3 AND status = \\$4"
+	mock.ExpectExec(claim).
+		WithArgs(ReturnStatusRefunding, sqlmock.AnyArg(), 5, ReturnStatusReceived).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(claim).
+		WithArgs(ReturnStatusRefunding, sqlmock.AnyArg(), 5, ReturnStatusReceived).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(claim).
+		WithArgs(ReturnStatusReceived, sqlmock.AnyArg(), 5, ReturnStatusRefunding).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	model := DBModel{DB: db}
+	require.NoError(t, model.ClaimReturnRefund(5))
+	assert.ErrorIs(t, model.ClaimReturnRefund(5), ErrReturnNotReceived, "claimed twice")
+	require.NoError(t, model.ReleaseReturnRefund(5))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
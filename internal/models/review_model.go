@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Review moderation statuses
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+// Review is a customer's star rating and review of a widget.
+type Review struct {
+	ID            int        `json:"id"`
+	WidgetID      int        `json:"widget_id"`
+	UserID        *int       `json:"user_id,omitempty"`
+	CustomerEmail string     `json:"-"`
+	ReviewerName  string     `json:"reviewer_name"`
+	Rating        int        `json:"rating"`
+	Title         string     `json:"title"`
+	Body          string     `json:"body"`
+	Status        string     `json:"status"`
+	ModeratedBy   *int       `json:"moderated_by,omitempty"`
+	ModeratedAt   *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// HasPurchasedWidget reports whether a customer with the given email has a
+// non-refunded order for the widget.
+func (m *DBModel) HasPurchasedWidget(ctx context.Context, email string, widgetID int) (bool, error) {
+	query := `SELECT EXISTS(
+		SELECT 1 FROM orders o
+			INNER JOIN customers c ON (o.customer_id = c.id)
+		WHERE (c.email_index = $3 OR LOWER(c.email) = $1) AND o.widget_id = $2 AND o.status_id <> 2)`
+
+	var purchased bool
+	err := m.DB.QueryRowContext(ctx, query, strings.ToLower(email), widgetID, m.PII.Index(email)).Scan(&purchased)
+	if err != nil {
+		return false, fmt.Errorf("could not verify purchase: %w", err)
+	}
+	return purchased, nil
+}
+
+// InsertReview stores a new review in the moderation queue and returns its id.
+func (m *DBModel) InsertReview(review Review) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO reviews
+				(widget_id, user_id, customer_email, reviewer_name, rating, title, body, status, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			 RETURNING id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		review.WidgetID,
+		review.UserID,
+		strings.ToLower(review.CustomerEmail),
+		review.ReviewerName,
+		review.Rating,
+		review.Title,
+		review.Body,
+		ReviewStatusPending,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert review: %w", err)
+	}
+	return id, nil
+}
+
+// GetApprovedReviewsForWidget returns the published reviews of a widget, newest first.
+func (m *DBModel) GetApprovedReviewsForWidget(widgetID int) ([]*Review, error) {
+	query := `SELECT id, widget_id, user_id, customer_email, reviewer_name, rating, title, body,
+				     status, moderated_by, moderated_at, created_at, updated_at
+			  FROM reviews
+			  WHERE widget_id = $1 AND status = $2
+			  ORDER BY created_at DESC`
+	return m.queryReviews(query, widgetID, ReviewStatusApproved)
+}
+
+// GetReviewsByStatus returns reviews in the given moderation state, oldest first.
+func (m *DBModel) GetReviewsByStatus(status string) ([]*Review, error) {
+	query := `SELECT id, widget_id, user_id, customer_email, reviewer_name, rating, title, body,
+				     status, moderated_by, moderated_at, created_at, updated_at
+			  FROM reviews
+			  WHERE status = $1
+			  ORDER BY created_at ASC`
+	return m.queryReviews(query, status)
+}
+
+// UpdateReviewStatus approves or rejects a review on behalf of a moderator.
+func (m *DBModel) UpdateReviewStatus(id int, status string, moderatorID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE reviews
+			 SET status = $1, moderated_by = $2, moderated_at = $3, updated_at = $3
+			 WHERE id = $4`
+	result, err := m.DB.ExecContext(ctx, stmt, status, moderatorID, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("no review found with ID %d", id)
+	}
+	return nil
+}
+
+// queryReviews runs a review SELECT and scans all rows.
+func (m *DBModel) queryReviews(query string, args ...interface{}) ([]*Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []*Review
+	for rows.Next() {
+		var review Review
+		err = rows.Scan(
+			&review.ID,
+			&review.WidgetID,
+			&review.UserID,
+			&review.CustomerEmail,
+			&review.ReviewerName,
+			&review.Rating,
+			&review.Title,
+			&review.Body,
+			&review.Status,
+			&review.ModeratedBy,
+			&review.ModeratedAt,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_HasPurchasedWidget(t *testing.T) {
+	query := "SELECT EXISTS\\(\\s*SELECT 1 FROM orders o"
+	tests := []struct {
+		name      string
+		email     string
+		widgetID  int
+		mockSetup func(mock sqlmock.Sqlmock)
+		expected  bool
+		wantErr   bool
+	}{
+		{
+			name:     "customer bought the widget",
+			email:    "Buyer@Example.com",
+			widgetID: 1,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).
+					WithArgs("buyer@example.com", 1, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			expected: true,
+		},
+		{
+			name:     "customer never bought the widget",
+			email:    "someone@example.com",
+			widgetID: 2,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).
+					WithArgs("someone@example.com", 2, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:     "database error",
+			email:    "error@example.com",
+			widgetID: 3,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query).
+					WithArgs("error@example.com", 3, sqlmock.AnyArg()).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+			model := DBModel{DB: db}
+
+			purchased, err := model.HasPurchasedWidget(context.Background(), tt.email, tt.widgetID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, purchased)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_InsertReview(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	userID := 7
+	review := Review{
+		WidgetID:      1,
+		UserID:        &userID,
+		CustomerEmail: "Buyer@Example.com",
+		ReviewerName:  "Jane Buyer",
+		Rating:        5,
+		Title:         "Great",
+		Body:          "Works as described",
+	}
+
+	mock.ExpectQuery("INSERT INTO reviews").
+		WithArgs(1, &userID, "buyer@example.com", "Jane Buyer", 5, "Great", "Works as described",
+			ReviewStatusPending, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+	model := DBModel{DB: db}
+	id, err := model.InsertReview(review)
+	require.NoError(t, err)
+	assert.Equal(t, 42, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_UpdateReviewStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		mockSetup func(mock sqlmock.Sqlmock)
+		wantErr   bool
+	}{
+		{
+			name: "review approved",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE reviews").
+					WithArgs(ReviewStatusApproved, 1, sqlmock.AnyArg(), 10).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "review not found",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE reviews").
+					WithArgs(ReviewStatusApproved, 1, sqlmock.AnyArg(), 10).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+			model := DBModel{DB: db}
+
+			err = model.UpdateReviewStatus(10, ReviewStatusApproved, 1)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_GetAllWidgetsSorted(t *testing.T) {
+	columns := []string{"id", "name", "description", "inventory_level", "price", "image",
+		"is_recurring", "plan_id", "average_rating", "review_count", "created_at", "updated_at"}
+
+	tests := []struct {
+		name          string
+		sortBy        string
+		sortOrder     string
+		expectedOrder string
+	}{
+		{"sort by rating descending", "rating", "desc", "ORDER BY COALESCE\\(r.average_rating, 0\\) DESC, w.id"},
+		{"sort by price ascending", "price", "asc", "ORDER BY w.price ASC, w.id"},
+		{"unknown sort key falls back to id", "password", "", "ORDER BY w.id ASC, w.id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery(tt.expectedOrder).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(1, "Widget", "A very nice widget.", 10, 1000, "", false, "", 4.5, 2, time.Now(), time.Now()))
+
+			model := DBModel{DB: db}
+			widgets, err := model.GetAllWidgetsSorted(tt.sortBy, tt.sortOrder)
+			require.NoError(t, err)
+			require.Len(t, widgets, 1)
+			assert.Equal(t, 4.5, widgets[0].AverageRating)
+			assert.Equal(t, 2, widgets[0].ReviewCount)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Address types of an order
+const (
+	AddressTypeBilling  = "billing"
+	AddressTypeShipping = "shipping"
+)
+
+// ErrShippingUnavailable is returned when a shipping method does not deliver a parcel to a country
+var ErrShippingUnavailable = errors.New("shipping method is not available for this destination")
+
+// ShippingZone groups destination countries. A zone without countries covers
+// every country no other zone lists.
+type ShippingZone struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Countries []string  `json:"countries"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ShippingMethod is a way of delivering to a zone, priced by its rate table.
+type ShippingMethod struct {
+	ID       int             `json:"id"`
+	ZoneID   int             `json:"zone_id"`
+	Name     string          `json:"name"`
+	Carrier  string          `json:"carrier"`
+	IsActive bool            `json:"is_active"`
+	Rates    []*ShippingRate `json:"rates"`
+}
+
+// ShippingRate is the price of a shipping method for a range of parcel
+// weights and order totals. Nil maximums are unbounded.
+type ShippingRate struct {
+	ID             int  `json:"id"`
+	MinWeightGrams int  `json:"min_weight_grams"`
+	MaxWeightGrams *int `json:"max_weight_grams"`
+	MinOrderTotal  int  `json:"min_order_total"`
+	MaxOrderTotal  *int `json:"max_order_total"`
+	Price          int  `json:"price"`
+}
+
+// ShippingQuote is the price of a shipping method for a given parcel.
+type ShippingQuote struct {
+	MethodID int    `json:"shipping_method_id"`
+	Name     string `json:"name"`
+	Carrier  string `json:"carrier"`
+	Price    int    `json:"price"`
+}
+
+// Address is a billing or shipping address of an order.
+type Address struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+}
+
+// Shipment is a parcel sent for an order.
+type Shipment struct {
+	ID             int       `json:"id"`
+	OrderID        int       `json:"order_id"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"tracking_number"`
+	TrackingURL    string    `json:"tracking_url"`
+	ShippedAt      time.Time `json:"shipped_at"`
+	CreatedBy      *int      `json:"created_by,omitempty"`
+}
+
+// quoteQuery selects the cheapest matching rate of every active method that
+// delivers to $1, for a parcel of $2 grams and an order total of $3.
+const quoteQuery = `SELECT DISTINCT ON (m.id) m.id, m.name, m.carrier, r.price
+	FROM shipping_methods m
+		INNER JOIN shipping_zones z ON (m.zone_id = z.id)
+		INNER JOIN shipping_rates r ON (r.shipping_method_id = m.id)
+	WHERE m.is_active
+	  AND ($1 = ANY(z.countries)
+	       OR (cardinality(z.countries) = 0
+	           AND NOT EXISTS (SELECT 1 FROM shipping_zones o WHERE $1 = ANY(o.countries))))
+	  AND r.min_weight_grams <= $2 AND (r.max_weight_grams IS NULL OR r.max_weight_grams >= $2)
+	  AND r.min_order_total <= $3 AND (r.max_order_total IS NULL OR r.max_order_total >= $3)`
+
+// GetShippingQuotes returns the price of every shipping method available for
+// a parcel to country, cheapest first.
+func (m *DBModel) GetShippingQuotes(country string, weightGrams, orderTotal int) ([]ShippingQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, name, carrier, price FROM (` + quoteQuery + `
+		ORDER BY m.id, r.price) q
+		ORDER BY price, name`
+
+	rows, err := m.DB.QueryContext(ctx, query, strings.ToUpper(country), weightGrams, orderTotal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := []ShippingQuote{}
+	for rows.Next() {
+		var q ShippingQuote
+		if err = rows.Scan(&q.MethodID, &q.Name, &q.Carrier, &q.Price); err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, q)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// QuoteShippingMethod prices one shipping method for a parcel to country. It
+// returns ErrShippingUnavailable if the method does not deliver the parcel there.
+func (m *DBModel) QuoteShippingMethod(methodID int, country string, weightGrams, orderTotal int) (ShippingQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var q ShippingQuote
+	err := m.DB.QueryRowContext(ctx, quoteQuery+` AND m.id = $4
+		ORDER BY m.id, r.price`, strings.ToUpper(country), weightGrams, orderTotal, methodID).
+		Scan(&q.MethodID, &q.Name, &q.Carrier, &q.Price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return q, ErrShippingUnavailable
+	}
+	return q, err
+}
+
+// GetShippingWeight returns the parcel weight of quantity units of a widget.
+func (m *DBModel) GetShippingWeight(widgetID, quantity int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var weight int
+	err := m.DB.QueryRowContext(ctx, `SELECT weight_grams FROM widgets WHERE id = $1`, widgetID).Scan(&weight)
+	if err != nil {
+		return 0, fmt.Errorf("could not get widget weight: %w", err)
+	}
+	return weight * quantity, nil
+}
+
+// SetWidgetWeight sets the shipping weight of one unit of a widget.
+func (m *DBModel) SetWidgetWeight(widgetID, weightGrams int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE widgets SET weight_grams = $1, updated_at = $2 WHERE id = $3`, weightGrams, time.Now(), widgetID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("no widget found with ID %d", widgetID)
+	}
+	return nil
+}
+
+// InsertShippingZone creates a shipping zone and returns its id.
+func (m *DBModel) InsertShippingZone(zone ShippingZone) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	countries := make([]string, 0, len(zone.Countries))
+	for _, c := range zone.Countries {
+		countries = append(countries, strings.ToUpper(c))
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx,
+		`INSERT INTO shipping_zones (name, countries, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		zone.Name, pq.Array(countries), time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert shipping zone: %w", err)
+	}
+	return id, nil
+}
+
+// GetShippingZones returns every shipping zone.
+func (m *DBModel) GetShippingZones() ([]*ShippingZone, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, name, countries, created_at, updated_at FROM shipping_zones ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []*ShippingZone{}
+	for rows.Next() {
+		var z ShippingZone
+		err = rows.Scan(&z.ID, &z.Name, pq.Array(&z.Countries), &z.CreatedAt, &z.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, &z)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// InsertShippingMethod creates a shipping method with its rate table and returns its id.
+func (m *DBModel) InsertShippingMethod(method ShippingMethod) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO shipping_methods (zone_id, name, carrier, is_active, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id`,
+		method.ZoneID, method.Name, method.Carrier, method.IsActive, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert shipping method: %w", err)
+	}
+
+	if err = insertShippingRates(ctx, tx, id, method.Rates); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// ReplaceShippingRates replaces the rate table of a shipping method.
+func (m *DBModel) ReplaceShippingRates(methodID int, rates []*ShippingRate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM shipping_rates WHERE shipping_method_id = $1`, methodID)
+	if err != nil {
+		return err
+	}
+	if err = insertShippingRates(ctx, tx, methodID, rates); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE shipping_methods SET updated_at = $1 WHERE id = $2`, time.Now(), methodID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertShippingRates(ctx context.Context, tx *sql.Tx, methodID int, rates []*ShippingRate) error {
+	for _, rate := range rates {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO shipping_rates
+				(shipping_method_id, min_weight_grams, max_weight_grams, min_order_total, max_order_total, price)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			methodID, rate.MinWeightGrams, rate.MaxWeightGrams, rate.MinOrderTotal, rate.MaxOrderTotal, rate.Price)
+		if err != nil {
+			return fmt.Errorf("failed to insert shipping rate: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetShippingMethodActive enables or disables a shipping method at checkout.
+func (m *DBModel) SetShippingMethodActive(methodID int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE shipping_methods SET is_active = $1, updated_at = $2 WHERE id = $3`, active, time.Now(), methodID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("no shipping method found with ID %d", methodID)
+	}
+	return nil
+}
+
+// GetShippingMethods returns every shipping method with its rate table.
+func (m *DBModel) GetShippingMethods() ([]*ShippingMethod, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, zone_id, name, carrier, is_active FROM shipping_methods ORDER BY zone_id, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []*ShippingMethod{}
+	byID := make(map[int]*ShippingMethod)
+	for rows.Next() {
+		var sm ShippingMethod
+		if err = rows.Scan(&sm.ID, &sm.ZoneID, &sm.Name, &sm.Carrier, &sm.IsActive); err != nil {
+			return nil, err
+		}
+		sm.Rates = []*ShippingRate{}
+		methods = append(methods, &sm)
+		byID[sm.ID] = &sm
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rateRows, err := m.DB.QueryContext(ctx,
+		`SELECT id, shipping_method_id, min_weight_grams, max_weight_grams, min_order_total, max_order_total, price
+		 FROM shipping_rates
+		 ORDER BY shipping_method_id, min_weight_grams, min_order_total`)
+	if err != nil {
+		return nil, err
+	}
+	defer rateRows.Close()
+
+	for rateRows.Next() {
+		var rate ShippingRate
+		var methodID int
+		err = rateRows.Scan(&rate.ID, &methodID, &rate.MinWeightGrams, &rate.MaxWeightGrams,
+			&rate.MinOrderTotal, &rate.MaxOrderTotal, &rate.Price)
+		if err != nil {
+			return nil, err
+		}
+		if sm, ok := byID[methodID]; ok {
+			sm.Rates = append(sm.Rates, &rate)
+		}
+	}
+	if err = rateRows.Err(); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+// SaveOrderAddresses stores the billing and shipping address of an order.
+// A nil shipping address means the order ships to the billing address.
+func (m *DBModel) SaveOrderAddresses(orderID int, billing Address, shipping *Address) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if shipping == nil {
+		shipping = &billing
+	}
+
+	stmt := `INSERT INTO order_addresses
+				(order_id, address_type, name, line1, line2, city, region, postal_code, country, phone, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	for addressType, a := range map[string]*Address{AddressTypeBilling: &billing, AddressTypeShipping: shipping} {
+		_, err := m.DB.ExecContext(ctx, stmt, orderID, addressType,
+			a.Name, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, strings.ToUpper(a.Country), a.Phone, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to save %s address: %w", addressType, err)
+		}
+	}
+	return nil
+}
+
+// GetOrderAddresses returns the addresses of an order by type.
+func (m *DBModel) GetOrderAddresses(orderID int) (map[string]*Address, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT address_type, name, line1, line2, city, region, postal_code, country, phone
+		 FROM order_addresses WHERE order_id = $1`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make(map[string]*Address)
+	for rows.Next() {
+		var addressType string
+		var a Address
+		err = rows.Scan(&addressType, &a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.Phone)
+		if err != nil {
+			return nil, err
+		}
+		addresses[addressType] = &a
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// InsertShipment records a shipment of an order and returns its id.
+func (m *DBModel) InsertShipment(s Shipment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if s.ShippedAt.IsZero() {
+		s.ShippedAt = time.Now()
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx,
+		`INSERT INTO shipments (order_id, carrier, tracking_number, tracking_url, shipped_at, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id`,
+		s.OrderID, s.Carrier, s.TrackingNumber, s.TrackingURL, s.ShippedAt, s.CreatedBy, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert shipment: %w", err)
+	}
+	return id, nil
+}
+
+// GetShipmentsForOrder returns the shipments of an order, oldest first.
+func (m *DBModel) GetShipmentsForOrder(orderID int) ([]*Shipment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, order_id, carrier, tracking_number, tracking_url, shipped_at, created_by
+		 FROM shipments WHERE order_id = $1 ORDER BY shipped_at, id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shipments := []*Shipment{}
+	for rows.Next() {
+		var s Shipment
+		err = rows.Scan(&s.ID, &s.OrderID, &s.Carrier, &s.TrackingNumber, &s.TrackingURL, &s.ShippedAt, &s.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		shipments = append(shipments, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return shipments, nil
+}
+
+// LoadFulfilment fills in the addresses and shipments of an order.
+func (m *DBModel) LoadFulfilment(order *Order) error {
+	addresses, err := m.GetOrderAddresses(order.ID)
+	if err != nil {
+		return err
+	}
+	order.BillingAddress = addresses[AddressTypeBilling]
+	order.ShippingAddress = addresses[AddressTypeShipping]
+
+	order.Shipments, err = m.GetShipmentsForOrder(order.ID)
+	return err
+}
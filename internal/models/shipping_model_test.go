@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_GetShippingQuotes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT DISTINCT ON \\(m.id\\)").
+		WithArgs("DE", 1500, 4000).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "carrier", "price"}).
+			AddRow(2, "Standard", "DHL", 490).
+			AddRow(1, "Express", "UPS", 1290))
+
+	model := DBModel{DB: db}
+	quotes, err := model.GetShippingQuotes("de", 1500, 4000)
+	require.NoError(t, err)
+	assert.Equal(t, []ShippingQuote{
+		{MethodID: 2, Name: "Standard", Carrier: "DHL", Price: 490},
+		{MethodID: 1, Name: "Express", Carrier: "UPS", Price: 1290},
+	}, quotes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_QuoteShippingMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		want    int
+		wantErr error
+	}{
+		{"delivers", sqlmock.NewRows([]string{"id", "name", "carrier", "price"}).AddRow(3, "Standard", "DHL", 490), 490, nil},
+		{"does not deliver", sqlmock.NewRows([]string{"id", "name", "carrier", "price"}), 0, ErrShippingUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("AND m.id = \\$4").
+				WithArgs("US", 500, 2000, 3).
+				WillReturnRows(tt.rows)
+
+			model := DBModel{DB: db}
+			quote, err := model.QuoteShippingMethod(3, "us", 500, 2000)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, quote.Price)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_InsertShipment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	adminID := 1
+	shippedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("INSERT INTO shipments").
+		WithArgs(7, "DHL", "JD0123", "https://track.example.com/JD0123", shippedAt, &adminID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+
+	model := DBModel{DB: db}
+	id, err := model.InsertShipment(Shipment{
+		OrderID:        7,
+		Carrier:        "DHL",
+		TrackingNumber: "JD0123",
+		TrackingURL:    "https://track.example.com/JD0123",
+		ShippedAt:      shippedAt,
+		CreatedBy:      &adminID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 11, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Statuses of a user account
+const (
+	// UserStatusPending has not verified their email address yet
+	UserStatusPending = "pending"
+	// UserStatusInvited was invited by an admin and has not set a password yet
+	UserStatusInvited = "invited"
+	// UserStatusActive can sign in
+	UserStatusActive = "active"
+)
+
+// ErrSignupLinkInvalid is returned for a verification or invitation link
+// that was already used or replaced by a newer one
+var ErrSignupLinkInvalid = errors.New("link was already used or replaced by a newer one")
+
+// InsertSignupUser adds a user with the status of user, pending or invited,
+// who is sent a link at sentAt. invitedBy is the admin who invited them, or
+// zero. It returns the ID of the new user.
+func (m *DBModel) InsertSignupUser(user User, hash string, invitedBy int, sentAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inviter sql.NullInt64
+	if invitedBy != 0 {
+		inviter = sql.NullInt64{Int64: int64(invitedBy), Valid: true}
+	}
+
+	err := m.CheckPlainEmail(ctx, "users", user.Email, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	stmt := `INSERT INTO users (first_name, last_name, email, password, role, status, invited_by, link_sent_at, created_at, updated_at, email_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10)
+		RETURNING id`
+	err = m.DB.QueryRowContext(ctx, stmt, m.PII.Value(user.FirstName), m.PII.Value(user.LastName), m.PII.Value(user.Email), hash, user.Role,
+		user.Status, inviter, sentAt, time.Now(), m.PII.Index(user.Email)).Scan(&id)
+	return id, err
+}
+
+// RenewSignupLink records that a new link was sent at sentAt to the user
+// with email, which replaces the link before. It returns ErrUserNotFound
+// when there is no such user with status.
+func (m *DBModel) RenewSignupLink(email, status string, sentAt time.Time) (User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{Status: status}
+	stmt := `UPDATE users SET link_sent_at = $1
+		WHERE (email_index = $4 OR LOWER(email) = LOWER($2)) AND status = $3
+		RETURNING id, first_name, last_name, email, role`
+	err := m.DB.QueryRowContext(ctx, stmt, sentAt, email, status, m.PII.Index(email)).Scan(
+		&user.ID, m.PII.Field(&user.FirstName), m.PII.Field(&user.LastName), m.PII.Field(&user.Email), &user.Role,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+// VerifyEmail activates the pending user with email, from the latest link
+// they were sent at sentAt. It returns the ID of the user, or
+// ErrSignupLinkInvalid.
+func (m *DBModel) VerifyEmail(email string, sentAt, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `UPDATE users SET status = $1, email_verified_at = $2, link_sent_at = NULL, updated_at = $2
+		WHERE (email_index = $6 OR LOWER(email) = LOWER($3)) AND status = $4 AND link_sent_at = $5
+		RETURNING id`
+	err := m.DB.QueryRowContext(ctx, stmt, UserStatusActive, now, email, UserStatusPending, sentAt, m.PII.Index(email)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSignupLinkInvalid
+	}
+	return id, err
+}
+
+// AcceptInvitation sets the password of the invited user with email and
+// activates them, from the latest invitation sent at sentAt. Following the
+// invitation also verifies the email address. It returns
+// ErrSignupLinkInvalid when the invitation is not the latest or was accepted.
+func (m *DBModel) AcceptInvitation(email string, sentAt time.Time, hash string, now time.Time) (User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{Status: UserStatusActive}
+	stmt := `UPDATE users SET password = $1, status = $2, email_verified_at = $3, link_sent_at = NULL, updated_at = $3
+		WHERE (email_index = $7 OR LOWER(email) = LOWER($4)) AND status = $5 AND link_sent_at = $6
+		RETURNING id, first_name, last_name, email, role`
+	err := m.DB.QueryRowContext(ctx, stmt, hash, UserStatusActive, now, email, UserStatusInvited, sentAt, m.PII.Index(email)).Scan(
+		&user.ID, m.PII.Field(&user.FirstName), m.PII.Field(&user.LastName), m.PII.Field(&user.Email), &user.Role,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrSignupLinkInvalid
+	}
+	return user, err
+}
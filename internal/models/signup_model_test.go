@@ -0,0 +1,122 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModel_InsertSignupUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sentAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	mock.ExpectQuery("INSERT INTO users \\(first_name, last_name, email, password, role, status, invited_by, link_sent_at").
+		WithArgs("Jane", "Doe", "jane@example.com", "!invited", "supporter", UserStatusInvited, int64(1), sentAt, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	model := DBModel{DB: db}
+	user := User{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Role: "supporter", Status: UserStatusInvited}
+	id, err := model.InsertSignupUser(user, "!invited", 1, sentAt)
+	require.NoError(t, err)
+	assert.Equal(t, 7, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_RenewSignupLink(t *testing.T) {
+	sentAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{"waiting user", sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "role"}).
+			AddRow(7, "Jane", "Doe", "jane@example.com", "user"), nil},
+		{"no such user", sqlmock.NewRows([]string{"id"}), ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("UPDATE users SET link_sent_at = \\$1").
+				WithArgs(sentAt, "Jane@example.com", UserStatusPending, sqlmock.AnyArg()).
+				WillReturnRows(tt.rows)
+
+			model := DBModel{DB: db}
+			user, err := model.RenewSignupLink("Jane@example.com", UserStatusPending, sentAt)
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, 7, user.ID)
+				assert.Equal(t, UserStatusPending, user.Status)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_VerifyEmail(t *testing.T) {
+	sentAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	now := sentAt.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantID  int
+		wantErr error
+	}{
+		{"latest link", sqlmock.NewRows([]string{"id"}).AddRow(7), 7, nil},
+		{"used or replaced link", sqlmock.NewRows([]string{"id"}), 0, ErrSignupLinkInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("UPDATE users SET status = \\$1, email_verified_at = \\$2, link_sent_at = NULL").
+				WithArgs(UserStatusActive, now, "jane@example.com", UserStatusPending, sentAt, sqlmock.AnyArg()).
+				WillReturnRows(tt.rows)
+
+			model := DBModel{DB: db}
+			id, err := model.VerifyEmail("jane@example.com", sentAt, now)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantID, id)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_AcceptInvitation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sentAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	now := sentAt.Add(24 * time.Hour)
+	mock.ExpectQuery("UPDATE users SET password = \\$1, status = \\$2").
+		WithArgs("hash", UserStatusActive, now, "jane@example.com", UserStatusInvited, sentAt, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "role"}).
+			AddRow(7, "Jane", "Doe", "jane@example.com", "supporter"))
+	mock.ExpectQuery("UPDATE users SET password = \\$1, status = \\$2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	model := DBModel{DB: db}
+	user, err := model.AcceptInvitation("jane@example.com", sentAt, "hash", now)
+	require.NoError(t, err)
+	assert.Equal(t, User{ID: 7, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Role: "supporter",
+		Status: UserStatusActive}, user)
+
+	// The same invitation cannot be accepted twice
+	_, err = model.AcceptInvitation("jane@example.com", sentAt, "hash", now)
+	assert.Equal(t, ErrSignupLinkInvalid, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
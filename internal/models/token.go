@@ -6,13 +6,36 @@ import (
 
 const (
 	ScopeAuthentication = "authentication"
+	ScopeRefresh        = "refresh"
+	// ScopeMFAPending tokens prove the password was checked and can only be
+	// exchanged for an authentication token with a second factor
+	ScopeMFAPending = "mfa_pending"
 )
 
 // Token is the type of authentication token
 type Token struct {
-	PlainText string    `json:"token"`
-	UserId    int64     `json:"-"`
-	Hash      []byte    `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	PlainText string      `json:"token"`
+	UserId    int64       `json:"-"`
+	Hash      []byte      `json:"-"`
+	Expiry    time.Time   `json:"expiry"`
+	Scope     string      `json:"-"`
+	FamilyID  string      `json:"-"`
+	Device    TokenDevice `json:"-"`
+}
+
+// TokenDevice describes the device a token was issued to
+type TokenDevice struct {
+	Label     string `json:"device"`
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+}
+
+// ActiveToken is a token family that is still valid, shown to its user as a signed-in session
+type ActiveToken struct {
+	ID int `json:"id"`
+	TokenDevice
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
 }
@@ -3,7 +3,9 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -17,31 +19,42 @@ type Widget struct {
 	Image          string    `json:"image"`
 	IsRecurring    bool      `json:"is_recurring"`
 	PlanID         string    `json:"plan_id"`
+	AverageRating  float64   `json:"average_rating"`
+	ReviewCount    int       `json:"review_count"`
 	CreatedAt      time.Time `json:"-"`
 	UpdatedAt      time.Time `json:"-"`
 }
 
+// widgetSelect selects widget columns together with the aggregate of approved reviews.
+const widgetSelect = `SELECT w.id, w.name, w.description, w.inventory_level, w.price, w.image,
+		w.is_recurring, w.plan_id, COALESCE(r.average_rating, 0), COALESCE(r.review_count, 0),
+		w.created_at, w.updated_at
+	FROM widgets w
+		LEFT JOIN (
+			SELECT widget_id, AVG(rating)::FLOAT AS average_rating, COUNT(*) AS review_count
+			FROM reviews
+			WHERE status = 'approved'
+			GROUP BY widget_id
+		) r ON (r.widget_id = w.id)`
+
+// widgetSortFields maps catalog sort keys to SQL ORDER BY expressions.
+var widgetSortFields = map[string]string{
+	"id":      "w.id",
+	"name":    "w.name",
+	"price":   "w.price",
+	"rating":  "COALESCE(r.average_rating, 0)",
+	"reviews": "COALESCE(r.review_count, 0)",
+}
+
 // GetWidget retrieves a widget by its ID.
 func (m *DBModel) GetWidget(id int) (Widget, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `SELECT id, name, description, inventory_level, price, image, is_recurring, plan_id, created_at, updated_at FROM widgets WHERE id=$1`
-	row := m.DB.QueryRowContext(ctx, stmt, id)
+	row := m.DB.QueryRowContext(ctx, widgetSelect+` WHERE w.id=$1`, id)
 
 	var widget Widget
-	err := row.Scan(
-		&widget.ID,
-		&widget.Name,
-		&widget.Description,
-		&widget.InventoryLevel,
-		&widget.Price,
-		&widget.Image,
-		&widget.IsRecurring,
-		&widget.PlanID,
-		&widget.CreatedAt,
-		&widget.UpdatedAt,
-	)
+	err := scanWidget(row, &widget)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return widget, fmt.Errorf("widget not found")
@@ -53,10 +66,26 @@ func (m *DBModel) GetWidget(id int) (Widget, error) {
 
 // GetAllWidgets retrieves all widgets from the database.
 func (m *DBModel) GetAllWidgets() ([]Widget, error) {
+	return m.GetAllWidgetsSorted("id", "asc")
+}
+
+// GetAllWidgetsSorted retrieves all widgets ordered by one of the supported
+// sort keys (id, name, price, rating, reviews). Unknown keys fall back to id.
+func (m *DBModel) GetAllWidgetsSorted(sortBy, sortOrder string) ([]Widget, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `SELECT id, name, description, inventory_level, price, image, is_recurring, plan_id, created_at, updated_at FROM widgets ORDER BY id`
+	orderBy, ok := widgetSortFields[sortBy]
+	if !ok {
+		orderBy = widgetSortFields["id"]
+	}
+	if strings.ToUpper(sortOrder) == "DESC" {
+		orderBy += " DESC"
+	} else {
+		orderBy += " ASC"
+	}
+
+	stmt := widgetSelect + ` ORDER BY ` + orderBy + `, w.id`
 	rows, err := m.DB.QueryContext(ctx, stmt)
 	if err != nil {
 		return nil, err
@@ -66,18 +95,7 @@ func (m *DBModel) GetAllWidgets() ([]Widget, error) {
 	var widgets []Widget
 	for rows.Next() {
 		var widget Widget
-		err := rows.Scan(
-			&widget.ID,
-			&widget.Name,
-			&widget.Description,
-			&widget.InventoryLevel,
-			&widget.Price,
-			&widget.Image,
-			&widget.IsRecurring,
-			&widget.PlanID,
-			&widget.CreatedAt,
-			&widget.UpdatedAt,
-		)
+		err := scanWidget(rows, &widget)
 		if err != nil {
 			return nil, err
 		}
@@ -90,3 +108,88 @@ func (m *DBModel) GetAllWidgets() ([]Widget, error) {
 
 	return widgets, nil
 }
+
+// UpdateWidget updates the editable catalog fields of a widget. Price and
+// inventory changes are recorded in the price history and the stock movements
+// on behalf of changedBy.
+func (m *DBModel) UpdateWidget(widget Widget, changedBy int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var currentPrice, currentInventory int
+	err = tx.QueryRowContext(ctx, `SELECT price, inventory_level FROM widgets WHERE id = $1 FOR UPDATE`, widget.ID).
+		Scan(&currentPrice, &currentInventory)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("widget not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE widgets
+			 SET name = $1, description = $2, image = $3, updated_at = $4
+			 WHERE id = $5`
+	_, err = tx.ExecContext(ctx, stmt,
+		widget.Name,
+		widget.Description,
+		widget.Image,
+		time.Now(),
+		widget.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if widget.Price != currentPrice {
+		err = setWidgetPrice(ctx, tx, widget.ID, widget.Price, PriceReasonManual, nil, &changedBy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if widget.InventoryLevel != currentInventory {
+		_, err = adjustInventory(ctx, tx, StockAdjustment{
+			WidgetID:  widget.ID,
+			Change:    widget.InventoryLevel - currentInventory,
+			Reason:    StockReasonManualAdjustment,
+			Note:      "inventory level edited",
+			CreatedBy: &changedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWidget scans a row produced by widgetSelect into widget.
+func scanWidget(row rowScanner, widget *Widget) error {
+	return row.Scan(
+		&widget.ID,
+		&widget.Name,
+		&widget.Description,
+		&widget.InventoryLevel,
+		&widget.Price,
+		&widget.Image,
+		&widget.IsRecurring,
+		&widget.PlanID,
+		&widget.AverageRating,
+		&widget.ReviewCount,
+		&widget.CreatedAt,
+		&widget.UpdatedAt,
+	)
+}
@@ -0,0 +1,333 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWishlistNotFound is returned when a wishlist does not exist or belongs to someone else
+var ErrWishlistNotFound = errors.New("wishlist not found")
+
+// Wishlist is a named list of widgets saved by a user or an anonymous session.
+type Wishlist struct {
+	ID        int             `json:"id"`
+	UserID    *int            `json:"user_id,omitempty"`
+	SessionID *string         `json:"-"`
+	Name      string          `json:"name"`
+	Items     []*WishlistItem `json:"items"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// WishlistItem is a widget saved on a wishlist.
+type WishlistItem struct {
+	ID             int       `json:"id"`
+	WishlistID     int       `json:"wishlist_id"`
+	WidgetID       int       `json:"widget_id"`
+	Widget         Widget    `json:"widget"`
+	PriceWhenAdded int       `json:"price_when_added"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WishlistOwner identifies who a wishlist belongs to: a logged-in user or an
+// anonymous session, by the hash of the session cookie the server issued.
+type WishlistOwner struct {
+	UserID    *int
+	SessionID string
+}
+
+// WishlistSubscriber is someone to notify about changes to a wishlisted widget.
+type WishlistSubscriber struct {
+	Email     string
+	FirstName string
+}
+
+// clause returns the WHERE condition matching wishlists of the owner, using
+// placeholder $n, and the value to bind to it.
+func (o WishlistOwner) clause(n int) (string, interface{}) {
+	if o.UserID != nil {
+		return fmt.Sprintf("user_id = $%d", n), *o.UserID
+	}
+	return fmt.Sprintf("user_id IS NULL AND session_id = $%d", n), o.SessionID
+}
+
+// CreateWishlist creates a wishlist for the owner and returns its id.
+func (m *DBModel) CreateWishlist(owner WishlistOwner, name string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sessionID *string
+	if owner.UserID == nil {
+		sessionID = &owner.SessionID
+	}
+
+	stmt := `INSERT INTO wishlists (user_id, session_id, name, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5)
+			 RETURNING id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt, owner.UserID, sessionID, name, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create wishlist: %w", err)
+	}
+	return id, nil
+}
+
+// GetWishlistsForOwner returns every wishlist of the owner with its items.
+func (m *DBModel) GetWishlistsForOwner(owner WishlistOwner) ([]*Wishlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	where, arg := owner.clause(1)
+	query := `SELECT id, user_id, session_id, name, created_at, updated_at
+			  FROM wishlists
+			  WHERE ` + where + `
+			  ORDER BY created_at ASC`
+
+	rows, err := m.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wishlists []*Wishlist
+	byID := make(map[int]*Wishlist)
+	for rows.Next() {
+		var wl Wishlist
+		err = rows.Scan(&wl.ID, &wl.UserID, &wl.SessionID, &wl.Name, &wl.CreatedAt, &wl.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		wl.Items = []*WishlistItem{}
+		wishlists = append(wishlists, &wl)
+		byID[wl.ID] = &wl
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(wishlists) == 0 {
+		return wishlists, nil
+	}
+
+	itemQuery := `SELECT i.id, i.wishlist_id, i.widget_id, i.price_when_added, i.created_at,
+					     w.id, w.name, w.description, w.inventory_level, w.price, w.image
+				  FROM wishlist_items i
+				  	INNER JOIN wishlists l ON (i.wishlist_id = l.id)
+				  	INNER JOIN widgets w ON (i.widget_id = w.id)
+				  WHERE l.` + where + `
+				  ORDER BY i.created_at ASC`
+
+	itemRows, err := m.DB.QueryContext(ctx, itemQuery, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var item WishlistItem
+		err = itemRows.Scan(
+			&item.ID,
+			&item.WishlistID,
+			&item.WidgetID,
+			&item.PriceWhenAdded,
+			&item.CreatedAt,
+			&item.Widget.ID,
+			&item.Widget.Name,
+			&item.Widget.Description,
+			&item.Widget.InventoryLevel,
+			&item.Widget.Price,
+			&item.Widget.Image,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if wl, ok := byID[item.WishlistID]; ok {
+			wl.Items = append(wl.Items, &item)
+		}
+	}
+	if err = itemRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return wishlists, nil
+}
+
+// wishlistBelongsTo returns ErrWishlistNotFound unless the wishlist is owned by owner.
+func (m *DBModel) wishlistBelongsTo(ctx context.Context, wishlistID int, owner WishlistOwner) error {
+	where, arg := owner.clause(2)
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM wishlists WHERE id = $1 AND `+where+`)`, wishlistID, arg).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrWishlistNotFound
+	}
+	return nil
+}
+
+// DeleteWishlist removes one of the owner's wishlists together with its items.
+func (m *DBModel) DeleteWishlist(wishlistID int, owner WishlistOwner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	where, arg := owner.clause(2)
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM wishlists WHERE id = $1 AND `+where, wishlistID, arg)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWishlistNotFound
+	}
+	return nil
+}
+
+// AddWishlistItem saves a widget on one of the owner's wishlists, remembering its current price.
+func (m *DBModel) AddWishlistItem(wishlistID, widgetID int, owner WishlistOwner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.wishlistBelongsTo(ctx, wishlistID, owner); err != nil {
+		return err
+	}
+
+	if err := m.CheckWidgetExistence(ctx, widgetID); err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO wishlist_items (wishlist_id, widget_id, price_when_added, created_at)
+			 SELECT $1, id, price, $3 FROM widgets WHERE id = $2
+			 ON CONFLICT (wishlist_id, widget_id) DO NOTHING`
+	_, err := m.DB.ExecContext(ctx, stmt, wishlistID, widgetID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to add wishlist item: %w", err)
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE wishlists SET updated_at = $1 WHERE id = $2`, time.Now(), wishlistID)
+	return err
+}
+
+// RemoveWishlistItem removes a widget from one of the owner's wishlists.
+func (m *DBModel) RemoveWishlistItem(wishlistID, widgetID int, owner WishlistOwner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.wishlistBelongsTo(ctx, wishlistID, owner); err != nil {
+		return err
+	}
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM wishlist_items WHERE wishlist_id = $1 AND widget_id = $2`, wishlistID, widgetID)
+	return err
+}
+
+// MergeSessionWishlists moves the wishlists of an anonymous session to a user.
+// A session list whose name matches one of the user's lists is merged into it;
+// any other list is simply reassigned to the user.
+func (m *DBModel) MergeSessionWishlists(sessionID string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name FROM wishlists WHERE user_id IS NULL AND session_id = $1`, sessionID)
+	if err != nil {
+		return err
+	}
+
+	type anonymousList struct {
+		id   int
+		name string
+	}
+	var lists []anonymousList
+	for rows.Next() {
+		var l anonymousList
+		if err = rows.Scan(&l.id, &l.name); err != nil {
+			rows.Close()
+			return err
+		}
+		lists = append(lists, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range lists {
+		var targetID int
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM wishlists WHERE user_id = $1 AND name = $2 ORDER BY id LIMIT 1`, userID, l.name).Scan(&targetID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx,
+				`UPDATE wishlists SET user_id = $1, session_id = NULL, updated_at = $2 WHERE id = $3`,
+				userID, time.Now(), l.id)
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO wishlist_items (wishlist_id, widget_id, price_when_added, created_at)
+				 SELECT $1, widget_id, price_when_added, created_at FROM wishlist_items WHERE wishlist_id = $2
+				 ON CONFLICT (wishlist_id, widget_id) DO NOTHING`, targetID, l.id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `DELETE FROM wishlists WHERE id = $1`, l.id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetWishlistSubscribers returns the users to notify about changes to a
+// widget: the owners of the wishlists it is on, at their verified email.
+// Anonymous wishlists get no emails until they are merged on login.
+func (m *DBModel) GetWishlistSubscribers(widgetID int) ([]WishlistSubscriber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT DISTINCT u.id, u.email, u.first_name
+			  FROM wishlist_items i
+			  	INNER JOIN wishlists l ON (i.wishlist_id = l.id)
+			  	INNER JOIN users u ON (l.user_id = u.id)
+			  WHERE i.widget_id = $1 AND u.status = $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, widgetID, UserStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []WishlistSubscriber
+	for rows.Next() {
+		var id int
+		var s WishlistSubscriber
+		if err = rows.Scan(&id, m.PII.Field(&s.Email), m.PII.Field(&s.FirstName)); err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
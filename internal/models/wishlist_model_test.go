@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWishlistOwner_clause(t *testing.T) {
+	userID := 5
+	tests := []struct {
+		name        string
+		owner       WishlistOwner
+		wantClause  string
+		wantBinding interface{}
+	}{
+		{"logged-in user", WishlistOwner{UserID: &userID}, "user_id = $2", 5},
+		{"anonymous session", WishlistOwner{SessionID: "sess-1"}, "user_id IS NULL AND session_id = $2", "sess-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clause, binding := tt.owner.clause(2)
+			assert.Equal(t, tt.wantClause, clause)
+			assert.Equal(t, tt.wantBinding, binding)
+		})
+	}
+}
+
+func TestDBModel_DeleteWishlist(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"own wishlist deleted", 1, nil},
+		{"someone else's wishlist", 0, ErrWishlistNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("DELETE FROM wishlists WHERE id = \\$1 AND user_id IS NULL AND session_id = \\$2").
+				WithArgs(3, "sess-1").
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			model := DBModel{DB: db}
+			err = model.DeleteWishlist(3, WishlistOwner{SessionID: "sess-1"})
+			assert.Equal(t, tt.wantErr, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_MergeSessionWishlists(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name FROM wishlists WHERE user_id IS NULL AND session_id = \\$1").
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(10, "My Wishlist").
+			AddRow(11, "Gifts"))
+
+	// "My Wishlist" already exists for the user: items are merged and the session list removed
+	mock.ExpectQuery("SELECT id FROM wishlists WHERE user_id = \\$1 AND name = \\$2").
+		WithArgs(7, "My Wishlist").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectExec("INSERT INTO wishlist_items").
+		WithArgs(20, 10).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM wishlists WHERE id = \\$1").
+		WithArgs(10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// "Gifts" is new for the user: the list is reassigned
+	mock.ExpectQuery("SELECT id FROM wishlists WHERE user_id = \\$1 AND name = \\$2").
+		WithArgs(7, "Gifts").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("UPDATE wishlists SET user_id = \\$1, session_id = NULL").
+		WithArgs(7, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	model := DBModel{DB: db}
+	err = model.MergeSessionWishlists("sess-1", 7)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_GetWishlistSubscribers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Only owners with a verified email hear about the widget
+	mock.ExpectQuery("INNER JOIN users u ON \\(l.user_id = u.id\\)\\s+WHERE i.widget_id = \\$1 AND u.status = \\$2").
+		WithArgs(4, UserStatusActive).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name"}).
+			AddRow(3, "jane@example.com", "Jane").
+			AddRow(5, "john@example.com", "John"))
+
+	model := DBModel{DB: db}
+	subscribers, err := model.GetWishlistSubscribers(4)
+	require.NoError(t, err)
+	assert.Equal(t, []WishlistSubscriber{
+		{Email: "jane@example.com", FirstName: "Jane"},
+		{Email: "john@example.com", FirstName: "John"},
+	}, subscribers)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
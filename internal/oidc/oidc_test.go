@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"usual_store/internal/oidc/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURI = "https://store.example.com/auth/oidc/callback"
+
+func testProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	srv := oidctest.NewServer("usual-store", "s3cret")
+	t.Cleanup(srv.Close)
+	srv.SetUser(oidctest.User{
+		Subject:       "00u1",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		GivenName:     "Jane",
+		FamilyName:    "Doe",
+		Groups:        []string{"store-admins", "everyone"},
+	})
+	p := NewProvider(Config{Issuer: srv.Issuer() + "/", ClientID: "usual-store", ClientSecret: "s3cret"})
+	return p, srv
+}
+
+// signIn runs the authorization code flow and returns the code and PKCE verifier
+func signIn(t *testing.T, p *Provider, srv *oidctest.Server, nonce string) (string, string) {
+	verifier, challenge, err := NewPKCE()
+	require.NoError(t, err)
+	authURL, err := p.AuthCodeURL(context.Background(), redirectURI, "state-1", nonce, challenge)
+	require.NoError(t, err)
+	assert.Contains(t, authURL, "code_challenge_method=S256")
+	assert.Contains(t, authURL, "scope=openid+email+profile+groups")
+
+	code, state, err := srv.Authorize(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", state)
+	return code, verifier
+}
+
+func TestExchange(t *testing.T) {
+	p, srv := testProvider(t)
+	code, verifier := signIn(t, p, srv, "nonce-1")
+
+	claims, err := p.Exchange(context.Background(), code, redirectURI, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, &Claims{
+		Issuer:        srv.Issuer(),
+		Subject:       "00u1",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+		GivenName:     "Jane",
+		FamilyName:    "Doe",
+		Groups:        []string{"store-admins", "everyone"},
+	}, claims)
+
+	// Codes are single use
+	_, err = p.Exchange(context.Background(), code, redirectURI, verifier, "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestExchange_Rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier func(string) string
+		redirect string
+		nonce    string
+		wantErr  string
+	}{
+		{"wrong code verifier", func(string) string { return "guessed" }, redirectURI, "nonce-1", "PKCE"},
+		{"other redirect URI", func(v string) string { return v }, "https://evil.example.com/cb", "nonce-1", "redirect_uri"},
+		{"replayed ID token", func(v string) string { return v }, redirectURI, "other-nonce", "nonce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, srv := testProvider(t)
+			code, verifier := signIn(t, p, srv, "nonce-1")
+
+			_, err := p.Exchange(context.Background(), code, tt.redirect, tt.verifier(verifier), tt.nonce)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, srv := testProvider(t)
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid", func() string { return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n"}) }, false},
+		{"audience list with azp", func() string {
+			return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "aud": []string{"usual-store", "other"}, "azp": "usual-store"})
+		}, false},
+		{"expired", func() string {
+			return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()})
+		}, true},
+		{"other audience", func() string {
+			return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "aud": "other-client"})
+		}, true},
+		{"audience list without azp", func() string {
+			return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "aud": []string{"usual-store", "other"}})
+		}, true},
+		{"other issuer", func() string {
+			return srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "iss": "https://evil.example.com"})
+		}, true},
+		{"no subject", func() string { return srv.IDToken(map[string]interface{}{"nonce": "n"}) }, true},
+		{"tampered payload", func() string {
+			parts := strings.Split(srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n"}), ".")
+			other := strings.Split(srv.IDToken(map[string]interface{}{"sub": "00u2", "nonce": "n"}), ".")
+			return parts[0] + "." + other[1] + "." + parts[2]
+		}, true},
+		{"unsigned", func() string {
+			parts := strings.Split(srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n"}), ".")
+			return "eyJhbGciOiJub25lIn0." + parts[1] + "."
+		}, true},
+		{"malformed", func() string { return "not-a-jwt" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.Verify(context.Background(), tt.token(), "n")
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "00u1", claims.Subject)
+		})
+	}
+}
+
+func TestProvider_Caching(t *testing.T) {
+	p, srv := testProvider(t)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := p.Verify(context.Background(), srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n"}), "n")
+		require.NoError(t, err)
+	}
+	discovery, jwks := srv.Hits()
+	assert.Equal(t, 1, discovery)
+	assert.Equal(t, 1, jwks)
+
+	// A token signed with a new key is refused until keys may be refetched
+	srv.RotateKey()
+	token := srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n", "exp": now.Add(time.Hour).Unix()})
+	_, err := p.Verify(context.Background(), token, "n")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, jwks = srv.Hits()
+	assert.Equal(t, 1, jwks)
+
+	now = now.Add(2 * time.Minute)
+	_, err = p.Verify(context.Background(), token, "n")
+	require.NoError(t, err)
+	_, jwks = srv.Hits()
+	assert.Equal(t, 2, jwks)
+
+	// Both are refetched once stale
+	now = now.Add(DiscoveryTTL)
+	_, err = p.Verify(context.Background(), srv.IDToken(map[string]interface{}{"sub": "00u1", "nonce": "n",
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}), "n")
+	require.NoError(t, err)
+	discovery, jwks = srv.Hits()
+	assert.Equal(t, 2, discovery)
+	assert.Equal(t, 3, jwks)
+}
+
+func TestChallenge(t *testing.T) {
+	// BASE64URL(SHA256(verifier)) without padding, as computed by openssl
+	assert.Equal(t, "LwXvvjnKlkcRkFhED5JqY577Vv3grzlvfHFLx2gGrUc", Challenge("dBjftJeZ4CVP-mB92K1uvbpkyD7NdUYs0pmU8BC8O9o"))
+}
+
+func TestDiscovery_IssuerMismatch(t *testing.T) {
+	srv := oidctest.NewServer("usual-store", "s3cret")
+	defer srv.Close()
+
+	p := NewProvider(Config{Issuer: srv.Issuer() + "/tenant", ClientID: "usual-store"})
+	_, err := p.AuthCodeURL(context.Background(), redirectURI, "s", "n", "c")
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+// Package password hashes and verifies user passwords. Hashes start with the
+// identifier of the algorithm that made them: new ones use Argon2id, and the
+// bcrypt hashes of earlier versions still verify until they are upgraded on
+// the next sign-in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms a hash can be made with, as named in the hash
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrUnknownHash is returned for a stored value that is not a hash of a known
+// algorithm, such as the placeholder of a user without a password
+var ErrUnknownHash = errors.New("unknown password hash")
+
+// Params tune Argon2id. Raising them makes every hash slower to compute, for
+// attackers and for sign-ins alike.
+type Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the second recommendation of RFC 9106 with a little
+// more time, for servers that cannot spare 2 GiB a sign-in
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var encoding = base64.RawStdEncoding
+
+// Hash returns the Argon2id hash of password made with p, in the PHC string
+// format: $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func Hash(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, p.Memory, p.Iterations,
+		p.Parallelism, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// Algorithm returns the algorithm hash was made with, or "" when it is not a
+// known hash
+func Algorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$"+Argon2id+"$"):
+		return Argon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return Bcrypt
+	}
+	return ""
+}
+
+// Verify reports whether password matches hash, whichever algorithm made it.
+// A wrong password is not an error.
+func Verify(password, hash string) (bool, error) {
+	switch Algorithm(hash) {
+	case Argon2id:
+		p, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case Bcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, ErrUnknownHash
+}
+
+// NeedsRehash reports whether hash should be replaced by one made with p,
+// because it was made with another algorithm or weaker parameters. Values
+// that are not hashes are left alone.
+func NeedsRehash(hash string, p Params) bool {
+	switch Algorithm(hash) {
+	case Argon2id:
+		current, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		current.SaltLength = uint32(len(salt))
+		current.KeyLength = uint32(len(key))
+		return current != p
+	case Bcrypt:
+		return true
+	}
+	return false
+}
+
+// decodeArgon2id splits an Argon2id hash in the PHC string format
+func decodeArgon2id(hash string) (Params, []byte, []byte, error) {
+	var p Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
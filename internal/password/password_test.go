@@ -0,0 +1,99 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("correct horse battery staple", testParams)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	assert.Equal(t, Argon2id, Algorithm(hash))
+
+	// Every hash has its own salt
+	other, err := Hash("correct horse battery staple", testParams)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	ok, err := Verify("correct horse battery staple", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("Correct horse battery staple", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerify_Bcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("qwerty"), bcrypt.MinCost)
+	require.NoError(t, err)
+	assert.Equal(t, Bcrypt, Algorithm(string(legacy)))
+
+	ok, err := Verify("qwerty", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("qwerty1", string(legacy))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerify_NotAHash(t *testing.T) {
+	for _, hash := range []string{"!invited", "!sso", "", "$argon2id$v=19$m=1024"} {
+		ok, err := Verify("", hash)
+		assert.False(t, ok, hash)
+		assert.Error(t, err, hash)
+	}
+
+	_, err := Verify("secret", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5")
+	assert.ErrorContains(t, err, "version")
+}
+
+func TestNeedsRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("qwerty"), bcrypt.MinCost)
+	require.NoError(t, err)
+	current, err := Hash("qwerty", testParams)
+	require.NoError(t, err)
+
+	stronger := testParams
+	stronger.Iterations = 2
+
+	assert.True(t, NeedsRehash(string(legacy), testParams))
+	assert.False(t, NeedsRehash(current, testParams))
+	assert.True(t, NeedsRehash(current, stronger))
+	assert.False(t, NeedsRehash("!invited", testParams))
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := NewPolicy(MinLength)
+	require.NoError(t, policy.AddBreached(strings.NewReader("# leaked last week\n\nCorrectHorse1\n")))
+
+	tests := []struct {
+		password string
+		wantErr  string
+	}{
+		{"short", "at least 8 characters"},
+		{"password123", ErrBreached.Error()},
+		{"PASSWORD123", ErrBreached.Error()},
+		{"correcthorse1", ErrBreached.Error()},
+		{"ünïcödé", "at least 8 characters"},
+		{"plum tree at dawn", ""},
+	}
+
+	for _, tt := range tests {
+		err := policy.Check(tt.password)
+		if tt.wantErr == "" {
+			assert.NoError(t, err, tt.password)
+		} else {
+			assert.ErrorContains(t, err, tt.wantErr, tt.password)
+		}
+	}
+}
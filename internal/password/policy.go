@@ -0,0 +1,76 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// MinLength is the shortest password the default policy allows
+const MinLength = 8
+
+// ErrBreached is returned for a password found in the breached-password list
+var ErrBreached = errors.New("is a common password found in data breaches, please choose another")
+
+//go:embed breached.txt
+var commonPasswords string
+
+// Policy decides which passwords users may set
+type Policy struct {
+	MinLength int
+	// breached holds the lowercased passwords of the breached-password list
+	breached map[string]bool
+}
+
+// NewPolicy returns a policy requiring minLength characters and rejecting the
+// common passwords built in
+func NewPolicy(minLength int) *Policy {
+	p := &Policy{MinLength: minLength, breached: make(map[string]bool)}
+	_ = p.AddBreached(strings.NewReader(commonPasswords))
+	return p
+}
+
+// AddBreached adds the passwords read from r, one a line, to the
+// breached-password list. Empty lines and lines starting with # are skipped.
+func (p *Policy) AddBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+// AddBreachedFile adds the passwords of a local file, one a line, to the
+// breached-password list
+func (p *Policy) AddBreachedFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = p.AddBreached(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// Check returns why password may not be used, or nil. The error reads as the
+// end of a sentence starting with "password".
+func (p *Policy) Check(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("must be at least %d characters long", p.MinLength)
+	}
+	if p.breached[strings.ToLower(password)] {
+		return ErrBreached
+	}
+	return nil
+}
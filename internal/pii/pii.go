@@ -0,0 +1,165 @@
+// Package pii encrypts the personal data of customers and users before it is
+// stored: names and email addresses are sealed with AES-GCM under the active
+// key of the keyring, and email addresses also get a blind index, an HMAC
+// that lets rows be looked up by email without decrypting them.
+//
+// A nil *Cipher leaves values in plain text, and values that were stored
+// before encryption was turned on read as they are, so rows can be encrypted
+// in batches while the application runs.
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"usual_store/internal/encryption"
+	"usual_store/internal/keyring"
+)
+
+// Prefix starts every sealed value, telling it apart from plain text
+const Prefix = "pii:"
+
+// MinIndexKeyLength is the shortest key the blind index may use
+const MinIndexKeyLength = 32
+
+// ErrNoCipher is returned for a sealed value read without a cipher
+var ErrNoCipher = errors.New("personal data is encrypted but no PII key is configured")
+
+// Cipher seals personal data and computes its blind index
+type Cipher struct {
+	enc      encryption.Encryption
+	indexKey []byte
+}
+
+// New returns a cipher sealing with the active key of keys and indexing with
+// indexKey. The active key must be able to encrypt. The index key cannot be
+// rotated like the keyring: every index would have to be recomputed.
+func New(keys *keyring.Keyring, indexKey []byte) (*Cipher, error) {
+	if len(indexKey) < MinIndexKeyLength {
+		return nil, fmt.Errorf("the PII index key must be at least %d bytes long", MinIndexKeyLength)
+	}
+	if active := keys.Active(); !active.CanEncrypt() {
+		return nil, fmt.Errorf("key %s cannot encrypt personal data: it must be 16, 24 or 32 bytes long", active.ID)
+	}
+	return &Cipher{enc: encryption.Encryption{Keys: keys}, indexKey: indexKey}, nil
+}
+
+// Load returns the cipher of keys and indexKey, or nil, storing personal data
+// in plain text, when indexKey is empty
+func Load(keys *keyring.Keyring, indexKey string) (*Cipher, error) {
+	if indexKey == "" {
+		return nil, nil
+	}
+	return New(keys, []byte(indexKey))
+}
+
+// Sealed reports whether value was sealed by a cipher
+func Sealed(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Seal returns value encrypted. A nil cipher and empty values leave it as it is.
+func (c *Cipher) Seal(value string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+	sealed, err := c.enc.Encrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + sealed, nil
+}
+
+// Open returns value decrypted. Values that are not sealed are returned as
+// they are.
+func (c *Cipher) Open(value string) (string, error) {
+	if !Sealed(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrNoCipher
+	}
+	return c.enc.Decrypt(strings.TrimPrefix(value, Prefix))
+}
+
+// Reseal returns value sealed with the active key, and whether it changed.
+// Plain values are sealed, and values sealed with an older key re-encrypted.
+func (c *Cipher) Reseal(value string) (string, bool, error) {
+	if c == nil {
+		return "", false, ErrNoCipher
+	}
+	if value == "" {
+		return value, false, nil
+	}
+	if !Sealed(value) {
+		sealed, err := c.Seal(value)
+		return sealed, err == nil, err
+	}
+	sealed, changed, err := c.enc.Reencrypt(strings.TrimPrefix(value, Prefix))
+	if err != nil || !changed {
+		return value, false, err
+	}
+	return Prefix + sealed, true, nil
+}
+
+// Index returns the blind index of an email address, which is the same for
+// every spelling that differs only in case or surrounding space. It is nil,
+// stored as NULL, for a nil cipher or an empty email.
+func (c *Cipher) Index(email string) []byte {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if c == nil || email == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(email))
+	return mac.Sum(nil)
+}
+
+// Value returns a query argument storing value sealed
+func (c *Cipher) Value(value string) driver.Valuer {
+	return sealedValue{cipher: c, value: value}
+}
+
+// Field returns a scan destination that opens the value read into dst. NULL
+// reads as an empty string.
+func (c *Cipher) Field(dst *string) sql.Scanner {
+	return openedField{cipher: c, dst: dst}
+}
+
+type sealedValue struct {
+	cipher *Cipher
+	value  string
+}
+
+func (v sealedValue) Value() (driver.Value, error) {
+	return v.cipher.Seal(v.value)
+}
+
+type openedField struct {
+	cipher *Cipher
+	dst    *string
+}
+
+func (f openedField) Scan(src interface{}) error {
+	var value string
+	switch src := src.(type) {
+	case nil:
+	case string:
+		value = src
+	case []byte:
+		value = string(src)
+	default:
+		return fmt.Errorf("cannot read %T as personal data", src)
+	}
+
+	opened, err := f.cipher.Open(value)
+	if err != nil {
+		return err
+	}
+	*f.dst = opened
+	return nil
+}
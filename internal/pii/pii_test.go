@@ -0,0 +1,113 @@
+package pii
+
+import (
+	"testing"
+	"usual_store/internal/keyring"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldSecret = "old-secret-key-of-exactly-32-by!"
+	newSecret = "new-secret-key-of-exactly-32-by!"
+	indexKey  = "blind-index-key-of-at-least-32-bytes"
+)
+
+func testCipher(t *testing.T, spec string) *Cipher {
+	keys, err := keyring.Parse(spec, oldSecret)
+	require.NoError(t, err)
+	c, err := New(keys, []byte(indexKey))
+	require.NoError(t, err)
+	return c
+}
+
+func TestCipher_SealOpen(t *testing.T) {
+	c := testCipher(t, "")
+
+	sealed, err := c.Seal("jane@example.com")
+	require.NoError(t, err)
+	assert.True(t, Sealed(sealed), sealed)
+	assert.NotContains(t, sealed, "jane")
+
+	opened, err := c.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", opened)
+
+	// Rows stored before encryption read as they are
+	opened, err = c.Open("jane@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", opened)
+
+	var off *Cipher
+	plain, err := off.Seal("jane@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", plain)
+	_, err = off.Open(sealed)
+	assert.ErrorIs(t, err, ErrNoCipher)
+}
+
+func TestCipher_Index(t *testing.T) {
+	c := testCipher(t, "")
+	assert.Len(t, c.Index("jane@example.com"), 32)
+	assert.Equal(t, c.Index("jane@example.com"), c.Index(" Jane@Example.com"))
+	assert.NotEqual(t, c.Index("jane@example.com"), c.Index("john@example.com"))
+	assert.Nil(t, c.Index(""))
+
+	var off *Cipher
+	assert.Nil(t, off.Index("jane@example.com"))
+
+	_, err := New(nil, []byte("short"))
+	assert.Error(t, err)
+
+	keys, err := keyring.Parse("", "your-secret-key-change-in-production")
+	require.NoError(t, err)
+	_, err = New(keys, []byte(indexKey))
+	assert.ErrorContains(t, err, "cannot encrypt")
+}
+
+func TestCipher_Reseal(t *testing.T) {
+	before := testCipher(t, "")
+	old, err := before.Seal("Jane")
+	require.NoError(t, err)
+
+	after := testCipher(t, "1:"+newSecret+",0:"+oldSecret)
+	for _, value := range []string{"Jane", old} {
+		resealed, changed, err := after.Reseal(value)
+		require.NoError(t, err)
+		assert.True(t, changed, value)
+		opened, err := after.Open(resealed)
+		require.NoError(t, err)
+		assert.Equal(t, "Jane", opened)
+
+		_, changed, err = after.Reseal(resealed)
+		require.NoError(t, err)
+		assert.False(t, changed)
+	}
+}
+
+func TestCipher_FieldValue(t *testing.T) {
+	c := testCipher(t, "")
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sealed, err := c.Seal("Jane")
+	require.NoError(t, err)
+	mock.ExpectExec("INSERT INTO customers").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT first_name, user_name FROM").
+		WillReturnRows(sqlmock.NewRows([]string{"first_name", "user_name"}).AddRow(sealed, nil))
+
+	_, err = db.Exec("INSERT INTO customers (first_name) VALUES ($1)", c.Value("Jane"))
+	require.NoError(t, err)
+
+	var firstName, userName string
+	err = db.QueryRow("SELECT first_name, user_name FROM t").Scan(c.Field(&firstName), c.Field(&userName))
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", firstName)
+	assert.Equal(t, "", userName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,198 @@
+// Package rbac maps user roles to permissions and guards routes with them.
+// It is shared by the API and the support service. Access is denied by
+// default: unknown roles and permissions a role was not granted are refused.
+package rbac
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Permission allows an action on a kind of resource
+type Permission string
+
+// Permissions checked by the services
+const (
+	PermAccount             Permission = "account:self"
+	PermOrdersRead          Permission = "orders:read"
+	PermOrdersRefund        Permission = "orders:refund"
+	PermOrdersExport        Permission = "orders:export"
+	PermPaymentsCharge      Permission = "payments:charge"
+	PermSubscriptionsCancel Permission = "subscriptions:cancel"
+	PermUsersRead           Permission = "users:read"
+	PermUsersWrite          Permission = "users:write"
+	PermUsersImpersonate    Permission = "users:impersonate"
+	PermCatalogWrite        Permission = "catalog:write"
+	PermPricesManage        Permission = "prices:manage"
+	PermReportsRead         Permission = "reports:read"
+	PermInventoryManage     Permission = "inventory:manage"
+	PermPurchasingManage    Permission = "purchasing:manage"
+	PermShippingManage      Permission = "shipping:manage"
+	PermShipmentsManage     Permission = "shipments:manage"
+	PermReviewsModerate     Permission = "reviews:moderate"
+	PermReturnsManage       Permission = "returns:manage"
+	PermGDPRManage          Permission = "gdpr:manage"
+	PermAPIKeysManage       Permission = "apikeys:manage"
+	PermAuditRead           Permission = "audit:read"
+	PermSupportTicketsRead  Permission = "support:tickets:read"
+	PermSupportTicketAssign Permission = "support:tickets:assign"
+	PermSupportChat         Permission = "support:chat"
+	PermMessagesSend        Permission = "messages:send"
+)
+
+// Roles stored in users.role
+const (
+	RoleSuperAdmin = "super_admin"
+	RoleAdmin      = "admin"
+	RoleSupporter  = "supporter"
+	RoleUser       = "user"
+)
+
+// allPermissions is every permission, granted to administrators
+var allPermissions = []Permission{
+	PermAccount,
+	PermOrdersRead,
+	PermOrdersRefund,
+	PermOrdersExport,
+	PermPaymentsCharge,
+	PermSubscriptionsCancel,
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersImpersonate,
+	PermCatalogWrite,
+	PermPricesManage,
+	PermReportsRead,
+	PermInventoryManage,
+	PermPurchasingManage,
+	PermShippingManage,
+	PermShipmentsManage,
+	PermReviewsModerate,
+	PermReturnsManage,
+	PermGDPRManage,
+	PermAPIKeysManage,
+	PermAuditRead,
+	PermSupportTicketsRead,
+	PermSupportTicketAssign,
+	PermSupportChat,
+	PermMessagesSend,
+}
+
+// rolePermissions lists what each role may do
+var rolePermissions = map[string][]Permission{
+	RoleSuperAdmin: allPermissions,
+	RoleAdmin:      allPermissions,
+	RoleSupporter: {
+		PermAccount,
+		PermOrdersRead,
+		PermUsersImpersonate,
+		PermReviewsModerate,
+		PermReturnsManage,
+		PermSupportTicketsRead,
+		PermSupportTicketAssign,
+		PermSupportChat,
+	},
+	RoleUser: {
+		PermAccount,
+	},
+}
+
+// grants indexes rolePermissions for lookups
+var grants = func() map[string]map[Permission]bool {
+	g := make(map[string]map[Permission]bool, len(rolePermissions))
+	for role, permissions := range rolePermissions {
+		g[role] = make(map[Permission]bool, len(permissions))
+		for _, p := range permissions {
+			g[role][p] = true
+		}
+	}
+	return g
+}()
+
+// Can reports whether role has permission p
+func Can(role string, p Permission) bool {
+	return grants[role][p]
+}
+
+// Valid reports whether p is a permission checked by the services
+func Valid(p Permission) bool {
+	return grants[RoleSuperAdmin][p]
+}
+
+// mfaRoles must sign in with a second factor; they can refund orders, delete
+// users or read customer conversations
+var mfaRoles = map[string]bool{
+	RoleSuperAdmin: true,
+	RoleAdmin:      true,
+	RoleSupporter:  true,
+}
+
+// RequiresMFA reports whether users with role must use two-factor authentication
+func RequiresMFA(role string) bool {
+	return mfaRoles[role]
+}
+
+// Permissions returns the permissions of role, nil for an unknown role
+func Permissions(role string) []Permission {
+	return append([]Permission(nil), rolePermissions[role]...)
+}
+
+// RoleFunc returns the role of the user who made a request, and false when
+// the request is not authenticated
+type RoleFunc func(r *http.Request) (string, bool)
+
+// RequirePermission returns middleware that only lets requests through whose
+// user has permission p. Unauthenticated requests get 401 and users without
+// the permission 403.
+func RequirePermission(p Permission, roleOf RoleFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := roleOf(r)
+			if !ok {
+				deny(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			if !Can(role, p) {
+				deny(w, http.StatusForbidden, "permission "+string(p)+" required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ScopeFunc returns the scopes a request is limited to, as for API keys, and
+// false when it is only limited by the role of its user
+type ScopeFunc func(r *http.Request) ([]Permission, bool)
+
+// RequireScope returns middleware that refuses requests limited to scopes
+// without p with 403. Combine it with RequirePermission, which checks the role.
+func RequireScope(p Permission, scopesOf ScopeFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, limited := scopesOf(r)
+			if limited && !hasScope(scopes, p) {
+				deny(w, http.StatusForbidden, "scope "+string(p)+" required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasScope(scopes []Permission, p Permission) bool {
+	for _, s := range scopes {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+func deny(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{true, message})
+}
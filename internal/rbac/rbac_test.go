@@ -0,0 +1,148 @@
+package rbac
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleSuperAdmin, PermGDPRManage, true},
+		{RoleAdmin, PermOrdersRefund, true},
+		{RoleAdmin, PermSupportChat, true},
+		{RoleSupporter, PermSupportTicketAssign, true},
+		{RoleSupporter, PermReturnsManage, true},
+		{RoleSupporter, PermOrdersRefund, false},
+		{RoleSupporter, PermUsersWrite, false},
+		{RoleSupporter, PermAuditRead, false},
+		{RoleSupporter, PermMessagesSend, false},
+		{RoleAdmin, PermMessagesSend, true},
+		{RoleSupporter, PermUsersImpersonate, true},
+		{RoleUser, PermAccount, true},
+		{RoleUser, PermOrdersRead, false},
+		{RoleUser, PermSupportTicketsRead, false},
+		{RoleUser, PermUsersImpersonate, false},
+		{"", PermAccount, false},
+		{"root", PermAccount, false},
+		{RoleAdmin, Permission("widgets:launch"), false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Can(tt.role, tt.perm), "%s %s", tt.role, tt.perm)
+	}
+}
+
+func TestRequiresMFA(t *testing.T) {
+	assert.True(t, RequiresMFA(RoleSuperAdmin))
+	assert.True(t, RequiresMFA(RoleAdmin))
+	assert.True(t, RequiresMFA(RoleSupporter))
+	assert.False(t, RequiresMFA(RoleUser))
+	assert.False(t, RequiresMFA(""))
+}
+
+func TestAdminsHaveEveryPermission(t *testing.T) {
+	for _, role := range []string{RoleSuperAdmin, RoleAdmin} {
+		for _, p := range allPermissions {
+			assert.True(t, Can(role, p), "%s %s", role, p)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	assert.Equal(t, []Permission{PermAccount}, Permissions(RoleUser))
+	assert.Nil(t, Permissions("root"))
+
+	// Callers get a copy and cannot change the grants
+	p := Permissions(RoleUser)
+	p[0] = PermGDPRManage
+	assert.False(t, Can(RoleUser, PermGDPRManage))
+}
+
+func TestRequirePermission(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name        string
+		role        string
+		authed      bool
+		wantStatus  int
+		wantMessage string
+	}{
+		{"unauthenticated", "", false, http.StatusUnauthorized, "authentication required"},
+		{"missing permission", RoleUser, true, http.StatusForbidden, "permission orders:refund required"},
+		{"unknown role", "root", true, http.StatusForbidden, "permission orders:refund required"},
+		{"allowed", RoleAdmin, true, http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequirePermission(PermOrdersRefund, func(r *http.Request) (string, bool) {
+				return tt.role, tt.authed
+			})(next)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/refund", nil))
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantMessage == "" {
+				return
+			}
+			var body struct {
+				Error   bool   `json:"error"`
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.True(t, body.Error)
+			assert.Equal(t, tt.wantMessage, body.Message)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(PermOrdersRead))
+	assert.True(t, Valid(PermAPIKeysManage))
+	assert.False(t, Valid(Permission("widgets:launch")))
+	assert.False(t, Valid(""))
+}
+
+func TestRequireScope(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		scopes     []Permission
+		limited    bool
+		wantStatus int
+	}{
+		{"not limited", nil, false, http.StatusNoContent},
+		{"scope granted", []Permission{PermOrdersRead, PermOrdersExport}, true, http.StatusNoContent},
+		{"scope missing", []Permission{PermOrdersRead}, true, http.StatusForbidden},
+		{"no scopes", nil, true, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireScope(PermOrdersExport, func(r *http.Request) ([]Permission, bool) {
+				return tt.scopes, tt.limited
+			})(next)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/exports", nil))
+			assert.Equal(t, tt.wantStatus, rr.Code)
+		})
+	}
+}
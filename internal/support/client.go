@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"time"
+	"usual_store/internal/pii"
 
 	"github.com/gorilla/websocket"
 )
@@ -33,6 +34,7 @@ type Client struct {
 	UserID     *int
 	UserName   string
 	DB         *sql.DB
+	PII        *pii.Cipher
 }
 
 // IncomingMessage represents a message received from the client
@@ -150,7 +152,7 @@ func (c *Client) WritePump() {
 // handleIncomingMessage processes incoming chat messages
 func (c *Client) handleIncomingMessage(messageText string) {
 	// Get the ticket
-	ticket, err := GetTicketBySessionID(c.DB, c.SessionID)
+	ticket, err := GetTicketBySessionID(c.DB, c.PII, c.SessionID)
 	if err != nil {
 		log.Printf("Error getting ticket: %v", err)
 		return
@@ -191,7 +193,7 @@ func (c *Client) handleTypingIndicator() {
 // handleReadReceipt marks messages as read
 func (c *Client) handleReadReceipt() {
 	// Get the ticket
-	ticket, err := GetTicketBySessionID(c.DB, c.SessionID)
+	ticket, err := GetTicketBySessionID(c.DB, c.PII, c.SessionID)
 	if err != nil {
 		log.Printf("Error getting ticket: %v", err)
 		return
@@ -3,6 +3,7 @@ package support
 import (
 	"database/sql"
 	"time"
+	"usual_store/internal/pii"
 )
 
 // Ticket represents a support ticket
@@ -46,11 +47,12 @@ type Session struct {
 	SupporterConnected bool       `json:"supporter_connected"`
 }
 
-// CreateTicket creates a new support ticket
-func CreateTicket(db *sql.DB, ticket *Ticket) error {
+// CreateTicket creates a new support ticket, with the email and name of the
+// user sealed by cipher
+func CreateTicket(db *sql.DB, cipher *pii.Cipher, ticket *Ticket) error {
 	query := `
-		INSERT INTO support_tickets (user_id, subject, status, priority, session_id, user_email, user_name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO support_tickets (user_id, subject, status, priority, session_id, user_email, user_name, user_email_index, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id, created_at, updated_at
 	`
 	err := db.QueryRow(
@@ -60,8 +62,9 @@ func CreateTicket(db *sql.DB, ticket *Ticket) error {
 		ticket.Status,
 		ticket.Priority,
 		ticket.SessionID,
-		ticket.UserEmail,
-		ticket.UserName,
+		cipher.Value(ticket.UserEmail),
+		cipher.Value(ticket.UserName),
+		cipher.Index(ticket.UserEmail),
 		time.Now(),
 		time.Now(),
 	).Scan(&ticket.ID, &ticket.CreatedAt, &ticket.UpdatedAt)
@@ -69,7 +72,7 @@ func CreateTicket(db *sql.DB, ticket *Ticket) error {
 }
 
 // GetTicketBySessionID retrieves a ticket by session ID
-func GetTicketBySessionID(db *sql.DB, sessionID string) (*Ticket, error) {
+func GetTicketBySessionID(db *sql.DB, cipher *pii.Cipher, sessionID string) (*Ticket, error) {
 	query := `
 		SELECT id, user_id, supporter_id, subject, status, priority, session_id, user_email, user_name, created_at, updated_at, closed_at
 		FROM support_tickets
@@ -84,8 +87,8 @@ func GetTicketBySessionID(db *sql.DB, sessionID string) (*Ticket, error) {
 		&ticket.Status,
 		&ticket.Priority,
 		&ticket.SessionID,
-		&ticket.UserEmail,
-		&ticket.UserName,
+		cipher.Field(&ticket.UserEmail),
+		cipher.Field(&ticket.UserName),
 		&ticket.CreatedAt,
 		&ticket.UpdatedAt,
 		&ticket.ClosedAt,
@@ -97,7 +100,7 @@ func GetTicketBySessionID(db *sql.DB, sessionID string) (*Ticket, error) {
 }
 
 // GetTicketByID retrieves a ticket by ID
-func GetTicketByID(db *sql.DB, ticketID int) (*Ticket, error) {
+func GetTicketByID(db *sql.DB, cipher *pii.Cipher, ticketID int) (*Ticket, error) {
 	query := `
 		SELECT id, user_id, supporter_id, subject, status, priority, session_id, user_email, user_name, created_at, updated_at, closed_at
 		FROM support_tickets
@@ -112,8 +115,8 @@ func GetTicketByID(db *sql.DB, ticketID int) (*Ticket, error) {
 		&ticket.Status,
 		&ticket.Priority,
 		&ticket.SessionID,
-		&ticket.UserEmail,
-		&ticket.UserName,
+		cipher.Field(&ticket.UserEmail),
+		cipher.Field(&ticket.UserName),
 		&ticket.CreatedAt,
 		&ticket.UpdatedAt,
 		&ticket.ClosedAt,
@@ -136,7 +139,7 @@ func UpdateTicketStatus(db *sql.DB, ticketID int, status string, supporterID *in
 }
 
 // GetAllOpenTickets retrieves all open tickets
-func GetAllOpenTickets(db *sql.DB) ([]*Ticket, error) {
+func GetAllOpenTickets(db *sql.DB, cipher *pii.Cipher) ([]*Ticket, error) {
 	query := `
 		SELECT id, user_id, supporter_id, subject, status, priority, session_id, user_email, user_name, created_at, updated_at, closed_at
 		FROM support_tickets
@@ -160,8 +163,8 @@ func GetAllOpenTickets(db *sql.DB) ([]*Ticket, error) {
 			&ticket.Status,
 			&ticket.Priority,
 			&ticket.SessionID,
-			&ticket.UserEmail,
-			&ticket.UserName,
+			cipher.Field(&ticket.UserEmail),
+			cipher.Field(&ticket.UserName),
 			&ticket.CreatedAt,
 			&ticket.UpdatedAt,
 			&ticket.ClosedAt,
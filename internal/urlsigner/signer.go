@@ -3,41 +3,86 @@ package urlsigner
 import (
 	"fmt"
 	goalone "github.com/bwmarrin/go-alone"
+	"net/url"
 	"strings"
 	"time"
+	"usual_store/internal/keyring"
 )
 
+// Signer signs URLs with Keys when set, or else with Secret. URLs signed with
+// a keyring carry the ID of the key in a kid parameter, and verify as long as
+// that key is in the ring; URLs without one verify with the legacy key.
 type Signer struct {
 	Secret []byte
+	Keys   *keyring.Keyring
 }
 
 func (s Signer) GenerateTokenFromString(data string) string {
 	var urlToSign string
-	crypt := goalone.New(s.Secret, goalone.Timestamp)
-
-	if strings.Contains(data, "?") {
-		urlToSign = fmt.Sprintf("%s&hash=", data)
-	} else {
-		urlToSign = fmt.Sprintf("%s?hash=", data)
+	secret := s.Secret
+	if s.Keys != nil {
+		key := s.Keys.Active()
+		secret = key.Secret
+		data = addParam(data, "kid="+url.QueryEscape(key.ID))
 	}
+	crypt := goalone.New(secret, goalone.Timestamp)
+
+	urlToSign = addParam(data, "hash=")
 	tokenBytes := crypt.Sign([]byte(urlToSign))
 	token := string(tokenBytes)
 	return token
 }
 
+// VerifyToken reports whether token was signed by a key of the signer
 func (s Signer) VerifyToken(token string) bool {
-	crypt := goalone.New(s.Secret, goalone.Timestamp)
-	_, err := crypt.Unsign([]byte(token))
+	return s.Verify(token) == nil
+}
+
+// Verify returns why token was not signed by a key of the signer, or nil
+func (s Signer) Verify(token string) error {
+	secret, err := s.secret(token)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return err
 	}
-	return true
+	crypt := goalone.New(secret, goalone.Timestamp)
+	_, err = crypt.Unsign([]byte(token))
+	return err
 }
 
+// Expired reports whether token was signed more than minutesUntilExpire
+// minutes ago. Tokens of a key that is not in the ring count as expired.
 func (s Signer) Expired(token string, minutesUntilExpire int) bool {
-	crypt := goalone.New(s.Secret, goalone.Timestamp)
+	secret, err := s.secret(token)
+	if err != nil {
+		return true
+	}
+	crypt := goalone.New(secret, goalone.Timestamp)
 	ts := crypt.Parse([]byte(token))
 
 	return time.Since(ts.Timestamp) > time.Duration(minutesUntilExpire)*time.Minute
 }
+
+// secret returns the secret token was signed with, from the key its kid
+// parameter names
+func (s Signer) secret(token string) ([]byte, error) {
+	if s.Keys == nil {
+		return s.Secret, nil
+	}
+	u, err := url.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.Keys.Key(u.Query().Get("kid"))
+	if err != nil {
+		return nil, err
+	}
+	return key.Secret, nil
+}
+
+// addParam appends a query parameter to link
+func addParam(link, param string) string {
+	if strings.Contains(link, "?") {
+		return fmt.Sprintf("%s&%s", link, param)
+	}
+	return fmt.Sprintf("%s?%s", link, param)
+}
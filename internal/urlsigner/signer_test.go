@@ -0,0 +1,46 @@
+package urlsigner
+
+import (
+	"strings"
+	"testing"
+	"usual_store/internal/keyring"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldSecret = "old-secret-key-of-exactly-32-by!"
+	newSecret = "new-secret-key-of-exactly-32-by!"
+)
+
+func TestSigner_Rotation(t *testing.T) {
+	before, err := keyring.Parse("", oldSecret)
+	require.NoError(t, err)
+	after, err := keyring.Parse("1:"+newSecret+",0:"+oldSecret, "")
+	require.NoError(t, err)
+	retired, err := keyring.Parse("1:"+newSecret, "")
+	require.NoError(t, err)
+
+	legacy := Signer{Secret: []byte(oldSecret)}.GenerateTokenFromString("https://store.example.com/reset-password?email=jane%40example.com")
+	old := Signer{Keys: before}.GenerateTokenFromString("https://store.example.com/reset-password?email=jane%40example.com")
+	current := Signer{Keys: after}.GenerateTokenFromString("https://store.example.com/reset-password?email=jane%40example.com")
+	assert.Contains(t, old, "&kid=0&hash=")
+	assert.Contains(t, current, "&kid=1&hash=")
+
+	// Links signed before the rotation still verify while their key is in the ring
+	signer := Signer{Keys: after}
+	assert.True(t, signer.VerifyToken(legacy))
+	assert.True(t, signer.VerifyToken(old))
+	assert.True(t, signer.VerifyToken(current))
+	assert.False(t, signer.Expired(current, 15))
+
+	signer = Signer{Keys: retired}
+	assert.False(t, signer.VerifyToken(old))
+	assert.ErrorIs(t, signer.Verify(old), keyring.ErrUnknownKey)
+	assert.True(t, signer.Expired(old, 15))
+	assert.True(t, signer.VerifyToken(current))
+
+	// The key ID is signed too
+	assert.False(t, Signer{Keys: after}.VerifyToken(strings.Replace(current, "kid=1", "kid=0", 1)))
+}
@@ -0,0 +1,4 @@
+-- Drop reviews table
+DROP INDEX IF EXISTS idx_reviews_status;
+DROP INDEX IF EXISTS idx_reviews_widget_id;
+DROP TABLE IF EXISTS reviews;
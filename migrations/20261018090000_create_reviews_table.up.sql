@@ -0,0 +1,23 @@
+-- Create reviews table for customer product ratings
+CREATE TABLE IF NOT EXISTS reviews (
+    id SERIAL PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    customer_email VARCHAR(255) NOT NULL,
+    reviewer_name VARCHAR(255) NOT NULL,
+    rating INTEGER NOT NULL CHECK (rating >= 1 AND rating <= 5),
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    moderated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    moderated_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (widget_id, customer_email)
+);
+
+CREATE INDEX idx_reviews_widget_id ON reviews(widget_id);
+CREATE INDEX idx_reviews_status ON reviews(status);
+
+COMMENT ON TABLE reviews IS 'Verified-purchase product reviews';
+COMMENT ON COLUMN reviews.status IS 'Moderation state: pending, approved, rejected';
@@ -0,0 +1,6 @@
+-- Drop wishlist tables
+DROP INDEX IF EXISTS idx_wishlist_items_widget_id;
+DROP INDEX IF EXISTS idx_wishlists_session_id;
+DROP INDEX IF EXISTS idx_wishlists_user_id;
+DROP TABLE IF EXISTS wishlist_items;
+DROP TABLE IF EXISTS wishlists;
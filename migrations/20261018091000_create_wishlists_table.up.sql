@@ -0,0 +1,27 @@
+-- Create wishlists owned by a user or by an anonymous session
+CREATE TABLE IF NOT EXISTS wishlists (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    session_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL DEFAULT 'My Wishlist',
+    notify_email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (user_id IS NOT NULL OR session_id IS NOT NULL)
+);
+
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    id SERIAL PRIMARY KEY,
+    wishlist_id INTEGER NOT NULL REFERENCES wishlists(id) ON DELETE CASCADE,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    price_when_added INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (wishlist_id, widget_id)
+);
+
+CREATE INDEX idx_wishlists_user_id ON wishlists(user_id);
+CREATE INDEX idx_wishlists_session_id ON wishlists(session_id);
+CREATE INDEX idx_wishlist_items_widget_id ON wishlist_items(widget_id);
+
+COMMENT ON COLUMN wishlists.session_id IS 'Anonymous session owning the list until it is merged on login';
+COMMENT ON COLUMN wishlists.notify_email IS 'Where price-drop and back-in-stock emails go; falls back to the user email';
@@ -0,0 +1,6 @@
+-- Drop price schedules and history
+DROP INDEX IF EXISTS idx_price_schedules_widget_id;
+DROP INDEX IF EXISTS idx_price_schedules_status_starts_at;
+DROP TABLE IF EXISTS price_schedules;
+DROP INDEX IF EXISTS idx_widget_price_history_widget_period;
+DROP TABLE IF EXISTS widget_price_history;
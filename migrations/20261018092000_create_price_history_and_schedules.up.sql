@@ -0,0 +1,38 @@
+-- Create price history: one row per period a widget price was in effect
+CREATE TABLE IF NOT EXISTS widget_price_history (
+    id SERIAL PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    price INTEGER NOT NULL,
+    effective_from TIMESTAMP NOT NULL,
+    effective_to TIMESTAMP,
+    reason VARCHAR(50) NOT NULL DEFAULT 'manual', -- initial, manual, scheduled, schedule_ended
+    schedule_id INTEGER,
+    changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_widget_price_history_widget_period ON widget_price_history(widget_id, effective_from);
+
+-- The current price of every widget opens its history
+INSERT INTO widget_price_history (widget_id, price, effective_from, reason)
+SELECT id, price, COALESCE(created_at, NOW()), 'initial' FROM widgets;
+
+-- Create scheduled price changes
+CREATE TABLE IF NOT EXISTS price_schedules (
+    id SERIAL PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    price INTEGER NOT NULL CHECK (price > 0),
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    previous_price INTEGER,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'completed', 'cancelled')),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_price_schedules_status_starts_at ON price_schedules(status, starts_at);
+CREATE INDEX idx_price_schedules_widget_id ON price_schedules(widget_id);
+
+COMMENT ON COLUMN price_schedules.previous_price IS 'Price restored when the schedule ends';
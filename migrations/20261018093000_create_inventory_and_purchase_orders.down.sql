@@ -0,0 +1,7 @@
+-- Drop inventory tracking and purchase orders
+DROP TABLE IF EXISTS low_stock_alerts;
+DROP TABLE IF EXISTS stock_movements;
+DROP TABLE IF EXISTS purchase_order_items;
+DROP TABLE IF EXISTS purchase_orders;
+DROP TABLE IF EXISTS widget_stock_settings;
+DROP TABLE IF EXISTS suppliers;
@@ -0,0 +1,76 @@
+-- Create suppliers
+CREATE TABLE IF NOT EXISTS suppliers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    phone VARCHAR(50) NOT NULL DEFAULT '',
+    lead_time_days INTEGER NOT NULL DEFAULT 0 CHECK (lead_time_days >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Per-widget low-stock threshold and restock configuration
+CREATE TABLE IF NOT EXISTS widget_stock_settings (
+    widget_id INTEGER PRIMARY KEY REFERENCES widgets(id) ON DELETE CASCADE,
+    low_stock_threshold INTEGER NOT NULL DEFAULT 0 CHECK (low_stock_threshold >= 0),
+    reorder_quantity INTEGER NOT NULL DEFAULT 0 CHECK (reorder_quantity >= 0),
+    supplier_id INTEGER REFERENCES suppliers(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create purchase orders
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id SERIAL PRIMARY KEY,
+    supplier_id INTEGER NOT NULL REFERENCES suppliers(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'ordered', 'partially_received', 'received', 'cancelled')),
+    notes TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL, -- NULL when drafted automatically
+    ordered_at TIMESTAMP,
+    received_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_purchase_orders_status ON purchase_orders(status);
+
+CREATE TABLE IF NOT EXISTS purchase_order_items (
+    id SERIAL PRIMARY KEY,
+    purchase_order_id INTEGER NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id),
+    quantity_ordered INTEGER NOT NULL CHECK (quantity_ordered > 0),
+    quantity_received INTEGER NOT NULL DEFAULT 0 CHECK (quantity_received >= 0 AND quantity_received <= quantity_ordered),
+    unit_cost INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (purchase_order_id, widget_id)
+);
+
+CREATE INDEX idx_purchase_order_items_widget_id ON purchase_order_items(widget_id);
+
+-- Append-only ledger of every inventory change
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id SERIAL PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id),
+    quantity_change INTEGER NOT NULL,
+    inventory_before INTEGER NOT NULL,
+    inventory_after INTEGER NOT NULL,
+    reason VARCHAR(50) NOT NULL, -- sale, purchase_order_receipt, manual_adjustment
+    reference_type VARCHAR(50) NOT NULL DEFAULT '',
+    reference_id INTEGER,
+    note TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_stock_movements_widget_id ON stock_movements(widget_id, created_at);
+
+-- Low-stock alerts waiting to be sent to admins
+CREATE TABLE IF NOT EXISTS low_stock_alerts (
+    id SERIAL PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    inventory_level INTEGER NOT NULL,
+    threshold INTEGER NOT NULL,
+    purchase_order_id INTEGER REFERENCES purchase_orders(id) ON DELETE SET NULL,
+    notified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_low_stock_alerts_pending ON low_stock_alerts(created_at) WHERE notified_at IS NULL;
@@ -0,0 +1,9 @@
+-- Drop shipping and fulfilment data
+DROP TABLE IF EXISTS shipments;
+DROP TABLE IF EXISTS order_addresses;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_method_id;
+DROP TABLE IF EXISTS shipping_rates;
+DROP TABLE IF EXISTS shipping_methods;
+DROP TABLE IF EXISTS shipping_zones;
+ALTER TABLE widgets DROP COLUMN IF EXISTS weight_grams;
@@ -0,0 +1,70 @@
+-- Widget weight, used to pick shipping rates
+ALTER TABLE widgets ADD COLUMN IF NOT EXISTS weight_grams INTEGER NOT NULL DEFAULT 0;
+
+-- Shipping zones group destination countries (ISO 3166-1 alpha-2).
+-- A zone without countries covers every country no other zone lists.
+CREATE TABLE IF NOT EXISTS shipping_zones (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    countries VARCHAR(2)[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS shipping_methods (
+    id SERIAL PRIMARY KEY,
+    zone_id INTEGER NOT NULL REFERENCES shipping_zones(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    carrier VARCHAR(100) NOT NULL DEFAULT '',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Rate table: the cheapest rate matching the parcel weight and order total applies
+CREATE TABLE IF NOT EXISTS shipping_rates (
+    id SERIAL PRIMARY KEY,
+    shipping_method_id INTEGER NOT NULL REFERENCES shipping_methods(id) ON DELETE CASCADE,
+    min_weight_grams INTEGER NOT NULL DEFAULT 0,
+    max_weight_grams INTEGER, -- NULL means no upper bound
+    min_order_total INTEGER NOT NULL DEFAULT 0,
+    max_order_total INTEGER, -- NULL means no upper bound
+    price INTEGER NOT NULL CHECK (price >= 0)
+);
+
+CREATE INDEX idx_shipping_rates_method_id ON shipping_rates(shipping_method_id);
+
+-- Shipping on orders; amount already includes shipping_amount
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_method_id INTEGER REFERENCES shipping_methods(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_amount INTEGER NOT NULL DEFAULT 0;
+
+-- Billing and shipping addresses captured at checkout
+CREATE TABLE IF NOT EXISTS order_addresses (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    address_type VARCHAR(20) NOT NULL CHECK (address_type IN ('billing', 'shipping')),
+    name VARCHAR(255) NOT NULL,
+    line1 VARCHAR(255) NOT NULL,
+    line2 VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    region VARCHAR(100) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    phone VARCHAR(50) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (order_id, address_type)
+);
+
+-- Shipments sent for an order
+CREATE TABLE IF NOT EXISTS shipments (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    carrier VARCHAR(100) NOT NULL,
+    tracking_number VARCHAR(255) NOT NULL,
+    tracking_url VARCHAR(512) NOT NULL DEFAULT '',
+    shipped_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_shipments_order_id ON shipments(order_id);
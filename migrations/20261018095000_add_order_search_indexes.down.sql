@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_transactions_last_four;
+DROP INDEX IF EXISTS idx_orders_status_id;
+DROP INDEX IF EXISTS idx_orders_amount_id;
+DROP INDEX IF EXISTS idx_orders_created_at_id;
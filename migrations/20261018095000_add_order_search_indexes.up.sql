@@ -0,0 +1,5 @@
+-- Keyset pagination of the admin order search sorts on (column, id)
+CREATE INDEX IF NOT EXISTS idx_orders_created_at_id ON orders(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_orders_amount_id ON orders(amount, id);
+CREATE INDEX IF NOT EXISTS idx_orders_status_id ON orders(status_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_last_four ON transactions(last_four);
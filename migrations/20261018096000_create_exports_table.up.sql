@@ -0,0 +1,17 @@
+-- Asynchronous data exports requested by admins; files live in the API's export directory
+CREATE TABLE IF NOT EXISTS exports (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('orders', 'transactions', 'customers')),
+    format VARCHAR(10) NOT NULL CHECK (format IN ('csv', 'json', 'xlsx')),
+    filters JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    file_name VARCHAR(255) NOT NULL DEFAULT '',
+    row_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_exports_created_at ON exports(created_at);
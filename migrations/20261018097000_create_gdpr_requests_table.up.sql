@@ -0,0 +1,14 @@
+-- Audit trail of data subject access and erasure requests. The subject is
+-- identified by the SHA-256 of the lower-cased email so the trail itself
+-- holds no personal data after an erasure.
+CREATE TABLE IF NOT EXISTS gdpr_requests (
+    id SERIAL PRIMARY KEY,
+    request_type VARCHAR(20) NOT NULL CHECK (request_type IN ('access', 'erasure')),
+    subject_hash CHAR(64) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    summary JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_gdpr_requests_subject_hash ON gdpr_requests(subject_hash);
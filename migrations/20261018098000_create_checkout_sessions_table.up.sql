@@ -0,0 +1,26 @@
+-- Checkouts started with a payment intent, to find and recover abandoned ones
+CREATE TABLE IF NOT EXISTS checkout_sessions (
+    id SERIAL PRIMARY KEY,
+    payment_intent VARCHAR(255) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    first_name VARCHAR(255) NOT NULL DEFAULT '',
+    widget_id INTEGER NOT NULL REFERENCES widgets(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL DEFAULT 1,
+    amount INTEGER NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'completed')),
+    order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+    reminder_sent_at TIMESTAMP,
+    resumed_at TIMESTAMP,
+    recovered_order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+    recovered_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_checkout_sessions_open ON checkout_sessions(created_at) WHERE status = 'open';
+CREATE INDEX idx_checkout_sessions_email_widget ON checkout_sessions(LOWER(email), widget_id);
+
+COMMENT ON COLUMN checkout_sessions.reminder_sent_at IS 'When the abandoned checkout recovery email was sent';
+COMMENT ON COLUMN checkout_sessions.recovered_order_id IS 'Order placed for the same email and widget after the recovery email';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS store_credits;
+DROP TABLE IF EXISTS returns;
+UPDATE orders SET status_id = 1 WHERE status_id IN (4, 5, 6, 7);
+DELETE FROM statuses WHERE id IN (4, 5, 6, 7);
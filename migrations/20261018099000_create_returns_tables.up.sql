@@ -0,0 +1,42 @@
+-- Order states of the return workflow
+INSERT INTO statuses (id, name) VALUES (4, 'Return requested'), (5, 'Return approved'), (6, 'Return received'), (7, 'Returned')
+    ON CONFLICT (id) DO NOTHING;
+SELECT setval('statuses_id_seq', GREATEST((SELECT MAX(id) FROM statuses), 1));
+
+-- Return merchandise authorisations
+CREATE TABLE IF NOT EXISTS returns (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    reason VARCHAR(50) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'requested'
+        CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'completed')),
+    outcome VARCHAR(20) CHECK (outcome IN ('refund', 'store_credit', 'replacement')),
+    refund_amount INTEGER NOT NULL DEFAULT 0,
+    credit_amount INTEGER NOT NULL DEFAULT 0,
+    replacement_order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+    decision_note TEXT NOT NULL DEFAULT '',
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    handled_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMP,
+    received_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_returns_order_id ON returns(order_id);
+CREATE INDEX idx_returns_status ON returns(status);
+
+-- Store credit ledger; a customer's balance is the sum of their entries
+CREATE TABLE IF NOT EXISTS store_credits (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    return_id INTEGER REFERENCES returns(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_store_credits_customer_id ON store_credits(customer_id);
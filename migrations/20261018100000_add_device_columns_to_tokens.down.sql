@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_tokens_user_id;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS last_used_at,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip_address,
+    DROP COLUMN IF EXISTS device;
+
+-- Keep only the newest token of each user so the unique email constraint can return
+DELETE FROM tokens t
+    USING tokens newer
+    WHERE newer.email = t.email AND newer.id > t.id;
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_email_key UNIQUE (email);
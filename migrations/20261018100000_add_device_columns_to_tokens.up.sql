@@ -0,0 +1,10 @@
+-- A user may hold several tokens, one per signed-in device
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_email_key;
+
+ALTER TABLE tokens
+    ADD COLUMN device       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN ip_address   VARCHAR(45)  NOT NULL DEFAULT '',
+    ADD COLUMN user_agent   TEXT         NOT NULL DEFAULT '',
+    ADD COLUMN last_used_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id ON tokens (user_id);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_tokens_family_id;
+
+DELETE FROM tokens WHERE scope <> 'authentication';
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS used_at,
+    DROP COLUMN IF EXISTS family_id,
+    DROP COLUMN IF EXISTS scope;
@@ -0,0 +1,12 @@
+-- Refresh tokens live next to access tokens. Every sign-in starts a family;
+-- rotating a refresh token marks it used and issues the next pair in the same
+-- family, so a used refresh token presented again revokes the whole family.
+ALTER TABLE tokens
+    ADD COLUMN scope     VARCHAR(20) NOT NULL DEFAULT 'authentication',
+    ADD COLUMN family_id VARCHAR(36) NOT NULL DEFAULT '',
+    ADD COLUMN used_at   TIMESTAMP;
+
+-- Tokens issued before refresh tokens each form a family of their own
+UPDATE tokens SET family_id = 'legacy-' || id WHERE family_id = '';
+
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens (family_id);
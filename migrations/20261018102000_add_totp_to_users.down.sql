@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+DELETE FROM tokens WHERE scope = 'mfa_pending';
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,18 @@
+-- Optional TOTP two-factor authentication. The secret is encrypted by the
+-- application; totp_last_step is the time step of the last accepted code, so
+-- that a code cannot be used twice.
+ALTER TABLE users
+    ADD COLUMN totp_secret    TEXT    NOT NULL DEFAULT '',
+    ADD COLUMN totp_enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT  NOT NULL DEFAULT 0;
+
+-- Single-use codes for signing in without the authenticator app, stored hashed
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash  BYTEA   NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
@@ -0,0 +1,20 @@
+-- Consecutive failed sign-ins of an account, reset by a successful sign-in
+-- or an unlock. Reaching the limit locks the account until locked_until.
+ALTER TABLE users
+    ADD COLUMN failed_logins        INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_failed_login_at TIMESTAMP,
+    ADD COLUMN locked_until         TIMESTAMP;
+
+-- Every sign-in attempt, for throttling by IP address and spotting attacks
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id         SERIAL PRIMARY KEY,
+    email      VARCHAR(255) NOT NULL,
+    user_id    INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    ip_address VARCHAR(45)  NOT NULL,
+    user_agent TEXT         NOT NULL DEFAULT '',
+    succeeded  BOOLEAN      NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_address ON login_attempts (ip_address, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id, created_at);
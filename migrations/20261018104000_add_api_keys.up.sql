@@ -0,0 +1,19 @@
+-- Long-lived keys for machine-to-machine clients. A key acts as the admin who
+-- created it, limited to its scopes. Only the SHA-256 hash of the key is kept.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER      NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         VARCHAR(255) NOT NULL,
+    prefix       VARCHAR(16)  NOT NULL,
+    key_hash     BYTEA        NOT NULL UNIQUE,
+    scopes       TEXT[]       NOT NULL,
+    allowed_ips  TEXT[]       NOT NULL DEFAULT '{}',
+    rate_limit   INTEGER      NOT NULL,
+    expires_at   TIMESTAMP    NOT NULL,
+    last_used_at TIMESTAMP,
+    last_used_ip VARCHAR(45),
+    revoked_at   TIMESTAMP,
+    created_at   TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,22 @@
+-- Single sign-on with the company identity provider. An identity links the
+-- provider's subject to a user, created on their first sign-in.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER      NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer        VARCHAR(255) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMP    NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP    NOT NULL DEFAULT NOW(),
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- Sign-ins sent to the provider and not back yet, by the hash of their state
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state_hash    BYTEA        PRIMARY KEY,
+    nonce         VARCHAR(64)  NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    redirect_uri  TEXT         NOT NULL,
+    expires_at    TIMESTAMP    NOT NULL
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_users_status;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS link_sent_at,
+    DROP COLUMN IF EXISTS invited_by,
+    DROP COLUMN IF EXISTS email_verified_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,15 @@
+-- New users start pending until they follow the link emailed to verify
+-- their address. Invited users set their own password from the link of their
+-- invitation. link_sent_at identifies the latest link, so a resent one
+-- replaces the one before.
+ALTER TABLE users
+    ADD COLUMN status            VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'invited', 'active')),
+    ADD COLUMN email_verified_at TIMESTAMP,
+    ADD COLUMN invited_by        INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN link_sent_at      TIMESTAMP;
+
+-- Existing users stay active
+UPDATE users SET email_verified_at = created_at;
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status) WHERE status <> 'active';
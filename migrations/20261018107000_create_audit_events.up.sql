@@ -0,0 +1,39 @@
+-- Who did what to whom for security-sensitive admin actions. Rows are never
+-- changed: each holds the SHA-256 of the row before it and of itself, so
+-- editing or removing a row breaks the chain from there on. actor_id has no
+-- foreign key, events outlive the users they mention.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id          BIGSERIAL PRIMARY KEY,
+    actor_id    INTEGER,
+    actor       VARCHAR(255) NOT NULL,
+    action      VARCHAR(64)  NOT NULL,
+    target_type VARCHAR(64)  NOT NULL,
+    target_id   VARCHAR(64)  NOT NULL,
+    before      JSONB        NOT NULL DEFAULT '{}',
+    after       JSONB        NOT NULL DEFAULT '{}',
+    ip_address  VARCHAR(45)  NOT NULL DEFAULT '',
+    trace_id    VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at  TIMESTAMP    NOT NULL,
+    prev_hash   BYTEA        NOT NULL,
+    hash        BYTEA        NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+
+-- Append-only, even for the application's own database user
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_change
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,17 @@
+-- Staff acting as a customer with a short-lived, read-only token, while the
+-- customer has a support ticket open. Only the SHA-256 hash of the token is
+-- kept.
+CREATE TABLE IF NOT EXISTS impersonations (
+    id         SERIAL PRIMARY KEY,
+    token_hash BYTEA     NOT NULL UNIQUE,
+    user_id    INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id   INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ticket_id  INTEGER   NOT NULL REFERENCES support_tickets(id) ON DELETE CASCADE,
+    reason     TEXT      NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    ended_at   TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonations_actor_id ON impersonations (actor_id);
+CREATE INDEX IF NOT EXISTS idx_impersonations_ticket_id ON impersonations (ticket_id);
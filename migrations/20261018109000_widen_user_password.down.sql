@@ -0,0 +1,3 @@
+-- Fails while Argon2id hashes are stored: their users must reset their
+-- passwords with a bcrypt build first.
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(60);
@@ -0,0 +1,3 @@
+-- Argon2id hashes, with their algorithm and parameters, are longer than the
+-- 60 characters of a bcrypt hash. bcrypt hashes stay until their users sign in.
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(255);
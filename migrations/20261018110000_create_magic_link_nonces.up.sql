@@ -0,0 +1,11 @@
+-- Nonces of the magic sign-in links that were already used. A signed link
+-- stays valid until it expires, so its nonce is kept until then to stop it
+-- from being used twice.
+CREATE TABLE IF NOT EXISTS magic_link_nonces (
+    nonce       VARCHAR(64) PRIMARY KEY,
+    user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    consumed_at TIMESTAMP   NOT NULL DEFAULT NOW(),
+    expires_at  TIMESTAMP   NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_link_nonces_expires_at ON magic_link_nonces (expires_at);
@@ -0,0 +1,22 @@
+-- Fails while encrypted values are stored: decrypt them with a build that
+-- reads them before rolling back.
+ALTER TABLE tokens
+    ALTER COLUMN name TYPE VARCHAR(255),
+    ALTER COLUMN email TYPE VARCHAR(255);
+
+ALTER TABLE support_tickets
+    DROP COLUMN user_email_index,
+    ALTER COLUMN user_email TYPE VARCHAR(255),
+    ALTER COLUMN user_name TYPE VARCHAR(255);
+
+ALTER TABLE customers
+    DROP COLUMN email_index,
+    ALTER COLUMN first_name TYPE VARCHAR(255),
+    ALTER COLUMN last_name TYPE VARCHAR(255),
+    ALTER COLUMN email TYPE VARCHAR(255);
+
+ALTER TABLE users
+    DROP COLUMN email_index,
+    ALTER COLUMN first_name TYPE VARCHAR(255),
+    ALTER COLUMN last_name TYPE VARCHAR(255),
+    ALTER COLUMN email TYPE VARCHAR(255);
@@ -0,0 +1,33 @@
+-- Names and emails of users, customers and support tickets may be stored
+-- encrypted, which is longer than the 255 characters allowed so far. An
+-- encrypted email is different every time it is sealed, so lookups by email
+-- go through a blind index: the HMAC of the lower-cased email.
+ALTER TABLE users
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT,
+    ADD COLUMN email_index BYTEA;
+CREATE UNIQUE INDEX idx_users_email_index ON users(email_index);
+
+ALTER TABLE customers
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT,
+    ADD COLUMN email_index BYTEA;
+CREATE UNIQUE INDEX idx_customers_email_index ON customers(email_index);
+
+ALTER TABLE support_tickets
+    ALTER COLUMN user_email TYPE TEXT,
+    ALTER COLUMN user_name TYPE TEXT,
+    ADD COLUMN user_email_index BYTEA;
+CREATE INDEX idx_support_tickets_user_email_index ON support_tickets(user_email_index);
+
+-- Tokens keep a copy of the name and email of their user, encrypted for new
+-- tokens. Old ones are left to expire.
+ALTER TABLE tokens
+    ALTER COLUMN name TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT;
+
+COMMENT ON COLUMN users.email_index IS 'HMAC of the lower-cased email, set once the row is encrypted';
+COMMENT ON COLUMN customers.email_index IS 'HMAC of the lower-cased email, set once the row is encrypted';
+COMMENT ON COLUMN support_tickets.user_email_index IS 'HMAC of the lower-cased user_email, set once the row is encrypted';
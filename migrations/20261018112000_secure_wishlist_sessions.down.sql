@@ -0,0 +1,7 @@
+-- Hashed sessions cannot be turned back into the ids their clients hold, so
+-- anonymous lists stay owned by their hash and cannot be reached after
+-- rolling back.
+COMMENT ON COLUMN wishlists.session_id IS 'Anonymous session owning the list until it is merged on login';
+
+ALTER TABLE wishlists ADD COLUMN IF NOT EXISTS notify_email VARCHAR(255) NOT NULL DEFAULT '';
+COMMENT ON COLUMN wishlists.notify_email IS 'Where price-drop and back-in-stock emails go; falls back to the user email';
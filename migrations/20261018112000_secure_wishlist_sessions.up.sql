@@ -0,0 +1,11 @@
+-- Price-drop emails go to the verified email of the owner of a wishlist only,
+-- so that nobody can subscribe someone else's address
+ALTER TABLE wishlists DROP COLUMN IF EXISTS notify_email;
+
+-- Anonymous sessions are now issued by the server in a cookie and stored as
+-- its hash. Sessions that clients chose themselves are hashed the same way,
+-- so their lists are kept and the table holds no usable session any more.
+UPDATE wishlists SET session_id = encode(sha256(convert_to(session_id, 'UTF8')), 'hex')
+    WHERE session_id IS NOT NULL;
+
+COMMENT ON COLUMN wishlists.session_id IS 'SHA-256 of the wishlist cookie of the anonymous session owning the list until it is merged on login';
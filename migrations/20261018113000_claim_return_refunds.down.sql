@@ -0,0 +1,7 @@
+-- Returns left refunding were refunded, or not, without being completed:
+-- check them in Stripe after rolling back
+UPDATE returns SET status = 'received' WHERE status = 'refunding';
+
+ALTER TABLE returns DROP CONSTRAINT returns_status_check;
+ALTER TABLE returns ADD CONSTRAINT returns_status_check
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'completed'));
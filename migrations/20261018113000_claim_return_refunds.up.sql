@@ -0,0 +1,5 @@
+-- Returns are claimed while they are refunded, so that two admins completing
+-- the same return cannot both refund it
+ALTER TABLE returns DROP CONSTRAINT returns_status_check;
+ALTER TABLE returns ADD CONSTRAINT returns_status_check
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'completed'));
@@ -0,0 +1,4 @@
+-- Signed-in users link an identity to their account through the provider;
+-- the sign-in remembers who is linking
+ALTER TABLE oidc_logins
+    ADD COLUMN link_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
@@ -4,21 +4,61 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 	"usual_store/internal/models"
+	"usual_store/internal/pii"
 
 	"github.com/go-playground/validator/v10"
 )
 
+var (
+	// ErrTokenNotFound is returned when a token does not exist or belongs to another user.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. Its whole family has then been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// tokenTouchInterval is how stale last_used_at may get before a request updates it,
+// so that not every authenticated request writes to the tokens table.
+const tokenTouchInterval = time.Minute
+
 // TokenRepository defines the methods for token-related database operations.
 type TokenRepository interface {
 	InsertToken(ctx context.Context, token *models.Token, user models.User) error
 	GetUserForToken(ctx context.Context, token string) (*models.User, error)
+	GetUserForScopedToken(ctx context.Context, token, scope string) (*models.User, error)
+	ConsumeToken(ctx context.Context, token, scope string) (*models.User, error)
+	RotateRefreshToken(ctx context.Context, refreshToken string, access, refresh *models.Token) (*models.User, error)
+	GetActiveTokens(ctx context.Context, userID int, currentToken string) ([]models.ActiveToken, error)
+	DeleteToken(ctx context.Context, userID, id int) error
+	DeleteTokensForUser(ctx context.Context, userID int) (int64, error)
 }
 
+// DBModel reads users with their personal data decrypted by PII, when set.
+// Errors that do not fail a call go to ErrorLog, or the standard logger when
+// it is nil.
 type DBModel struct {
-	DB *sql.DB
+	DB       *sql.DB
+	PII      *pii.Cipher
+	ErrorLog *log.Logger
+}
+
+// logError logs an error that does not fail the call
+func (m *DBModel) logError(format string, args ...any) {
+	if m.ErrorLog == nil {
+		log.Printf(format, args...)
+		return
+	}
+	m.ErrorLog.Printf(format, args...)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 // NewDBModel creates a new instance of DBModel.
@@ -26,7 +66,8 @@ func NewDBModel(db *sql.DB) *DBModel {
 	return &DBModel{DB: db}
 }
 
-// InsertToken inserts or updates a token for a user in the database.
+// InsertToken stores a new token for a user. Tokens issued earlier stay valid,
+// so a user can be signed in on several devices; expired ones are pruned.
 func (m *DBModel) InsertToken(ctx context.Context, token *models.Token, user models.User) error {
 	validate := validator.New()
 
@@ -41,40 +82,239 @@ func (m *DBModel) InsertToken(ctx context.Context, token *models.Token, user mod
 	}
 
 	// Use $1 for parameterized queries in PostgreSQL
-	stmt := `DELETE FROM tokens WHERE user_id = $1`
-	_, err = m.DB.ExecContext(ctx, stmt, user.ID)
+	stmt := `DELETE FROM tokens WHERE user_id = $1 AND expiry < $2`
+	_, err = m.DB.ExecContext(ctx, stmt, user.ID, time.Now())
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO tokens 
-				(user_id, name, email, token_hash, expiry, created_at, updated_at)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = m.DB.ExecContext(ctx, stmt, user.ID, user.LastName, user.Email, token.Hash, token.Expiry, time.Now(), time.Now())
-	if err != nil {
-		return err
-	}
-	return nil
+	return insertToken(ctx, m.DB, m.PII, token, user)
+}
+
+// insertToken writes one token row, with the name and email of user sealed
+// by cipher
+func insertToken(ctx context.Context, ex execer, cipher *pii.Cipher, token *models.Token, user models.User) error {
+	stmt := `INSERT INTO tokens 
+				(user_id, name, email, token_hash, expiry, scope, family_id, device, ip_address,
+				user_agent, last_used_at, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	_, err := ex.ExecContext(ctx, stmt, user.ID, cipher.Value(user.LastName), cipher.Value(user.Email), token.Hash, token.Expiry,
+		token.Scope, token.FamilyID, token.Device.Label, token.Device.IPAddress, token.Device.UserAgent,
+		time.Now(), time.Now(), time.Now())
+	return err
 }
 
-// GetUserForToken retrieves a user based on the provided token.
+// GetUserForToken retrieves a user based on the provided authentication token
+// and records that the token was used. Refresh and pending tokens cannot be
+// used to call the API.
 func (m *DBModel) GetUserForToken(ctx context.Context, token string) (*models.User, error) {
+	return m.GetUserForScopedToken(ctx, token, models.ScopeAuthentication)
+}
+
+// GetUserForScopedToken retrieves a user based on the provided token of the
+// given scope and records that the token was used.
+func (m *DBModel) GetUserForScopedToken(ctx context.Context, token, scope string) (*models.User, error) {
 	var user models.User
+	var tokenID int
+	var lastUsed sql.NullTime
 	tokenHash := sha256.Sum256([]byte(token))
+	now := time.Now()
 
 	// PostgreSQL specific query using CURRENT_TIMESTAMP
 	query := `SELECT 
-		u.id, u.last_name, u.email, u.first_name 
+		u.id, u.last_name, u.email, u.first_name, u.role, t.id, t.last_used_at 
 		FROM users u 
 		INNER JOIN tokens t ON u.id = t.user_id 
-		WHERE t.token_hash = $1 AND t.expiry > $2`
+		WHERE t.token_hash = $1 AND t.expiry > $2 AND t.scope = $3`
+
+	// Execute the query with placeholders for the token hash, current time and scope
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], now, scope).Scan(
+		&user.ID, m.PII.Field(&user.LastName), m.PII.Field(&user.Email), m.PII.Field(&user.FirstName), &user.Role, &tokenID, &lastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failing to record the use must not sign the user out
+	if !lastUsed.Valid || now.Sub(lastUsed.Time) > tokenTouchInterval {
+		stmt := `UPDATE tokens SET last_used_at = $1 WHERE id = $2`
+		_, err = m.DB.ExecContext(ctx, stmt, now, tokenID)
+		if err != nil {
+			m.logError("failed to record use of token %d: %v", tokenID, err)
+		}
+	}
+	return &user, nil
+}
+
+// ConsumeToken deletes an unexpired token of the given scope and returns its
+// user, so that a single-use token cannot be presented twice. It returns
+// ErrTokenNotFound when there is no such token.
+func (m *DBModel) ConsumeToken(ctx context.Context, token, scope string) (*models.User, error) {
+	tokenHash := sha256.Sum256([]byte(token))
+
+	var user models.User
+	query := `WITH t AS (
+			DELETE FROM tokens WHERE token_hash = $1 AND scope = $2 AND expiry > $3 RETURNING user_id
+		)
+		SELECT u.id, u.last_name, u.email, u.first_name, u.role
+		FROM users u INNER JOIN t ON u.id = t.user_id`
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(
+		&user.ID, m.PII.Field(&user.LastName), m.PII.Field(&user.Email), m.PII.Field(&user.FirstName), &user.Role,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for the access and refresh
+// tokens given, which join the family of the old one and inherit its device.
+// The old refresh token is kept, marked as used, and access tokens issued
+// before are revoked. Presenting a used refresh token again means it was
+// stolen: the whole family is revoked and ErrRefreshTokenReused returned.
+func (m *DBModel) RotateRefreshToken(ctx context.Context, refreshToken string, access, refresh *models.Token) (*models.User, error) {
+	tokenHash := sha256.Sum256([]byte(refreshToken))
+	now := time.Now()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var id, userID int
+	var familyID string
+	var expiry time.Time
+	var usedAt sql.NullTime
+	var device models.TokenDevice
+	query := `SELECT id, user_id, family_id, expiry, used_at, device, ip_address, user_agent
+		FROM tokens
+		WHERE token_hash = $1 AND scope = $2
+		FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], models.ScopeRefresh).Scan(
+		&id, &userID, &familyID, &expiry, &usedAt, &device.Label, &device.IPAddress, &device.UserAgent,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if !expiry.After(now) {
+		return nil, ErrTokenNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = $1, updated_at = $1 WHERE id = $2`, now, id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1 AND scope = $2`,
+		familyID, models.ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
 
-	// Execute the query with $1 and $2 placeholders for the token hash and current time
-	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
-		&user.ID, &user.LastName, &user.Email, &user.FirstName,
+	var user models.User
+	query = `SELECT id, last_name, email, first_name, role FROM users WHERE id = $1`
+	err = tx.QueryRowContext(ctx, query, userID).Scan(
+		&user.ID, m.PII.Field(&user.LastName), m.PII.Field(&user.Email), m.PII.Field(&user.FirstName), &user.Role,
 	)
 	if err != nil {
 		return nil, err
 	}
+
+	for _, token := range []*models.Token{access, refresh} {
+		token.FamilyID = familyID
+		token.Device = device
+		err = insertToken(ctx, tx, m.PII, token, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
+
+// GetActiveTokens returns the signed-in sessions of a user, one per token
+// family that still holds an unexpired, unused token, most recently used first.
+// Sign-ins still waiting for a second factor are not sessions yet. The session
+// of currentToken is marked as current.
+func (m *DBModel) GetActiveTokens(ctx context.Context, userID int, currentToken string) ([]models.ActiveToken, error) {
+	currentHash := sha256.Sum256([]byte(currentToken))
+	query := `SELECT MAX(id), MAX(device), MAX(ip_address), MAX(user_agent), MIN(created_at),
+			MAX(last_used_at), MAX(expiry), BOOL_OR(token_hash = $3)
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2 AND used_at IS NULL AND scope <> $4
+		GROUP BY family_id
+		ORDER BY COALESCE(MAX(last_used_at), MIN(created_at)) DESC, MAX(id) DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now(), currentHash[:], models.ScopeMFAPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.ActiveToken{}
+	for rows.Next() {
+		var t models.ActiveToken
+		var lastUsed sql.NullTime
+		err = rows.Scan(&t.ID, &t.Label, &t.IPAddress, &t.UserAgent, &t.CreatedAt, &lastUsed, &t.Expiry, &t.Current)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteToken revokes the session of a token: every token in its family. It
+// returns ErrTokenNotFound when the user has no such token.
+func (m *DBModel) DeleteToken(ctx context.Context, userID, id int) error {
+	stmt := `DELETE FROM tokens
+		WHERE user_id = $2 AND family_id = (SELECT family_id FROM tokens WHERE id = $1 AND user_id = $2)`
+	res, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// DeleteTokensForUser revokes every token of a user, signing them out on all
+// devices, and returns how many were revoked.
+func (m *DBModel) DeleteTokensForUser(ctx context.Context, userID int) (int64, error) {
+	stmt := `DELETE FROM tokens WHERE user_id = $1`
+	res, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
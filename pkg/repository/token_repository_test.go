@@ -1,10 +1,12 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"log"
 	"testing"
 	"time"
 	"usual_store/internal/models"
@@ -25,8 +27,15 @@ func TestDBModel_InsertToken(t *testing.T) {
 		{
 			name: "successful token insertion",
 			token: &models.Token{
-				Hash:   []byte("test-hash"),
-				Expiry: time.Now().Add(24 * time.Hour),
+				Hash:     []byte("test-hash"),
+				Expiry:   time.Now().Add(24 * time.Hour),
+				Scope:    models.ScopeAuthentication,
+				FamilyID: "0b6f1c1e-6a55-4c1b-9d1e-2f7f8f6f3a10",
+				Device: models.TokenDevice{
+					Label:     "Firefox on Linux",
+					IPAddress: "192.0.2.10",
+					UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+				},
 			},
 			user: models.User{
 				ID:        1,
@@ -36,12 +45,13 @@ func TestDBModel_InsertToken(t *testing.T) {
 				Password:  "hashed-password",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, token *models.Token, user models.User) {
-				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
-					WithArgs(user.ID).
+				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1 AND expiry < \\$2").
+					WithArgs(user.ID, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 
 				mock.ExpectExec("INSERT INTO tokens").
-					WithArgs(user.ID, user.LastName, user.Email, token.Hash,
+					WithArgs(user.ID, user.LastName, user.Email, token.Hash, sqlmock.AnyArg(),
+						token.Scope, token.FamilyID, token.Device.Label, token.Device.IPAddress, token.Device.UserAgent,
 						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
@@ -51,7 +61,7 @@ func TestDBModel_InsertToken(t *testing.T) {
 			},
 		},
 		{
-			name: "no tokens to delete before insert",
+			name: "no expired tokens to prune before insert",
 			token: &models.Token{
 				Hash:   []byte("test-hash"),
 				Expiry: time.Now().Add(24 * time.Hour),
@@ -63,12 +73,13 @@ func TestDBModel_InsertToken(t *testing.T) {
 				Email:     "jane@example.com",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, token *models.Token, user models.User) {
-				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
-					WithArgs(user.ID).
+				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1 AND expiry < \\$2").
+					WithArgs(user.ID, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(0, 0))
 
 				mock.ExpectExec("INSERT INTO tokens").
-					WithArgs(user.ID, user.LastName, user.Email, token.Hash,
+					WithArgs(user.ID, user.LastName, user.Email, token.Hash, sqlmock.AnyArg(),
+						token.Scope, token.FamilyID, token.Device.Label, token.Device.IPAddress, token.Device.UserAgent,
 						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
@@ -90,8 +101,8 @@ func TestDBModel_InsertToken(t *testing.T) {
 				Email:     "john@example.com",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, token *models.Token, user models.User) {
-				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
-					WithArgs(user.ID).
+				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1 AND expiry < \\$2").
+					WithArgs(user.ID, sqlmock.AnyArg()).
 					WillReturnError(errors.New("database connection error"))
 			},
 			validate: func(t *testing.T, err error, mock sqlmock.Sqlmock) {
@@ -112,12 +123,13 @@ func TestDBModel_InsertToken(t *testing.T) {
 				Email:     "john@example.com",
 			},
 			mockSetup: func(mock sqlmock.Sqlmock, token *models.Token, user models.User) {
-				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
-					WithArgs(user.ID).
+				mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1 AND expiry < \\$2").
+					WithArgs(user.ID, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 
 				mock.ExpectExec("INSERT INTO tokens").
-					WithArgs(user.ID, user.LastName, user.Email, token.Hash,
+					WithArgs(user.ID, user.LastName, user.Email, token.Hash, sqlmock.AnyArg(),
+						token.Scope, token.FamilyID, token.Device.Label, token.Device.IPAddress, token.Device.UserAgent,
 						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnError(errors.New("insert failed"))
 			},
@@ -156,10 +168,10 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			name:  "successful user retrieval with valid token",
 			token: "valid-token-12345",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
-				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name"}).
-					AddRow(1, "Doe", "john@example.com", "John")
+				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+					AddRow(1, "Doe", "john@example.com", "John", "user", 10, time.Now())
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnRows(rows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -169,6 +181,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 				require.Equal(t, "John", user.FirstName)
 				require.Equal(t, "Doe", user.LastName)
 				require.Equal(t, "john@example.com", user.Email)
+				require.Equal(t, "user", user.Role)
 				require.NoError(t, mock.ExpectationsWereMet())
 			},
 		},
@@ -176,10 +189,10 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			name:  "user retrieval with different user data",
 			token: "another-valid-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
-				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name"}).
-					AddRow(42, "Smith", "jane.smith@example.com", "Jane")
+				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+					AddRow(42, "Smith", "jane.smith@example.com", "Jane", "supporter", 10, time.Now())
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnRows(rows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -189,6 +202,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 				require.Equal(t, "Jane", user.FirstName)
 				require.Equal(t, "Smith", user.LastName)
 				require.Equal(t, "jane.smith@example.com", user.Email)
+				require.Equal(t, "supporter", user.Role)
 				require.NoError(t, mock.ExpectationsWereMet())
 			},
 		},
@@ -197,7 +211,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			token: "non-existent-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnError(sql.ErrNoRows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -211,7 +225,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			token: "expired-token-xyz",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnError(sql.ErrNoRows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -225,7 +239,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			token: "some-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnError(errors.New("connection refused"))
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -239,7 +253,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			token: "timeout-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnError(errors.New("query timeout exceeded"))
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -255,7 +269,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 				rows := sqlmock.NewRows([]string{"id", "last_name"}).
 					AddRow(1, "Doe")
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnRows(rows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -267,10 +281,10 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			name:  "scan error - type mismatch",
 			token: "type-mismatch-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
-				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name"}).
-					AddRow("not-an-int", "Doe", "john@example.com", "John")
+				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+					AddRow("not-an-int", "Doe", "john@example.com", "John", "user", 10, time.Now())
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnRows(rows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -283,7 +297,7 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			token: "",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnError(sql.ErrNoRows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -295,10 +309,10 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 			name:  "user with empty first name",
 			token: "no-firstname-token",
 			mockSetup: func(mock sqlmock.Sqlmock, tokenHash [32]byte) {
-				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name"}).
-					AddRow(5, "Anonymous", "anonymous@example.com", "")
+				rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+					AddRow(5, "Anonymous", "anonymous@example.com", "", "user", 10, time.Now())
 				mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-					WithArgs(tokenHash[:], sqlmock.AnyArg()).
+					WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 					WillReturnRows(rows)
 			},
 			validate: func(t *testing.T, user *models.User, err error, mock sqlmock.Sqlmock) {
@@ -331,6 +345,316 @@ func TestDBModel_GetUserForToken(t *testing.T) {
 	}
 }
 
+func TestDBModel_GetUserForToken_RecordsUse(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastUsed interface{}
+		touch    bool
+	}{
+		{"never used", nil, true},
+		{"idle", time.Now().Add(-time.Hour), true},
+		{"used moments ago", time.Now().Add(-time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			token := "valid-token-12345"
+			tokenHash := sha256.Sum256([]byte(token))
+			rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+				AddRow(1, "Doe", "john@example.com", "John", "user", 10, tt.lastUsed)
+			mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
+				WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
+				WillReturnRows(rows)
+			if tt.touch {
+				mock.ExpectExec("UPDATE tokens SET last_used_at = \\$1 WHERE id = \\$2").
+					WithArgs(sqlmock.AnyArg(), 10).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			user, err := NewDBModel(db).GetUserForToken(context.Background(), token)
+			require.NoError(t, err)
+			require.Equal(t, 1, user.ID)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_GetUserForToken_RecordUseFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	token := "valid-token-12345"
+	tokenHash := sha256.Sum256([]byte(token))
+	rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+		AddRow(1, "Doe", "john@example.com", "John", "user", 10, nil)
+	mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
+		WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
+		WillReturnRows(rows)
+	mock.ExpectExec("UPDATE tokens SET last_used_at").
+		WillReturnError(errors.New("read-only transaction"))
+
+	// The user stays signed in and the failure is logged
+	var logged bytes.Buffer
+	m := NewDBModel(db)
+	m.ErrorLog = log.New(&logged, "", 0)
+	user, err := m.GetUserForToken(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, 1, user.ID)
+	assert.Equal(t, "failed to record use of token 10: read-only transaction\n", logged.String())
+}
+
+func TestDBModel_RotateRefreshToken(t *testing.T) {
+	refreshToken := "REFRESHTOKEN00000000000000"
+	tokenHash := sha256.Sum256([]byte(refreshToken))
+	familyID := "0b6f1c1e-6a55-4c1b-9d1e-2f7f8f6f3a10"
+	tokenColumns := []string{"id", "user_id", "family_id", "expiry", "used_at", "device", "ip_address", "user_agent"}
+
+	tests := []struct {
+		name      string
+		mockSetup func(mock sqlmock.Sqlmock)
+		wantErr   error
+	}{
+		{
+			name: "rotates an unused refresh token",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM tokens WHERE token_hash = \\$1 AND scope = \\$2 FOR UPDATE").
+					WithArgs(tokenHash[:], models.ScopeRefresh).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).
+						AddRow(8, 1, familyID, time.Now().Add(time.Hour), nil, "Firefox on Linux", "192.0.2.10", "Mozilla/5.0"))
+				mock.ExpectExec("UPDATE tokens SET used_at = \\$1, updated_at = \\$1 WHERE id = \\$2").
+					WithArgs(sqlmock.AnyArg(), 8).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM tokens WHERE family_id = \\$1 AND scope = \\$2").
+					WithArgs(familyID, models.ScopeAuthentication).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT id, last_name, email, first_name, role FROM users WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role"}).
+						AddRow(1, "Doe", "john@example.com", "John", "admin"))
+				for _, scope := range []string{models.ScopeAuthentication, models.ScopeRefresh} {
+					mock.ExpectExec("INSERT INTO tokens").
+						WithArgs(1, "Doe", "john@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), scope, familyID,
+							"Firefox on Linux", "192.0.2.10", "Mozilla/5.0",
+							sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "reused refresh token revokes the family",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM tokens WHERE token_hash = \\$1").
+					WithArgs(tokenHash[:], models.ScopeRefresh).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).
+						AddRow(8, 1, familyID, time.Now().Add(time.Hour), time.Now().Add(-time.Minute), "", "", ""))
+				mock.ExpectExec("DELETE FROM tokens WHERE family_id = \\$1$").
+					WithArgs(familyID).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectCommit()
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name: "unknown refresh token",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM tokens WHERE token_hash = \\$1").
+					WithArgs(tokenHash[:], models.ScopeRefresh).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantErr: ErrTokenNotFound,
+		},
+		{
+			name: "expired refresh token",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM tokens WHERE token_hash = \\$1").
+					WithArgs(tokenHash[:], models.ScopeRefresh).
+					WillReturnRows(sqlmock.NewRows(tokenColumns).
+						AddRow(8, 1, familyID, time.Now().Add(-time.Hour), nil, "", "", ""))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrTokenNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mockSetup(mock)
+
+			access := &models.Token{Hash: []byte("access-hash"), Scope: models.ScopeAuthentication}
+			refresh := &models.Token{Hash: []byte("refresh-hash"), Scope: models.ScopeRefresh}
+			user, err := NewDBModel(db).RotateRefreshToken(context.Background(), refreshToken, access, refresh)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Nil(t, user)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "admin", user.Role)
+				require.Equal(t, familyID, access.FamilyID)
+				require.Equal(t, familyID, refresh.FamilyID)
+				require.Equal(t, "Firefox on Linux", refresh.Device.Label)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_GetUserForScopedToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	token := "PENDINGTOKEN00000000000000"
+	tokenHash := sha256.Sum256([]byte(token))
+	rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "id", "last_used_at"}).
+		AddRow(1, "Doe", "john@example.com", "John", "admin", 4, time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t ON u.id = t.user_id WHERE t.token_hash = \\$1 AND t.expiry > \\$2 AND t.scope = \\$3").
+		WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeMFAPending).
+		WillReturnRows(rows)
+
+	user, err := NewDBModel(db).GetUserForScopedToken(context.Background(), token, models.ScopeMFAPending)
+	require.NoError(t, err)
+	assert.Equal(t, 1, user.ID)
+	assert.Equal(t, "admin", user.Role)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_ConsumeToken(t *testing.T) {
+	token := "PENDINGTOKEN00000000000000"
+	tokenHash := sha256.Sum256([]byte(token))
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{
+			name: "consumed",
+			rows: sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role"}).
+				AddRow(1, "Doe", "john@example.com", "John", "admin"),
+		},
+		{
+			name:    "already used or expired",
+			rows:    sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role"}),
+			wantErr: ErrTokenNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectQuery("DELETE FROM tokens WHERE token_hash = \\$1 AND scope = \\$2 AND expiry > \\$3 RETURNING user_id").
+				WithArgs(tokenHash[:], models.ScopeMFAPending, sqlmock.AnyArg()).
+				WillReturnRows(tt.rows)
+
+			user, err := NewDBModel(db).ConsumeToken(context.Background(), token, models.ScopeMFAPending)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, user)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "john@example.com", user.Email)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_GetActiveTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	current := "CURRENTTOKEN00000000000000"
+	currentHash := sha256.Sum256([]byte(current))
+	created := time.Now().Add(-2 * time.Hour)
+	lastUsed := time.Now().Add(-time.Minute)
+	expiry := time.Now().Add(22 * time.Hour)
+	rows := sqlmock.NewRows([]string{"id", "device", "ip_address", "user_agent", "created_at", "last_used_at", "expiry", "current"}).
+		AddRow(3, "Chrome on Android", "198.51.100.7", "Mozilla/5.0 (Linux; Android 14)", created, lastUsed, expiry, true).
+		AddRow(2, "Firefox on Linux", "192.0.2.10", "Mozilla/5.0 (X11; Linux x86_64)", created, nil, expiry, false)
+	mock.ExpectQuery("SELECT (.+) FROM tokens WHERE user_id = \\$1 AND expiry > \\$2 AND used_at IS NULL AND scope <> \\$4 GROUP BY family_id").
+		WithArgs(1, sqlmock.AnyArg(), currentHash[:], models.ScopeMFAPending).
+		WillReturnRows(rows)
+
+	tokens, err := NewDBModel(db).GetActiveTokens(context.Background(), 1, current)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+
+	assert.Equal(t, 3, tokens[0].ID)
+	assert.Equal(t, "Chrome on Android", tokens[0].Label)
+	assert.Equal(t, "198.51.100.7", tokens[0].IPAddress)
+	assert.True(t, tokens[0].Current)
+	require.NotNil(t, tokens[0].LastUsedAt)
+	assert.WithinDuration(t, lastUsed, *tokens[0].LastUsedAt, time.Second)
+	assert.Nil(t, tokens[1].LastUsedAt)
+	assert.False(t, tokens[1].Current)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBModel_DeleteToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  sql.Result
+		wantErr error
+	}{
+		{"revoked", sqlmock.NewResult(0, 2), nil},
+		{"another user's token", sqlmock.NewResult(0, 0), ErrTokenNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$2 AND family_id = \\(SELECT family_id FROM tokens WHERE id = \\$1 AND user_id = \\$2\\)").
+				WithArgs(7, 1).
+				WillReturnResult(tt.result)
+
+			err = NewDBModel(db).DeleteToken(context.Background(), 1, 7)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDBModel_DeleteTokensForUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := NewDBModel(db).DeleteTokensForUser(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestDBModel_InsertToken_ContextCancellation(t *testing.T) {
 	// Create mock database
 	db, mock, err := sqlmock.New()
@@ -348,9 +672,9 @@ func TestDBModel_InsertToken_ContextCancellation(t *testing.T) {
 		Email:     "john@example.com",
 	}
 
-	// Expect DELETE to fail due to context cancellation
-	mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1").
-		WithArgs(user.ID).
+	// Expect pruning expired tokens to fail due to context cancellation
+	mock.ExpectExec("DELETE FROM tokens WHERE user_id = \\$1 AND expiry < \\$2").
+		WithArgs(user.ID, sqlmock.AnyArg()).
 		WillReturnError(context.Canceled)
 
 	repo := NewDBModel(db)
@@ -376,7 +700,7 @@ func TestDBModel_GetUserForToken_ContextCancellation(t *testing.T) {
 
 	// Expect query to fail due to context cancellation
 	mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-		WithArgs(tokenHash[:], sqlmock.AnyArg()).
+		WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 		WillReturnError(context.Canceled)
 
 	repo := NewDBModel(db)
@@ -437,6 +761,12 @@ func BenchmarkInsertToken(b *testing.B) {
 				user.Email,
 				token.Hash,
 				sqlmock.AnyArg(),
+				token.Scope,
+				token.FamilyID,
+				token.Device.Label,
+				token.Device.IPAddress,
+				token.Device.UserAgent,
+				sqlmock.AnyArg(),
 				sqlmock.AnyArg(),
 				sqlmock.AnyArg(),
 			).
@@ -464,11 +794,11 @@ func BenchmarkGetUserForToken(b *testing.B) {
 
 	// Setup expectations for N iterations
 	for i := 0; i < b.N; i++ {
-		rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name"}).
-			AddRow(1, "Doe", "john@example.com", "John")
+		rows := sqlmock.NewRows([]string{"id", "last_name", "email", "first_name", "role", "token_id", "last_used_at"}).
+			AddRow(1, "Doe", "john@example.com", "John", "user", 10, time.Now())
 
 		mock.ExpectQuery("SELECT (.+) FROM users u INNER JOIN tokens t").
-			WithArgs(tokenHash[:], sqlmock.AnyArg()).
+			WithArgs(tokenHash[:], sqlmock.AnyArg(), models.ScopeAuthentication).
 			WillReturnRows(rows)
 	}
 
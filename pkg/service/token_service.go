@@ -5,6 +5,18 @@ import (
 	"time"
 	"usual_store/internal/models"
 	"usual_store/pkg/repository"
+
+	"github.com/google/uuid"
+)
+
+// Lifetimes of the tokens issued at sign-in. Access tokens are short-lived;
+// clients keep their session by exchanging the refresh token for a new pair.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	// MFAPendingTokenTTL is how long a user has to enter their second factor
+	// after their password was accepted
+	MFAPendingTokenTTL = 5 * time.Minute
 )
 
 type TokenService struct {
@@ -15,12 +27,14 @@ func NewTokenService(repo repository.TokenRepository) *TokenService {
 	return &TokenService{Repo: repo}
 }
 
-// CreateToken generates a token and stores it in the database.
-func (s *TokenService) CreateToken(ctx context.Context, user models.User, ttl time.Duration, scope string) (*models.Token, error) {
+// CreateToken generates a token for the given device and stores it in the database.
+func (s *TokenService) CreateToken(ctx context.Context, user models.User, ttl time.Duration, scope string, device models.TokenDevice) (*models.Token, error) {
 	token, err := models.GenerateToken(user.ID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
+	token.Device = device
+	token.FamilyID = uuid.New().String()
 
 	err = s.Repo.InsertToken(ctx, token, user)
 	if err != nil {
@@ -30,7 +44,77 @@ func (s *TokenService) CreateToken(ctx context.Context, user models.User, ttl ti
 	return token, nil
 }
 
+// CreateTokenPair signs a user in on a device with a new access token and a
+// refresh token, which start a token family of their own.
+func (s *TokenService) CreateTokenPair(ctx context.Context, user models.User, device models.TokenDevice) (access, refresh *models.Token, err error) {
+	access, err = models.GenerateToken(user.ID, AccessTokenTTL, models.ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err = models.GenerateToken(user.ID, RefreshTokenTTL, models.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	familyID := uuid.New().String()
+	for _, token := range []*models.Token{access, refresh} {
+		token.FamilyID = familyID
+		token.Device = device
+		err = s.Repo.InsertToken(ctx, token, user)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return access, refresh, nil
+}
+
+// RefreshTokens rotates a refresh token: it is exchanged for a new access and
+// refresh token, and using it again revokes the session.
+func (s *TokenService) RefreshTokens(ctx context.Context, refreshToken string) (*models.User, *models.Token, *models.Token, error) {
+	access, err := models.GenerateToken(0, AccessTokenTTL, models.ScopeAuthentication)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	refresh, err := models.GenerateToken(0, RefreshTokenTTL, models.ScopeRefresh)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	user, err := s.Repo.RotateRefreshToken(ctx, refreshToken, access, refresh)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	access.UserId = int64(user.ID)
+	refresh.UserId = int64(user.ID)
+	return user, access, refresh, nil
+}
+
 // GetUserForToken retrieves a user based on the provided token.
 func (s *TokenService) GetUserForToken(ctx context.Context, token string) (*models.User, error) {
 	return s.Repo.GetUserForToken(ctx, token)
 }
+
+// GetUserForScopedToken retrieves the user of a token of the given scope.
+func (s *TokenService) GetUserForScopedToken(ctx context.Context, token, scope string) (*models.User, error) {
+	return s.Repo.GetUserForScopedToken(ctx, token, scope)
+}
+
+// ConsumeToken deletes a single-use token of the given scope and returns its user.
+func (s *TokenService) ConsumeToken(ctx context.Context, token, scope string) (*models.User, error) {
+	return s.Repo.ConsumeToken(ctx, token, scope)
+}
+
+// GetActiveTokens lists the devices a user is signed in on, marking the one currentToken belongs to.
+func (s *TokenService) GetActiveTokens(ctx context.Context, userID int, currentToken string) ([]models.ActiveToken, error) {
+	return s.Repo.GetActiveTokens(ctx, userID, currentToken)
+}
+
+// RevokeToken signs a user out on one device, revoking the token's family.
+func (s *TokenService) RevokeToken(ctx context.Context, userID, id int) error {
+	return s.Repo.DeleteToken(ctx, userID, id)
+}
+
+// RevokeAllTokens signs a user out on every device.
+func (s *TokenService) RevokeAllTokens(ctx context.Context, userID int) (int64, error) {
+	return s.Repo.DeleteTokensForUser(ctx, userID)
+}
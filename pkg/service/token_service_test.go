@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"usual_store/internal/mocks"
+	"usual_store/internal/models"
+	"usual_store/pkg/repository"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenService_CreateTokenPair(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTokenRepository(ctrl)
+
+	user := models.User{ID: 1, LastName: "Doe", Email: "john@example.com"}
+	device := models.TokenDevice{Label: "Firefox on Linux", IPAddress: "192.0.2.10"}
+
+	var inserted []*models.Token
+	repo.EXPECT().InsertToken(gomock.Any(), gomock.Any(), user).
+		DoAndReturn(func(_ context.Context, token *models.Token, _ models.User) error {
+			inserted = append(inserted, token)
+			return nil
+		}).Times(2)
+
+	access, refresh, err := NewTokenService(repo).CreateTokenPair(context.Background(), user, device)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Token{access, refresh}, inserted)
+
+	assert.Equal(t, models.ScopeAuthentication, access.Scope)
+	assert.Equal(t, models.ScopeRefresh, refresh.Scope)
+	assert.WithinDuration(t, time.Now().Add(AccessTokenTTL), access.Expiry, time.Minute)
+	assert.WithinDuration(t, time.Now().Add(RefreshTokenTTL), refresh.Expiry, time.Minute)
+	assert.NotEmpty(t, access.FamilyID)
+	assert.Equal(t, access.FamilyID, refresh.FamilyID)
+	assert.NotEqual(t, access.PlainText, refresh.PlainText)
+	assert.Equal(t, device, refresh.Device)
+}
+
+func TestTokenService_RefreshTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockTokenRepository(ctrl)
+
+	repo.EXPECT().RotateRefreshToken(gomock.Any(), "OLDREFRESH", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, access, refresh *models.Token) (*models.User, error) {
+			assert.Equal(t, models.ScopeAuthentication, access.Scope)
+			assert.Equal(t, models.ScopeRefresh, refresh.Scope)
+			return &models.User{ID: 5}, nil
+		})
+	repo.EXPECT().RotateRefreshToken(gomock.Any(), "REUSED", gomock.Any(), gomock.Any()).
+		Return(nil, repository.ErrRefreshTokenReused)
+
+	s := NewTokenService(repo)
+
+	user, access, refresh, err := s.RefreshTokens(context.Background(), "OLDREFRESH")
+	require.NoError(t, err)
+	assert.Equal(t, 5, user.ID)
+	assert.Equal(t, int64(5), access.UserId)
+	assert.Equal(t, int64(5), refresh.UserId)
+	assert.NotEmpty(t, access.PlainText)
+
+	_, _, _, err = s.RefreshTokens(context.Background(), "REUSED")
+	assert.ErrorIs(t, err, repository.ErrRefreshTokenReused)
+}
@@ -55,12 +55,17 @@ function AdminUsers() {
     }
   }, [navigate]);
 
+  // User management needs the admin's token
+  const authHeaders = () => ({
+    headers: { Authorization: `Bearer ${localStorage.getItem('authToken')}` }
+  });
+
   // Fetch users
   const fetchUsers = async () => {
     setLoading(true);
     setError('');
     try {
-      const response = await axios.get('/api/users');
+      const response = await axios.get('/api/users', authHeaders());
       setUsers(response.data.users || []);
     } catch (err) {
       setError('Failed to load users: ' + (err.response?.data?.message || err.message));
@@ -84,7 +89,7 @@ function AdminUsers() {
     }
 
     try {
-      await axios.post('/api/users', formData);
+      await axios.post('/api/users', formData, authHeaders());
       setSuccess('User created successfully!');
       setOpenDialog(false);
       resetForm();
@@ -104,7 +109,7 @@ function AdminUsers() {
     setSuccess('');
 
     try {
-      await axios.delete(`/api/users/${userId}`);
+      await axios.delete(`/api/users/${userId}`, authHeaders());
       setSuccess('User deleted successfully!');
       fetchUsers();
     } catch (err) {
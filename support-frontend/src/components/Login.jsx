@@ -24,7 +24,13 @@ function Login() {
   const [password, setPassword] = useState('');
   const [showPassword, setShowPassword] = useState(false);
   const [error, setError] = useState('');
-  const { login, loading } = useAuth();
+  // Second step of signing in: the pending sign-in, the code entered and,
+  // once enrolment completed, the recovery codes to show
+  const [mfa, setMfa] = useState(null);
+  const [code, setCode] = useState('');
+  const [recoveryCode, setRecoveryCode] = useState('');
+  const [recovery, setRecovery] = useState(null);
+  const { login, enrolMFA, verifyMFA, loading } = useAuth();
   const navigate = useNavigate();
 
   const handleSubmit = async (e) => {
@@ -40,11 +46,47 @@ function Login() {
     
     if (result.success) {
       navigate('/support/dashboard');
+    } else if (result.mfa) {
+      if (!result.mfa.enrolled) {
+        const enrolment = await enrolMFA(result.mfa.token);
+        if (!enrolment.success) {
+          setError(enrolment.error);
+          return;
+        }
+        result.mfa.qrCode = enrolment.qrCode;
+        result.mfa.secret = enrolment.secret;
+      }
+      setMfa(result.mfa);
     } else {
       setError(result.error);
     }
   };
 
+  const handleVerify = async (e) => {
+    e.preventDefault();
+    setError('');
+
+    const result = await verifyMFA(mfa, code, recoveryCode);
+    setCode('');
+    setRecoveryCode('');
+    if (result.success && result.recoveryCodes) {
+      setRecovery(result);
+    } else if (result.success) {
+      navigate('/support/dashboard');
+    } else {
+      // A wrong code ends the pending sign-in; start over from the password
+      setError(mfa.enrolled ? `${result.error}. Please sign in again.` : result.error);
+      if (mfa.enrolled) {
+        setMfa(null);
+      }
+    }
+  };
+
+  const handleFinish = () => {
+    recovery.finish();
+    navigate('/support/dashboard');
+  };
+
   return (
     <Container maxWidth="sm">
       <Box
@@ -91,63 +133,137 @@ function Login() {
             </Alert>
           )}
 
-          {/* Login Form */}
-          <form onSubmit={handleSubmit}>
-            <TextField
-              fullWidth
-              label="Email Address"
-              type="email"
-              value={email}
-              onChange={(e) => setEmail(e.target.value)}
-              margin="normal"
-              required
-              autoComplete="email"
-              autoFocus
-              disabled={loading}
-            />
-
-            <TextField
-              fullWidth
-              label="Password"
-              type={showPassword ? 'text' : 'password'}
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              margin="normal"
-              required
-              autoComplete="current-password"
-              disabled={loading}
-              InputProps={{
-                endAdornment: (
-                  <InputAdornment position="end">
-                    <IconButton
-                      onClick={() => setShowPassword(!showPassword)}
-                      edge="end"
-                    >
-                      {showPassword ? <VisibilityOff /> : <Visibility />}
-                    </IconButton>
-                  </InputAdornment>
-                ),
-              }}
-            />
-
-            <Button
-              type="submit"
-              fullWidth
-              variant="contained"
-              size="large"
-              sx={{ mt: 3, mb: 2 }}
-              disabled={loading}
-            >
-              {loading ? (
-                <>
-                  <CircularProgress size={24} sx={{ mr: 1 }} />
-                  Signing In...
-                </>
-              ) : (
-                'Sign In'
+          {recovery && (
+            <Box>
+              <Typography variant="h6" gutterBottom>
+                Recovery Codes
+              </Typography>
+              <Typography variant="body2" color="text.secondary" gutterBottom>
+                Store these codes somewhere safe. If you lose your authenticator app, each can be
+                used once instead of a code. They will not be shown again.
+              </Typography>
+              <Box component="ul" sx={{ fontFamily: 'monospace', listStyle: 'none', pl: 0 }}>
+                {recovery.recoveryCodes.map((c) => (
+                  <li key={c}>{c}</li>
+                ))}
+              </Box>
+              <Button fullWidth variant="contained" size="large" onClick={handleFinish}>
+                Continue
+              </Button>
+            </Box>
+          )}
+
+          {/* Two-Factor Authentication */}
+          {mfa && !recovery && (
+            <form onSubmit={handleVerify}>
+              {!mfa.enrolled && (
+                <Box sx={{ textAlign: 'center', mb: 2 }}>
+                  <Typography variant="body2" color="text.secondary" gutterBottom>
+                    Two-factor authentication is required for staff accounts. Scan this code with an
+                    authenticator app, or enter the key by hand, then enter the code the app shows.
+                  </Typography>
+                  <img src={mfa.qrCode} alt="QR code for your authenticator app" />
+                  <Typography variant="body2" sx={{ fontFamily: 'monospace' }}>
+                    {mfa.secret}
+                  </Typography>
+                </Box>
               )}
-            </Button>
-          </form>
+
+              <TextField
+                fullWidth
+                label="Authentication Code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                margin="normal"
+                autoComplete="one-time-code"
+                inputProps={{ inputMode: 'numeric', maxLength: 6 }}
+                autoFocus
+                disabled={loading}
+              />
+
+              {mfa.enrolled && (
+                <TextField
+                  fullWidth
+                  label="Or a Recovery Code"
+                  value={recoveryCode}
+                  onChange={(e) => setRecoveryCode(e.target.value)}
+                  margin="normal"
+                  disabled={loading}
+                />
+              )}
+
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                size="large"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading || (!code && !recoveryCode)}
+              >
+                Verify
+              </Button>
+            </form>
+          )}
+
+          {/* Login Form */}
+          {!mfa && (
+            <form onSubmit={handleSubmit}>
+              <TextField
+                fullWidth
+                label="Email Address"
+                type="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                margin="normal"
+                required
+                autoComplete="email"
+                autoFocus
+                disabled={loading}
+              />
+
+              <TextField
+                fullWidth
+                label="Password"
+                type={showPassword ? 'text' : 'password'}
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                margin="normal"
+                required
+                autoComplete="current-password"
+                disabled={loading}
+                InputProps={{
+                  endAdornment: (
+                    <InputAdornment position="end">
+                      <IconButton
+                        onClick={() => setShowPassword(!showPassword)}
+                        edge="end"
+                      >
+                        {showPassword ? <VisibilityOff /> : <Visibility />}
+                      </IconButton>
+                    </InputAdornment>
+                  ),
+                }}
+              />
+
+              <Button
+                type="submit"
+                fullWidth
+                variant="contained"
+                size="large"
+                sx={{ mt: 3, mb: 2 }}
+                disabled={loading}
+              >
+                {loading ? (
+                  <>
+                    <CircularProgress size={24} sx={{ mr: 1 }} />
+                    Signing In...
+                  </>
+                ) : (
+                  'Sign In'
+                )}
+              </Button>
+            </form>
+          )}
 
           {/* Help Text */}
           <Box sx={{ mt: 3, textAlign: 'center' }}>
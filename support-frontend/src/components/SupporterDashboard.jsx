@@ -124,7 +124,8 @@ function SupporterDashboard() {
 
   // Connect to WebSocket
   const connectWebSocket = (sessionID) => {
-    const wsUrl = `${WS_URL}/ws/support/supporter/${sessionID}?supporter_id=${supporterID}&name=${encodeURIComponent(supporterName)}`;
+    // Browsers cannot set headers on WebSockets, so the token goes in the query string
+    const wsUrl = `${WS_URL}/ws/support/supporter/${sessionID}?token=${encodeURIComponent(user?.token || '')}`;
     
     ws.current = new WebSocket(wsUrl);
 
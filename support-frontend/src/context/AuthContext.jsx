@@ -6,6 +6,15 @@ const AuthContext = createContext(null);
 // Use the main backend authentication endpoint
 const AUTH_API_URL = process.env.REACT_APP_AUTH_API_URL || 'http://localhost:4001';
 
+// Send the API token with every request; the API and support service check its role
+const setAuthToken = (token) => {
+  if (token) {
+    axios.defaults.headers.common.Authorization = `Bearer ${token}`;
+  } else {
+    delete axios.defaults.headers.common.Authorization;
+  }
+};
+
 export const AuthProvider = ({ children }) => {
   const [user, setUser] = useState(null);
   const [loading, setLoading] = useState(true);
@@ -16,7 +25,9 @@ export const AuthProvider = ({ children }) => {
     const storedUser = localStorage.getItem('support_user');
     if (storedUser) {
       try {
-        setUser(JSON.parse(storedUser));
+        const parsedUser = JSON.parse(storedUser);
+        setAuthToken(parsedUser.token);
+        setUser(parsedUser);
       } catch (e) {
         localStorage.removeItem('support_user');
       }
@@ -24,6 +35,86 @@ export const AuthProvider = ({ children }) => {
     setLoading(false);
   }, []);
 
+  // Access tokens are short-lived. When a request is rejected, exchange the
+  // refresh token for a new pair once and retry the request with the new token.
+  useEffect(() => {
+    let refreshing = null;
+
+    const interceptor = axios.interceptors.response.use(undefined, async (err) => {
+      const request = err.config;
+      const storedUser = JSON.parse(localStorage.getItem('support_user') || 'null');
+      if (err.response?.status !== 401 || !request || request.retried || !storedUser?.refreshToken ||
+          request.url.endsWith('/api/refresh-token') || request.url.endsWith('/api/authenticate')) {
+        return Promise.reject(err);
+      }
+      request.retried = true;
+
+      if (!refreshing) {
+        refreshing = axios
+          .post(`${AUTH_API_URL}/api/refresh-token`, { refresh_token: storedUser.refreshToken })
+          .then((response) => {
+            const refreshedUser = {
+              ...storedUser,
+              token: response.data.authentication_token.token,
+              refreshToken: response.data.refresh_token.token,
+            };
+            localStorage.setItem('support_user', JSON.stringify(refreshedUser));
+            setAuthToken(refreshedUser.token);
+            setUser(refreshedUser);
+            return refreshedUser.token;
+          })
+          .finally(() => {
+            refreshing = null;
+          });
+      }
+
+      try {
+        const token = await refreshing;
+        request.headers.Authorization = `Bearer ${token}`;
+        return axios(request);
+      } catch (refreshErr) {
+        // The session was revoked or the refresh token expired
+        setAuthToken(null);
+        setUser(null);
+        localStorage.removeItem('support_user');
+        return Promise.reject(err);
+      }
+    });
+
+    return () => axios.interceptors.response.eject(interceptor);
+  }, []);
+
+  // Signs in with the tokens the API issued once the password, and the second
+  // factor if needed, were accepted
+  const completeLogin = (userData, email) => {
+    // Check if user has super_admin, admin, or supporter role
+    const allowedRoles = ['super_admin', 'admin', 'supporter'];
+    if (!allowedRoles.includes(userData.role)) {
+      throw new Error('Access denied. Only administrators and support staff can access this dashboard.');
+    }
+
+    const userInfo = {
+      id: userData.id,
+      email: userData.email || email,
+      firstName: userData.first_name || 'User',
+      lastName: userData.last_name || '',
+      role: userData.role,
+      token: userData.authentication_token?.token,
+      refreshToken: userData.refresh_token?.token,
+    };
+
+    setAuthToken(userInfo.token);
+    setUser(userInfo);
+    localStorage.setItem('support_user', JSON.stringify(userInfo));
+  };
+
+  const failed = (err) => {
+    const errorMessage = err.response?.data?.message || err.response?.data?.error || err.message || 'Authentication failed';
+    setError(errorMessage);
+    setLoading(false);
+    return { success: false, error: errorMessage };
+  };
+
   const login = async (email, password) => {
     setError(null);
     setLoading(true);
@@ -36,34 +127,73 @@ export const AuthProvider = ({ children }) => {
       });
 
       const userData = response.data;
-      
-      // Check if user has super_admin, admin, or supporter role
-      const allowedRoles = ['super_admin', 'admin', 'supporter'];
-      if (!allowedRoles.includes(userData.role)) {
-        throw new Error('Access denied. Only administrators and support staff can access this dashboard.');
+
+      // Staff accounts need a second factor; the caller asks for it and
+      // passes the pending token on to verifyMFA
+      if (userData.mfa_required) {
+        setLoading(false);
+        return {
+          success: false,
+          mfa: { token: userData.mfa_token.token, enrolled: userData.mfa_enrolled },
+        };
       }
 
-      const userInfo = {
-        id: userData.id,
-        email: userData.email || email,
-        firstName: userData.first_name || 'User',
-        lastName: userData.last_name || '',
-        role: userData.role,
+      completeLogin(userData, email);
+      setLoading(false);
+      return { success: true };
+    } catch (err) {
+      return failed(err);
+    }
+  };
+
+  // enrolMFA starts setting up an authenticator app for a pending sign-in
+  const enrolMFA = async (mfaToken) => {
+    setError(null);
+    try {
+      const response = await axios.post(`${AUTH_API_URL}/api/mfa/enrol`, { mfa_token: mfaToken });
+      return {
+        success: true,
+        qrCode: `data:image/png;base64,${response.data.qr_png}`,
+        secret: response.data.secret,
       };
+    } catch (err) {
+      return failed(err);
+    }
+  };
+
+  // verifyMFA completes a pending sign-in with a code from the authenticator
+  // app or a recovery code. Completing enrolment returns the recovery codes
+  // and a finish callback, so they can be shown before the dashboard opens.
+  const verifyMFA = async (mfa, code, recoveryCode) => {
+    setError(null);
+    setLoading(true);
+
+    try {
+      const response = mfa.enrolled
+        ? await axios.post(`${AUTH_API_URL}/api/mfa/verify`, {
+            mfa_token: mfa.token,
+            code,
+            recovery_code: recoveryCode,
+          })
+        : await axios.post(`${AUTH_API_URL}/api/mfa/enrol/verify`, { mfa_token: mfa.token, code });
 
-      setUser(userInfo);
-      localStorage.setItem('support_user', JSON.stringify(userInfo));
       setLoading(false);
+      if (response.data.recovery_codes) {
+        return {
+          success: true,
+          recoveryCodes: response.data.recovery_codes,
+          finish: () => completeLogin(response.data),
+        };
+      }
+      completeLogin(response.data);
       return { success: true };
     } catch (err) {
-      const errorMessage = err.response?.data?.error || err.message || 'Authentication failed';
-      setError(errorMessage);
-      setLoading(false);
-      return { success: false, error: errorMessage };
+      return failed(err);
     }
   };
 
   const logout = () => {
+    setAuthToken(null);
     setUser(null);
     localStorage.removeItem('support_user');
   };
@@ -81,6 +211,8 @@ export const AuthProvider = ({ children }) => {
     loading,
     error,
     login,
+    enrolMFA,
+    verifyMFA,
     logout,
     isAdmin,
     isSuperAdmin,